@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ChatDestinationRequest sets where alerts are posted in a chat app
+type ChatDestinationRequest struct {
+	WebhookURL string `json:"webhook_url"` // Slack and Discord
+	BotToken   string `json:"bot_token"`   // Telegram
+	ChatID     string `json:"chat_id"`     // Telegram
+}
+
+// SetChatDestination turns on alerts to the Slack, Discord or Telegram
+// destination in the request
+func (h *Handler) SetChatDestination(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req ChatDestinationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.prefs.SetChat(c.Request().Context(), userID, c.Param("channel"), models.ChatDestination{
+		WebhookURL: req.WebhookURL,
+		BotToken:   req.BotToken,
+		ChatID:     req.ChatID,
+	})
+	switch {
+	case errors.Is(err, notifications.ErrUnknownChatChannel):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Unknown chat channel",
+		})
+	case errors.Is(err, notifications.ErrInvalidChatDestination):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Preferences were modified concurrently, try again",
+		})
+	case err != nil:
+		fmt.Printf("[SetChatDestination] Failed to save destination: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to save chat destination",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Chat alerts turned on",
+	})
+}
+
+// RemoveChatDestination turns off alerts to a chat app and forgets where
+// they went
+func (h *Handler) RemoveChatDestination(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	err := h.prefs.RemoveChat(c.Request().Context(), userID, c.Param("channel"))
+	switch {
+	case errors.Is(err, notifications.ErrUnknownChatChannel):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Unknown chat channel",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to remove chat destination",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Chat alerts turned off",
+	})
+}
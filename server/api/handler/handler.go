@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/features/auth"
+	"stockmarket/server/internal/features/portfolio"
+	"stockmarket/server/internal/features/triggers"
+	"stockmarket/server/internal/leader"
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/notifications"
+	"stockmarket/server/internal/sharding"
+	"stockmarket/server/internal/tracking"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Handler holds the services used by the HTTP handlers
+type Handler struct {
+	auth      *auth.Service
+	portfolio *portfolio.Service
+	triggers  *triggers.Service
+	symbols   tracking.Registry
+	calendar  *marketcalendar.Calendar
+	phones    *notifications.PhoneVerifier // nil when SMS is disabled
+	webhooks  *notifications.WebhookNotifier
+	push      *notifications.PushNotifier // nil when Web Push is not configured
+	prefs     *notifications.Preferences
+	notifier  *notifications.Service
+	links     *notifications.UnsubscribeLinks
+	elector   *leader.Elector   // Elects the instance that sends digests, and polls unless sharded
+	sharder   *sharding.Sharder // nil when the poller is elected
+}
+
+// NewHandler creates a new handler
+func NewHandler(auth *auth.Service, portfolio *portfolio.Service, triggers *triggers.Service, symbols tracking.Registry, calendar *marketcalendar.Calendar, phones *notifications.PhoneVerifier, webhooks *notifications.WebhookNotifier, push *notifications.PushNotifier, prefs *notifications.Preferences, notifier *notifications.Service, links *notifications.UnsubscribeLinks, elector *leader.Elector, sharder *sharding.Sharder) *Handler {
+	return &Handler{
+		auth:      auth,
+		portfolio: portfolio,
+		triggers:  triggers,
+		symbols:   symbols,
+		calendar:  calendar,
+		phones:    phones,
+		webhooks:  webhooks,
+		push:      push,
+		prefs:     prefs,
+		notifier:  notifier,
+		links:     links,
+		elector:   elector,
+		sharder:   sharder,
+	}
+}
+
+// pageOptions reads the ?limit= and ?cursor= query parameters of a list
+// endpoint
+func pageOptions(c echo.Context) (database.PageOptions, error) {
+	page := database.PageOptions{Cursor: c.QueryParam("cursor")}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("limit must be a positive integer")
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
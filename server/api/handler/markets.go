@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"stockmarket/server/internal/marketcalendar"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetMarketStatus reports an exchange's session, and when it next opens and
+// closes. ?at= asks about another time, in RFC 3339.
+func (h *Handler) GetMarketStatus(c echo.Context) error {
+	at := time.Now()
+	if raw := c.QueryParam("at"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "at must be an RFC 3339 time",
+			})
+		}
+		at = parsed
+	}
+
+	status, err := h.calendar.Status(c.Param("exchange"), at)
+	if errors.Is(err, marketcalendar.ErrUnknownExchange) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Unknown exchange",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get market status",
+		})
+	}
+	return c.JSON(http.StatusOK, status)
+}
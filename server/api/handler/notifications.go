@@ -0,0 +1,425 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PhoneRequest asks for a code to be texted to a phone number
+type PhoneRequest struct {
+	Phone string `json:"phone"` // E.164, e.g. +14155550100
+}
+
+// PhoneCodeRequest confirms a phone number with the code texted to it
+type PhoneCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// StartPhoneVerification texts a one-time code to the number the user wants
+// SMS alerts on
+func (h *Handler) StartPhoneVerification(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+	if h.phones == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "SMS notifications are not available",
+		})
+	}
+
+	var req PhoneRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.phones.Start(c.Request().Context(), userID, req.Phone)
+	switch {
+	case errors.Is(err, notifications.ErrInvalidPhone):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, notifications.ErrSMSLimit):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{
+			"error": "Daily SMS limit reached, try again tomorrow",
+		})
+	case err != nil:
+		fmt.Printf("[StartPhoneVerification] Failed to send code: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to send verification code",
+		})
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message": "Verification code sent",
+	})
+}
+
+// ConfirmPhoneVerification checks the code texted to the user and turns on
+// SMS alerts to their number
+func (h *Handler) ConfirmPhoneVerification(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+	if h.phones == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "SMS notifications are not available",
+		})
+	}
+
+	var req PhoneCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.phones.Confirm(c.Request().Context(), userID, req.Code)
+	switch {
+	case errors.Is(err, notifications.ErrNoPendingVerification),
+		errors.Is(err, notifications.ErrCodeExpired),
+		errors.Is(err, notifications.ErrWrongCode),
+		errors.Is(err, notifications.ErrTooManyAttempts):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case err != nil:
+		fmt.Printf("[ConfirmPhoneVerification] Failed to verify phone: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to verify phone number",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Phone number verified",
+	})
+}
+
+// LanguageRequest picks the language notifications are written in
+type LanguageRequest struct {
+	Language string `json:"language"` // e.g. en, de, es, fr or hi
+}
+
+// SetNotificationLanguage sets the language the user's notifications are
+// written in
+func (h *Handler) SetNotificationLanguage(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req LanguageRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.prefs.SetLanguage(c.Request().Context(), userID, req.Language)
+	switch {
+	case errors.Is(err, notifications.ErrUnsupportedLanguage):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Preferences were modified concurrently, try again",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to set language",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Notification language set",
+	})
+}
+
+// DigestRequest sets how often the user is emailed a portfolio digest
+type DigestRequest struct {
+	Frequency string `json:"frequency"` // daily, weekly or empty for none
+	Timezone  string `json:"timezone"`  // IANA name, e.g. Europe/Berlin; unchanged if empty
+}
+
+// SetDigest sets how often and in which timezone the user's portfolio
+// digest is emailed
+func (h *Handler) SetDigest(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req DigestRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.prefs.SetDigest(c.Request().Context(), userID, req.Frequency, req.Timezone)
+	switch {
+	case errors.Is(err, notifications.ErrInvalidDigest):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Preferences were modified concurrently, try again",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to set digest",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Digest settings saved",
+	})
+}
+
+// QuietHoursRequest sets when alerts are held
+type QuietHoursRequest struct {
+	Start    string `json:"start"`    // HH:MM; empty with End to turn quiet hours off
+	End      string `json:"end"`      // HH:MM
+	Timezone string `json:"timezone"` // IANA name, e.g. Europe/Berlin; unchanged if empty
+}
+
+// SetQuietHours sets the time of day when the user's alerts are held, to be
+// sent together once it ends
+func (h *Handler) SetQuietHours(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req QuietHoursRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.prefs.SetQuietHours(c.Request().Context(), userID, req.Start, req.End, req.Timezone)
+	switch {
+	case errors.Is(err, notifications.ErrInvalidQuietHours):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Preferences were modified concurrently, try again",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to set quiet hours",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Quiet hours saved",
+	})
+}
+
+// NotificationSettingsResponse describes how the user is notified
+type NotificationSettingsResponse struct {
+	Channels          map[string]bool            `json:"channels"` // Whether alerts go out on each channel
+	EmailSubscription string                     `json:"email_subscription,omitempty"`
+	Phone             string                     `json:"phone,omitempty"`
+	PhoneVerified     bool                       `json:"phone_verified"`
+	PendingPhone      string                     `json:"pending_phone,omitempty"` // Waiting for its code
+	Language          string                     `json:"language,omitempty"`
+	Timezone          string                     `json:"timezone,omitempty"`
+	Digest            string                     `json:"digest,omitempty"`
+	QuietHours        *QuietHoursResponse        `json:"quiet_hours,omitempty"`
+	Webhooks          []WebhookResponse          `json:"webhooks"`
+	PushSubscriptions []PushSubscriptionResponse `json:"push_subscriptions"`
+}
+
+// QuietHoursResponse is when the user's alerts are held
+type QuietHoursResponse struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// ChannelsRequest turns channels on or off, leaving those it doesn't name
+// as they are
+type ChannelsRequest struct {
+	Channels map[string]bool `json:"channels"` // e.g. {"email": true, "sms": false}
+}
+
+// TestNotificationRequest picks the channels a test notification goes to
+type TestNotificationRequest struct {
+	Channels []string `json:"channels"` // Every channel the user has on if empty
+}
+
+// GetNotificationSettings returns the user's notification settings
+func (h *Handler) GetNotificationSettings(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	return h.notificationSettings(c, userID)
+}
+
+// UpdateNotificationSettings turns notification channels on or off and
+// returns the settings that result. Turning email on subscribes the user
+// if the mailer needs it; SNS then emails them to confirm.
+func (h *Handler) UpdateNotificationSettings(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req ChannelsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	err := h.prefs.SetChannels(c.Request().Context(), userID, req.Channels)
+	switch {
+	case errors.Is(err, notifications.ErrUnknownChannel),
+		errors.Is(err, notifications.ErrChannelNotSetUp):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Preferences were modified concurrently, try again",
+		})
+	case err != nil:
+		fmt.Printf("[UpdateNotificationSettings] Failed to set channels: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update notification settings",
+		})
+	}
+
+	return h.notificationSettings(c, userID)
+}
+
+// notificationSettings responds with the user's notification settings
+func (h *Handler) notificationSettings(c echo.Context, userID string) error {
+	ctx := c.Request().Context()
+	user, err := h.prefs.Get(ctx, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get notification settings",
+		})
+	}
+	webhooks, err := h.webhooks.Webhooks(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get webhooks",
+		})
+	}
+
+	prefs := user.NotificationPreferences
+	response := NotificationSettingsResponse{
+		Channels:          make(map[string]bool),
+		Phone:             prefs.Phone,
+		PhoneVerified:     prefs.PhoneVerified,
+		Language:          prefs.Language,
+		Timezone:          prefs.Timezone,
+		Digest:            prefs.Digest,
+		Webhooks:          make([]WebhookResponse, 0, len(webhooks)),
+		PushSubscriptions: []PushSubscriptionResponse{},
+	}
+	if h.push != nil {
+		subs, err := h.push.Subscriptions(ctx, userID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to get push subscriptions",
+			})
+		}
+		for _, sub := range subs {
+			response.PushSubscriptions = append(response.PushSubscriptions, pushSubscriptionResponse(sub))
+		}
+	}
+	for _, channel := range append([]string{models.ChannelWebSocket, models.ChannelEmail, models.ChannelSMS, models.ChannelWebhook, models.ChannelPush}, notifications.ChatChannels...) {
+		response.Channels[channel] = user.ChannelEnabled(channel)
+	}
+	if prefs.Email {
+		// The settings are still worth showing if SNS can't be reached
+		response.EmailSubscription, err = h.prefs.EmailSubscription(ctx, user)
+		if err != nil {
+			fmt.Printf("[NotificationSettings] Failed to look up email subscription: %v\n", err)
+		}
+	}
+	if prefs.PhoneVerification != nil {
+		response.PendingPhone = prefs.PhoneVerification.Phone
+	}
+	if prefs.QuietHours != nil {
+		response.QuietHours = &QuietHoursResponse{Start: prefs.QuietHours.Start, End: prefs.QuietHours.End}
+	}
+	for _, webhook := range webhooks {
+		response.Webhooks = append(response.Webhooks, webhookResponse(webhook))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// SendTestNotification sends the user a sample alert and returns how it
+// went on each channel. Quiet hours and rate limits don't apply.
+func (h *Handler) SendTestNotification(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req TestNotificationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	deliveries, err := h.notifier.SendTest(c.Request().Context(), userID, req.Channels)
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		fmt.Printf("[SendTestNotification] Failed to send test: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to send test notification",
+		})
+	}
+	if deliveries == nil {
+		deliveries = []models.Delivery{}
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
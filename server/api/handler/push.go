@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications"
+	"stockmarket/server/internal/notifications/webpush"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PushSubscriptionRequest registers a browser for push alerts. Endpoint and
+// keys are the browser's PushSubscription as its toJSON() returns it.
+type PushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256DH string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	Device string `json:"device"` // Optional name to tell the user's browsers apart by
+}
+
+// PushSubscriptionResponse describes a subscribed browser. The keys are
+// left out, as they are only of use to the push service.
+type PushSubscriptionResponse struct {
+	SubscriptionID string    `json:"subscription_id"`
+	Endpoint       string    `json:"endpoint"`
+	Device         string    `json:"device,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func pushSubscriptionResponse(sub *models.PushSubscription) PushSubscriptionResponse {
+	return PushSubscriptionResponse{
+		SubscriptionID: sub.SubscriptionID,
+		Endpoint:       sub.Endpoint,
+		Device:         sub.Device,
+		CreatedAt:      sub.CreatedAt,
+	}
+}
+
+// GetPushKey returns the VAPID public key browsers subscribe with
+func (h *Handler) GetPushKey(c echo.Context) error {
+	if h.push == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Push notifications are not available",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"public_key": h.push.PublicKey(),
+	})
+}
+
+// GetPushSubscriptions lists the browsers the user subscribed
+func (h *Handler) GetPushSubscriptions(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+	if h.push == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Push notifications are not available",
+		})
+	}
+
+	subs, err := h.push.Subscriptions(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get push subscriptions",
+		})
+	}
+
+	response := make([]PushSubscriptionResponse, 0, len(subs))
+	for _, sub := range subs {
+		response = append(response, pushSubscriptionResponse(sub))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// AddPushSubscription registers a browser for push alerts and turns them on
+func (h *Handler) AddPushSubscription(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+	if h.push == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Push notifications are not available",
+		})
+	}
+
+	var req PushSubscriptionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	sub := webpush.Subscription{Endpoint: req.Endpoint, P256DH: req.Keys.P256DH, Auth: req.Keys.Auth}
+	subscription, err := h.push.Register(c.Request().Context(), userID, sub, req.Device)
+	if errors.Is(err, notifications.ErrInvalidPushSubscription) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("[AddPushSubscription] Failed to register push subscription: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to add push subscription",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, pushSubscriptionResponse(subscription))
+}
+
+// RemovePushSubscription stops push alerts to one of the user's browsers
+func (h *Handler) RemovePushSubscription(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+	if h.push == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Push notifications are not available",
+		})
+	}
+
+	err := h.push.Delete(c.Request().Context(), userID, c.Param("subscriptionId"))
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Push subscription not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to remove push subscription",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Push subscription removed successfully",
+	})
+}
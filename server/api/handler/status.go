@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetStatus reports this instance's role in the leader election
+func (h *Handler) GetStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.elector.Status(c.Request().Context()))
+}
+
+// GetShardStatus reports the live instances and the symbols each polls
+func (h *Handler) GetShardStatus(c echo.Context) error {
+	if h.sharder == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Poller is not sharded; see /status",
+		})
+	}
+
+	entries, err := h.symbols.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list tracked symbols",
+		})
+	}
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.Key.String())
+	}
+	return c.JSON(http.StatusOK, h.sharder.Status(keys))
+}
@@ -1,9 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/features/portfolio"
 	"stockmarket/server/internal/features/stock"
+	"stockmarket/server/internal/models"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -16,11 +19,17 @@ type SearchStockRequest struct {
 
 // AddStockRequest represents a request to add a stock to user's portfolio
 type AddStockRequest struct {
-	Symbol string `json:"symbol"`
+	Symbol   string  `json:"symbol"`
+	Quantity float64 `json:"quantity"` // Shares held; 0 to only watch the stock
+}
+
+// QuantityRequest sets the number of shares held of a stock
+type QuantityRequest struct {
+	Quantity float64 `json:"quantity"`
 }
 
 // SearchStock handles stock search requests
-func SearchStock(c echo.Context) error {
+func (h *Handler) SearchStock(c echo.Context) error {
 	var req SearchStockRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -45,7 +54,7 @@ func SearchStock(c echo.Context) error {
 }
 
 // FetchStockDetails handles detailed stock information requests
-func FetchStockDetails(c echo.Context) error {
+func (h *Handler) FetchStockDetails(c echo.Context) error {
 	symbol := c.QueryParam("symbol")
 	if symbol == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -64,7 +73,7 @@ func FetchStockDetails(c echo.Context) error {
 }
 
 // AddStock handles adding a stock to user's portfolio
-func AddStock(c echo.Context) error {
+func (h *Handler) AddStock(c echo.Context) error {
 	var req AddStockRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -77,6 +86,11 @@ func AddStock(c echo.Context) error {
 			"error": "Stock symbol is required",
 		})
 	}
+	if req.Quantity < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": portfolio.ErrInvalidQuantity.Error(),
+		})
+	}
 
 	// Get user ID from context (set by auth middleware)
 	userID := c.Get("user").(string)
@@ -95,7 +109,12 @@ func AddStock(c echo.Context) error {
 	}
 
 	// Add stock to user's portfolio with current price
-	err = database.AddStockToUser(c.Request().Context(), userID, details)
+	_, err = h.portfolio.AddStock(c.Request().Context(), userID, details, req.Quantity)
+	if errors.Is(err, database.ErrConflict) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Stock was modified concurrently, please retry",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to add stock to portfolio",
@@ -105,8 +124,14 @@ func AddStock(c echo.Context) error {
 	return c.JSON(http.StatusOK, details)
 }
 
-// GetUserStocks handles retrieving all stocks in user's portfolio
-func GetUserStocks(c echo.Context) error {
+// StockListResponse is one page of a user's portfolio
+type StockListResponse struct {
+	Stocks     []models.Stock `json:"stocks"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Pass as ?cursor= to get the next page
+}
+
+// GetUserStocks handles retrieving a page of stocks in user's portfolio
+func (h *Handler) GetUserStocks(c echo.Context) error {
 	userID := c.Get("user").(string)
 	if userID == "" {
 		return c.JSON(http.StatusUnauthorized, map[string]string{
@@ -114,18 +139,33 @@ func GetUserStocks(c echo.Context) error {
 		})
 	}
 
-	stocks, err := database.GetUserStocks(c.Request().Context(), userID)
+	page, err := pageOptions(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	stocks, next, err := h.portfolio.ListUserStocks(c.Request().Context(), userID, page)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to fetch user stocks",
 		})
 	}
 
-	return c.JSON(http.StatusOK, stocks)
+	if stocks == nil {
+		stocks = []models.Stock{}
+	}
+	return c.JSON(http.StatusOK, StockListResponse{Stocks: stocks, NextCursor: next})
 }
 
 // RemoveStock handles removing a stock from user's portfolio
-func RemoveStock(c echo.Context) error {
+func (h *Handler) RemoveStock(c echo.Context) error {
 	stockID := c.Param("stockId")
 	if stockID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -140,7 +180,7 @@ func RemoveStock(c echo.Context) error {
 		})
 	}
 
-	err := database.RemoveStockFromUser(c.Request().Context(), userID, stockID)
+	err := h.portfolio.RemoveStock(c.Request().Context(), userID, stockID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to remove stock",
@@ -151,3 +191,43 @@ func RemoveStock(c echo.Context) error {
 		"message": "Stock removed successfully",
 	})
 }
+
+// SetStockQuantity sets the number of shares held of a stock in the user's
+// portfolio
+func (h *Handler) SetStockQuantity(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req QuantityRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	entry, err := h.portfolio.SetQuantity(c.Request().Context(), userID, c.Param("stockId"), req.Quantity)
+	switch {
+	case errors.Is(err, portfolio.ErrInvalidQuantity):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Stock not found",
+		})
+	case errors.Is(err, database.ErrConflict):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Stock was modified concurrently, please retry",
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update stock",
+		})
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
@@ -1 +1,51 @@
-package handler
\ No newline at end of file
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TriggerListResponse is one page of a user's triggers
+type TriggerListResponse struct {
+	Triggers   []*models.StockTrigger `json:"triggers"`
+	NextCursor string                 `json:"next_cursor,omitempty"` // Pass as ?cursor= to get the next page
+}
+
+// GetUserTriggers handles retrieving a page of the user's triggers
+func (h *Handler) GetUserTriggers(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	page, err := pageOptions(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	triggers, next, err := h.triggers.ListUserTriggers(c.Request().Context(), userID, page)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch triggers",
+		})
+	}
+
+	if triggers == nil {
+		triggers = []*models.StockTrigger{}
+	}
+	return c.JSON(http.StatusOK, TriggerListResponse{Triggers: triggers, NextCursor: next})
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"stockmarket/server/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+// unsubscribePage is shown for the link in an email. Mail scanners open
+// links, so unsubscribing takes a POST; the form posts back to the same
+// URL, token included.
+const unsubscribePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body style="font-family:sans-serif;max-width:480px;margin:40px auto">
+<p>Stop emailing you stock alerts and portfolio digests?</p>
+<form method="post"><button type="submit">Unsubscribe</button></form>
+</body></html>`
+
+// unsubscribedPage confirms the user was unsubscribed
+const unsubscribedPage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribed</title></head>
+<body style="font-family:sans-serif;max-width:480px;margin:40px auto">
+<p>You won't be emailed alerts or digests any more. You can turn email back on in your notification settings.</p>
+</body></html>`
+
+// invalidUnsubscribePage is shown for a link the server didn't sign
+const invalidUnsubscribePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body style="font-family:sans-serif;max-width:480px;margin:40px auto">
+<p>This unsubscribe link is not valid. You can turn email off in your notification settings.</p>
+</body></html>`
+
+// ShowUnsubscribe asks the owner of an unsubscribe link to confirm
+func (h *Handler) ShowUnsubscribe(c echo.Context) error {
+	if _, err := h.links.Verify(c.QueryParam("token")); err != nil {
+		return c.HTML(http.StatusBadRequest, invalidUnsubscribePage)
+	}
+	return c.HTML(http.StatusOK, unsubscribePage)
+}
+
+// Unsubscribe stops emailing the user an unsubscribe link names. Mail
+// clients post to it for one-click unsubscribing (RFC 8058), so it needs no
+// login; the link's signature stands in for one.
+func (h *Handler) Unsubscribe(c echo.Context) error {
+	userID, err := h.links.Verify(c.QueryParam("token"))
+	if err != nil {
+		return c.HTML(http.StatusBadRequest, invalidUnsubscribePage)
+	}
+
+	err = h.prefs.UnsubscribeEmail(c.Request().Context(), userID)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		// The account is gone, so nothing will be emailed to it anyway
+	case err != nil:
+		fmt.Printf("[Unsubscribe] Failed to unsubscribe %s: %v\n", userID, err)
+		return c.HTML(http.StatusInternalServerError, "Failed to unsubscribe, please try again")
+	}
+
+	return c.HTML(http.StatusOK, unsubscribedPage)
+}
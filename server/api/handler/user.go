@@ -1,10 +1,11 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
-	"stockmarket/server/internal/features/auth"
+	"stockmarket/server/internal/database"
 
 	"github.com/labstack/echo/v4"
 )
@@ -15,7 +16,7 @@ type AuthRequest struct {
 }
 
 // SignUp handles user registration
-func SignUp(c echo.Context) error {
+func (h *Handler) SignUp(c echo.Context) error {
 	var req AuthRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -31,7 +32,12 @@ func SignUp(c echo.Context) error {
 	}
 
 	// Create user using request context
-	err := auth.CreateUser(c.Request().Context(), req.Email, req.Password)
+	err := h.auth.CreateUser(c.Request().Context(), req.Email, req.Password)
+	if errors.Is(err, database.ErrConflict) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "User already exists",
+		})
+	}
 	if err != nil {
 		// Log the error but don't send it to client
 		fmt.Printf("[SignUp] Failed to create user: %v\n", err)
@@ -46,7 +52,7 @@ func SignUp(c echo.Context) error {
 }
 
 // Login handles user authentication
-func Login(c echo.Context) error {
+func (h *Handler) Login(c echo.Context) error {
 	var req AuthRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -62,7 +68,7 @@ func Login(c echo.Context) error {
 	}
 
 	// Validate user using request context
-	token, err := auth.ValidateUser(c.Request().Context(), req.Email, req.Password)
+	token, err := h.auth.ValidateUser(c.Request().Context(), req.Email, req.Password)
 	if err != nil {
 		// Log the error but don't expose details
 		fmt.Printf("[Login] Authentication failed: %v\n", err)
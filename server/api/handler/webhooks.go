@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WebhookRequest registers a webhook
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+// WebhookResponse describes a webhook. The signing secret is only shown
+// when the webhook is registered.
+type WebhookResponse struct {
+	WebhookID  string     `json:"webhook_id"`
+	URL        string     `json:"url"`
+	Secret     string     `json:"secret,omitempty"`
+	Enabled    bool       `json:"enabled"`
+	Failures   int        `json:"failures"` // Deliveries failed in a row
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func webhookResponse(webhook *models.Webhook) WebhookResponse {
+	return WebhookResponse{
+		WebhookID:  webhook.WebhookID,
+		URL:        webhook.URL,
+		Enabled:    webhook.Enabled,
+		Failures:   webhook.Failures,
+		DisabledAt: webhook.DisabledAt,
+		CreatedAt:  webhook.CreatedAt,
+	}
+}
+
+// DeadLetterResponse describes a delivery that failed every attempt
+type DeadLetterResponse struct {
+	DeadLetterID string    `json:"dead_letter_id"`
+	WebhookID    string    `json:"webhook_id"`
+	FireID       string    `json:"fire_id"`
+	Payload      string    `json:"payload"`
+	Error        string    `json:"error"`
+	Attempts     int       `json:"attempts"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+// GetWebhooks lists the user's webhooks
+func (h *Handler) GetWebhooks(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	webhooks, err := h.webhooks.Webhooks(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get webhooks",
+		})
+	}
+
+	response := make([]WebhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		response = append(response, webhookResponse(webhook))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// AddWebhook registers a webhook and returns the secret its deliveries are
+// signed with
+func (h *Handler) AddWebhook(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	var req WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request format",
+		})
+	}
+
+	webhook, err := h.webhooks.Register(c.Request().Context(), userID, req.URL)
+	if errors.Is(err, notifications.ErrInvalidWebhookURL) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("[AddWebhook] Failed to register webhook: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to add webhook",
+		})
+	}
+
+	response := webhookResponse(webhook)
+	response.Secret = webhook.Secret
+	return c.JSON(http.StatusCreated, response)
+}
+
+// RemoveWebhook deletes one of the user's webhooks and its dead letters
+func (h *Handler) RemoveWebhook(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	err := h.webhooks.Delete(c.Request().Context(), userID, c.Param("webhookId"))
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to remove webhook",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Webhook removed successfully",
+	})
+}
+
+// EnableWebhook turns a webhook that was disabled after failing back on
+func (h *Handler) EnableWebhook(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	webhook, err := h.webhooks.Enable(c.Request().Context(), userID, c.Param("webhookId"))
+	if errors.Is(err, database.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook not found",
+		})
+	}
+	if errors.Is(err, database.ErrConflict) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "Webhook was modified concurrently, try again",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to enable webhook",
+		})
+	}
+	return c.JSON(http.StatusOK, webhookResponse(webhook))
+}
+
+// GetWebhookDeadLetters lists the deliveries to the user's webhooks that
+// failed every attempt
+func (h *Handler) GetWebhookDeadLetters(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	letters, err := h.webhooks.DeadLetters(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get dead letters",
+		})
+	}
+
+	response := make([]DeadLetterResponse, 0, len(letters))
+	for _, letter := range letters {
+		response = append(response, DeadLetterResponse{
+			DeadLetterID: letter.DeadLetterID,
+			WebhookID:    letter.WebhookID,
+			FireID:       letter.FireID,
+			Payload:      letter.Payload,
+			Error:        letter.Error,
+			Attempts:     letter.Attempts,
+			FailedAt:     letter.FailedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// ReplayWebhookDeadLetter posts a dead-lettered delivery again
+func (h *Handler) ReplayWebhookDeadLetter(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	err := h.webhooks.Replay(c.Request().Context(), userID, c.Param("deadLetterId"))
+	var failed *notifications.DeadLetterError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Dead letter or its webhook not found",
+		})
+	case errors.As(err, &failed):
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Webhook still failing: " + failed.Reason,
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to replay delivery",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Delivery replayed successfully",
+	})
+}
+
+// TestWebhook posts a sample alert to one of the user's webhooks, so they
+// can check their endpoint accepts and verifies it
+func (h *Handler) TestWebhook(c echo.Context) error {
+	userID := c.Get("user").(string)
+	if userID == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "User not authenticated",
+		})
+	}
+
+	err := h.webhooks.Test(c.Request().Context(), userID, c.Param("webhookId"))
+	var failed *notifications.WebhookTestError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Webhook not found",
+		})
+	case errors.As(err, &failed):
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": "Webhook failed: " + failed.Reason,
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to test webhook",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Webhook accepted the test alert",
+	})
+}
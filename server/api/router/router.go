@@ -8,7 +8,8 @@ import (
 	echomw "github.com/labstack/echo/v4/middleware"
 )
 
-func StartServer() {
+// NewRouter creates the echo instance with all routes registered
+func NewRouter(h *handler.Handler) *echo.Echo {
 	e := echo.New()
 
 	// Global middleware
@@ -17,21 +18,60 @@ func StartServer() {
 	e.Use(echomw.CORS())
 
 	// Public routes
-	e.POST("/signup", handler.SignUp)
-	e.POST("/login", handler.Login)
+	e.POST("/signup", h.SignUp)
+	e.POST("/login", h.Login)
+	e.GET("/status", h.GetStatus)
+	e.GET("/status/shards", h.GetShardStatus)
+	// Linked to from every email, signed instead of logged in
+	e.GET("/unsubscribe", h.ShowUnsubscribe)
+	e.POST("/unsubscribe", h.Unsubscribe)
 
 	// Public stock routes
-	e.POST("/api/stock/search", handler.SearchStock)
-	e.GET("/api/stock/details", handler.FetchStockDetails)
+	e.POST("/api/stock/search", h.SearchStock)
+	e.GET("/api/stock/details", h.FetchStockDetails)
+	e.GET("/api/markets/:exchange/status", h.GetMarketStatus)
 
 	// Protected routes (require authentication)
 	api := e.Group("/api")
 	api.Use(middleware.JWTMiddleware())
 
 	// Stock management routes (fixed paths)
-	api.POST("/stock/add", handler.AddStock)
-	api.GET("/stock/list", handler.GetUserStocks)
-	api.DELETE("/stock/:stockId", handler.RemoveStock)
+	api.POST("/stock/add", h.AddStock)
+	api.GET("/stock/list", h.GetUserStocks)
+	api.DELETE("/stock/:stockId", h.RemoveStock)
+	api.PUT("/stock/:stockId/quantity", h.SetStockQuantity)
+
+	// Trigger routes
+	api.GET("/triggers", h.GetUserTriggers)
+
+	// Notification settings
+	api.GET("/me/notifications", h.GetNotificationSettings)
+	api.PUT("/me/notifications", h.UpdateNotificationSettings)
+	api.POST("/me/notifications/test", h.SendTestNotification)
+	api.POST("/me/notifications/phone", h.StartPhoneVerification)
+	api.POST("/me/notifications/phone/verify", h.ConfirmPhoneVerification)
+	api.PUT("/me/notifications/language", h.SetNotificationLanguage)
+	api.PUT("/me/notifications/digest", h.SetDigest)
+	api.PUT("/me/notifications/quiet-hours", h.SetQuietHours)
+	api.GET("/me/notifications/webhooks", h.GetWebhooks)
+	api.POST("/me/notifications/webhooks", h.AddWebhook)
+	api.DELETE("/me/notifications/webhooks/:webhookId", h.RemoveWebhook)
+	api.POST("/me/notifications/webhooks/:webhookId/enable", h.EnableWebhook)
+	api.POST("/me/notifications/webhooks/:webhookId/test", h.TestWebhook)
+	api.GET("/me/notifications/webhooks/dead-letters", h.GetWebhookDeadLetters)
+	api.POST("/me/notifications/webhooks/dead-letters/:deadLetterId/replay", h.ReplayWebhookDeadLetter)
+	api.GET("/me/notifications/push/key", h.GetPushKey)
+	api.GET("/me/notifications/push/subscriptions", h.GetPushSubscriptions)
+	api.POST("/me/notifications/push/subscriptions", h.AddPushSubscription)
+	api.DELETE("/me/notifications/push/subscriptions/:subscriptionId", h.RemovePushSubscription)
+	api.PUT("/me/notifications/chat/:channel", h.SetChatDestination)
+	api.DELETE("/me/notifications/chat/:channel", h.RemoveChatDestination)
+
+	return e
+}
+
+func StartServer(h *handler.Handler) {
+	e := NewRouter(h)
 
 	// Start server
 	e.Logger.Fatal(e.Start(":8080"))
@@ -0,0 +1,148 @@
+// Command migrate applies and reports schema migrations for the configured
+// storage backend.
+//
+//	go run ./cmd/migrate up      # apply pending migrations
+//	go run ./cmd/migrate status  # list migrations and whether they are applied
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"stockmarket/server/internal/config"
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/sqlstore"
+
+	"github.com/joho/godotenv"
+)
+
+// status is one row of `migrate status`
+type status struct {
+	version   int
+	name      string
+	appliedAt time.Time // zero if pending
+}
+
+// migrator is the part of a backend's migration support the CLI uses
+type migrator interface {
+	up(ctx context.Context) error
+	status(ctx context.Context) ([]status, error)
+}
+
+func main() {
+	if len(os.Args) != 2 || (os.Args[1] != "up" && os.Args[1] != "status") {
+		fmt.Fprintln(os.Stderr, "usage: migrate up|status")
+		os.Exit(2)
+	}
+
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = "configs/secrets.env"
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		log.Printf("Note: not loading %s: %v", envFile, err)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	m, err := newMigrator(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", cfg.StorageBackend, err)
+	}
+
+	switch os.Args[1] {
+	case "up":
+		if err := m.up(ctx); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("%s schema is up to date", cfg.StorageBackend)
+	case "status":
+		rows, err := m.status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, r := range rows {
+			applied := "pending"
+			if !r.appliedAt.IsZero() {
+				applied = r.appliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", r.version, r.name, applied)
+		}
+		w.Flush()
+	}
+}
+
+// newMigrator opens the backend selected by STORAGE_BACKEND
+func newMigrator(ctx context.Context, cfg *config.Config) (migrator, error) {
+	switch cfg.StorageBackend {
+	case "sqlite", "postgres":
+		store, err := sqlstore.Open(ctx, sqlstore.Dialect(cfg.StorageBackend), cfg.DatabaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return sqlMigrator{store}, nil
+	case "memory":
+		return nil, fmt.Errorf("the memory backend has no schema")
+	default:
+		client, err := database.NewDynamoDBClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		db := database.NewDatabase(client, database.TablesFromConfig(cfg))
+		return dynamoMigrator{database.NewMigrator(db)}, nil
+	}
+}
+
+type dynamoMigrator struct {
+	m *database.Migrator
+}
+
+func (d dynamoMigrator) up(ctx context.Context) error {
+	return d.m.Up(ctx)
+}
+
+func (d dynamoMigrator) status(ctx context.Context) ([]status, error) {
+	migrations, err := d.m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]status, 0, len(migrations))
+	for _, m := range migrations {
+		rows = append(rows, status{version: m.Version, name: m.Name, appliedAt: m.AppliedAt})
+	}
+	return rows, nil
+}
+
+type sqlMigrator struct {
+	store *sqlstore.Store
+}
+
+func (s sqlMigrator) up(ctx context.Context) error {
+	return s.store.Migrate(ctx)
+}
+
+func (s sqlMigrator) status(ctx context.Context) ([]status, error) {
+	migrations, err := s.store.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.store.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]status, 0, len(migrations))
+	for _, m := range migrations {
+		rows = append(rows, status{version: m.Version, name: m.Name, appliedAt: applied[m.Version]})
+	}
+	return rows, nil
+}
@@ -0,0 +1,70 @@
+// Command rebuildtracking recounts the tracked symbols from every holding
+// and replaces the set the servers share in Redis. The servers keep the set
+// up to date as holdings and triggers come and go, so it is only needed to
+// repair drift, e.g. after a crash between a database write and its
+// reference, or to fill a new Redis. Run it while no server is writing, as
+// changes they make meanwhile are lost.
+//
+//	go run ./cmd/rebuildtracking
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"stockmarket/server/internal/cache"
+	"stockmarket/server/internal/config"
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/sqlstore"
+	"stockmarket/server/internal/tracking"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = "configs/secrets.env"
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		log.Printf("Note: not loading %s: %v", envFile, err)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	store, err := openStore(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", cfg.StorageBackend, err)
+	}
+	if err := cache.InitRedis(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	symbols := tracking.NewRedisRegistry(cache.RedisClient, tracking.DefaultRedisKey)
+	if err := tracking.Rebuild(ctx, symbols, store.ScanStocks); err != nil {
+		log.Fatalf("Failed to rebuild tracked symbols: %v", err)
+	}
+	entries, err := symbols.List(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list tracked symbols: %v", err)
+	}
+	log.Printf("Tracking %d symbols", len(entries))
+}
+
+// openStore opens the backend selected by STORAGE_BACKEND
+func openStore(ctx context.Context, cfg *config.Config) (database.Store, error) {
+	switch cfg.StorageBackend {
+	case "sqlite", "postgres":
+		return sqlstore.Open(ctx, sqlstore.Dialect(cfg.StorageBackend), cfg.DatabaseURL)
+	case "memory":
+		return nil, fmt.Errorf("the memory backend is not shared with the servers")
+	default:
+		return database.InitDynamoDB(ctx, cfg)
+	}
+}
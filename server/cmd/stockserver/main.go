@@ -2,23 +2,54 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"stockmarket/server/api/handler"
 	"stockmarket/server/api/router"
 	"stockmarket/server/internal/cache"
+	"stockmarket/server/internal/config"
 	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/database/sqlstore"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/features/auth"
+	"stockmarket/server/internal/features/portfolio"
 	"stockmarket/server/internal/features/stock"
+	"stockmarket/server/internal/features/triggers"
+	"stockmarket/server/internal/leader"
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/notifications"
+	"stockmarket/server/internal/notifications/webpush"
+	"stockmarket/server/internal/scheduler"
+	"stockmarket/server/internal/sharding"
+	"stockmarket/server/internal/streams"
+	"stockmarket/server/internal/tracking"
+	"stockmarket/server/internal/websocket"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load environment configs
-	err := godotenv.Load("C:/Users/canud/OneDrive/Desktop/HOM work/stockmarket/server/configs/secrets.env")
+	// Load environment configs. A missing file is fine when the environment
+	// is already set, e.g. in containers or for local SQLite runs.
+	envFile := os.Getenv("ENV_FILE")
+	if envFile == "" {
+		envFile = "configs/secrets.env"
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		log.Printf("Note: not loading %s: %v", envFile, err)
+	}
+
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
 	// Check if the environment variables are loaded correctly
@@ -27,46 +58,429 @@ func main() {
 	// After loading the environment file
 	log.Printf("JWT_SECRET loaded: %v", os.Getenv("JWT_SECRET") != "")
 
-	// Initialize DynamoDB
-	if err := database.InitDynamoDB(); err != nil {
-		log.Fatalf("Failed to initialize DynamoDB: %v", err)
+	// Initialize storage
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
-	// Initialize Redis (non-fatal if it fails)
+	// Initialize Redis (non-fatal if it fails). Without it the tracked
+	// symbols and the event bus stay within this process.
+	var shared bool
+	var symbols tracking.Registry
+	var bus *events.Bus
+	var lease leader.Lease
+	var membership sharding.Membership
+	var counter notifications.Counter
 	if err := cache.InitRedis(); err != nil {
 		log.Printf("Note: Application will run without caching. Redis error: %v", err)
+		symbols = tracking.NewMemoryRegistry()
+		bus = events.NewBus(events.NewMemoryTransport())
+		lease = leader.NewMemoryLease()
+		membership = sharding.NewMemoryMembership()
+		counter = notifications.NewMemoryCounter()
+	} else {
+		shared = true
+		symbols = tracking.NewRedisRegistry(cache.RedisClient, tracking.DefaultRedisKey)
+		bus = events.NewBus(events.NewRedisTransport(cache.RedisClient, events.DefaultRedisPrefix, cfg.InstanceName))
+		lease = leader.NewRedisLease(cache.RedisClient, leader.DefaultRedisKey)
+		membership = sharding.NewRedisMembership(cache.RedisClient, sharding.DefaultRedisKey)
+		counter = notifications.NewRedisCounter(cache.RedisClient, notifications.DefaultCounterPrefix)
 	}
 
-	// Fetch stock prices in the background for user portfolios
-	go func() {
-		ctx := context.Background()
-		for {
-			// Get unique stocks from all user portfolios
-			stocks, err := database.GetAllUniqueStocks(ctx)
-			if err != nil {
-				log.Printf("Failed to fetch user stocks: %v", err)
-				time.Sleep(10 * time.Second)
-				continue
-			}
-
-			// If no stocks in any portfolio, wait and try again
-			if len(stocks) == 0 {
-				time.Sleep(10 * time.Second)
-				continue
-			}
-
-			// Update prices for all stocks in portfolios
-			for _, s := range stocks {
-				_, err := stock.FetchStockPrice(s.Symbol)
-				if err != nil {
-					log.Printf("Failed to fetch price for %s: %v", s.Symbol, err)
+	// Count the tracked symbols of a registry of our own at startup; from
+	// then on they are maintained as holdings and triggers come and go.
+	// Resetting a shared registry would lose the changes other instances
+	// make meanwhile, so it is rebuilt with cmd/rebuildtracking instead.
+	if !shared {
+		if err := tracking.Rebuild(context.Background(), symbols, store.ScanStocks); err != nil {
+			log.Fatalf("Failed to rebuild tracked symbols: %v", err)
+		}
+	}
+
+	calendar, err := marketcalendar.Default()
+	if err != nil {
+		log.Fatalf("Failed to load market calendar: %v", err)
+	}
+	marketWS := websocket.NewMarketWebSocket(calendar)
+	portfolioService := portfolio.NewService(store, symbols, bus)
+	authService := auth.NewService(store, bus)
+	triggerService := triggers.NewService(store, marketWS, symbols, bus)
+
+	// Trigger fires are delivered on each trigger's channels. Without a
+	// working mailer alerts still reach the app.
+	notifiers := []notifications.Notifier{notifications.NewWebSocketNotifier(bus)}
+	// Every email links to a signed one-click unsubscribe
+	links := notifications.NewUnsubscribeLinks(cfg.UnsubscribeSecret, cfg.PublicURL)
+	var emailService *notifications.EmailService
+	mailer, err := notifications.NewMailer(context.Background(), cfg)
+	if err != nil {
+		log.Printf("Note: Email notifications are disabled: %v", err)
+	} else {
+		emailService = notifications.NewEmailService(mailer)
+		emailService.Links = links
+		notifiers = append(notifiers, notifications.NewEmailNotifier(emailService))
+	}
+	// Webhook owners are emailed when their webhook is disabled, if email
+	// works
+	webhooks := notifications.NewWebhookNotifier(store, emailService)
+	webhooks.AllowHTTP = cfg.WebhookAllowHTTP
+	webhooks.AllowPrivate = cfg.WebhookAllowPrivate
+	notifiers = append(notifiers, webhooks,
+		notifications.NewSlackNotifier(cfg.AppURL),
+		notifications.NewDiscordNotifier(cfg.AppURL),
+		notifications.NewTelegramNotifier(cfg.AppURL))
+	// Browsers subscribe to push with the server's VAPID public key, so
+	// push needs the same key on every instance and across restarts
+	var push *notifications.PushNotifier
+	if cfg.VAPIDPrivateKey != "" {
+		keys, err := webpush.ParseVAPIDKeys(cfg.VAPIDPrivateKey)
+		if err != nil {
+			log.Fatalf("Invalid VAPID_PRIVATE_KEY: %v", err)
+		}
+		push = notifications.NewPushNotifier(store, webpush.NewSender(keys, cfg.VAPIDSubject), cfg.AppURL)
+		notifiers = append(notifiers, push)
+	}
+	// Texts go only to numbers their owner verified with a code, and both
+	// alerts and codes count towards each user's daily cap
+	var phones *notifications.PhoneVerifier
+	if cfg.SMSEnabled {
+		sender, err := notifications.NewSMSSender(cfg)
+		if err != nil {
+			log.Printf("Note: SMS notifications are disabled: %v", err)
+		} else {
+			limiter := notifications.NewSMSLimiter(counter, cfg.SMSDailyLimit)
+			notifiers = append(notifiers, notifications.NewSMSNotifier(sender, limiter))
+			phones = notifications.NewPhoneVerifier(store, sender, limiter)
+		}
+	}
+	// Every alert passes the same quiet hours, deduplication and rate limits
+	// before any channel sends it
+	dispatcher := notifications.NewDispatcher(notifiers...)
+	dispatcher.Policy = notifications.NewPolicy(store, counter)
+	dispatcher.Policy.UserLimit = cfg.NotifyUserHourlyLimit
+	dispatcher.Policy.ChannelLimit = cfg.NotifyChannelHourlyLimit
+	dispatcher.Policy.DedupeWindow = cfg.NotifyDedupeWindow
+	notificationService := notifications.NewService(store, dispatcher)
+	notificationService.MaxAttempts = cfg.NotifyMaxAttempts
+	go notificationService.RunOutbox(context.Background(), 5*time.Second)
+	go notificationService.RunReleases(context.Background(), time.Minute)
+
+	// Opted-in users are emailed a digest of their portfolio after their
+	// markets close. The leader checks, and claims keep each digest to one
+	// send across a change of leader.
+	var digester *notifications.Digester
+	if emailService != nil {
+		digester = notifications.NewDigester(store, calendar, marketData{}, emailService)
+		digester.SendHour = cfg.DigestSendHour
+	}
+
+	// Refresh each symbol as often as its market session, viewers and
+	// triggers call for
+	proximity := func(ctx context.Context, key tracking.Key, price float64) (float64, bool, error) {
+		return triggerService.ThresholdDistance(ctx, key.Symbol, key.Exchange, price)
+	}
+	// Symbols on exchanges the calendar doesn't know keep being polled as if
+	// they were trading
+	sessions := func(exchange string, at time.Time) marketcalendar.Session {
+		session, err := calendar.Session(exchange, at)
+		if err != nil {
+			return marketcalendar.SessionRegular
+		}
+		return session
+	}
+	sched := scheduler.New(symbols, sessions, proximity, scheduler.DefaultPolicy)
+
+	// With sharding every instance polls and evaluates its share of the
+	// symbols; otherwise one elected instance polls them all
+	var sharder *sharding.Sharder
+	var owns func(key string) bool
+	if cfg.ShardingEnabled {
+		// Each instance evaluates with its own trigger index, which only
+		// learns of triggers written elsewhere over a shared bus
+		if !shared {
+			log.Fatalf("SHARDING_ENABLED requires Redis to keep the trigger indexes of all instances in step")
+		}
+		sharder = sharding.NewSharder(membership, cfg.InstanceName)
+		owns = sharder.Owns
+		poller := &pricePoller{bus: bus}
+		// Symbols that move here are evaluated against their stored
+		// triggers, and those that move away are forgotten. Reloading runs
+		// off the heartbeat loop, one reshard at a time.
+		var resharding sync.Mutex
+		sharder.OnChange = func(ctx context.Context) {
+			go func() {
+				resharding.Lock()
+				defer resharding.Unlock()
+				poller.release(sharder.Owns)
+				if err := triggerService.Reshard(ctx, sharder.Owns); err != nil {
+					log.Printf("Failed to reload triggers after resharding: %v", err)
 				}
-			}
-			time.Sleep(10 * time.Second)
+			}()
 		}
+		go sharder.Run(context.Background())
+		go sched.Run(context.Background(), poller.fetch, owns)
+	}
+
+	// The elected instance runs the jobs that cover every user
+	elector := leader.NewElector(lease, cfg.InstanceName)
+	go elector.Run(context.Background(), func(ctx context.Context) {
+		var wg sync.WaitGroup
+		if digester != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				digester.Run(ctx, 5*time.Minute)
+			}()
+		}
+		if !cfg.ShardingEnabled {
+			// Each term as leader starts without previous prices
+			poller := &pricePoller{bus: bus}
+			sched.Run(ctx, poller.fetch, nil)
+		}
+		wg.Wait()
+	})
+
+	consuming, stopConsuming := context.WithCancel(context.Background())
+	if err := subscribe(consuming, bus, cfg, triggerService, notificationService, emailService, marketWS, sched, owns); err != nil {
+		log.Fatalf("Failed to subscribe to events: %v", err)
+	}
+
+	// The groups of this instance alone would otherwise be left on their
+	// streams, keeping the entries they never acknowledge
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		stopConsuming()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := unsubscribe(ctx, bus, cfg, owns); err != nil {
+			log.Printf("Failed to delete this instance's event groups: %v", err)
+		}
+		os.Exit(0)
 	}()
 
+	// Keep the trigger index in step with writes made by other instances
+	if cfg.StreamsEnabled {
+		db, ok := store.(*database.Database)
+		if !ok {
+			log.Fatalf("STREAMS_ENABLED requires the dynamodb storage backend")
+		}
+		if err := startStreams(context.Background(), cfg, db, triggerService); err != nil {
+			log.Fatalf("Failed to start stream consumer: %v", err)
+		}
+	}
+
 	// Start HTTP server
 	log.Println("Starting Stock Tracker Server...")
-	router.StartServer()
+	prefs := notifications.NewPreferences(store)
+	prefs.Email = emailService
+	router.StartServer(handler.NewHandler(authService, portfolioService, triggerService, symbols, calendar, phones, webhooks, push, prefs, notificationService, links, elector, sharder))
+}
+
+// pricePoller fetches prices for the scheduler and publishes each with the
+// one it fetched before for the symbol, so whichever instance evaluates the
+// update checks the whole move
+type pricePoller struct {
+	bus        *events.Bus
+	lastPrices sync.Map // tracking.Key -> float64
+}
+
+func (p *pricePoller) fetch(ctx context.Context, key tracking.Key) (float64, error) {
+	data, err := stock.FetchStockPrice(key.Symbol)
+	if err != nil {
+		return 0, err
+	}
+	update := events.PriceUpdated{Symbol: key.Symbol, Exchange: key.Exchange, Price: data.Price, At: time.Now()}
+	if prev, ok := p.lastPrices.Swap(key, data.Price); ok {
+		update.PrevPrice = prev.(float64)
+	}
+	if err := p.bus.Publish(ctx, update); err != nil {
+		log.Printf("Failed to publish price for %s: %v", key.Symbol, err)
+	}
+	return data.Price, nil
+}
+
+// release forgets the prices of symbols polled elsewhere now, which other
+// instances have moved on from
+func (p *pricePoller) release(owns func(key string) bool) {
+	p.lastPrices.Range(func(key, _ any) bool {
+		if !owns(key.(tracking.Key).String()) {
+			p.lastPrices.Delete(key)
+		}
+		return true
+	})
+}
+
+// subscribe connects the services to the events they react to
+func subscribe(ctx context.Context, bus *events.Bus, cfg *config.Config, triggerService *triggers.Service, notificationService *notifications.Service, emailService *notifications.EmailService, marketWS *websocket.MarketWebSocket, sched *scheduler.Scheduler, owns func(key string) bool) error {
+	// Any instance may evaluate a symbol's prices, so every instance gets
+	// its own group and applies every trigger write to its index
+	err := events.Subscribe(ctx, bus, "trigger-sync:"+cfg.InstanceName, triggerService.ApplyTriggerChange)
+	if err != nil {
+		return err
+	}
+
+	// One instance evaluates each price update against the triggers. When
+	// sharded, every instance sees every update and evaluates its own.
+	group := "triggers"
+	if owns != nil {
+		group = "triggers:" + cfg.InstanceName
+	}
+	err = events.Subscribe(ctx, bus, group, func(ctx context.Context, e events.PriceUpdated) error {
+		key := tracking.Key{Symbol: e.Symbol, Exchange: e.Exchange}
+		if owns != nil && !owns(key.String()) {
+			return nil
+		}
+		return triggerService.UpdatePriceFrom(ctx, e.Symbol, e.Exchange, e.PrevPrice, e.Price)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Whichever instance polls a symbol needs to know it is being watched
+	err = events.Subscribe(ctx, bus, "scheduler:"+cfg.InstanceName, func(ctx context.Context, e events.StocksViewed) error {
+		for _, key := range e.Symbols {
+			sched.MarkViewed(tracking.ParseKey(key), e.At)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Fires go out as soon as they are announced, by whichever instance
+	// takes the announcement; the outbox catches any that are lost
+	err = events.Subscribe(ctx, bus, "notifications", notificationService.DeliverTriggerFire)
+	if err != nil {
+		return err
+	}
+
+	// New accounts have email on, so mailers that deliver through
+	// subscriptions need them subscribed
+	if emailService != nil {
+		err = events.Subscribe(ctx, bus, "email-subscriptions", func(ctx context.Context, e events.UserSignedUp) error {
+			return emailService.SubscribeUser(ctx, notifications.Recipient{UserID: e.UserID, Email: e.Email})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Users may be connected to any instance, so every instance gets its
+	// own group and pushes to the sockets it holds
+	return events.Subscribe(ctx, bus, "websocket:"+cfg.InstanceName, func(ctx context.Context, e events.SocketPush) error {
+		if err := marketWS.SendMessage(e.UserID, e.Payload); err != nil {
+			log.Printf("Not pushing to websocket: %v", err)
+		}
+		return nil
+	})
+}
+
+// unsubscribe deletes the groups subscribe created for this instance alone
+func unsubscribe(ctx context.Context, bus *events.Bus, cfg *config.Config, owns func(key string) bool) error {
+	err := errors.Join(
+		events.Unsubscribe[events.TriggerChanged](ctx, bus, "trigger-sync:"+cfg.InstanceName),
+		events.Unsubscribe[events.StocksViewed](ctx, bus, "scheduler:"+cfg.InstanceName),
+		events.Unsubscribe[events.SocketPush](ctx, bus, "websocket:"+cfg.InstanceName),
+	)
+	if owns != nil {
+		err = errors.Join(err, events.Unsubscribe[events.PriceUpdated](ctx, bus, "triggers:"+cfg.InstanceName))
+	}
+	return err
+}
+
+// marketData serves digests prices and earnings dates from TwelveData
+type marketData struct{}
+
+func (marketData) Quote(ctx context.Context, symbol string) (notifications.Quote, error) {
+	details, err := stock.FetchStockDetails(symbol)
+	if err != nil {
+		return notifications.Quote{}, err
+	}
+	price, err := details.Price.Float64()
+	if err != nil {
+		return notifications.Quote{}, fmt.Errorf("invalid price for %s: %v", symbol, err)
+	}
+	// Without a change the previous close is unknown
+	change, err := details.Change.Float64()
+	if err != nil {
+		return notifications.Quote{Price: price}, nil
+	}
+	return notifications.Quote{Price: price, PrevClose: price - change}, nil
+}
+
+func (marketData) Earnings(ctx context.Context, symbol string, from, to time.Time) ([]time.Time, error) {
+	return stock.FetchEarnings(symbol, from, to)
+}
+
+// openStore opens the storage backend selected by STORAGE_BACKEND and checks
+// that its schema is up to date
+func openStore(cfg *config.Config) (database.Store, error) {
+	ctx := context.Background()
+
+	switch cfg.StorageBackend {
+	case "memory":
+		log.Println("Using in-memory storage; data will be lost on restart")
+		return memory.NewStore(), nil
+	case "sqlite", "postgres":
+		log.Printf("Using %s storage", cfg.StorageBackend)
+		store, err := sqlstore.Open(ctx, sqlstore.Dialect(cfg.StorageBackend), cfg.DatabaseURL)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AutoMigrate {
+			err = store.Migrate(ctx)
+		} else {
+			err = store.CheckMigrations(ctx)
+		}
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	default:
+		return database.InitDynamoDB(ctx, cfg)
+	}
+}
+
+// startStreams consumes the Stocks and Triggers table streams in the
+// background, applying trigger changes to the trigger index
+func startStreams(ctx context.Context, cfg *config.Config, db *database.Database, triggerService *triggers.Service) error {
+	client, err := streams.NewClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	tables := db.Tables()
+	stocksARN, err := db.StreamARN(ctx, tables.Stocks)
+	if err != nil {
+		return err
+	}
+	triggersARN, err := db.StreamARN(ctx, tables.Triggers)
+	if err != nil {
+		return err
+	}
+
+	handle := func(ctx context.Context, event streams.Event) error {
+		switch event.Type {
+		case streams.TriggerCreated, streams.TriggerChanged, streams.TriggerDeleted:
+			triggerService.SyncTrigger(event.OldTrigger, event.NewTrigger)
+		}
+		return nil
+	}
+
+	checkpoints := streams.NewDynamoCheckpointer(db.Client(), tables.StreamCheckpoints, cfg.StreamConsumerName)
+	consumer := streams.NewConsumer(client, checkpoints, handle,
+		streams.Stream{ARN: stocksARN, Kind: streams.StocksTable},
+		streams.Stream{ARN: triggersARN, Kind: streams.TriggersTable},
+	)
+	// The index reads each symbol's triggers from the table when it first
+	// needs them, so a new consumer has no use for the changes before it
+	consumer.StartAtLatest = true
+	go consumer.Run(ctx)
+
+	log.Printf("Consuming table streams as %s", cfg.StreamConsumerName)
+	return nil
 }
@@ -0,0 +1,22 @@
+// Command vapidkeys generates the key pair the server signs Web Push
+// messages with. Set VAPID_PRIVATE_KEY to the private key; browsers are
+// given the public key by the server.
+//
+//	go run ./cmd/vapidkeys
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"stockmarket/server/internal/notifications/webpush"
+)
+
+func main() {
+	keys, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("VAPID_PRIVATE_KEY=%s\n", keys.PrivateKey())
+	fmt.Printf("# Public key: %s\n", keys.PublicKey())
+}
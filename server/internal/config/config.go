@@ -3,12 +3,19 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds all configuration for the application
 type Config struct {
 	// Server configuration
-	Port string
+	Port         string
+	InstanceName string // Identifies this instance to its peers; the hostname by default
+	AppURL       string // Where users open the app, linked to from chat alerts
+
+	// Poller configuration
+	ShardingEnabled bool // Split symbols between instances instead of electing one poller
 
 	// JWT configuration
 	JWTSecret string
@@ -19,9 +26,24 @@ type Config struct {
 	AWSSecretAccessKey string
 
 	// Database configuration
-	UsersTable    string
-	StocksTable   string
-	TriggersTable string
+	StorageBackend          string // dynamodb, postgres, sqlite or memory
+	DatabaseURL             string // DSN for postgres, file path for sqlite
+	UsersTable              string
+	StocksTable             string
+	TriggersTable           string
+	UserStockTriggersTable  string
+	MigrationsTable         string
+	StreamCheckpointsTable  string
+	TriggerFiresTable       string
+	WebhooksTable           string
+	WebhookDeadLettersTable string
+	DigestClaimsTable       string
+	ReleaseClaimsTable      string
+	PushSubscriptionsTable  string
+	DynamoDBEndpoint        string // Overrides the AWS endpoint, e.g. for DynamoDB Local
+	AutoMigrate             bool   // Apply pending migrations at startup instead of failing
+	StreamsEnabled          bool   // Consume the DynamoDB Streams of the Stocks and Triggers tables
+	StreamConsumerName      string // Names this instance's stream checkpoints
 
 	// Redis configuration
 	RedisHost     string
@@ -32,24 +54,127 @@ type Config struct {
 
 	// SNS configuration
 	SNSTopicName string
+
+	// Email configuration
+	EmailBackend string // smtp or sns
+	EmailFrom    string
+	SMTPAddr     string // host:port of the SMTP relay
+	SMTPUsername string
+	SMTPPassword string
+	PublicURL    string // Where the API is reached from outside, linked to from emails to unsubscribe
+	// Signs unsubscribe links; JWT_SECRET if not set. Changing it breaks
+	// the links in emails already sent.
+	UnsubscribeSecret string
+
+	// SMS configuration
+	SMSEnabled    bool   // Text alerts to verified phone numbers through SNS
+	SMSDailyLimit int    // Texts per user per day, verification codes included
+	SMSSenderID   string // Sender name shown where carriers allow it
+
+	// Webhook configuration
+	WebhookAllowHTTP    bool // Accept plain http webhook URLs, e.g. on a private network
+	WebhookAllowPrivate bool // Post webhooks to loopback, private and link-local addresses
+
+	// Web Push configuration. Push is off without a private key; changing
+	// it invalidates every browser's subscription.
+	VAPIDPrivateKey string // base64url P-256 scalar, as go run ./cmd/vapidkeys prints
+	VAPIDSubject    string // mailto: or https: URL push services can contact the operator at
+
+	// Limits on alerts, across channels and on each one. 0 turns a limit
+	// off.
+	NotifyUserHourlyLimit    int           // Alerts per user per hour
+	NotifyChannelHourlyLimit int           // Alerts per user per hour on any one channel
+	NotifyDedupeWindow       time.Duration // Identical alerts within it are sent once
+	NotifyMaxAttempts        int           // Attempts at each trigger fire before failed channels are dead-lettered
+
+	// Digest configuration
+	DigestSendHour int // Hour of the day in each user's timezone portfolio digests go out from
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() (*Config, error) {
 	config := &Config{
-		Port:               getEnvOrDefault("PORT", "8080"),
-		JWTSecret:          getEnvOrDefault("JWT_SECRET", ""),
-		AWSRegion:          getEnvOrDefault("AWS_REGION", "ap-south-1"),
-		AWSAccessKeyID:     getEnvOrDefault("AWS_ACCESS_KEY_ID", ""),
-		AWSSecretAccessKey: getEnvOrDefault("AWS_SECRET_ACCESS_KEY", ""),
-		UsersTable:         getEnvOrDefault("USERS_TABLE", "Users"),
-		StocksTable:        getEnvOrDefault("STOCKS_TABLE", "Stocks"),
-		TriggersTable:      getEnvOrDefault("TRIGGERS_TABLE", "Triggers"),
-		RedisHost:          getEnvOrDefault("REDIS_HOST", "localhost:6379"),
-		RedisPassword:      getEnvOrDefault("REDIS_PASSWORD", ""),
-		TwelveDataAPIKey:   getEnvOrDefault("TWELVEDATA_API_KEY", ""),
-		SNSTopicName:       getEnvOrDefault("SNS_TOPIC_NAME", "stock-market-alerts"),
+		Port:                    getEnvOrDefault("PORT", "8080"),
+		AppURL:                  getEnvOrDefault("APP_URL", ""),
+		JWTSecret:               getEnvOrDefault("JWT_SECRET", ""),
+		AWSRegion:               getEnvOrDefault("AWS_REGION", "ap-south-1"),
+		AWSAccessKeyID:          getEnvOrDefault("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey:      getEnvOrDefault("AWS_SECRET_ACCESS_KEY", ""),
+		StorageBackend:          getEnvOrDefault("STORAGE_BACKEND", "dynamodb"),
+		DatabaseURL:             getEnvOrDefault("DATABASE_URL", "stockmarket.db"),
+		UsersTable:              getEnvOrDefault("USERS_TABLE", "Users"),
+		StocksTable:             getEnvOrDefault("STOCKS_TABLE", "Stocks"),
+		TriggersTable:           getEnvOrDefault("TRIGGERS_TABLE", "Triggers"),
+		UserStockTriggersTable:  getEnvOrDefault("USER_STOCK_TRIGGERS_TABLE", "UserStockTriggers"),
+		MigrationsTable:         getEnvOrDefault("MIGRATIONS_TABLE", "SchemaMigrations"),
+		StreamCheckpointsTable:  getEnvOrDefault("STREAM_CHECKPOINTS_TABLE", "StreamCheckpoints"),
+		TriggerFiresTable:       getEnvOrDefault("TRIGGER_FIRES_TABLE", "TriggerFires"),
+		WebhooksTable:           getEnvOrDefault("WEBHOOKS_TABLE", "Webhooks"),
+		WebhookDeadLettersTable: getEnvOrDefault("WEBHOOK_DEAD_LETTERS_TABLE", "WebhookDeadLetters"),
+		DigestClaimsTable:       getEnvOrDefault("DIGEST_CLAIMS_TABLE", "DigestClaims"),
+		ReleaseClaimsTable:      getEnvOrDefault("RELEASE_CLAIMS_TABLE", "ReleaseClaims"),
+		PushSubscriptionsTable:  getEnvOrDefault("PUSH_SUBSCRIPTIONS_TABLE", "PushSubscriptions"),
+		DynamoDBEndpoint:        getEnvOrDefault("DYNAMODB_ENDPOINT", ""),
+		RedisHost:               getEnvOrDefault("REDIS_HOST", "localhost:6379"),
+		RedisPassword:           getEnvOrDefault("REDIS_PASSWORD", ""),
+		TwelveDataAPIKey:        getEnvOrDefault("TWELVEDATA_API_KEY", ""),
+		SNSTopicName:            getEnvOrDefault("SNS_TOPIC_NAME", "stock-market-alerts"),
+		EmailBackend:            getEnvOrDefault("EMAIL_BACKEND", "sns"),
+		EmailFrom:               getEnvOrDefault("EMAIL_FROM", "alerts@stockmarket.local"),
+		SMTPAddr:                getEnvOrDefault("SMTP_ADDR", "localhost:1025"),
+		SMTPUsername:            getEnvOrDefault("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnvOrDefault("SMTP_PASSWORD", ""),
+		PublicURL:               getEnvOrDefault("PUBLIC_URL", "http://localhost:8080"),
+		SMSEnabled:              getEnvOrDefault("SMS_ENABLED", "false") == "true",
+		SMSSenderID:             getEnvOrDefault("SMS_SENDER_ID", ""),
+		WebhookAllowHTTP:        getEnvOrDefault("WEBHOOK_ALLOW_HTTP", "false") == "true",
+		WebhookAllowPrivate:     getEnvOrDefault("WEBHOOK_ALLOW_PRIVATE", "false") == "true",
+		VAPIDPrivateKey:         getEnvOrDefault("VAPID_PRIVATE_KEY", ""),
+		StreamsEnabled:          getEnvOrDefault("STREAMS_ENABLED", "false") == "true",
+		ShardingEnabled:         getEnvOrDefault("SHARDING_ENABLED", "false") == "true",
+	}
+
+	// Each instance keeps its own in-memory state in sync, so by default
+	// each reads the streams under its own name
+	hostname, _ := os.Hostname()
+	config.InstanceName = getEnvOrDefault("INSTANCE_NAME", hostname)
+	config.StreamConsumerName = getEnvOrDefault("STREAM_CONSUMER_NAME", config.InstanceName)
+	config.UnsubscribeSecret = getEnvOrDefault("UNSUBSCRIBE_SECRET", config.JWTSecret)
+	config.VAPIDSubject = getEnvOrDefault("VAPID_SUBJECT", "mailto:"+config.EmailFrom)
+
+	smsDailyLimit, err := strconv.Atoi(getEnvOrDefault("SMS_DAILY_LIMIT", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("SMS_DAILY_LIMIT must be an integer: %v", err)
+	}
+	config.SMSDailyLimit = smsDailyLimit
+
+	if config.NotifyUserHourlyLimit, err = strconv.Atoi(getEnvOrDefault("NOTIFY_USER_HOURLY_LIMIT", "30")); err != nil {
+		return nil, fmt.Errorf("NOTIFY_USER_HOURLY_LIMIT must be an integer: %v", err)
+	}
+	if config.NotifyChannelHourlyLimit, err = strconv.Atoi(getEnvOrDefault("NOTIFY_CHANNEL_HOURLY_LIMIT", "10")); err != nil {
+		return nil, fmt.Errorf("NOTIFY_CHANNEL_HOURLY_LIMIT must be an integer: %v", err)
+	}
+	if config.NotifyDedupeWindow, err = time.ParseDuration(getEnvOrDefault("NOTIFY_DEDUPE_WINDOW", "10m")); err != nil {
+		return nil, fmt.Errorf("NOTIFY_DEDUPE_WINDOW must be a duration such as 10m: %v", err)
+	}
+	notifyMaxAttempts, err := strconv.Atoi(getEnvOrDefault("NOTIFY_MAX_ATTEMPTS", "8"))
+	if err != nil || notifyMaxAttempts < 1 {
+		return nil, fmt.Errorf("NOTIFY_MAX_ATTEMPTS must be a positive integer")
 	}
+	config.NotifyMaxAttempts = notifyMaxAttempts
+
+	digestSendHour, err := strconv.Atoi(getEnvOrDefault("DIGEST_SEND_HOUR", "18"))
+	if err != nil || digestSendHour < 0 || digestSendHour > 23 {
+		return nil, fmt.Errorf("DIGEST_SEND_HOUR must be an hour from 0 to 23")
+	}
+	config.DigestSendHour = digestSendHour
+
+	// Local SQLite databases are migrated on startup unless told otherwise
+	autoMigrateDefault := "false"
+	if config.StorageBackend == "sqlite" {
+		autoMigrateDefault = "true"
+	}
+	config.AutoMigrate = getEnvOrDefault("AUTO_MIGRATE", autoMigrateDefault) == "true"
 
 	// Validate required fields
 	if err := config.validate(); err != nil {
@@ -62,10 +187,28 @@ func LoadConfig() (*Config, error) {
 // validate checks if all required configuration values are set
 func (c *Config) validate() error {
 	required := map[string]string{
-		"JWT_SECRET":            c.JWTSecret,
-		"AWS_ACCESS_KEY_ID":     c.AWSAccessKeyID,
-		"AWS_SECRET_ACCESS_KEY": c.AWSSecretAccessKey,
-		"TWELVEDATA_API_KEY":    c.TwelveDataAPIKey,
+		"JWT_SECRET":         c.JWTSecret,
+		"TWELVEDATA_API_KEY": c.TwelveDataAPIKey,
+	}
+
+	switch c.StorageBackend {
+	case "dynamodb":
+		// AWS credentials are only needed when storing data in DynamoDB
+		required["AWS_ACCESS_KEY_ID"] = c.AWSAccessKeyID
+		required["AWS_SECRET_ACCESS_KEY"] = c.AWSSecretAccessKey
+	case "postgres":
+		if os.Getenv("DATABASE_URL") == "" {
+			return fmt.Errorf("required environment variable DATABASE_URL is not set")
+		}
+	case "sqlite", "memory":
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", c.StorageBackend)
+	}
+
+	switch c.EmailBackend {
+	case "smtp", "sns":
+	default:
+		return fmt.Errorf("unknown EMAIL_BACKEND %q", c.EmailBackend)
 	}
 
 	for name, value := range required {
@@ -1,17 +1,194 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"stockmarket/server/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-// Database represents the database client
+// maxTransactionAttempts bounds how often a transaction is retried after a
+// condition check fails because of a concurrent write
+const maxTransactionAttempts = 3
+
+// maxTransactItems is the most items DynamoDB accepts in one transaction
+const maxTransactItems = 100
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrConflict is returned when a conditional write fails because the record
+// was changed by someone else since it was read. Callers can re-read and
+// retry, or surface it as a 409.
+var ErrConflict = errors.New("record was modified concurrently")
+
+// DynamoDBAPI is the subset of the DynamoDB client used by Database
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+// Tables holds the names of the DynamoDB tables
+type Tables struct {
+	Users              string
+	Stocks             string
+	Triggers           string
+	UserStockTriggers  string
+	Migrations         string // Records applied schema migrations
+	StreamCheckpoints  string // Records how far each stream shard has been read
+	TriggerFires       string // Records each trigger fire and its deliveries
+	Webhooks           string
+	WebhookDeadLetters string // Webhook deliveries that failed for good
+	DigestClaims       string // Records the digests sent to each user
+	ReleaseClaims      string // Records the releases of held alerts being sent
+	PushSubscriptions  string // Browsers subscribed to Web Push alerts
+}
+
+// TablesFromConfig returns the table names configured in cfg
+func TablesFromConfig(cfg *config.Config) Tables {
+	return Tables{
+		Users:              cfg.UsersTable,
+		Stocks:             cfg.StocksTable,
+		Triggers:           cfg.TriggersTable,
+		UserStockTriggers:  cfg.UserStockTriggersTable,
+		Migrations:         cfg.MigrationsTable,
+		StreamCheckpoints:  cfg.StreamCheckpointsTable,
+		TriggerFires:       cfg.TriggerFiresTable,
+		Webhooks:           cfg.WebhooksTable,
+		WebhookDeadLetters: cfg.WebhookDeadLettersTable,
+		DigestClaims:       cfg.DigestClaimsTable,
+		ReleaseClaims:      cfg.ReleaseClaimsTable,
+		PushSubscriptions:  cfg.PushSubscriptionsTable,
+	}
+}
+
+// Database is the DynamoDB implementation of Store
 type Database struct {
-	client *dynamodb.Client
+	client DynamoDBAPI
+	tables Tables
 }
 
 // NewDatabase creates a new database client
-func NewDatabase(client *dynamodb.Client) *Database {
+func NewDatabase(client DynamoDBAPI, tables Tables) *Database {
 	return &Database{
 		client: client,
+		tables: tables,
+	}
+}
+
+// stockKey returns the primary key of a stock item
+func stockKey(userID, stockID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"user_id":  &types.AttributeValueMemberS{Value: userID},
+		"stock_id": &types.AttributeValueMemberS{Value: stockID},
+	}
+}
+
+// timeValue encodes a time the same way attributevalue marshals struct fields
+func timeValue(t time.Time) types.AttributeValue {
+	av, err := attributevalue.Marshal(t)
+	if err != nil {
+		return &types.AttributeValueMemberS{Value: t.Format(time.RFC3339Nano)}
+	}
+	return av
+}
+
+// versionCondition returns a condition expression, with its values, that
+// holds only while the stored item identified by keyAttr is at version
+// expected. Items written before versioning have no version attribute and
+// are treated as version 0.
+func versionCondition(keyAttr string, expected int64) (string, map[string]types.AttributeValue) {
+	if expected == 0 {
+		return "attribute_exists(" + keyAttr + ") AND (attribute_not_exists(version) OR version = :expected)",
+			map[string]types.AttributeValue{":expected": numberValue(0)}
 	}
+	return "version = :expected", map[string]types.AttributeValue{":expected": numberValue(expected)}
+}
+
+// numberValue encodes an integer as a DynamoDB number
+func numberValue(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
 }
+
+// cursorFromKey encodes a LastEvaluatedKey as a page cursor. Only string key
+// attributes are supported, which covers every table and index here.
+func cursorFromKey(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	values := make(map[string]string, len(key))
+	for attr, av := range key {
+		s, ok := av.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("unsupported key attribute %s in page cursor", attr)
+		}
+		values[attr] = s.Value
+	}
+	return EncodeCursor(values), nil
+}
+
+// keyFromCursor decodes a page cursor into an ExclusiveStartKey, checking it
+// against scope. An empty cursor yields a nil key.
+func keyFromCursor(cursor string, scope map[string]string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	values, err := DecodeCursor(cursor, scope)
+	if err != nil {
+		return nil, err
+	}
+	key := make(map[string]types.AttributeValue, len(values))
+	for attr, v := range values {
+		key[attr] = &types.AttributeValueMemberS{Value: v}
+	}
+	return key, nil
+}
+
+// indexOf returns the position of id in ids, or -1
+func indexOf(ids []string, id string) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// isConditionFailure reports whether err is a failed condition expression,
+// either on a single write or on any item of a transaction
+func isConditionFailure(err error) bool {
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		return true
+	}
+
+	var txErr *types.TransactionCanceledException
+	if errors.As(err, &txErr) {
+		for _, reason := range txErr.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var _ Store = (*Database)(nil)
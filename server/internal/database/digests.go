@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ClaimDigest records that a digest is about to be sent. It fails with
+// ErrConflict if the digest was claimed before.
+func (db *Database) ClaimDigest(ctx context.Context, claim *models.DigestClaim) error {
+	item, err := attributevalue.MarshalMap(claim)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest claim: %v", err)
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tables.DigestClaims),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(digest_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("digest %s of %s already claimed: %w", claim.DigestID, claim.UserID, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim digest: %v", err)
+	}
+	return nil
+}
+
+// ReleaseDigest drops a digest claim
+func (db *Database) ReleaseDigest(ctx context.Context, userID, digestID string) error {
+	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tables.DigestClaims),
+		Key: map[string]types.AttributeValue{
+			"user_id":   &types.AttributeValueMemberS{Value: userID},
+			"digest_id": &types.AttributeValueMemberS{Value: digestID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release digest: %v", err)
+	}
+	return nil
+}
@@ -3,9 +3,9 @@ package database
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
+	appconfig "stockmarket/server/internal/config"
 	"stockmarket/server/internal/models"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,197 +15,147 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-var db *dynamodb.Client
-
-// InitDynamoDB initializes the DynamoDB client and ensures table exists
-func InitDynamoDB() error {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+// InitDynamoDB initializes the DynamoDB client and checks that every schema
+// migration has been applied, applying pending ones if cfg.AutoMigrate is set
+func InitDynamoDB(ctx context.Context, cfg *appconfig.Config) (*Database, error) {
+	client, err := NewDynamoDBClient(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("unable to load SDK config: %v", err)
+		return nil, err
 	}
 
-	db = dynamodb.NewFromConfig(cfg)
+	db := NewDatabase(client, TablesFromConfig(cfg))
+	migrator := NewMigrator(db)
 
-	// Ensure table exists
-	if err := ensureTableExists(); err != nil {
-		return fmt.Errorf("failed to ensure table exists: %v", err)
+	if cfg.AutoMigrate {
+		if err := migrator.Up(ctx); err != nil {
+			return nil, fmt.Errorf("failed to migrate DynamoDB tables: %v", err)
+		}
+		return db, nil
 	}
 
-	return nil
-}
-
-// ensureTableExists creates the Users and Stocks tables if they don't exist
-func ensureTableExists() error {
-	// Create Users table
-	if err := ensureUsersTableExists(); err != nil {
-		return fmt.Errorf("failed to ensure Users table exists: %v", err)
+	if err := migrator.Check(ctx); err != nil {
+		return nil, err
 	}
-
-	// Create Stocks table
-	if err := ensureStocksTableExists(); err != nil {
-		return fmt.Errorf("failed to ensure Stocks table exists: %v", err)
-	}
-
-	return nil
+	return db, nil
 }
 
-// ensureUsersTableExists creates the Users table if it doesn't exist
-func ensureUsersTableExists() error {
-	tableName := os.Getenv("USERS_TABLE")
-	if tableName == "" {
-		return fmt.Errorf("USERS_TABLE environment variable not set")
-	}
-
-	// Check if table exists
-	_, err := db.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	})
-	if err == nil {
-		// Table exists
-		return nil
-	}
-
-	// Create table
-	_, err = db.CreateTable(context.Background(), &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("email"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("email"),
-				KeyType:       types.KeyTypeHash,
-			},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create Users table: %v", err)
-	}
-
-	// Wait for table to be active
-	waiter := dynamodb.NewTableExistsWaiter(db)
-	err = waiter.Wait(context.Background(),
-		&dynamodb.DescribeTableInput{TableName: aws.String(tableName)},
-		2*time.Minute)
+// NewDynamoDBClient creates a DynamoDB client for the configured region and
+// endpoint
+func NewDynamoDBClient(ctx context.Context, cfg *appconfig.Config) (*dynamodb.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
 	if err != nil {
-		return fmt.Errorf("timeout waiting for Users table creation: %v", err)
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
 	}
 
-	return nil
+	return dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	}), nil
 }
 
-// ensureStocksTableExists creates the Stocks table if it doesn't exist
-func ensureStocksTableExists() error {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return fmt.Errorf("STOCKS_TABLE environment variable not set")
-	}
+// Tables returns the names of the tables the database uses
+func (db *Database) Tables() Tables {
+	return db.tables
+}
 
-	// Check if table exists
-	_, err := db.DescribeTable(context.Background(), &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	})
-	if err == nil {
-		// Table exists
-		return nil
-	}
+// Client returns the underlying DynamoDB client
+func (db *Database) Client() DynamoDBAPI {
+	return db.client
+}
 
-	// Create table with composite key (user_id as partition key, stock_id as sort key)
-	_, err = db.CreateTable(context.Background(), &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("user_id"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			{
-				AttributeName: aws.String("stock_id"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("user_id"),
-				KeyType:       types.KeyTypeHash,
-			},
-			{
-				AttributeName: aws.String("stock_id"),
-				KeyType:       types.KeyTypeRange,
-			},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-	})
+// StreamARN returns the ARN of a table's latest stream
+func (db *Database) StreamARN(ctx context.Context, table string) (string, error) {
+	out, err := db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
 	if err != nil {
-		return fmt.Errorf("failed to create Stocks table: %v", err)
+		return "", fmt.Errorf("failed to describe table %s: %v", table, err)
 	}
-
-	// Wait for table to be active
-	waiter := dynamodb.NewTableExistsWaiter(db)
-	err = waiter.Wait(context.Background(),
-		&dynamodb.DescribeTableInput{TableName: aws.String(tableName)},
-		2*time.Minute)
-	if err != nil {
-		return fmt.Errorf("timeout waiting for Stocks table creation: %v", err)
+	if out.Table.LatestStreamArn == nil {
+		return "", fmt.Errorf("table %s has no stream", table)
 	}
-
-	return nil
+	return *out.Table.LatestStreamArn, nil
 }
 
-func SaveUser(ctx context.Context, user models.User) error {
-	tableName := os.Getenv("USERS_TABLE")
-	if tableName == "" {
-		fmt.Println("DEBUG: USERS_TABLE environment variable is not set")
-		return fmt.Errorf("USERS_TABLE environment variable not set")
-	}
-
-	fmt.Printf("DEBUG: Using table name: %s\n", tableName)
+// CreateUser creates a new user. It fails with ErrConflict if a user with the
+// same email already exists.
+func (db *Database) CreateUser(ctx context.Context, user *models.User) error {
+	user.Version = 1
 
 	// Marshal the user object to DynamoDB attribute map
 	av, err := attributevalue.MarshalMap(user)
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to marshal user data: %+v\n", err)
 		return fmt.Errorf("failed to marshal user data: %v", err)
 	}
-	fmt.Printf("DEBUG: Marshalled user data: %+v\n", av)
 
 	// Perform the PutItem operation to save the user into DynamoDB
-	output, err := db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      av,
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tables.Users),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(email)"),
 	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("user %s already exists: %w", user.Email, ErrConflict)
+	}
 	if err != nil {
-		fmt.Printf("DEBUG: Failed to save user to DynamoDB: %+v\n", err)
 		return fmt.Errorf("failed to save user to DynamoDB: %v", err)
 	}
 
-	fmt.Printf("DEBUG: Successfully saved user. DynamoDB response: %+v\n", output)
 	return nil
 }
 
 // GetUserByEmail retrieves a user from DynamoDB based on the email.
-func GetUserByEmail(ctx context.Context, email string) (models.User, error) {
-	var user models.User
-
+func (db *Database) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	key := map[string]types.AttributeValue{
 		"email": &types.AttributeValueMemberS{Value: email},
 	}
 
-	result, err := db.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(os.Getenv("USERS_TABLE")),
+	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.tables.Users),
 		Key:       key,
 	})
-	if err != nil || result.Item == nil {
-		return user, fmt.Errorf("user not found")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("user %s: %w", email, ErrNotFound)
 	}
 
+	var user models.User
 	err = attributevalue.UnmarshalMap(result.Item, &user)
 	if err != nil {
-		return user, fmt.Errorf("failed to unmarshal result: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal result: %v", err)
+	}
+
+	return &user, nil
+}
+
+// UpdateUser replaces a user. The write only succeeds if the stored user is
+// still at user.Version; otherwise ErrConflict is returned.
+func (db *Database) UpdateUser(ctx context.Context, user *models.User) error {
+	expected := user.Version
+	user.UpdatedAt = time.Now()
+	user.Version = expected + 1
+
+	av, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		user.Version = expected
+		return fmt.Errorf("failed to marshal user data: %v", err)
+	}
+
+	condition, values := versionCondition("email", expected)
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(db.tables.Users),
+		Item:                      av,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		user.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("user %s: %w", user.Email, ErrConflict)
+		}
+		return fmt.Errorf("failed to update user: %v", err)
 	}
 
-	return user, nil
+	return nil
 }
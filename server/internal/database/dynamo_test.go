@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/storetest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// TestDynamoDBContract runs against DynamoDB Local (or another endpoint) at
+// DYNAMODB_TEST_ENDPOINT, e.g. http://localhost:8000
+func TestDynamoDBContract(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_TEST_ENDPOINT not set")
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+	)
+	if err != nil {
+		t.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	n := 0
+	storetest.Run(t, func(t *testing.T) database.Store {
+		// Every subtest gets its own tables, provisioned by the migrations
+		n++
+		suffix := fmt.Sprintf("_%d_%d", time.Now().UnixNano(), n)
+		db := database.NewDatabase(client, database.Tables{
+			Users:              "Users" + suffix,
+			Stocks:             "Stocks" + suffix,
+			Triggers:           "Triggers" + suffix,
+			UserStockTriggers:  "UserStockTriggers" + suffix,
+			Migrations:         "SchemaMigrations" + suffix,
+			StreamCheckpoints:  "StreamCheckpoints" + suffix,
+			TriggerFires:       "TriggerFires" + suffix,
+			Webhooks:           "Webhooks" + suffix,
+			WebhookDeadLetters: "WebhookDeadLetters" + suffix,
+			DigestClaims:       "DigestClaims" + suffix,
+			ReleaseClaims:      "ReleaseClaims" + suffix,
+			PushSubscriptions:  "PushSubscriptions" + suffix,
+		})
+		migrator := database.NewMigrator(db)
+		if err := migrator.Up(ctx); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if err := migrator.Check(ctx); err != nil {
+			t.Fatalf("Check after Up: %v", err)
+		}
+		return db
+	})
+}
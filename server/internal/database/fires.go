@@ -0,0 +1,311 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SaveTriggerFire stores a fire with its deliveries, replacing any earlier
+// record of it
+func (db *Database) SaveTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	item, err := marshalFire(fire)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.tables.TriggerFires),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save trigger fire: %v", err)
+	}
+	return nil
+}
+
+// UpdateTriggerFire replaces a fire if it is still at the version it was
+// read at
+func (db *Database) UpdateTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	expected := fire.Version
+	fire.Version = expected + 1
+
+	item, err := marshalFire(fire)
+	if err != nil {
+		fire.Version = expected
+		return err
+	}
+
+	condition, values := versionCondition("fire_id", expected)
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(db.tables.TriggerFires),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		fire.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("trigger fire %s: %w", fire.FireID, ErrConflict)
+		}
+		return fmt.Errorf("failed to update trigger fire: %v", err)
+	}
+	return nil
+}
+
+// FireTrigger replaces a fired trigger and puts its fire in a single
+// transaction
+func (db *Database) FireTrigger(ctx context.Context, trigger *models.StockTrigger, fire *models.TriggerFire) error {
+	expected := trigger.Version
+	trigger.UpdatedAt = time.Now()
+	trigger.Version = expected + 1
+
+	triggerItem, err := attributevalue.MarshalMap(trigger)
+	if err != nil {
+		trigger.Version = expected
+		return err
+	}
+	fireItem, err := marshalFire(fire)
+	if err != nil {
+		trigger.Version = expected
+		return err
+	}
+
+	condition, values := versionCondition("trigger_id", expected)
+	_, err = db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:                 aws.String(db.tables.Triggers),
+					Item:                      triggerItem,
+					ConditionExpression:       aws.String(condition),
+					ExpressionAttributeValues: values,
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:           aws.String(db.tables.TriggerFires),
+					Item:                fireItem,
+					ConditionExpression: aws.String("attribute_not_exists(fire_id)"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		trigger.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("trigger %s fire %s: %w", trigger.TriggerID, fire.FireID, ErrConflict)
+		}
+		return fmt.Errorf("failed to fire trigger: %v", err)
+	}
+	return nil
+}
+
+// heldPartition is the HeldIndex partition every fire with held deliveries
+// is in
+const heldPartition = "held"
+
+// marshalFire encodes a fire for the fires table. Its fire, next attempt
+// and held until times are sort keys of indexes, so they are kept in UTC
+// where their text sorts in time order.
+func marshalFire(fire *models.TriggerFire) (map[string]types.AttributeValue, error) {
+	stored := *fire
+	stored.FiredAt = fire.FiredAt.UTC()
+	stored.NextAttemptAt = fire.NextAttemptAt.UTC()
+	stored.HeldUntil = fire.HeldUntil.UTC()
+	item, err := attributevalue.MarshalMap(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal trigger fire: %v", err)
+	}
+	if fire.Outbox == "" {
+		// Only pending fires are in OutboxIndex
+		delete(item, "next_attempt_at")
+	}
+	if fire.HeldUntil.IsZero() {
+		// Only fires with held deliveries are in HeldIndex
+		delete(item, "held_until")
+	} else {
+		item["held"] = &types.AttributeValueMemberS{Value: heldPartition}
+	}
+	return item, nil
+}
+
+// GetTriggerFire returns a fire by its ID
+func (db *Database) GetTriggerFire(ctx context.Context, fireID string) (*models.TriggerFire, error) {
+	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.tables.TriggerFires),
+		Key: map[string]types.AttributeValue{
+			"fire_id": &types.AttributeValueMemberS{Value: fireID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trigger fire: %v", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("trigger fire %s: %w", fireID, ErrNotFound)
+	}
+
+	var fire models.TriggerFire
+	if err := attributevalue.UnmarshalMap(result.Item, &fire); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal trigger fire: %v", err)
+	}
+	return &fire, nil
+}
+
+// GetUserTriggerFires returns a user's fires from since up to but not
+// including until, oldest first
+func (db *Database) GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error) {
+	// Fractions of a second are written without trailing zeros, so text
+	// order can differ from time order within a second. The query takes a
+	// second either side and the exact range is picked out of that.
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.TriggerFires),
+		IndexName:              aws.String("UserFiredIndex"),
+		KeyConditionExpression: aws.String("user_id = :user_id AND fired_at BETWEEN :since AND :until"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+			":since":   &types.AttributeValueMemberS{Value: since.Add(-time.Second).UTC().Format(time.RFC3339)},
+			":until":   &types.AttributeValueMemberS{Value: until.Add(time.Second).UTC().Format(time.RFC3339)},
+		},
+	})
+
+	var fires []*models.TriggerFire
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query trigger fires: %v", err)
+		}
+		var pageFires []*models.TriggerFire
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageFires); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trigger fires: %v", err)
+		}
+		for _, fire := range pageFires {
+			if !fire.FiredAt.Before(since) && fire.FiredAt.Before(until) {
+				fires = append(fires, fire)
+			}
+		}
+	}
+	sortFires(fires)
+	return fires, nil
+}
+
+// GetDueTriggerFires returns up to limit pending fires due by now, the
+// longest due first
+func (db *Database) GetDueTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	// As with fire times, a second past now is asked for and the fires due
+	// by now are picked out of that
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.TriggerFires),
+		IndexName:              aws.String("OutboxIndex"),
+		KeyConditionExpression: aws.String("outbox = :pending AND next_attempt_at <= :until"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: models.OutboxPending},
+			":until":   &types.AttributeValueMemberS{Value: now.Add(time.Second).UTC().Format(time.RFC3339)},
+		},
+	})
+
+	var fires []*models.TriggerFire
+	for paginator.HasMorePages() && len(fires) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query due trigger fires: %v", err)
+		}
+		var pageFires []*models.TriggerFire
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageFires); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trigger fires: %v", err)
+		}
+		for _, fire := range pageFires {
+			if !fire.NextAttemptAt.After(now) {
+				fires = append(fires, fire)
+			}
+		}
+	}
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) })
+	if len(fires) > limit {
+		fires = fires[:limit]
+	}
+	return fires, nil
+}
+
+// GetHeldTriggerFires returns up to limit fires with deliveries held until
+// now or before, the longest due first
+func (db *Database) GetHeldTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	// As with fire times, a second past now is asked for and the fires due
+	// by now are picked out of that
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.TriggerFires),
+		IndexName:              aws.String("HeldIndex"),
+		KeyConditionExpression: aws.String("held = :held AND held_until <= :until"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":held":  &types.AttributeValueMemberS{Value: heldPartition},
+			":until": &types.AttributeValueMemberS{Value: now.Add(time.Second).UTC().Format(time.RFC3339)},
+		},
+	})
+
+	var fires []*models.TriggerFire
+	for paginator.HasMorePages() && len(fires) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query held trigger fires: %v", err)
+		}
+		var pageFires []*models.TriggerFire
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageFires); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trigger fires: %v", err)
+		}
+		for _, fire := range pageFires {
+			if !fire.HeldUntil.After(now) {
+				fires = append(fires, fire)
+			}
+		}
+	}
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int { return a.HeldUntil.Compare(b.HeldUntil) })
+	if len(fires) > limit {
+		fires = fires[:limit]
+	}
+	return fires, nil
+}
+
+// LeaseTriggerFire starts a delivery attempt at a pending fire, on the
+// condition that it wasn't written since it was read
+func (db *Database) LeaseTriggerFire(ctx context.Context, fire *models.TriggerFire, until time.Time) error {
+	until = until.UTC()
+	condition, values := versionCondition("fire_id", fire.Version)
+	values[":next"] = numberValue(int64(fire.Attempts + 1))
+	values[":until"] = &types.AttributeValueMemberS{Value: until.Format(time.RFC3339Nano)}
+	values[":pending"] = &types.AttributeValueMemberS{Value: models.OutboxPending}
+	values[":attempts"] = numberValue(int64(fire.Attempts))
+	values[":version"] = numberValue(fire.Version + 1)
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tables.TriggerFires),
+		Key: map[string]types.AttributeValue{
+			"fire_id": &types.AttributeValueMemberS{Value: fire.FireID},
+		},
+		UpdateExpression:          aws.String("SET attempts = :next, next_attempt_at = :until, version = :version"),
+		ConditionExpression:       aws.String("outbox = :pending AND attempts = :attempts AND " + condition),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if isConditionFailure(err) {
+			return fmt.Errorf("trigger fire %s: %w", fire.FireID, ErrConflict)
+		}
+		return fmt.Errorf("failed to lease trigger fire: %v", err)
+	}
+
+	fire.Attempts++
+	fire.NextAttemptAt = until
+	fire.Version++
+	return nil
+}
+
+// sortFires orders fires oldest first
+func sortFires(fires []*models.TriggerFire) {
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int { return a.FiredAt.Compare(b.FiredAt) })
+}
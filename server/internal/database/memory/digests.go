@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// ClaimDigest records that a digest is about to be sent
+func (s *Store) ClaimDigest(ctx context.Context, claim *models.DigestClaim) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[claim.UserID]; !ok {
+		return fmt.Errorf("user %s: %w", claim.UserID, database.ErrNotFound)
+	}
+	key := stockKey{claim.UserID, claim.DigestID}
+	if _, ok := s.digests[key]; ok {
+		return fmt.Errorf("digest %s of %s already claimed: %w", claim.DigestID, claim.UserID, database.ErrConflict)
+	}
+	s.digests[key] = *claim
+	return nil
+}
+
+// ReleaseDigest drops a digest claim
+func (s *Store) ReleaseDigest(ctx context.Context, userID, digestID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.digests, stockKey{userID, digestID})
+	return nil
+}
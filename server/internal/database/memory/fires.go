@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// SaveTriggerFire stores a fire with its deliveries
+func (s *Store) SaveTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *fire
+	stored.Deliveries = slices.Clone(fire.Deliveries)
+	s.fires[fire.FireID] = stored
+	return nil
+}
+
+// UpdateTriggerFire replaces a fire if it is still at the version it was
+// read at
+func (s *Store) UpdateTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.fires[fire.FireID]
+	if !ok || stored.Version != fire.Version {
+		return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+	}
+	fire.Version++
+	stored = *fire
+	stored.Deliveries = slices.Clone(fire.Deliveries)
+	s.fires[fire.FireID] = stored
+	return nil
+}
+
+// GetTriggerFire returns a fire by its ID
+func (s *Store) GetTriggerFire(ctx context.Context, fireID string) (*models.TriggerFire, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fire, ok := s.fires[fireID]
+	if !ok {
+		return nil, fmt.Errorf("trigger fire %s: %w", fireID, database.ErrNotFound)
+	}
+	fire.Deliveries = slices.Clone(fire.Deliveries)
+	return &fire, nil
+}
+
+// GetUserTriggerFires returns a user's fires from since up to but not
+// including until, oldest first
+func (s *Store) GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var fires []*models.TriggerFire
+	for _, fire := range s.fires {
+		if fire.UserID == userID && !fire.FiredAt.Before(since) && fire.FiredAt.Before(until) {
+			fire.Deliveries = slices.Clone(fire.Deliveries)
+			fires = append(fires, &fire)
+		}
+	}
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int {
+		if c := a.FiredAt.Compare(b.FiredAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.FireID, b.FireID)
+	})
+	return fires, nil
+}
+
+// FireTrigger replaces a fired trigger and stores its fire together
+func (s *Store) FireTrigger(ctx context.Context, trigger *models.StockTrigger, fire *models.TriggerFire) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.triggers[trigger.TriggerID]
+	if !ok || stored.Version != trigger.Version {
+		return fmt.Errorf("trigger %s: %w", trigger.TriggerID, database.ErrConflict)
+	}
+	if _, ok := s.fires[fire.FireID]; ok {
+		return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+	}
+
+	trigger.UpdatedAt = time.Now()
+	trigger.Version++
+	s.triggers[trigger.TriggerID] = copyTrigger(*trigger)
+	storedFire := *fire
+	storedFire.Deliveries = slices.Clone(fire.Deliveries)
+	s.fires[fire.FireID] = storedFire
+	return nil
+}
+
+// GetDueTriggerFires returns up to limit pending fires due by now, the
+// longest due first
+func (s *Store) GetDueTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var fires []*models.TriggerFire
+	for _, fire := range s.fires {
+		if fire.Outbox == models.OutboxPending && !fire.NextAttemptAt.After(now) {
+			fire.Deliveries = slices.Clone(fire.Deliveries)
+			fires = append(fires, &fire)
+		}
+	}
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int {
+		if c := a.NextAttemptAt.Compare(b.NextAttemptAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.FireID, b.FireID)
+	})
+	if len(fires) > limit {
+		fires = fires[:limit]
+	}
+	return fires, nil
+}
+
+// GetHeldTriggerFires returns up to limit fires with deliveries held until
+// now or before, the longest due first
+func (s *Store) GetHeldTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var fires []*models.TriggerFire
+	for _, fire := range s.fires {
+		if !fire.HeldUntil.IsZero() && !fire.HeldUntil.After(now) {
+			fire.Deliveries = slices.Clone(fire.Deliveries)
+			fires = append(fires, &fire)
+		}
+	}
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int {
+		if c := a.HeldUntil.Compare(b.HeldUntil); c != 0 {
+			return c
+		}
+		return strings.Compare(a.FireID, b.FireID)
+	})
+	if len(fires) > limit {
+		fires = fires[:limit]
+	}
+	return fires, nil
+}
+
+// LeaseTriggerFire starts a delivery attempt at a pending fire
+func (s *Store) LeaseTriggerFire(ctx context.Context, fire *models.TriggerFire, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.fires[fire.FireID]
+	if !ok || stored.Outbox != models.OutboxPending || stored.Attempts != fire.Attempts || stored.Version != fire.Version {
+		return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+	}
+	stored.Attempts++
+	stored.NextAttemptAt = until
+	stored.Version++
+	s.fires[fire.FireID] = stored
+
+	fire.Attempts = stored.Attempts
+	fire.NextAttemptAt = until
+	fire.Version = stored.Version
+	return nil
+}
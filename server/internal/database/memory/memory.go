@@ -0,0 +1,95 @@
+// Package memory provides an in-memory implementation of the database
+// repositories for tests and local development.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// Store keeps users, stocks, triggers, trigger fires, webhooks, digest and
+// release claims and push subscriptions in maps. Records are copied on the way in and out so callers can't
+// modify stored data without a write, just like with a real database.
+type Store struct {
+	users    map[string]models.User                // email -> user
+	stocks   map[stockKey]models.Stock             // (user_id, stock_id) -> stock
+	triggers map[string]models.StockTrigger        // trigger_id -> trigger
+	fires    map[string]models.TriggerFire         // fire_id -> fire
+	webhooks map[stockKey]models.Webhook           // (user_id, webhook_id) -> webhook
+	letters  map[stockKey]models.WebhookDeadLetter // (user_id, dead_letter_id) -> dead letter
+	digests  map[stockKey]models.DigestClaim       // (user_id, digest_id) -> claim
+	releases map[stockKey]models.ReleaseClaim      // (user_id, release_id) -> claim
+	pushSubs map[stockKey]models.PushSubscription  // (user_id, subscription_id) -> subscription
+	mu       sync.RWMutex
+}
+
+// stockKey is the primary key of a stock, and of the other records that
+// belong to a user
+type stockKey struct {
+	userID  string
+	stockID string
+}
+
+var _ database.Store = (*Store)(nil)
+
+// NewStore creates an empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		users:    make(map[string]models.User),
+		stocks:   make(map[stockKey]models.Stock),
+		triggers: make(map[string]models.StockTrigger),
+		fires:    make(map[string]models.TriggerFire),
+		webhooks: make(map[stockKey]models.Webhook),
+		letters:  make(map[stockKey]models.WebhookDeadLetter),
+		digests:  make(map[stockKey]models.DigestClaim),
+		releases: make(map[stockKey]models.ReleaseClaim),
+		pushSubs: make(map[stockKey]models.PushSubscription),
+	}
+}
+
+// CreateUser stores a new user
+func (s *Store) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Email]; ok {
+		return fmt.Errorf("user %s already exists: %w", user.Email, database.ErrConflict)
+	}
+
+	user.Version = 1
+	s.users[user.Email] = *user
+	return nil
+}
+
+// GetUserByEmail returns the user with the given email
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[email]
+	if !ok {
+		return nil, fmt.Errorf("user %s: %w", email, database.ErrNotFound)
+	}
+	return &user, nil
+}
+
+// UpdateUser replaces a user if it is still at user.Version
+func (s *Store) UpdateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.Email]
+	if !ok || stored.Version != user.Version {
+		return fmt.Errorf("user %s: %w", user.Email, database.ErrConflict)
+	}
+
+	user.UpdatedAt = time.Now()
+	user.Version++
+	s.users[user.Email] = *user
+	return nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/storetest"
+)
+
+func TestStoreContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return NewStore()
+	})
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// SavePushSubscription stores a subscription, replacing any earlier one with
+// the same ID
+func (s *Store) SavePushSubscription(ctx context.Context, sub *models.PushSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pushSubs[stockKey{sub.UserID, sub.SubscriptionID}] = *sub
+	return nil
+}
+
+// GetUserPushSubscriptions returns all of a user's subscriptions ordered by
+// ID
+func (s *Store) GetUserPushSubscriptions(ctx context.Context, userID string) ([]*models.PushSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var subs []*models.PushSubscription
+	for key, sub := range s.pushSubs {
+		if key.userID == userID {
+			subs = append(subs, &sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].SubscriptionID < subs[j].SubscriptionID })
+	return subs, nil
+}
+
+// DeletePushSubscription deletes a subscription
+func (s *Store) DeletePushSubscription(ctx context.Context, userID, subscriptionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{userID, subscriptionID}
+	if _, ok := s.pushSubs[key]; !ok {
+		return fmt.Errorf("push subscription %s: %w", subscriptionID, database.ErrNotFound)
+	}
+	delete(s.pushSubs, key)
+	return nil
+}
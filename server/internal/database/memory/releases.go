@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// ClaimRelease records that a release of held alerts is about to be sent
+func (s *Store) ClaimRelease(ctx context.Context, claim *models.ReleaseClaim) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[claim.UserID]; !ok {
+		return fmt.Errorf("user %s: %w", claim.UserID, database.ErrNotFound)
+	}
+	key := stockKey{claim.UserID, claim.ReleaseID}
+	if _, ok := s.releases[key]; ok {
+		return fmt.Errorf("release %s of %s already claimed: %w", claim.ReleaseID, claim.UserID, database.ErrConflict)
+	}
+	s.releases[key] = *claim
+	return nil
+}
+
+// UnclaimRelease drops a release claim
+func (s *Store) UnclaimRelease(ctx context.Context, userID, releaseID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.releases, stockKey{userID, releaseID})
+	return nil
+}
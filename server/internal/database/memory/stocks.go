@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// CreateStock adds a stock to a user's portfolio
+func (s *Store) CreateStock(ctx context.Context, stock *models.Stock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stock.StockID == "" {
+		stock.StockID = uuid.New().String()
+	}
+	key := stockKey{stock.UserID, stock.StockID}
+	if _, ok := s.stocks[key]; ok {
+		return fmt.Errorf("stock %s already exists: %w", stock.StockID, database.ErrConflict)
+	}
+
+	if stock.AddedAt.IsZero() {
+		stock.AddedAt = time.Now()
+	}
+	stock.LastUpdated = time.Now()
+	stock.Version = 1
+	s.stocks[key] = copyStock(*stock)
+	return nil
+}
+
+// GetStock returns a stock from a user's portfolio
+func (s *Store) GetStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stock, ok := s.stocks[stockKey{userID, stockID}]
+	if !ok {
+		return nil, fmt.Errorf("stock %s: %w", stockID, database.ErrNotFound)
+	}
+	stock = copyStock(stock)
+	return &stock, nil
+}
+
+// GetUserStocks returns all stocks in a user's portfolio ordered by ID
+func (s *Store) GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stocks []models.Stock
+	for key, stock := range s.stocks {
+		if key.userID == userID {
+			stocks = append(stocks, copyStock(stock))
+		}
+	}
+	sort.Slice(stocks, func(i, j int) bool { return stocks[i].StockID < stocks[j].StockID })
+	return stocks, nil
+}
+
+// ListUserStocks returns one page of a user's portfolio ordered by ID
+func (s *Store) ListUserStocks(ctx context.Context, userID string, page database.PageOptions) ([]models.Stock, string, error) {
+	after, err := database.CursorValue(page.Cursor, map[string]string{"user_id": userID}, "stock_id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	stocks, err := s.GetUserStocks(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	start := sort.Search(len(stocks), func(i int) bool { return stocks[i].StockID > after })
+	stocks = stocks[start:]
+
+	var next string
+	if limit := page.PageLimit(); len(stocks) > limit {
+		stocks = stocks[:limit]
+		next = database.EncodeCursor(map[string]string{"user_id": userID, "stock_id": stocks[limit-1].StockID})
+	}
+	return stocks, next, nil
+}
+
+// UpdateStock replaces a stock if it is still at stock.Version
+func (s *Store) UpdateStock(ctx context.Context, stock *models.Stock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{stock.UserID, stock.StockID}
+	stored, ok := s.stocks[key]
+	if !ok || stored.Version != stock.Version {
+		return fmt.Errorf("stock %s: %w", stock.StockID, database.ErrConflict)
+	}
+
+	stock.LastUpdated = time.Now()
+	stock.Version++
+	s.stocks[key] = copyStock(*stock)
+	return nil
+}
+
+// UpdateStockPrice writes only the price fields of a stock if it is still
+// at stock.Version
+func (s *Store) UpdateStockPrice(ctx context.Context, stock *models.Stock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{stock.UserID, stock.StockID}
+	stored, ok := s.stocks[key]
+	if !ok || stored.Version != stock.Version {
+		return fmt.Errorf("stock %s: %w", stock.StockID, database.ErrConflict)
+	}
+
+	stored.Price = stock.Price
+	stored.LastPrice = stock.LastPrice
+	stored.LastUpdated = stock.LastUpdated
+	stored.Version++
+	s.stocks[key] = stored
+
+	stock.Version = stored.Version
+	return nil
+}
+
+// RemoveStock removes a stock and its triggers from a user's portfolio
+func (s *Store) RemoveStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{userID, stockID}
+	stock, ok := s.stocks[key]
+	if !ok {
+		return nil, fmt.Errorf("stock %s: %w", stockID, database.ErrNotFound)
+	}
+
+	for _, triggerID := range stock.Triggers {
+		delete(s.triggers, triggerID)
+	}
+	delete(s.stocks, key)
+	return &stock, nil
+}
+
+// GetAllUniqueStocks returns the most recently added stock for every symbol
+func (s *Store) GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	uniqueStocks := make(map[string]models.Stock)
+	for _, stock := range s.stocks {
+		existing, exists := uniqueStocks[stock.Symbol]
+		if !exists || stock.AddedAt.After(existing.AddedAt) {
+			uniqueStocks[stock.Symbol] = stock
+		}
+	}
+
+	var stocks []models.Stock
+	for _, stock := range uniqueStocks {
+		stocks = append(stocks, copyStock(stock))
+	}
+	return stocks, nil
+}
+
+// ScanStocks calls fn for a copy of every stock
+func (s *Store) ScanStocks(ctx context.Context, fn func(models.Stock) error) error {
+	s.mu.RLock()
+	stocks := make([]models.Stock, 0, len(s.stocks))
+	for _, stock := range s.stocks {
+		stocks = append(stocks, copyStock(stock))
+	}
+	s.mu.RUnlock()
+
+	for _, stock := range stocks {
+		if err := fn(stock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyStock returns a copy of stock that shares no memory with it
+func copyStock(stock models.Stock) models.Stock {
+	stock.Triggers = append([]string(nil), stock.Triggers...)
+	return stock
+}
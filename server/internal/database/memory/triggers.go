@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// CreateTrigger stores a new trigger and links it to its stock
+func (s *Store) CreateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{trigger.UserID, trigger.StockID}
+	stock, ok := s.stocks[key]
+	if !ok {
+		return fmt.Errorf("stock %s: %w", trigger.StockID, database.ErrNotFound)
+	}
+
+	trigger.TriggerID = uuid.New().String()
+	trigger.CreatedAt = time.Now()
+	trigger.UpdatedAt = time.Now()
+	trigger.LastTrigger = time.Time{}
+	trigger.Version = 1
+	s.triggers[trigger.TriggerID] = copyTrigger(*trigger)
+
+	stock.Triggers = append(append([]string(nil), stock.Triggers...), trigger.TriggerID)
+	stock.LastUpdated = trigger.UpdatedAt
+	stock.Version++
+	s.stocks[key] = stock
+	return nil
+}
+
+// GetTrigger returns a trigger by its ID
+func (s *Store) GetTrigger(ctx context.Context, triggerID string) (*models.StockTrigger, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trigger, ok := s.triggers[triggerID]
+	if !ok {
+		return nil, fmt.Errorf("trigger %s: %w", triggerID, database.ErrNotFound)
+	}
+	trigger = copyTrigger(trigger)
+	return &trigger, nil
+}
+
+// GetTriggersBySymbol returns all triggers on a symbol
+func (s *Store) GetTriggersBySymbol(ctx context.Context, symbol, exchange string) ([]*models.StockTrigger, error) {
+	return s.filterTriggers(func(t models.StockTrigger) bool {
+		return t.Symbol == symbol && t.Exchange == exchange
+	}), nil
+}
+
+// GetTriggersByUser returns all triggers owned by a user
+func (s *Store) GetTriggersByUser(ctx context.Context, userID string) ([]*models.StockTrigger, error) {
+	return s.filterTriggers(func(t models.StockTrigger) bool {
+		return t.UserID == userID
+	}), nil
+}
+
+// ListTriggersByUser returns one page of a user's triggers ordered by ID
+func (s *Store) ListTriggersByUser(ctx context.Context, userID string, page database.PageOptions) ([]*models.StockTrigger, string, error) {
+	after, err := database.CursorValue(page.Cursor, map[string]string{"user_id": userID}, "trigger_id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	triggers := s.filterTriggers(func(t models.StockTrigger) bool {
+		return t.UserID == userID && t.TriggerID > after
+	})
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].TriggerID < triggers[j].TriggerID })
+
+	var next string
+	if limit := page.PageLimit(); len(triggers) > limit {
+		triggers = triggers[:limit]
+		next = database.EncodeCursor(map[string]string{"user_id": userID, "trigger_id": triggers[limit-1].TriggerID})
+	}
+	return triggers, next, nil
+}
+
+// UpdateTrigger replaces a trigger if it is still at trigger.Version
+func (s *Store) UpdateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.triggers[trigger.TriggerID]
+	if !ok || stored.Version != trigger.Version {
+		return fmt.Errorf("trigger %s: %w", trigger.TriggerID, database.ErrConflict)
+	}
+
+	trigger.UpdatedAt = time.Now()
+	trigger.Version++
+	s.triggers[trigger.TriggerID] = copyTrigger(*trigger)
+	return nil
+}
+
+// DeleteTrigger deletes a trigger and unlinks it from its stock
+func (s *Store) DeleteTrigger(ctx context.Context, triggerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trigger, ok := s.triggers[triggerID]
+	if !ok {
+		return fmt.Errorf("trigger %s: %w", triggerID, database.ErrNotFound)
+	}
+	delete(s.triggers, triggerID)
+
+	key := stockKey{trigger.UserID, trigger.StockID}
+	if stock, ok := s.stocks[key]; ok {
+		var remaining []string
+		for _, id := range stock.Triggers {
+			if id != triggerID {
+				remaining = append(remaining, id)
+			}
+		}
+		stock.Triggers = remaining
+		stock.LastUpdated = time.Now()
+		stock.Version++
+		s.stocks[key] = stock
+	}
+	return nil
+}
+
+// filterTriggers returns copies of the triggers matching keep
+func (s *Store) filterTriggers(keep func(models.StockTrigger) bool) []*models.StockTrigger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var triggers []*models.StockTrigger
+	for _, t := range s.triggers {
+		if keep(t) {
+			t = copyTrigger(t)
+			triggers = append(triggers, &t)
+		}
+	}
+	return triggers
+}
+
+// copyTrigger returns a copy of trigger that shares no memory with it
+func copyTrigger(trigger models.StockTrigger) models.StockTrigger {
+	trigger.NotificationChannels = append([]string(nil), trigger.NotificationChannels...)
+	return trigger
+}
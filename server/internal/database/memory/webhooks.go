@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// CreateWebhook stores a new webhook
+func (s *Store) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if webhook.WebhookID == "" {
+		webhook.WebhookID = uuid.New().String()
+	}
+	key := stockKey{webhook.UserID, webhook.WebhookID}
+	if _, ok := s.webhooks[key]; ok {
+		return fmt.Errorf("webhook %s already exists: %w", webhook.WebhookID, database.ErrConflict)
+	}
+
+	if webhook.CreatedAt.IsZero() {
+		webhook.CreatedAt = time.Now()
+	}
+	webhook.UpdatedAt = time.Now()
+	webhook.Version = 1
+	s.webhooks[key] = copyWebhook(*webhook)
+	return nil
+}
+
+// GetWebhook returns one of a user's webhooks
+func (s *Store) GetWebhook(ctx context.Context, userID, webhookID string) (*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[stockKey{userID, webhookID}]
+	if !ok {
+		return nil, fmt.Errorf("webhook %s: %w", webhookID, database.ErrNotFound)
+	}
+	webhook = copyWebhook(webhook)
+	return &webhook, nil
+}
+
+// GetUserWebhooks returns all of a user's webhooks ordered by ID
+func (s *Store) GetUserWebhooks(ctx context.Context, userID string) ([]*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var webhooks []*models.Webhook
+	for key, webhook := range s.webhooks {
+		if key.userID == userID {
+			webhook = copyWebhook(webhook)
+			webhooks = append(webhooks, &webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].WebhookID < webhooks[j].WebhookID })
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces a webhook if it is still at webhook.Version
+func (s *Store) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{webhook.UserID, webhook.WebhookID}
+	stored, ok := s.webhooks[key]
+	if !ok || stored.Version != webhook.Version {
+		return fmt.Errorf("webhook %s: %w", webhook.WebhookID, database.ErrConflict)
+	}
+
+	webhook.UpdatedAt = time.Now()
+	webhook.Version++
+	s.webhooks[key] = copyWebhook(*webhook)
+	return nil
+}
+
+// DeleteWebhook deletes a webhook and its dead letters
+func (s *Store) DeleteWebhook(ctx context.Context, userID, webhookID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{userID, webhookID}
+	if _, ok := s.webhooks[key]; !ok {
+		return fmt.Errorf("webhook %s: %w", webhookID, database.ErrNotFound)
+	}
+	delete(s.webhooks, key)
+	for key, letter := range s.letters {
+		if key.userID == userID && letter.WebhookID == webhookID {
+			delete(s.letters, key)
+		}
+	}
+	return nil
+}
+
+// RecordWebhookResult clears or counts a webhook's failures
+func (s *Store) RecordWebhookResult(ctx context.Context, userID, webhookID string, ok bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{userID, webhookID}
+	webhook, found := s.webhooks[key]
+	if !found {
+		return 0, fmt.Errorf("webhook %s: %w", webhookID, database.ErrNotFound)
+	}
+	if ok {
+		webhook.Failures = 0
+	} else {
+		webhook.Failures++
+	}
+	s.webhooks[key] = webhook
+	return webhook.Failures, nil
+}
+
+// SaveWebhookDeadLetter stores a dead letter
+func (s *Store) SaveWebhookDeadLetter(ctx context.Context, letter *models.WebhookDeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters[stockKey{letter.UserID, letter.DeadLetterID}] = *letter
+	return nil
+}
+
+// GetWebhookDeadLetter returns one of a user's dead letters
+func (s *Store) GetWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) (*models.WebhookDeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letter, ok := s.letters[stockKey{userID, deadLetterID}]
+	if !ok {
+		return nil, fmt.Errorf("dead letter %s: %w", deadLetterID, database.ErrNotFound)
+	}
+	return &letter, nil
+}
+
+// GetUserWebhookDeadLetters returns all of a user's dead letters ordered by
+// ID
+func (s *Store) GetUserWebhookDeadLetters(ctx context.Context, userID string) ([]*models.WebhookDeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var letters []*models.WebhookDeadLetter
+	for key, letter := range s.letters {
+		if key.userID == userID {
+			letters = append(letters, &letter)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].DeadLetterID < letters[j].DeadLetterID })
+	return letters, nil
+}
+
+// DeleteWebhookDeadLetter deletes a dead letter
+func (s *Store) DeleteWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stockKey{userID, deadLetterID}
+	if _, ok := s.letters[key]; !ok {
+		return fmt.Errorf("dead letter %s: %w", deadLetterID, database.ErrNotFound)
+	}
+	delete(s.letters, key)
+	return nil
+}
+
+// copyWebhook returns a copy of webhook that shares no memory with it
+func copyWebhook(webhook models.Webhook) models.Webhook {
+	if webhook.DisabledAt != nil {
+		at := *webhook.DisabledAt
+		webhook.DisabledAt = &at
+	}
+	return webhook
+}
@@ -0,0 +1,616 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableWaitTimeout bounds how long a migration waits for a table or index to
+// become active
+const tableWaitTimeout = 5 * time.Minute
+
+// ErrPendingMigrations is returned at startup when the schema is behind the
+// migrations compiled into the binary
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// Migration is a versioned schema change. Up must be idempotent so that a
+// migration interrupted half way can simply be run again.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, m *Migrator) error
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// appliedMigration is the record written to the migrations table
+type appliedMigration struct {
+	Version   int       `dynamodbav:"version"`
+	Name      string    `dynamodbav:"name"`
+	AppliedAt time.Time `dynamodbav:"applied_at"`
+}
+
+// Migrator provisions the DynamoDB tables and records the applied migrations
+type Migrator struct {
+	db         *Database
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the tables of db
+func NewMigrator(db *Database) *Migrator {
+	return &Migrator{db: db, migrations: dynamoMigrations}
+}
+
+// dynamoMigrations is the schema history. Never edit or reorder an applied
+// migration; add a new one instead.
+var dynamoMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.Users),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("email")},
+				KeySchema:            []types.KeySchemaElement{keyElem("email", types.KeyTypeHash)},
+				BillingMode:          types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_stocks",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.Stocks),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("stock_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("stock_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_triggers",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.Triggers),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("trigger_id")},
+				KeySchema:            []types.KeySchemaElement{keyElem("trigger_id", types.KeyTypeHash)},
+				BillingMode:          types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_triggers_symbol_index",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureGSI(ctx, m.db.tables.Triggers,
+				[]types.AttributeDefinition{stringAttr("symbol"), stringAttr("exchange")},
+				types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("SymbolIndex"),
+					KeySchema: []types.KeySchemaElement{
+						keyElem("symbol", types.KeyTypeHash),
+						keyElem("exchange", types.KeyTypeRange),
+					},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_triggers_user_index",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureGSI(ctx, m.db.tables.Triggers,
+				[]types.AttributeDefinition{stringAttr("user_id")},
+				types.CreateGlobalSecondaryIndexAction{
+					IndexName:  aws.String("UserIndex"),
+					KeySchema:  []types.KeySchemaElement{keyElem("user_id", types.KeyTypeHash)},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_user_stock_triggers",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.UserStockTriggers),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id")},
+				KeySchema:            []types.KeySchemaElement{keyElem("user_id", types.KeyTypeHash)},
+				BillingMode:          types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "enable_stocks_and_triggers_streams",
+		Up: func(ctx context.Context, m *Migrator) error {
+			for _, table := range []string{m.db.tables.Stocks, m.db.tables.Triggers} {
+				if err := m.ensureStream(ctx, table, types.StreamViewTypeNewAndOldImages); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_stream_checkpoints",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.StreamCheckpoints),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("checkpoint_id"), stringAttr("shard_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("checkpoint_id", types.KeyTypeHash),
+					keyElem("shard_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+			if err != nil {
+				return err
+			}
+			// Checkpoints outlive their shards by a day and are then dropped
+			return m.ensureTTL(ctx, m.db.tables.StreamCheckpoints, "expires_at")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_trigger_fires",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.TriggerFires),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("fire_id")},
+				KeySchema:            []types.KeySchemaElement{keyElem("fire_id", types.KeyTypeHash)},
+				BillingMode:          types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_webhooks",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.Webhooks),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("webhook_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("webhook_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+			if err != nil {
+				return err
+			}
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.WebhookDeadLetters),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("dead_letter_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("dead_letter_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_trigger_fires_user_index",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureGSI(ctx, m.db.tables.TriggerFires,
+				[]types.AttributeDefinition{stringAttr("user_id"), stringAttr("fired_at")},
+				types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("UserFiredIndex"),
+					KeySchema: []types.KeySchemaElement{
+						keyElem("user_id", types.KeyTypeHash),
+						keyElem("fired_at", types.KeyTypeRange),
+					},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				})
+		},
+	},
+	{
+		Version: 12,
+		Name:    "create_digest_claims",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.DigestClaims),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("digest_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("digest_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+			if err != nil {
+				return err
+			}
+			// Claims are only checked for recent days
+			return m.ensureTTL(ctx, m.db.tables.DigestClaims, "expires_at")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_trigger_fires_outbox_index",
+		Up: func(ctx context.Context, m *Migrator) error {
+			// Sparse: only fires still to be delivered have an outbox
+			return m.ensureGSI(ctx, m.db.tables.TriggerFires,
+				[]types.AttributeDefinition{stringAttr("outbox"), stringAttr("next_attempt_at")},
+				types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("OutboxIndex"),
+					KeySchema: []types.KeySchemaElement{
+						keyElem("outbox", types.KeyTypeHash),
+						keyElem("next_attempt_at", types.KeyTypeRange),
+					},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				})
+		},
+	},
+	{
+		Version: 14,
+		Name:    "create_push_subscriptions",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.PushSubscriptions),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("subscription_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("subscription_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_trigger_fires_held_index",
+		Up: func(ctx context.Context, m *Migrator) error {
+			// Sparse: only fires with deliveries held through quiet hours
+			// have a held until time
+			return m.ensureGSI(ctx, m.db.tables.TriggerFires,
+				[]types.AttributeDefinition{stringAttr("held"), stringAttr("held_until")},
+				types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("HeldIndex"),
+					KeySchema: []types.KeySchemaElement{
+						keyElem("held", types.KeyTypeHash),
+						keyElem("held_until", types.KeyTypeRange),
+					},
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				})
+		},
+	},
+	{
+		Version: 16,
+		Name:    "create_release_claims",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.ensureTable(ctx, &dynamodb.CreateTableInput{
+				TableName:            aws.String(m.db.tables.ReleaseClaims),
+				AttributeDefinitions: []types.AttributeDefinition{stringAttr("user_id"), stringAttr("release_id")},
+				KeySchema: []types.KeySchemaElement{
+					keyElem("user_id", types.KeyTypeHash),
+					keyElem("release_id", types.KeyTypeRange),
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			})
+			if err != nil {
+				return err
+			}
+			// Claims only guard a release while it is being sent
+			return m.ensureTTL(ctx, m.db.tables.ReleaseClaims, "expires_at")
+		},
+	},
+}
+
+// Migrations returns every known migration in version order
+func (m *Migrator) Migrations() []Migration {
+	migrations := append([]Migration(nil), m.migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// Status reports which migrations have been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, migration := range m.Migrations() {
+		record, ok := applied[migration.Version]
+		status = append(status, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return status, nil
+}
+
+// Check returns ErrPendingMigrations if any migration has not been applied
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%04d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w %v; run `go run ./cmd/migrate up` or set AUTO_MIGRATE=true", ErrPendingMigrations, pending)
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order
+func (m *Migrator) Up(ctx context.Context) error {
+	if err := m.ensureTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(m.db.tables.Migrations),
+		AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String("version"), AttributeType: types.ScalarAttributeTypeN}},
+		KeySchema:            []types.KeySchemaElement{keyElem("version", types.KeyTypeHash)},
+		BillingMode:          types.BillingModePayPerRequest,
+	}); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.Migrations() {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := migration.Up(ctx, m); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+		if err := m.record(ctx, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applied returns the applied migrations by version. A missing migrations
+// table means nothing has been applied yet.
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+
+	paginator := dynamodb.NewScanPaginator(m.db.client, &dynamodb.ScanInput{
+		TableName:      aws.String(m.db.tables.Migrations),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if isResourceNotFound(err) {
+			return applied, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+		}
+
+		var records []appliedMigration
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &records); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal applied migrations: %v", err)
+		}
+		for _, r := range records {
+			applied[r.Version] = r
+		}
+	}
+	return applied, nil
+}
+
+// record marks a migration as applied
+func (m *Migrator) record(ctx context.Context, migration Migration) error {
+	av, err := attributevalue.MarshalMap(appliedMigration{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		AppliedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal migration record: %v", err)
+	}
+
+	_, err = m.db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(m.db.tables.Migrations),
+		Item:      av,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+	}
+	return nil
+}
+
+// ensureTable creates a table unless it already exists and waits for it to
+// become active
+func (m *Migrator) ensureTable(ctx context.Context, input *dynamodb.CreateTableInput) error {
+	table := aws.ToString(input.TableName)
+
+	_, err := m.db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: input.TableName})
+	if err == nil {
+		return nil
+	}
+	if !isResourceNotFound(err) {
+		return fmt.Errorf("failed to describe table %s: %v", table, err)
+	}
+
+	if _, err := m.db.client.CreateTable(ctx, input); err != nil {
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &inUse) {
+			return fmt.Errorf("failed to create table %s: %v", table, err)
+		}
+		// Another instance is creating it; fall through and wait
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(m.db.client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: input.TableName}, tableWaitTimeout); err != nil {
+		return fmt.Errorf("table %s did not become active: %v", table, err)
+	}
+	return nil
+}
+
+// ensureGSI adds a global secondary index to a table unless it already
+// exists, and waits for the index to finish backfilling
+func (m *Migrator) ensureGSI(ctx context.Context, table string, attrs []types.AttributeDefinition, index types.CreateGlobalSecondaryIndexAction) error {
+	name := aws.ToString(index.IndexName)
+
+	status, err := m.indexStatus(ctx, table, name)
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		_, err := m.db.client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(table),
+			AttributeDefinitions: attrs,
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{Create: &index},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index %s on %s: %v", name, table, err)
+		}
+	}
+
+	return m.waitFor(ctx, fmt.Sprintf("index %s on %s", name, table), func() (bool, error) {
+		status, err := m.indexStatus(ctx, table, name)
+		return status == types.IndexStatusActive, err
+	})
+}
+
+// indexStatus returns the status of a global secondary index, or "" if the
+// table has no such index
+func (m *Migrator) indexStatus(ctx context.Context, table, index string) (types.IndexStatus, error) {
+	out, err := m.db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe table %s: %v", table, err)
+	}
+	for _, gsi := range out.Table.GlobalSecondaryIndexes {
+		if aws.ToString(gsi.IndexName) == index {
+			return gsi.IndexStatus, nil
+		}
+	}
+	return "", nil
+}
+
+// ensureStream enables a DynamoDB stream with the given view type on a table
+func (m *Migrator) ensureStream(ctx context.Context, table string, viewType types.StreamViewType) error {
+	out, err := m.db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return fmt.Errorf("failed to describe table %s: %v", table, err)
+	}
+
+	if spec := out.Table.StreamSpecification; spec != nil && aws.ToBool(spec.StreamEnabled) {
+		if spec.StreamViewType == viewType {
+			return nil
+		}
+		// DynamoDB can't change the view type of an enabled stream in place
+		return fmt.Errorf("table %s already streams %s, want %s", table, spec.StreamViewType, viewType)
+	}
+
+	_, err = m.db.client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(table),
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: viewType,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable stream on %s: %v", table, err)
+	}
+
+	return m.waitFor(ctx, "table "+table, func() (bool, error) {
+		out, err := m.db.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+		if err != nil {
+			return false, err
+		}
+		return out.Table.TableStatus == types.TableStatusActive, nil
+	})
+}
+
+// ensureTTL enables time to live on a table using attr, which must hold an
+// epoch time in seconds
+func (m *Migrator) ensureTTL(ctx context.Context, table, attr string) error {
+	out, err := m.db.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(table)})
+	if err != nil {
+		return fmt.Errorf("failed to describe TTL of %s: %v", table, err)
+	}
+
+	if desc := out.TimeToLiveDescription; desc != nil {
+		switch desc.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if aws.ToString(desc.AttributeName) == attr {
+				return nil
+			}
+			return fmt.Errorf("table %s already expires items on %s, want %s", table, aws.ToString(desc.AttributeName), attr)
+		}
+	}
+
+	_, err = m.db.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(attr),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL on %s: %v", table, err)
+	}
+	return nil
+}
+
+// waitFor polls done until it reports true or tableWaitTimeout elapses
+func (m *Migrator) waitFor(ctx context.Context, what string, done func() (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, tableWaitTimeout)
+	defer cancel()
+
+	for {
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not become active: %v", what, ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// stringAttr defines a string key attribute
+func stringAttr(name string) types.AttributeDefinition {
+	return types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: types.ScalarAttributeTypeS}
+}
+
+// keyElem is a key schema element
+func keyElem(name string, keyType types.KeyType) types.KeySchemaElement {
+	return types.KeySchemaElement{AttributeName: aws.String(name), KeyType: keyType}
+}
+
+// isResourceNotFound reports whether err says a table does not exist
+func isResourceNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultPageSize is the page size used when a request gives no limit
+	DefaultPageSize = 50
+	// MaxPageSize caps the number of items a single page may return
+	MaxPageSize = 200
+)
+
+// ErrInvalidCursor is returned when a page cursor is malformed or was issued
+// for a different list
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageOptions selects one page of a list
+type PageOptions struct {
+	Limit  int    // Maximum number of items; DefaultPageSize if zero
+	Cursor string // Opaque cursor returned with the previous page; empty for the first
+}
+
+// PageLimit returns the number of items to return, clamped to MaxPageSize
+func (o PageOptions) PageLimit() int {
+	switch {
+	case o.Limit <= 0:
+		return DefaultPageSize
+	case o.Limit > MaxPageSize:
+		return MaxPageSize
+	default:
+		return o.Limit
+	}
+}
+
+// EncodeCursor turns the key of the last item on a page into an opaque cursor
+func EncodeCursor(key map[string]string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor. Every attribute in
+// scope must be present with the given value, so that a cursor issued for one
+// user's list can't be used to page through another's.
+func DecodeCursor(cursor string, scope map[string]string) (map[string]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	var key map[string]string
+	if err := json.Unmarshal(data, &key); err != nil || len(key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	for attr, want := range scope {
+		if key[attr] != want {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return key, nil
+}
+
+// CursorValue returns the attribute attr of a cursor checked against scope,
+// or "" for an empty cursor. Backends that page by a single ordered ID use it
+// to find where the next page starts.
+func CursorValue(cursor string, scope map[string]string, attr string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	key, err := DecodeCursor(cursor, scope)
+	if err != nil {
+		return "", err
+	}
+	value, ok := key[attr]
+	if !ok {
+		return "", ErrInvalidCursor
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SavePushSubscription stores a subscription, replacing any earlier one with
+// the same ID
+func (db *Database) SavePushSubscription(ctx context.Context, sub *models.PushSubscription) error {
+	item, err := attributevalue.MarshalMap(sub)
+	if err != nil {
+		return fmt.Errorf("failed to marshal push subscription: %v", err)
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.tables.PushSubscriptions),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %v", err)
+	}
+	return nil
+}
+
+// GetUserPushSubscriptions returns all of a user's subscriptions ordered by
+// ID
+func (db *Database) GetUserPushSubscriptions(ctx context.Context, userID string) ([]*models.PushSubscription, error) {
+	var subs []*models.PushSubscription
+	if err := db.queryUserItems(ctx, db.tables.PushSubscriptions, userID, &subs); err != nil {
+		return nil, fmt.Errorf("failed to query push subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// DeletePushSubscription deletes a subscription
+func (db *Database) DeletePushSubscription(ctx context.Context, userID, subscriptionID string) error {
+	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tables.PushSubscriptions),
+		Key: map[string]types.AttributeValue{
+			"user_id":         &types.AttributeValueMemberS{Value: userID},
+			"subscription_id": &types.AttributeValueMemberS{Value: subscriptionID},
+		},
+		ConditionExpression: aws.String("attribute_exists(subscription_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("push subscription %s: %w", subscriptionID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete push subscription: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ClaimRelease records that a release of held alerts is about to be sent.
+// It fails with ErrConflict if the release was claimed before.
+func (db *Database) ClaimRelease(ctx context.Context, claim *models.ReleaseClaim) error {
+	item, err := attributevalue.MarshalMap(claim)
+	if err != nil {
+		return fmt.Errorf("failed to marshal release claim: %v", err)
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tables.ReleaseClaims),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(release_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("release %s of %s already claimed: %w", claim.ReleaseID, claim.UserID, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim release: %v", err)
+	}
+	return nil
+}
+
+// UnclaimRelease drops a release claim
+func (db *Database) UnclaimRelease(ctx context.Context, userID, releaseID string) error {
+	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tables.ReleaseClaims),
+		Key: map[string]types.AttributeValue{
+			"user_id":    &types.AttributeValueMemberS{Value: userID},
+			"release_id": &types.AttributeValueMemberS{Value: releaseID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unclaim release: %v", err)
+	}
+	return nil
+}
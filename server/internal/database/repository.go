@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	// CreateUser stores a new user. It fails with ErrConflict if a user with
+	// the same email already exists.
+	CreateUser(ctx context.Context, user *models.User) error
+	// GetUserByEmail returns the user with the given email, or ErrNotFound
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	// UpdateUser replaces a user. It fails with ErrConflict if the stored
+	// user is missing or no longer at user.Version.
+	UpdateUser(ctx context.Context, user *models.User) error
+}
+
+// PortfolioRepository stores the stocks held in users' portfolios
+type PortfolioRepository interface {
+	// CreateStock adds a stock to a user's portfolio, assigning an ID if the
+	// stock has none. It fails with ErrConflict if the ID is already taken.
+	CreateStock(ctx context.Context, stock *models.Stock) error
+	// GetStock returns a stock from a user's portfolio, or ErrNotFound
+	GetStock(ctx context.Context, userID, stockID string) (*models.Stock, error)
+	// GetUserStocks returns all stocks in a user's portfolio ordered by ID
+	GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error)
+	// ListUserStocks returns one page of a user's portfolio ordered by ID,
+	// and the cursor of the next page or "" after the last one. It fails
+	// with ErrInvalidCursor if page.Cursor was not issued for this user.
+	ListUserStocks(ctx context.Context, userID string, page PageOptions) ([]models.Stock, string, error)
+	// UpdateStock replaces a stock. It fails with ErrConflict if the stored
+	// stock is missing or no longer at stock.Version.
+	UpdateStock(ctx context.Context, stock *models.Stock) error
+	// UpdateStockPrice writes only the price fields of a stock, with the same
+	// version check as UpdateStock
+	UpdateStockPrice(ctx context.Context, stock *models.Stock) error
+	// RemoveStock removes a stock and its triggers from a user's portfolio
+	// in one atomic write, and returns the removed stock. It fails with
+	// ErrNotFound if the stock does not exist.
+	RemoveStock(ctx context.Context, userID, stockID string) (*models.Stock, error)
+	// GetAllUniqueStocks returns the most recently added stock for every
+	// symbol held by any user
+	GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error)
+	// ScanStocks calls fn for every stock in every portfolio, with its
+	// trigger IDs. fn may be called concurrently; the first error it
+	// returns stops the scan.
+	ScanStocks(ctx context.Context, fn func(models.Stock) error) error
+}
+
+// TriggerRepository stores stock triggers
+type TriggerRepository interface {
+	// CreateTrigger stores a new trigger and links it to its stock in one
+	// atomic write. It fails with ErrNotFound if the stock does not exist.
+	CreateTrigger(ctx context.Context, trigger *models.StockTrigger) error
+	// GetTrigger returns a trigger by its ID, or ErrNotFound
+	GetTrigger(ctx context.Context, triggerID string) (*models.StockTrigger, error)
+	// GetTriggersBySymbol returns all triggers on a symbol
+	GetTriggersBySymbol(ctx context.Context, symbol, exchange string) ([]*models.StockTrigger, error)
+	// GetTriggersByUser returns all triggers owned by a user
+	GetTriggersByUser(ctx context.Context, userID string) ([]*models.StockTrigger, error)
+	// ListTriggersByUser returns one page of a user's triggers, and the
+	// cursor of the next page or "" after the last one. It fails with
+	// ErrInvalidCursor if page.Cursor was not issued for this user.
+	ListTriggersByUser(ctx context.Context, userID string, page PageOptions) ([]*models.StockTrigger, string, error)
+	// UpdateTrigger replaces a trigger. It fails with ErrConflict if the
+	// stored trigger is missing or no longer at trigger.Version.
+	UpdateTrigger(ctx context.Context, trigger *models.StockTrigger) error
+	// DeleteTrigger deletes a trigger and unlinks it from its stock in one
+	// atomic write. It fails with ErrNotFound if the trigger does not exist.
+	DeleteTrigger(ctx context.Context, triggerID string) error
+}
+
+// TriggerFireRepository stores trigger fires and the outcome of notifying
+// users of them
+type TriggerFireRepository interface {
+	// SaveTriggerFire stores a fire with its deliveries, replacing any
+	// earlier record of the same fire
+	SaveTriggerFire(ctx context.Context, fire *models.TriggerFire) error
+	// UpdateTriggerFire replaces a stored fire and increments its version.
+	// It fails with ErrConflict if the stored fire is missing or no longer
+	// at fire.Version, so a write based on a stale read never overwrites
+	// one made since.
+	UpdateTriggerFire(ctx context.Context, fire *models.TriggerFire) error
+	// GetTriggerFire returns a fire by its ID, or ErrNotFound
+	GetTriggerFire(ctx context.Context, fireID string) (*models.TriggerFire, error)
+	// GetUserTriggerFires returns the fires of a user's triggers from since
+	// up to but not including until, oldest first
+	GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error)
+
+	// FireTrigger replaces a fired trigger and stores its fire in one
+	// atomic write, so a fire is never recorded without its notifications
+	// going into the outbox. It fails with ErrConflict if the stored
+	// trigger is missing or no longer at trigger.Version, or the fire
+	// exists, and then writes neither.
+	FireTrigger(ctx context.Context, trigger *models.StockTrigger, fire *models.TriggerFire) error
+	// GetDueTriggerFires returns up to limit pending fires whose next
+	// attempt is due by now, the longest due first
+	GetDueTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error)
+	// LeaseTriggerFire starts a delivery attempt at a pending fire: it
+	// counts the attempt and puts the fire's next attempt off until until,
+	// so no one else attempts it meanwhile, and increments its version. It
+	// fails with ErrConflict if the stored fire is no longer pending or no
+	// longer at fire.Version.
+	LeaseTriggerFire(ctx context.Context, fire *models.TriggerFire, until time.Time) error
+	// GetHeldTriggerFires returns up to limit fires with deliveries held
+	// until now or before, the longest due first
+	GetHeldTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error)
+}
+
+// DigestRepository records which digests have gone out
+type DigestRepository interface {
+	// ClaimDigest records that a digest is about to be sent. It fails with
+	// ErrConflict if the digest was claimed before, so each is sent once.
+	ClaimDigest(ctx context.Context, claim *models.DigestClaim) error
+	// ReleaseDigest drops a claim so the digest can be sent again after
+	// sending it failed. Releasing an unclaimed digest is not an error.
+	ReleaseDigest(ctx context.Context, userID, digestID string) error
+}
+
+// ReleaseClaimRepository records which releases of alerts held through
+// quiet hours are being sent
+type ReleaseClaimRepository interface {
+	// ClaimRelease records that a release is about to be sent. It fails
+	// with ErrConflict if the release was claimed before, so each is sent
+	// once.
+	ClaimRelease(ctx context.Context, claim *models.ReleaseClaim) error
+	// UnclaimRelease drops a claim so the release can be sent again after
+	// sending it failed. Dropping an unclaimed release is not an error.
+	UnclaimRelease(ctx context.Context, userID, releaseID string) error
+}
+
+// WebhookRepository stores users' webhooks and the deliveries to them that
+// failed for good
+type WebhookRepository interface {
+	// CreateWebhook stores a new webhook, assigning an ID if it has none
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	// GetWebhook returns one of a user's webhooks, or ErrNotFound
+	GetWebhook(ctx context.Context, userID, webhookID string) (*models.Webhook, error)
+	// GetUserWebhooks returns all of a user's webhooks ordered by ID
+	GetUserWebhooks(ctx context.Context, userID string) ([]*models.Webhook, error)
+	// UpdateWebhook replaces a webhook. It fails with ErrConflict if the
+	// stored webhook is missing or no longer at webhook.Version.
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	// DeleteWebhook deletes a webhook and its dead letters. It fails with
+	// ErrNotFound if the webhook does not exist.
+	DeleteWebhook(ctx context.Context, userID, webhookID string) error
+	// RecordWebhookResult atomically clears a webhook's failures after a
+	// delivery succeeded, or adds one after it failed, and returns the new
+	// count. The webhook's version is left alone so the count never makes
+	// an update conflict. It fails with ErrNotFound if the webhook does not
+	// exist.
+	RecordWebhookResult(ctx context.Context, userID, webhookID string, ok bool) (int, error)
+
+	// SaveWebhookDeadLetter stores a dead letter, replacing any earlier one
+	// with the same ID
+	SaveWebhookDeadLetter(ctx context.Context, letter *models.WebhookDeadLetter) error
+	// GetWebhookDeadLetter returns one of a user's dead letters, or
+	// ErrNotFound
+	GetWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) (*models.WebhookDeadLetter, error)
+	// GetUserWebhookDeadLetters returns all of a user's dead letters ordered
+	// by ID
+	GetUserWebhookDeadLetters(ctx context.Context, userID string) ([]*models.WebhookDeadLetter, error)
+	// DeleteWebhookDeadLetter deletes a dead letter. It fails with
+	// ErrNotFound if the dead letter does not exist.
+	DeleteWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) error
+}
+
+// PushSubscriptionRepository stores the browsers users subscribed to Web
+// Push alerts
+type PushSubscriptionRepository interface {
+	// SavePushSubscription stores a subscription, replacing any earlier one
+	// with the same ID
+	SavePushSubscription(ctx context.Context, sub *models.PushSubscription) error
+	// GetUserPushSubscriptions returns all of a user's subscriptions ordered
+	// by ID
+	GetUserPushSubscriptions(ctx context.Context, userID string) ([]*models.PushSubscription, error)
+	// DeletePushSubscription deletes a subscription. It fails with
+	// ErrNotFound if the subscription does not exist.
+	DeletePushSubscription(ctx context.Context, userID, subscriptionID string) error
+}
+
+// Store is a storage backend providing every repository
+type Store interface {
+	UserRepository
+	PortfolioRepository
+	TriggerRepository
+	TriggerFireRepository
+	WebhookRepository
+	DigestRepository
+	ReleaseClaimRepository
+	PushSubscriptionRepository
+}
@@ -0,0 +1,35 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// ClaimDigest records that a digest is about to be sent. It fails with
+// ErrConflict if the digest was claimed before.
+func (s *Store) ClaimDigest(ctx context.Context, claim *models.DigestClaim) error {
+	_, err := s.exec(ctx, s.db, `INSERT INTO digest_claims (user_id, digest_id, claimed_at) VALUES (?, ?, ?)`,
+		claim.UserID, claim.DigestID, claim.ClaimedAt)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("digest %s of %s already claimed: %w", claim.DigestID, claim.UserID, database.ErrConflict)
+	}
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %s: %w", claim.UserID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim digest: %v", err)
+	}
+	return nil
+}
+
+// ReleaseDigest drops a digest claim
+func (s *Store) ReleaseDigest(ctx context.Context, userID, digestID string) error {
+	_, err := s.exec(ctx, s.db, `DELETE FROM digest_claims WHERE user_id = ? AND digest_id = ?`, userID, digestID)
+	if err != nil {
+		return fmt.Errorf("failed to release digest: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+const fireColumns = `fire_id, trigger_id, user_id, symbol, exchange, price, prev_price, message,
+	fired_at, deliveries, outbox, attempts, next_attempt_at, held_until, version`
+
+// SaveTriggerFire stores a fire with its deliveries, replacing any earlier
+// record of it
+func (s *Store) SaveTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	if err := s.insertFire(ctx, s.db, fire, `ON CONFLICT (fire_id) DO UPDATE SET deliveries = excluded.deliveries,
+		outbox = excluded.outbox, attempts = excluded.attempts, next_attempt_at = excluded.next_attempt_at,
+		held_until = excluded.held_until, version = excluded.version`); err != nil {
+		return fmt.Errorf("failed to save trigger fire: %v", err)
+	}
+	return nil
+}
+
+// UpdateTriggerFire replaces a fire's outcome if it is still at the version
+// it was read at
+func (s *Store) UpdateTriggerFire(ctx context.Context, fire *models.TriggerFire) error {
+	deliveries, err := json.Marshal(fire.Deliveries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deliveries: %v", err)
+	}
+	outbox, nextAttemptAt, heldUntil := fireIndexes(fire)
+
+	res, err := s.exec(ctx, s.db, `UPDATE trigger_fires SET deliveries = ?, outbox = ?, attempts = ?,
+		next_attempt_at = ?, held_until = ?, version = version + 1
+		WHERE fire_id = ? AND version = ?`,
+		string(deliveries), outbox, fire.Attempts, nextAttemptAt, heldUntil, fire.FireID, fire.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update trigger fire: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+	}
+	fire.Version++
+	return nil
+}
+
+// FireTrigger updates a fired trigger and inserts its fire in one
+// transaction
+func (s *Store) FireTrigger(ctx context.Context, trigger *models.StockTrigger, fire *models.TriggerFire) error {
+	updatedAt := time.Now()
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := s.updateTrigger(ctx, tx, trigger, updatedAt); err != nil {
+			return err
+		}
+		err := s.insertFire(ctx, tx, fire, "")
+		if isUniqueViolation(err) {
+			return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save trigger fire: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	trigger.UpdatedAt = updatedAt
+	trigger.Version++
+	return nil
+}
+
+// insertFire inserts a fire, followed by an ON CONFLICT clause if one is
+// given
+func (s *Store) insertFire(ctx context.Context, q querier, fire *models.TriggerFire, onConflict string) error {
+	deliveries, err := json.Marshal(fire.Deliveries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deliveries: %v", err)
+	}
+	outbox, nextAttemptAt, heldUntil := fireIndexes(fire)
+
+	_, err = s.exec(ctx, q, `INSERT INTO trigger_fires (`+fireColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) `+onConflict,
+		fire.FireID, fire.TriggerID, fire.UserID, fire.Symbol, fire.Exchange, fire.Price, fire.PrevPrice,
+		fire.Message, fire.FiredAt.UTC(), string(deliveries), outbox, fire.Attempts, nextAttemptAt, heldUntil,
+		fire.Version)
+	return err
+}
+
+// fireIndexes returns the columns fires are looked up by, NULL for fires
+// that aren't looked up that way
+func fireIndexes(fire *models.TriggerFire) (outbox, nextAttemptAt, heldUntil any) {
+	// Only pending fires are looked up by outbox and due time
+	if fire.Outbox != "" {
+		outbox, nextAttemptAt = fire.Outbox, fire.NextAttemptAt.UTC()
+	}
+	// Only fires with held deliveries are looked up by when they are released
+	if !fire.HeldUntil.IsZero() {
+		heldUntil = fire.HeldUntil.UTC()
+	}
+	return outbox, nextAttemptAt, heldUntil
+}
+
+// GetTriggerFire returns a fire by its ID
+func (s *Store) GetTriggerFire(ctx context.Context, fireID string) (*models.TriggerFire, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+fireColumns+` FROM trigger_fires WHERE fire_id = ?`), fireID)
+
+	fire, err := scanFire(row)
+	if err != nil {
+		return nil, notFound(err, "trigger fire "+fireID)
+	}
+	return fire, nil
+}
+
+// GetUserTriggerFires returns a user's fires from since up to but not
+// including until, oldest first
+func (s *Store) GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error) {
+	// SQLite compares times as text, which follows time order when all of
+	// them are in UTC
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+fireColumns+` FROM trigger_fires
+		WHERE user_id = ? AND fired_at >= ? AND fired_at < ? ORDER BY fired_at, fire_id`), userID, since.UTC(), until.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trigger fires: %v", err)
+	}
+	defer rows.Close()
+
+	var fires []*models.TriggerFire
+	for rows.Next() {
+		fire, err := scanFire(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trigger fire: %v", err)
+		}
+		fires = append(fires, fire)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trigger fires: %v", err)
+	}
+	return fires, nil
+}
+
+// GetDueTriggerFires returns up to limit pending fires due by now, the
+// longest due first
+func (s *Store) GetDueTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+fireColumns+` FROM trigger_fires
+		WHERE outbox = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, fire_id LIMIT ?`),
+		models.OutboxPending, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due trigger fires: %v", err)
+	}
+	defer rows.Close()
+
+	var fires []*models.TriggerFire
+	for rows.Next() {
+		fire, err := scanFire(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trigger fire: %v", err)
+		}
+		fires = append(fires, fire)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trigger fires: %v", err)
+	}
+	return fires, nil
+}
+
+// GetHeldTriggerFires returns up to limit fires with deliveries held until
+// now or before, the longest due first
+func (s *Store) GetHeldTriggerFires(ctx context.Context, now time.Time, limit int) ([]*models.TriggerFire, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+fireColumns+` FROM trigger_fires
+		WHERE held_until <= ? ORDER BY held_until, fire_id LIMIT ?`), now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query held trigger fires: %v", err)
+	}
+	defer rows.Close()
+
+	var fires []*models.TriggerFire
+	for rows.Next() {
+		fire, err := scanFire(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trigger fire: %v", err)
+		}
+		fires = append(fires, fire)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trigger fires: %v", err)
+	}
+	return fires, nil
+}
+
+// LeaseTriggerFire starts a delivery attempt at a pending fire if it wasn't
+// written since it was read
+func (s *Store) LeaseTriggerFire(ctx context.Context, fire *models.TriggerFire, until time.Time) error {
+	res, err := s.exec(ctx, s.db, `UPDATE trigger_fires SET attempts = attempts + 1, next_attempt_at = ?,
+		version = version + 1 WHERE fire_id = ? AND outbox = ? AND attempts = ? AND version = ?`,
+		until.UTC(), fire.FireID, models.OutboxPending, fire.Attempts, fire.Version)
+	if err != nil {
+		return fmt.Errorf("failed to lease trigger fire: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("trigger fire %s: %w", fire.FireID, database.ErrConflict)
+	}
+
+	fire.Attempts++
+	fire.NextAttemptAt = until
+	fire.Version++
+	return nil
+}
+
+func scanFire(row scanner) (*models.TriggerFire, error) {
+	var fire models.TriggerFire
+	var deliveries string
+	var outbox sql.NullString
+	var nextAttemptAt, heldUntil sql.NullTime
+	err := row.Scan(&fire.FireID, &fire.TriggerID, &fire.UserID, &fire.Symbol, &fire.Exchange,
+		&fire.Price, &fire.PrevPrice, &fire.Message, &fire.FiredAt, &deliveries,
+		&outbox, &fire.Attempts, &nextAttemptAt, &heldUntil, &fire.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(deliveries), &fire.Deliveries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deliveries: %v", err)
+	}
+	fire.Outbox = outbox.String
+	fire.NextAttemptAt = nextAttemptAt.Time
+	fire.HeldUntil = heldUntil.Time
+	return &fire, nil
+}
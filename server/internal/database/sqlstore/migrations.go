@@ -0,0 +1,164 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/database"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a versioned schema change loaded from migrations/<dialect>
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations for the store's dialect in version order
+func (s *Store) Migrations() ([]Migration, error) {
+	dir := path.Join("migrations", string(s.dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		// Files are named <version>_<description>.sql
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version prefix", name)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", name, err)
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    strings.TrimSuffix(name, ".sql"),
+			SQL:     string(body),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// AppliedVersions returns the migration versions recorded as applied
+func (s *Store) AppliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies every pending migration, each in its own transaction
+func (s *Store) Migrate(ctx context.Context) error {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := s.AppliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			for _, stmt := range splitStatements(m.SQL) {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("migration %s failed: %v", m.Name, err)
+				}
+			}
+			_, err := s.exec(ctx, tx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+				m.Version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMigrations returns database.ErrPendingMigrations if any migration has
+// not been applied
+func (s *Store) CheckMigrations(ctx context.Context) error {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := s.AppliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m.Name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w %v; run `go run ./cmd/migrate up` or set AUTO_MIGRATE=true", database.ErrPendingMigrations, pending)
+	}
+	return nil
+}
+
+// splitStatements splits a migration file into individual statements,
+// dropping comment lines
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,15 @@
+-- Each trigger fire and the outcome of notifying its owner on every channel.
+-- Fires outlive their triggers, so there is no foreign key.
+CREATE TABLE trigger_fires (
+    fire_id    TEXT PRIMARY KEY,
+    trigger_id TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    symbol     TEXT NOT NULL,
+    exchange   TEXT NOT NULL,
+    price      DOUBLE PRECISION NOT NULL,
+    message    TEXT NOT NULL,
+    fired_at   TIMESTAMPTZ NOT NULL,
+    deliveries TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX trigger_fires_trigger_idx ON trigger_fires (trigger_id);
@@ -0,0 +1,14 @@
+-- Shares held, so digests can value portfolios. Stocks added before hold
+-- none and are only watched.
+ALTER TABLE stocks ADD COLUMN quantity DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Digests list the day's fires of each user.
+CREATE INDEX trigger_fires_user_idx ON trigger_fires (user_id, fired_at);
+
+-- The digests sent to each user, so none is sent twice.
+CREATE TABLE digest_claims (
+    user_id    TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    digest_id  TEXT NOT NULL,
+    claimed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, digest_id)
+);
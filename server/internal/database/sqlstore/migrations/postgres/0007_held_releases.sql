@@ -0,0 +1,13 @@
+-- Fires with deliveries held through quiet hours are found by when the
+-- quiet hours end. Fires stored before are not held.
+ALTER TABLE trigger_fires ADD COLUMN held_until TIMESTAMPTZ;
+
+CREATE INDEX trigger_fires_held_idx ON trigger_fires (held_until);
+
+-- The releases of held alerts being sent, so each is sent once.
+CREATE TABLE release_claims (
+    user_id    TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    release_id TEXT NOT NULL,
+    claimed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, release_id)
+);
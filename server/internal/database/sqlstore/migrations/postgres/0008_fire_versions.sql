@@ -0,0 +1,3 @@
+-- Fires are updated on the condition that they weren't written since they
+-- were read, so a stale delivery attempt can't undo a later one.
+ALTER TABLE trigger_fires ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,53 @@
+-- Users, their portfolios and the triggers on each holding.
+-- Stocks and triggers reference users by email, which is how the API
+-- identifies the signed-in user.
+CREATE TABLE users (
+    email                    TEXT PRIMARY KEY,
+    user_id                  TEXT NOT NULL UNIQUE,
+    password_hash            TEXT NOT NULL,
+    created_at               TIMESTAMP NOT NULL,
+    updated_at               TIMESTAMP NOT NULL,
+    notification_preferences TEXT NOT NULL DEFAULT '{}',
+    active_triggers          INTEGER NOT NULL DEFAULT 0,
+    version                  INTEGER NOT NULL
+);
+
+CREATE TABLE stocks (
+    user_id      TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    stock_id     TEXT NOT NULL,
+    symbol       TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    exchange     TEXT NOT NULL,
+    currency     TEXT NOT NULL,
+    price        REAL NOT NULL,
+    last_price   REAL NOT NULL,
+    added_at     TIMESTAMP NOT NULL,
+    last_updated TIMESTAMP NOT NULL,
+    version      INTEGER NOT NULL,
+    PRIMARY KEY (user_id, stock_id)
+);
+
+CREATE INDEX stocks_symbol_idx ON stocks (symbol, exchange);
+
+CREATE TABLE triggers (
+    trigger_id            TEXT PRIMARY KEY,
+    user_id               TEXT NOT NULL,
+    stock_id              TEXT NOT NULL,
+    symbol                TEXT NOT NULL,
+    exchange              TEXT NOT NULL,
+    type                  TEXT NOT NULL,
+    is_active             BOOLEAN NOT NULL,
+    created_at            TIMESTAMP NOT NULL,
+    updated_at            TIMESTAMP NOT NULL,
+    last_trigger          TIMESTAMP NOT NULL,
+    price_threshold       REAL NOT NULL DEFAULT 0,
+    volume_multiplier     REAL NOT NULL DEFAULT 0,
+    notification_channels TEXT NOT NULL DEFAULT '[]',
+    cooldown_minutes      INTEGER NOT NULL DEFAULT 0,
+    version               INTEGER NOT NULL,
+    FOREIGN KEY (user_id, stock_id) REFERENCES stocks (user_id, stock_id) ON DELETE CASCADE
+);
+
+CREATE INDEX triggers_symbol_idx ON triggers (symbol, exchange);
+CREATE INDEX triggers_user_idx ON triggers (user_id);
+CREATE INDEX triggers_stock_idx ON triggers (user_id, stock_id);
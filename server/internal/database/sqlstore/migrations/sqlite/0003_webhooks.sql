@@ -0,0 +1,28 @@
+-- Endpoints users registered to receive trigger fires on, and the
+-- deliveries to them that failed every attempt.
+CREATE TABLE webhooks (
+    user_id     TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    webhook_id  TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    secret      TEXT NOT NULL,
+    enabled     BOOLEAN NOT NULL,
+    failures    INTEGER NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP,
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL,
+    version     INTEGER NOT NULL,
+    PRIMARY KEY (user_id, webhook_id)
+);
+
+CREATE TABLE webhook_dead_letters (
+    user_id        TEXT NOT NULL,
+    dead_letter_id TEXT NOT NULL,
+    webhook_id     TEXT NOT NULL,
+    fire_id        TEXT NOT NULL,
+    payload        TEXT NOT NULL,
+    error          TEXT NOT NULL,
+    attempts       INTEGER NOT NULL,
+    failed_at      TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, dead_letter_id),
+    FOREIGN KEY (user_id, webhook_id) REFERENCES webhooks (user_id, webhook_id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+-- Fires are the outbox their notifications are delivered from: written with
+-- the trigger, then attempted until every channel is settled. Fires stored
+-- before were delivered already.
+ALTER TABLE trigger_fires ADD COLUMN prev_price REAL NOT NULL DEFAULT 0;
+ALTER TABLE trigger_fires ADD COLUMN outbox TEXT;
+ALTER TABLE trigger_fires ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE trigger_fires ADD COLUMN next_attempt_at TIMESTAMP;
+
+CREATE INDEX trigger_fires_outbox_idx ON trigger_fires (outbox, next_attempt_at);
@@ -0,0 +1,11 @@
+-- Browsers users subscribed to Web Push alerts, one per push endpoint.
+CREATE TABLE push_subscriptions (
+    user_id         TEXT NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    subscription_id TEXT NOT NULL,
+    endpoint        TEXT NOT NULL,
+    p256dh          TEXT NOT NULL,
+    auth            TEXT NOT NULL,
+    device          TEXT NOT NULL,
+    created_at      TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, subscription_id)
+);
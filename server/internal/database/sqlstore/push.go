@@ -0,0 +1,68 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+const pushSubscriptionColumns = `user_id, subscription_id, endpoint, p256dh, auth, device, created_at`
+
+// SavePushSubscription stores a subscription, replacing any earlier one with
+// the same ID
+func (s *Store) SavePushSubscription(ctx context.Context, sub *models.PushSubscription) error {
+	_, err := s.exec(ctx, s.db, `INSERT INTO push_subscriptions (`+pushSubscriptionColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, subscription_id) DO UPDATE SET endpoint = excluded.endpoint,
+			p256dh = excluded.p256dh, auth = excluded.auth, device = excluded.device,
+			created_at = excluded.created_at`,
+		sub.UserID, sub.SubscriptionID, sub.Endpoint, sub.P256DH, sub.Auth, sub.Device, sub.CreatedAt)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %s: %w", sub.UserID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %v", err)
+	}
+	return nil
+}
+
+// GetUserPushSubscriptions returns all of a user's subscriptions ordered by
+// ID
+func (s *Store) GetUserPushSubscriptions(ctx context.Context, userID string) ([]*models.PushSubscription, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+pushSubscriptionColumns+` FROM push_subscriptions
+		WHERE user_id = ? ORDER BY subscription_id`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query push subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var subs []*models.PushSubscription
+	for rows.Next() {
+		var sub models.PushSubscription
+		err := rows.Scan(&sub.UserID, &sub.SubscriptionID, &sub.Endpoint, &sub.P256DH, &sub.Auth,
+			&sub.Device, &sub.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push subscription: %v", err)
+		}
+		subs = append(subs, &sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read push subscriptions: %v", err)
+	}
+	return subs, nil
+}
+
+// DeletePushSubscription deletes a subscription
+func (s *Store) DeletePushSubscription(ctx context.Context, userID, subscriptionID string) error {
+	res, err := s.exec(ctx, s.db, `DELETE FROM push_subscriptions WHERE user_id = ? AND subscription_id = ?`,
+		userID, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete push subscription: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("push subscription %s: %w", subscriptionID, database.ErrNotFound)
+	}
+	return nil
+}
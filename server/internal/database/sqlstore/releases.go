@@ -0,0 +1,35 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// ClaimRelease records that a release of held alerts is about to be sent.
+// It fails with ErrConflict if the release was claimed before.
+func (s *Store) ClaimRelease(ctx context.Context, claim *models.ReleaseClaim) error {
+	_, err := s.exec(ctx, s.db, `INSERT INTO release_claims (user_id, release_id, claimed_at) VALUES (?, ?, ?)`,
+		claim.UserID, claim.ReleaseID, claim.ClaimedAt)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("release %s of %s already claimed: %w", claim.ReleaseID, claim.UserID, database.ErrConflict)
+	}
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %s: %w", claim.UserID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim release: %v", err)
+	}
+	return nil
+}
+
+// UnclaimRelease drops a release claim
+func (s *Store) UnclaimRelease(ctx context.Context, userID, releaseID string) error {
+	_, err := s.exec(ctx, s.db, `DELETE FROM release_claims WHERE user_id = ? AND release_id = ?`, userID, releaseID)
+	if err != nil {
+		return fmt.Errorf("failed to unclaim release: %v", err)
+	}
+	return nil
+}
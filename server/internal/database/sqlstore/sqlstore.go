@@ -0,0 +1,174 @@
+// Package sqlstore is the SQL implementation of the database repositories.
+// It supports PostgreSQL for deployments that can't use DynamoDB and SQLite
+// for zero-dependency local runs.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"stockmarket/server/internal/database"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect identifies the SQL database in use
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// maxAttempts bounds how often a version-checked write is retried after a
+// concurrent change
+const maxAttempts = 3
+
+// Store is the SQL implementation of database.Store
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+var _ database.Store = (*Store)(nil)
+
+// Open connects to a SQLite or PostgreSQL database. It does not touch the
+// schema; call Migrate or CheckMigrations before use. For SQLite dsn is a
+// file path, or ":memory:".
+func Open(ctx context.Context, dialect Dialect, dsn string) (*Store, error) {
+	var driver string
+	switch dialect {
+	case SQLite:
+		driver = "sqlite"
+		// Foreign keys are off by default in SQLite and must be enabled on
+		// every connection
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	case Postgres:
+		driver = "pgx"
+	default:
+		return nil, fmt.Errorf("unsupported SQL dialect %q", dialect)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %v", dialect, err)
+	}
+	if dialect == SQLite {
+		// SQLite allows a single writer; serialising through one connection
+		// also keeps ":memory:" databases from being per-connection
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %v", dialect, err)
+	}
+
+	return New(db, dialect), nil
+}
+
+// New wraps an already opened database. The schema must be migrated.
+func New(db *sql.DB, dialect Dialect) *Store {
+	return &Store{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites the ? placeholders in query into the dialect's style
+func (s *Store) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// exec runs a statement with rebound placeholders
+func (s *Store) exec(ctx context.Context, q querier, query string, args ...any) (sql.Result, error) {
+	return q.ExecContext(ctx, s.rebind(query), args...)
+}
+
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// affectedOne reports whether a write touched exactly one row
+func affectedOne(res sql.Result) (bool, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// isUniqueViolation reports whether err is a primary key or unique
+// constraint violation
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	// SQLite: "UNIQUE constraint failed", PostgreSQL: SQLSTATE 23505
+	return strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "23505")
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation
+func isForeignKeyViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	// SQLite: "FOREIGN KEY constraint failed", PostgreSQL: SQLSTATE 23503
+	return strings.Contains(msg, "FOREIGN KEY constraint failed") || strings.Contains(msg, "23503")
+}
+
+// notFound maps sql.ErrNoRows to database.ErrNotFound
+func notFound(err error, what string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", what, database.ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/storetest"
+)
+
+func TestSQLiteContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return openMigrated(t, context.Background(), SQLite, ":memory:")
+	})
+}
+
+// TestPostgresContract runs against the database in POSTGRES_TEST_URL. The
+// database is wiped before every subtest.
+func TestPostgresContract(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_URL")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_URL not set")
+	}
+
+	storetest.Run(t, func(t *testing.T) database.Store {
+		ctx := context.Background()
+		store := openMigrated(t, ctx, Postgres, dsn)
+		if _, err := store.db.ExecContext(ctx, `TRUNCATE users CASCADE`); err != nil {
+			t.Fatalf("failed to reset database: %v", err)
+		}
+		return store
+	})
+}
+
+// openMigrated opens a store and brings its schema up to date
+func openMigrated(t *testing.T, ctx context.Context, dialect Dialect, dsn string) *Store {
+	t.Helper()
+	store, err := Open(ctx, dialect, dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return store
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(ctx, SQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CheckMigrations(ctx); !errors.Is(err, database.ErrPendingMigrations) {
+		t.Fatalf("CheckMigrations before Migrate = %v, want ErrPendingMigrations", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("Migrate #%d: %v", i+1, err)
+		}
+	}
+	if err := store.CheckMigrations(ctx); err != nil {
+		t.Fatalf("CheckMigrations after Migrate: %v", err)
+	}
+
+	migrations, err := store.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	applied, err := store.AppliedVersions(ctx)
+	if err != nil {
+		t.Fatalf("AppliedVersions: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+}
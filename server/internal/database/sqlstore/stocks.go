@@ -0,0 +1,297 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const stockColumns = `user_id, stock_id, symbol, name, exchange, currency, quantity, price,
+	last_price, added_at, last_updated, version`
+
+// CreateStock adds a stock to a user's portfolio
+func (s *Store) CreateStock(ctx context.Context, stock *models.Stock) error {
+	if stock.StockID == "" {
+		stock.StockID = uuid.New().String()
+	}
+	if stock.AddedAt.IsZero() {
+		stock.AddedAt = time.Now()
+	}
+	stock.LastUpdated = time.Now()
+
+	_, err := s.exec(ctx, s.db, `INSERT INTO stocks (`+stockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stock.UserID, stock.StockID, stock.Symbol, stock.Name, stock.Exchange, stock.Currency, stock.Quantity,
+		stock.Price, stock.LastPrice, stock.AddedAt, stock.LastUpdated, 1)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("stock %s already exists: %w", stock.StockID, database.ErrConflict)
+	}
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %s: %w", stock.UserID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save stock: %v", err)
+	}
+
+	stock.Version = 1
+	return nil
+}
+
+// GetStock returns a stock from a user's portfolio
+func (s *Store) GetStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+stockColumns+` FROM stocks
+		WHERE user_id = ? AND stock_id = ?`), userID, stockID)
+
+	stock, err := scanStock(row)
+	if err != nil {
+		return nil, notFound(err, "stock "+stockID)
+	}
+	if err := s.loadTriggerIDs(ctx, []*models.Stock{stock}); err != nil {
+		return nil, err
+	}
+	return stock, nil
+}
+
+// GetUserStocks returns all stocks in a user's portfolio ordered by ID
+func (s *Store) GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+stockColumns+` FROM stocks
+		WHERE user_id = ? ORDER BY stock_id`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stocks: %v", err)
+	}
+	stocks, err := scanStocks(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	ptrs := make([]*models.Stock, len(stocks))
+	for i := range stocks {
+		ptrs[i] = &stocks[i]
+	}
+	if err := s.loadTriggerIDs(ctx, ptrs); err != nil {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+// ListUserStocks returns one page of a user's portfolio ordered by ID
+func (s *Store) ListUserStocks(ctx context.Context, userID string, page database.PageOptions) ([]models.Stock, string, error) {
+	after, err := database.CursorValue(page.Cursor, map[string]string{"user_id": userID}, "stock_id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Read one extra row to learn whether another page follows
+	limit := page.PageLimit()
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+stockColumns+` FROM stocks
+		WHERE user_id = ? AND stock_id > ? ORDER BY stock_id LIMIT ?`), userID, after, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query stocks: %v", err)
+	}
+	stocks, err := scanStocks(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(stocks) > limit {
+		stocks = stocks[:limit]
+		next = database.EncodeCursor(map[string]string{"user_id": userID, "stock_id": stocks[limit-1].StockID})
+	}
+
+	ptrs := make([]*models.Stock, len(stocks))
+	for i := range stocks {
+		ptrs[i] = &stocks[i]
+	}
+	if err := s.loadTriggerIDs(ctx, ptrs); err != nil {
+		return nil, "", err
+	}
+	return stocks, next, nil
+}
+
+// UpdateStock replaces a stock if it is still at stock.Version. The triggers
+// list is derived from the triggers table and is not written here.
+func (s *Store) UpdateStock(ctx context.Context, stock *models.Stock) error {
+	lastUpdated := time.Now()
+	res, err := s.exec(ctx, s.db, `UPDATE stocks SET symbol = ?, name = ?, exchange = ?, currency = ?,
+		quantity = ?, price = ?, last_price = ?, last_updated = ?, version = version + 1
+		WHERE user_id = ? AND stock_id = ? AND version = ?`,
+		stock.Symbol, stock.Name, stock.Exchange, stock.Currency, stock.Quantity, stock.Price, stock.LastPrice,
+		lastUpdated, stock.UserID, stock.StockID, stock.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update stock: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("stock %s: %w", stock.StockID, database.ErrConflict)
+	}
+
+	stock.LastUpdated = lastUpdated
+	stock.Version++
+	return nil
+}
+
+// UpdateStockPrice writes only the price fields of a stock if it is still
+// at stock.Version
+func (s *Store) UpdateStockPrice(ctx context.Context, stock *models.Stock) error {
+	res, err := s.exec(ctx, s.db, `UPDATE stocks SET price = ?, last_price = ?, last_updated = ?,
+		version = version + 1 WHERE user_id = ? AND stock_id = ? AND version = ?`,
+		stock.Price, stock.LastPrice, stock.LastUpdated, stock.UserID, stock.StockID, stock.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update stock price: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("stock %s: %w", stock.StockID, database.ErrConflict)
+	}
+
+	stock.Version++
+	return nil
+}
+
+// RemoveStock removes a stock from a user's portfolio. Its triggers are
+// removed by the foreign key cascade.
+func (s *Store) RemoveStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		stock, err := s.GetStock(ctx, userID, stockID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only delete the version that was read, so the returned stock lists
+		// exactly the triggers that were cascaded away
+		res, err := s.exec(ctx, s.db, `DELETE FROM stocks WHERE user_id = ? AND stock_id = ? AND version = ?`,
+			userID, stockID, stock.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete stock: %v", err)
+		}
+		if ok, err := affectedOne(res); err == nil && ok {
+			return stock, nil
+		}
+		// A trigger was added or removed since the read; re-read and retry
+	}
+
+	return nil, fmt.Errorf("failed to delete stock %s: %w", stockID, database.ErrConflict)
+}
+
+// GetAllUniqueStocks returns the most recently added stock for every symbol
+func (s *Store) GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+stockColumns+` FROM stocks ORDER BY symbol, added_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan stocks table: %v", err)
+	}
+	all, err := scanStocks(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are grouped by symbol with the newest first
+	var stocks []models.Stock
+	for i, stock := range all {
+		if i == 0 || stock.Symbol != all[i-1].Symbol {
+			stocks = append(stocks, stock)
+		}
+	}
+	return stocks, nil
+}
+
+// ScanStocks calls fn for every stock, one at a time
+func (s *Store) ScanStocks(ctx context.Context, fn func(models.Stock) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+stockColumns+` FROM stocks ORDER BY user_id, stock_id`)
+	if err != nil {
+		return fmt.Errorf("failed to scan stocks table: %v", err)
+	}
+	stocks, err := scanStocks(rows)
+	if err != nil {
+		return err
+	}
+
+	// Attach the trigger IDs with a single pass over the triggers table
+	triggerIDs := make(map[[2]string][]string)
+	rows, err = s.db.QueryContext(ctx, `SELECT user_id, stock_id, trigger_id FROM triggers
+		ORDER BY created_at, trigger_id`)
+	if err != nil {
+		return fmt.Errorf("failed to scan triggers table: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID, stockID, triggerID string
+		if err := rows.Scan(&userID, &stockID, &triggerID); err != nil {
+			return fmt.Errorf("failed to read stock triggers: %v", err)
+		}
+		key := [2]string{userID, stockID}
+		triggerIDs[key] = append(triggerIDs[key], triggerID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read stock triggers: %v", err)
+	}
+	rows.Close()
+
+	for _, stock := range stocks {
+		stock.Triggers = triggerIDs[[2]string{stock.UserID, stock.StockID}]
+		if err := fn(stock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTriggerIDs fills in the Triggers list of each stock
+func (s *Store) loadTriggerIDs(ctx context.Context, stocks []*models.Stock) error {
+	for _, stock := range stocks {
+		rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT trigger_id FROM triggers
+			WHERE user_id = ? AND stock_id = ? ORDER BY created_at, trigger_id`), stock.UserID, stock.StockID)
+		if err != nil {
+			return fmt.Errorf("failed to query stock triggers: %v", err)
+		}
+		stock.Triggers = nil
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read stock triggers: %v", err)
+			}
+			stock.Triggers = append(stock.Triggers, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read stock triggers: %v", err)
+		}
+	}
+	return nil
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanStock(row scanner) (*models.Stock, error) {
+	var stock models.Stock
+	err := row.Scan(&stock.UserID, &stock.StockID, &stock.Symbol, &stock.Name, &stock.Exchange,
+		&stock.Currency, &stock.Quantity, &stock.Price, &stock.LastPrice, &stock.AddedAt, &stock.LastUpdated, &stock.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
+
+func scanStocks(rows *sql.Rows) ([]models.Stock, error) {
+	defer rows.Close()
+
+	var stocks []models.Stock
+	for rows.Next() {
+		stock, err := scanStock(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stock: %v", err)
+		}
+		stocks = append(stocks, *stock)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stocks: %v", err)
+	}
+	return stocks, nil
+}
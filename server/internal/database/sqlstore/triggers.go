@@ -0,0 +1,198 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const triggerColumns = `trigger_id, user_id, stock_id, symbol, exchange, type, is_active,
+	created_at, updated_at, last_trigger, price_threshold, volume_multiplier,
+	notification_channels, cooldown_minutes, version`
+
+// CreateTrigger stores a new trigger and bumps its stock's version in the
+// same transaction
+func (s *Store) CreateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
+	channels, err := json.Marshal(trigger.NotificationChannels)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification channels: %v", err)
+	}
+
+	now := time.Now()
+	id := uuid.New().String()
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := s.exec(ctx, tx, `UPDATE stocks SET last_updated = ?, version = version + 1
+			WHERE user_id = ? AND stock_id = ?`, now, trigger.UserID, trigger.StockID)
+		if err != nil {
+			return fmt.Errorf("failed to update stock: %v", err)
+		}
+		if ok, err := affectedOne(res); err != nil || !ok {
+			return fmt.Errorf("stock %s: %w", trigger.StockID, database.ErrNotFound)
+		}
+
+		_, err = s.exec(ctx, tx, `INSERT INTO triggers (`+triggerColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, trigger.UserID, trigger.StockID, trigger.Symbol, trigger.Exchange, trigger.Type,
+			trigger.IsActive, now, now, time.Time{}, trigger.PriceThreshold, trigger.VolumeMultiplier,
+			string(channels), trigger.CooldownMinutes, 1)
+		if err != nil {
+			return fmt.Errorf("failed to create trigger: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	trigger.TriggerID = id
+	trigger.CreatedAt = now
+	trigger.UpdatedAt = now
+	trigger.LastTrigger = time.Time{}
+	trigger.Version = 1
+	return nil
+}
+
+// GetTrigger returns a trigger by its ID
+func (s *Store) GetTrigger(ctx context.Context, triggerID string) (*models.StockTrigger, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+triggerColumns+` FROM triggers WHERE trigger_id = ?`), triggerID)
+	trigger, err := scanTrigger(row)
+	if err != nil {
+		return nil, notFound(err, "trigger "+triggerID)
+	}
+	return trigger, nil
+}
+
+// GetTriggersBySymbol returns all triggers on a symbol
+func (s *Store) GetTriggersBySymbol(ctx context.Context, symbol, exchange string) ([]*models.StockTrigger, error) {
+	return s.queryTriggers(ctx, `WHERE symbol = ? AND exchange = ?`, symbol, exchange)
+}
+
+// GetTriggersByUser returns all triggers owned by a user
+func (s *Store) GetTriggersByUser(ctx context.Context, userID string) ([]*models.StockTrigger, error) {
+	return s.queryTriggers(ctx, `WHERE user_id = ?`, userID)
+}
+
+// ListTriggersByUser returns one page of a user's triggers ordered by ID
+func (s *Store) ListTriggersByUser(ctx context.Context, userID string, page database.PageOptions) ([]*models.StockTrigger, string, error) {
+	after, err := database.CursorValue(page.Cursor, map[string]string{"user_id": userID}, "trigger_id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Read one extra row to learn whether another page follows
+	limit := page.PageLimit()
+	triggers, err := s.queryTriggers(ctx, `WHERE user_id = ? AND trigger_id > ? ORDER BY trigger_id LIMIT ?`,
+		userID, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(triggers) > limit {
+		triggers = triggers[:limit]
+		next = database.EncodeCursor(map[string]string{"user_id": userID, "trigger_id": triggers[limit-1].TriggerID})
+	}
+	return triggers, next, nil
+}
+
+// UpdateTrigger replaces a trigger if it is still at trigger.Version
+func (s *Store) UpdateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
+	updatedAt := time.Now()
+	if err := s.updateTrigger(ctx, s.db, trigger, updatedAt); err != nil {
+		return err
+	}
+	trigger.UpdatedAt = updatedAt
+	trigger.Version++
+	return nil
+}
+
+// updateTrigger writes a trigger over its stored version, leaving trigger
+// itself to be updated once the write is committed
+func (s *Store) updateTrigger(ctx context.Context, q querier, trigger *models.StockTrigger, updatedAt time.Time) error {
+	channels, err := json.Marshal(trigger.NotificationChannels)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification channels: %v", err)
+	}
+
+	res, err := s.exec(ctx, q, `UPDATE triggers SET type = ?, is_active = ?, updated_at = ?,
+		last_trigger = ?, price_threshold = ?, volume_multiplier = ?, notification_channels = ?,
+		cooldown_minutes = ?, version = version + 1
+		WHERE trigger_id = ? AND version = ?`,
+		trigger.Type, trigger.IsActive, updatedAt, trigger.LastTrigger, trigger.PriceThreshold,
+		trigger.VolumeMultiplier, string(channels), trigger.CooldownMinutes,
+		trigger.TriggerID, trigger.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update trigger: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("trigger %s: %w", trigger.TriggerID, database.ErrConflict)
+	}
+	return nil
+}
+
+// DeleteTrigger deletes a trigger and bumps its stock's version in the same
+// transaction
+func (s *Store) DeleteTrigger(ctx context.Context, triggerID string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var userID, stockID string
+		row := tx.QueryRowContext(ctx, s.rebind(`SELECT user_id, stock_id FROM triggers WHERE trigger_id = ?`), triggerID)
+		if err := row.Scan(&userID, &stockID); err != nil {
+			return notFound(err, "trigger "+triggerID)
+		}
+
+		if _, err := s.exec(ctx, tx, `DELETE FROM triggers WHERE trigger_id = ?`, triggerID); err != nil {
+			return fmt.Errorf("failed to delete trigger: %v", err)
+		}
+		_, err := s.exec(ctx, tx, `UPDATE stocks SET last_updated = ?, version = version + 1
+			WHERE user_id = ? AND stock_id = ?`, time.Now(), userID, stockID)
+		if err != nil {
+			return fmt.Errorf("failed to update stock: %v", err)
+		}
+		return nil
+	})
+}
+
+// queryTriggers returns the triggers matching a WHERE clause
+func (s *Store) queryTriggers(ctx context.Context, where string, args ...any) ([]*models.StockTrigger, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+triggerColumns+` FROM triggers `+where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query triggers: %v", err)
+	}
+	defer rows.Close()
+
+	var triggers []*models.StockTrigger
+	for rows.Next() {
+		trigger, err := scanTrigger(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trigger: %v", err)
+		}
+		triggers = append(triggers, trigger)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read triggers: %v", err)
+	}
+	return triggers, nil
+}
+
+func scanTrigger(row scanner) (*models.StockTrigger, error) {
+	var trigger models.StockTrigger
+	var channels string
+	err := row.Scan(&trigger.TriggerID, &trigger.UserID, &trigger.StockID, &trigger.Symbol,
+		&trigger.Exchange, &trigger.Type, &trigger.IsActive, &trigger.CreatedAt, &trigger.UpdatedAt,
+		&trigger.LastTrigger, &trigger.PriceThreshold, &trigger.VolumeMultiplier, &channels,
+		&trigger.CooldownMinutes, &trigger.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(channels), &trigger.NotificationChannels); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification channels: %v", err)
+	}
+	return &trigger, nil
+}
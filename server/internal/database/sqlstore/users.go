@@ -0,0 +1,80 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+const userColumns = `email, user_id, password_hash, created_at, updated_at,
+	notification_preferences, active_triggers, version`
+
+// CreateUser stores a new user
+func (s *Store) CreateUser(ctx context.Context, user *models.User) error {
+	prefs, err := json.Marshal(user.NotificationPreferences)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification preferences: %v", err)
+	}
+
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+	_, err = s.exec(ctx, s.db, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Email, user.UserID, user.PasswordHash, user.CreatedAt, user.UpdatedAt,
+		string(prefs), user.ActiveTriggers, 1)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("user %s already exists: %w", user.Email, database.ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save user: %v", err)
+	}
+
+	user.Version = 1
+	return nil
+}
+
+// GetUserByEmail returns the user with the given email
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+userColumns+` FROM users WHERE email = ?`), email)
+
+	var user models.User
+	var prefs string
+	err := row.Scan(&user.Email, &user.UserID, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt,
+		&prefs, &user.ActiveTriggers, &user.Version)
+	if err != nil {
+		return nil, notFound(err, "user "+email)
+	}
+	if err := json.Unmarshal([]byte(prefs), &user.NotificationPreferences); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notification preferences: %v", err)
+	}
+	return &user, nil
+}
+
+// UpdateUser replaces a user if it is still at user.Version
+func (s *Store) UpdateUser(ctx context.Context, user *models.User) error {
+	prefs, err := json.Marshal(user.NotificationPreferences)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification preferences: %v", err)
+	}
+
+	updatedAt := time.Now()
+	res, err := s.exec(ctx, s.db, `UPDATE users SET user_id = ?, password_hash = ?, updated_at = ?,
+		notification_preferences = ?, active_triggers = ?, version = version + 1
+		WHERE email = ? AND version = ?`,
+		user.UserID, user.PasswordHash, updatedAt, string(prefs), user.ActiveTriggers,
+		user.Email, user.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("user %s: %w", user.Email, database.ErrConflict)
+	}
+
+	user.UpdatedAt = updatedAt
+	user.Version++
+	return nil
+}
@@ -0,0 +1,227 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const webhookColumns = `user_id, webhook_id, url, secret, enabled, failures, disabled_at,
+	created_at, updated_at, version`
+
+const deadLetterColumns = `user_id, dead_letter_id, webhook_id, fire_id, payload, error,
+	attempts, failed_at`
+
+// CreateWebhook stores a new webhook
+func (s *Store) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.WebhookID == "" {
+		webhook.WebhookID = uuid.New().String()
+	}
+	if webhook.CreatedAt.IsZero() {
+		webhook.CreatedAt = time.Now()
+	}
+	webhook.UpdatedAt = time.Now()
+
+	_, err := s.exec(ctx, s.db, `INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		webhook.UserID, webhook.WebhookID, webhook.URL, webhook.Secret, webhook.Enabled,
+		webhook.Failures, webhook.DisabledAt, webhook.CreatedAt, webhook.UpdatedAt, 1)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("webhook %s already exists: %w", webhook.WebhookID, database.ErrConflict)
+	}
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("user %s: %w", webhook.UserID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save webhook: %v", err)
+	}
+
+	webhook.Version = 1
+	return nil
+}
+
+// GetWebhook returns one of a user's webhooks
+func (s *Store) GetWebhook(ctx context.Context, userID, webhookID string) (*models.Webhook, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+webhookColumns+` FROM webhooks
+		WHERE user_id = ? AND webhook_id = ?`), userID, webhookID)
+
+	webhook, err := scanWebhook(row)
+	if err != nil {
+		return nil, notFound(err, "webhook "+webhookID)
+	}
+	return webhook, nil
+}
+
+// GetUserWebhooks returns all of a user's webhooks ordered by ID
+func (s *Store) GetUserWebhooks(ctx context.Context, userID string) ([]*models.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+webhookColumns+` FROM webhooks
+		WHERE user_id = ? ORDER BY webhook_id`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook: %v", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %v", err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces a webhook if it is still at webhook.Version
+func (s *Store) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	updatedAt := time.Now()
+	res, err := s.exec(ctx, s.db, `UPDATE webhooks SET url = ?, secret = ?, enabled = ?, failures = ?,
+		disabled_at = ?, updated_at = ?, version = version + 1
+		WHERE user_id = ? AND webhook_id = ? AND version = ?`,
+		webhook.URL, webhook.Secret, webhook.Enabled, webhook.Failures, webhook.DisabledAt,
+		updatedAt, webhook.UserID, webhook.WebhookID, webhook.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("webhook %s: %w", webhook.WebhookID, database.ErrConflict)
+	}
+
+	webhook.UpdatedAt = updatedAt
+	webhook.Version++
+	return nil
+}
+
+// DeleteWebhook deletes a webhook. Its dead letters are removed by the
+// foreign key cascade.
+func (s *Store) DeleteWebhook(ctx context.Context, userID, webhookID string) error {
+	res, err := s.exec(ctx, s.db, `DELETE FROM webhooks WHERE user_id = ? AND webhook_id = ?`, userID, webhookID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("webhook %s: %w", webhookID, database.ErrNotFound)
+	}
+	return nil
+}
+
+// RecordWebhookResult clears or counts a webhook's failures
+func (s *Store) RecordWebhookResult(ctx context.Context, userID, webhookID string, ok bool) (int, error) {
+	update := `UPDATE webhooks SET failures = failures + 1 WHERE user_id = ? AND webhook_id = ?`
+	if ok {
+		update = `UPDATE webhooks SET failures = 0 WHERE user_id = ? AND webhook_id = ?`
+	}
+
+	var failures int
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.exec(ctx, tx, update, userID, webhookID); err != nil {
+			return fmt.Errorf("failed to record webhook result: %v", err)
+		}
+		row := tx.QueryRowContext(ctx, s.rebind(`SELECT failures FROM webhooks
+			WHERE user_id = ? AND webhook_id = ?`), userID, webhookID)
+		return notFound(row.Scan(&failures), "webhook "+webhookID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// SaveWebhookDeadLetter stores a dead letter, replacing any earlier one with
+// the same ID
+func (s *Store) SaveWebhookDeadLetter(ctx context.Context, letter *models.WebhookDeadLetter) error {
+	_, err := s.exec(ctx, s.db, `INSERT INTO webhook_dead_letters (`+deadLetterColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, dead_letter_id) DO UPDATE SET payload = excluded.payload,
+			error = excluded.error, attempts = excluded.attempts, failed_at = excluded.failed_at`,
+		letter.UserID, letter.DeadLetterID, letter.WebhookID, letter.FireID, letter.Payload,
+		letter.Error, letter.Attempts, letter.FailedAt)
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("webhook %s: %w", letter.WebhookID, database.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save dead letter: %v", err)
+	}
+	return nil
+}
+
+// GetWebhookDeadLetter returns one of a user's dead letters
+func (s *Store) GetWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) (*models.WebhookDeadLetter, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+deadLetterColumns+` FROM webhook_dead_letters
+		WHERE user_id = ? AND dead_letter_id = ?`), userID, deadLetterID)
+
+	letter, err := scanDeadLetter(row)
+	if err != nil {
+		return nil, notFound(err, "dead letter "+deadLetterID)
+	}
+	return letter, nil
+}
+
+// GetUserWebhookDeadLetters returns all of a user's dead letters ordered by
+// ID
+func (s *Store) GetUserWebhookDeadLetters(ctx context.Context, userID string) ([]*models.WebhookDeadLetter, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+deadLetterColumns+` FROM webhook_dead_letters
+		WHERE user_id = ? ORDER BY dead_letter_id`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %v", err)
+	}
+	defer rows.Close()
+
+	var letters []*models.WebhookDeadLetter
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead letter: %v", err)
+		}
+		letters = append(letters, letter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %v", err)
+	}
+	return letters, nil
+}
+
+// DeleteWebhookDeadLetter deletes a dead letter
+func (s *Store) DeleteWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) error {
+	res, err := s.exec(ctx, s.db, `DELETE FROM webhook_dead_letters WHERE user_id = ? AND dead_letter_id = ?`,
+		userID, deadLetterID)
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter: %v", err)
+	}
+	if ok, err := affectedOne(res); err != nil || !ok {
+		return fmt.Errorf("dead letter %s: %w", deadLetterID, database.ErrNotFound)
+	}
+	return nil
+}
+
+func scanWebhook(row scanner) (*models.Webhook, error) {
+	var webhook models.Webhook
+	var disabledAt sql.NullTime
+	err := row.Scan(&webhook.UserID, &webhook.WebhookID, &webhook.URL, &webhook.Secret, &webhook.Enabled,
+		&webhook.Failures, &disabledAt, &webhook.CreatedAt, &webhook.UpdatedAt, &webhook.Version)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+	return &webhook, nil
+}
+
+func scanDeadLetter(row scanner) (*models.WebhookDeadLetter, error) {
+	var letter models.WebhookDeadLetter
+	err := row.Scan(&letter.UserID, &letter.DeadLetterID, &letter.WebhookID, &letter.FireID,
+		&letter.Payload, &letter.Error, &letter.Attempts, &letter.FailedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
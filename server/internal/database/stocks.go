@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
+	"sync"
 	"time"
 
-	"stockmarket/server/internal/features/stock"
 	"stockmarket/server/internal/models"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,34 +15,23 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
 )
 
-// AddStockToUser adds a stock to a user's portfolio
-func AddStockToUser(ctx context.Context, userID string, stockDetails *stock.StockDetails) error {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return fmt.Errorf("STOCKS_TABLE environment variable not set")
-	}
+// scanSegments is the number of segments the background table scans are
+// split into and read in parallel
+const scanSegments = 4
 
-	// Convert price from json.Number to float64
-	price, err := stockDetails.Price.Float64()
-	if err != nil {
-		return fmt.Errorf("failed to parse price: %v", err)
+// CreateStock adds a stock to a user's portfolio
+func (db *Database) CreateStock(ctx context.Context, stock *models.Stock) error {
+	if stock.StockID == "" {
+		stock.StockID = uuid.New().String()
 	}
-
-	// Create the stock entry
-	stock := models.Stock{
-		StockID:     uuid.New().String(),
-		UserID:      userID,
-		Symbol:      stockDetails.Symbol,
-		Name:        stockDetails.Name,
-		Exchange:    stockDetails.Exchange,
-		Currency:    stockDetails.Currency,
-		Price:       price,
-		LastPrice:   price, // Initially same as current price
-		AddedAt:     time.Now(),
-		LastUpdated: time.Now(),
+	if stock.AddedAt.IsZero() {
+		stock.AddedAt = time.Now()
 	}
+	stock.LastUpdated = time.Now()
+	stock.Version = 1
 
 	// Marshal the stock object
 	av, err := attributevalue.MarshalMap(stock)
@@ -51,10 +40,14 @@ func AddStockToUser(ctx context.Context, userID string, stockDetails *stock.Stoc
 	}
 
 	// Save to DynamoDB
-	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      av,
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tables.Stocks),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(stock_id)"),
 	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("stock %s already exists: %w", stock.StockID, ErrConflict)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to save stock: %v", err)
 	}
@@ -62,120 +55,165 @@ func AddStockToUser(ctx context.Context, userID string, stockDetails *stock.Stoc
 	return nil
 }
 
-// GetUserStocks retrieves symbols of all stocks for a given user
-func GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error) {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return nil, fmt.Errorf("STOCKS_TABLE environment variable not set")
-	}
+// GetUserStocks retrieves all stocks for a given user, following
+// LastEvaluatedKey until every page has been read
+func (db *Database) GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error) {
+	paginator := dynamodb.NewQueryPaginator(db.client, db.userStocksQuery(userID))
 
-	// Query DynamoDB for all stocks with the given userID
-	result, err := db.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		KeyConditionExpression: aws.String("user_id = :uid"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":uid": &types.AttributeValueMemberS{Value: userID},
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to query stocks: %v", err)
+	var stocks []models.Stock
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query stocks: %v", err)
+		}
+
+		var pageStocks []models.Stock
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageStocks); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stocks: %v", err)
+		}
+		stocks = append(stocks, pageStocks...)
 	}
 
-	var stocks []models.Stock
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &stocks)
+	return stocks, nil
+}
+
+// ListUserStocks retrieves one page of a user's stocks
+func (db *Database) ListUserStocks(ctx context.Context, userID string, page PageOptions) ([]models.Stock, string, error) {
+	startKey, err := keyFromCursor(page.Cursor, map[string]string{"user_id": userID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal stocks: %v", err)
+		return nil, "", err
 	}
 
-	// Update current prices for all stocks
-	for i := range stocks {
-		details, err := stock.FetchStockDetails(stocks[i].Symbol)
-		if err != nil {
-			log.Printf("Failed to fetch price for %s: %v", stocks[i].Symbol, err)
-			continue
-		}
+	input := db.userStocksQuery(userID)
+	input.Limit = aws.Int32(int32(page.PageLimit()))
+	input.ExclusiveStartKey = startKey
 
-		// Update price information
-		price, _ := details.Price.Float64()
-		stocks[i].LastPrice = stocks[i].Price
-		stocks[i].Price = price
-		stocks[i].LastUpdated = time.Now()
-
-		// Update the stock in DynamoDB with new price using a background context
-		go func(s models.Stock) {
-			// Create a new background context for the update
-			updateCtx := context.Background()
-
-			av, err := attributevalue.MarshalMap(s)
-			if err != nil {
-				log.Printf("Failed to marshal updated stock: %v", err)
-				return
-			}
+	result, err := db.client.Query(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query stocks: %v", err)
+	}
 
-			_, err = db.PutItem(updateCtx, &dynamodb.PutItemInput{
-				TableName: aws.String(tableName),
-				Item:      av,
-			})
-			if err != nil {
-				log.Printf("Failed to update stock price in DB: %v", err)
-			}
-		}(stocks[i])
+	var stocks []models.Stock
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &stocks); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal stocks: %v", err)
 	}
 
-	return stocks, nil
+	next, err := cursorFromKey(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return stocks, next, nil
 }
 
-// RemoveStockFromUser removes a stock from a user's portfolio
-func RemoveStockFromUser(ctx context.Context, userID, stockID string) error {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return fmt.Errorf("STOCKS_TABLE environment variable not set")
+// userStocksQuery selects every stock in a user's portfolio
+func (db *Database) userStocksQuery(userID string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.Stocks),
+		KeyConditionExpression: aws.String("user_id = :uid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid": &types.AttributeValueMemberS{Value: userID},
+		},
 	}
+}
 
-	_, err := db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"user_id":  &types.AttributeValueMemberS{Value: userID},
-			"stock_id": &types.AttributeValueMemberS{Value: stockID},
-		},
+// UpdateStockPrice writes the price fields of a stock. Only the price fields
+// are touched, and only if nobody else wrote the stock since it was read, so
+// a refresh can never clobber a concurrent trigger list update.
+func (db *Database) UpdateStockPrice(ctx context.Context, stock *models.Stock) error {
+	condition, values := versionCondition("stock_id", stock.Version)
+	values[":price"] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(stock.Price, 'f', -1, 64)}
+	values[":last_price"] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(stock.LastPrice, 'f', -1, 64)}
+	values[":now"] = timeValue(stock.LastUpdated)
+	values[":next"] = numberValue(stock.Version + 1)
+
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(db.tables.Stocks),
+		Key:                       stockKey(stock.UserID, stock.StockID),
+		UpdateExpression:          aws.String("SET price = :price, last_price = :last_price, last_updated = :now, version = :next"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
 	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("stock %s: %w", stock.StockID, ErrConflict)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to delete stock: %v", err)
+		return fmt.Errorf("failed to update stock price: %v", err)
 	}
 
+	stock.Version++
 	return nil
 }
 
-// GetAllUniqueStocks retrieves all unique stocks across all user portfolios
-func GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return nil, fmt.Errorf("STOCKS_TABLE environment variable not set")
-	}
+// RemoveStock removes a stock from a user's portfolio and deletes its
+// triggers in a single transaction. The stock is only deleted if it is still
+// at the version read, so a trigger added concurrently is never orphaned.
+func (db *Database) RemoveStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		stock, err := db.GetStock(ctx, userID, stockID)
+		if err != nil {
+			return nil, err
+		}
+		if len(stock.Triggers)+1 > maxTransactItems {
+			return nil, fmt.Errorf("stock %s has too many triggers to remove at once", stockID)
+		}
 
-	// Scan the entire table
-	result, err := db.Scan(ctx, &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan stocks table: %v", err)
+		condition, values := versionCondition("stock_id", stock.Version)
+		items := []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:                 aws.String(db.tables.Stocks),
+					Key:                       stockKey(userID, stockID),
+					ConditionExpression:       aws.String(condition),
+					ExpressionAttributeValues: values,
+				},
+			},
+		}
+		for _, triggerID := range stock.Triggers {
+			items = append(items, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName: aws.String(db.tables.Triggers),
+					Key: map[string]types.AttributeValue{
+						"trigger_id": &types.AttributeValueMemberS{Value: triggerID},
+					},
+				},
+			})
+		}
+
+		_, err = db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if err == nil {
+			return stock, nil
+		}
+		if !isConditionFailure(err) {
+			return nil, fmt.Errorf("failed to delete stock: %v", err)
+		}
+		// The stock changed since it was read; re-read and retry
 	}
 
+	return nil, fmt.Errorf("failed to delete stock %s: too much contention", stockID)
+}
+
+// GetAllUniqueStocks retrieves all unique stocks across all user portfolios
+// with a parallel segmented scan of the Stocks table
+func (db *Database) GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
 	// Use a map to track unique stocks by symbol
 	uniqueStocks := make(map[string]models.Stock)
+	var mu sync.Mutex
+
+	err := db.ScanStocks(ctx, func(stock models.Stock) error {
+		mu.Lock()
+		defer mu.Unlock()
 
-	// Unmarshal items and collect unique stocks
-	for _, item := range result.Items {
-		var stock models.Stock
-		if err := attributevalue.UnmarshalMap(item, &stock); err != nil {
-			log.Printf("Warning: Failed to unmarshal stock: %v", err)
-			continue
-		}
 		// Only keep the most recently added stock for each symbol
 		existing, exists := uniqueStocks[stock.Symbol]
 		if !exists || stock.AddedAt.After(existing.AddedAt) {
 			uniqueStocks[stock.Symbol] = stock
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert map to slice
@@ -187,25 +225,57 @@ func GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
 	return stocks, nil
 }
 
-// GetStock retrieves a stock by its ID
-func (db *Database) GetStock(ctx context.Context, stockID string) (*models.Stock, error) {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return nil, fmt.Errorf("STOCKS_TABLE environment variable not set")
+// ScanStocks calls fn for every stock in the table. The table is split into
+// scanSegments segments that are scanned in parallel, so fn must be safe for
+// concurrent use. The first error from any segment stops the scan.
+func (db *Database) ScanStocks(ctx context.Context, fn func(models.Stock) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+
+	for segment := 0; segment < scanSegments; segment++ {
+		paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+			TableName:     aws.String(db.tables.Stocks),
+			Segment:       aws.Int32(int32(segment)),
+			TotalSegments: aws.Int32(scanSegments),
+		})
+
+		g.Go(func() error {
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to scan stocks table: %v", err)
+				}
+
+				for _, item := range page.Items {
+					var stock models.Stock
+					if err := attributevalue.UnmarshalMap(item, &stock); err != nil {
+						log.Printf("Warning: Failed to unmarshal stock: %v", err)
+						continue
+					}
+					if err := fn(stock); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
 	}
 
+	return g.Wait()
+}
+
+// GetStock retrieves a stock from a user's portfolio by its ID
+func (db *Database) GetStock(ctx context.Context, userID, stockID string) (*models.Stock, error) {
 	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"stock_id": &types.AttributeValueMemberS{Value: stockID},
-		},
+		TableName:      aws.String(db.tables.Stocks),
+		Key:            stockKey(userID, stockID),
+		ConsistentRead: aws.Bool(true),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get stock: %v", err)
 	}
 
 	if result.Item == nil {
-		return nil, fmt.Errorf("stock not found")
+		return nil, fmt.Errorf("stock %s: %w", stockID, ErrNotFound)
 	}
 
 	var stock models.Stock
@@ -217,25 +287,32 @@ func (db *Database) GetStock(ctx context.Context, stockID string) (*models.Stock
 	return &stock, nil
 }
 
-// UpdateStock updates a stock in the database
+// UpdateStock updates a stock in the database. The write only succeeds if the
+// stored stock is still at stock.Version; otherwise ErrConflict is returned
+// and the caller should re-read the stock before retrying.
 func (db *Database) UpdateStock(ctx context.Context, stock *models.Stock) error {
-	tableName := os.Getenv("STOCKS_TABLE")
-	if tableName == "" {
-		return fmt.Errorf("STOCKS_TABLE environment variable not set")
-	}
-
+	expected := stock.Version
 	stock.LastUpdated = time.Now()
+	stock.Version = expected + 1
 
 	av, err := attributevalue.MarshalMap(stock)
 	if err != nil {
+		stock.Version = expected
 		return fmt.Errorf("failed to marshal stock: %v", err)
 	}
 
+	condition, values := versionCondition("stock_id", expected)
 	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      av,
+		TableName:                 aws.String(db.tables.Stocks),
+		Item:                      av,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
 	})
 	if err != nil {
+		stock.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("stock %s: %w", stock.StockID, ErrConflict)
+		}
 		return fmt.Errorf("failed to update stock: %v", err)
 	}
 
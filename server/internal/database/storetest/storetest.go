@@ -0,0 +1,1051 @@
+// Package storetest is the contract test suite every database.Store
+// implementation must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// Run runs the contract tests against stores created by newStore. Each
+// subtest gets a fresh store.
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store database.Store)
+	}{
+		{"Users", testUsers},
+		{"Stocks", testStocks},
+		{"StockPrice", testStockPrice},
+		{"UniqueStocks", testUniqueStocks},
+		{"Triggers", testTriggers},
+		{"TriggerVersions", testTriggerVersions},
+		{"Pagination", testPagination},
+		{"TriggerFires", testTriggerFires},
+		{"Webhooks", testWebhooks},
+		{"WebhookDeadLetters", testWebhookDeadLetters},
+		{"UserTriggerFires", testUserTriggerFires},
+		{"DigestClaims", testDigestClaims},
+		{"TriggerFireOutbox", testTriggerFireOutbox},
+		{"HeldTriggerFires", testHeldTriggerFires},
+		{"ReleaseClaims", testReleaseClaims},
+		{"PushSubscriptions", testPushSubscriptions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// createUser stores a user that stocks and triggers can belong to
+func createUser(t *testing.T, store database.Store, email string) *models.User {
+	t.Helper()
+	user := &models.User{
+		UserID:       "id-" + email,
+		Email:        email,
+		PasswordHash: "hash",
+		CreatedAt:    time.Now(),
+	}
+	if err := store.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return user
+}
+
+// createStock stores a stock in a user's portfolio
+func createStock(t *testing.T, store database.Store, userID, symbol string) *models.Stock {
+	t.Helper()
+	stock := &models.Stock{
+		UserID:    userID,
+		Symbol:    symbol,
+		Name:      symbol + " Inc",
+		Exchange:  "NASDAQ",
+		Currency:  "USD",
+		Price:     100,
+		LastPrice: 100,
+	}
+	if err := store.CreateStock(context.Background(), stock); err != nil {
+		t.Fatalf("CreateStock(%s): %v", symbol, err)
+	}
+	return stock
+}
+
+// createTrigger stores a price trigger on a stock
+func createTrigger(t *testing.T, store database.Store, stock *models.Stock, threshold float64) *models.StockTrigger {
+	t.Helper()
+	trigger := &models.StockTrigger{
+		StockID:              stock.StockID,
+		UserID:               stock.UserID,
+		Symbol:               stock.Symbol,
+		Exchange:             stock.Exchange,
+		Type:                 "PRICE_UPPER_LIMIT",
+		IsActive:             true,
+		PriceThreshold:       threshold,
+		NotificationChannels: []string{"websocket"},
+		CooldownMinutes:      5,
+	}
+	if err := store.CreateTrigger(context.Background(), trigger); err != nil {
+		t.Fatalf("CreateTrigger(%s): %v", stock.Symbol, err)
+	}
+	return trigger
+}
+
+func testUsers(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	user := createUser(t, store, "alice@example.com")
+	if user.Version != 1 {
+		t.Fatalf("new user version = %d, want 1", user.Version)
+	}
+
+	dup := &models.User{UserID: "other", Email: user.Email, PasswordHash: "x", CreatedAt: time.Now()}
+	if err := store.CreateUser(ctx, dup); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateUser duplicate: got %v, want ErrConflict", err)
+	}
+
+	if _, err := store.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetUserByEmail missing: got %v, want ErrNotFound", err)
+	}
+
+	got, err := store.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if got.UserID != user.UserID || got.PasswordHash != "hash" || got.Version != 1 {
+		t.Fatalf("GetUserByEmail = %+v", got)
+	}
+
+	got.NotificationPreferences.Email = true
+	got.NotificationPreferences.Phone = "+15555550100"
+	got.NotificationPreferences.Language = "de"
+	got.NotificationPreferences.QuietHours = &models.QuietHours{Start: "22:00", End: "07:00"}
+	got.NotificationPreferences.Telegram = models.ChatDestination{Enabled: true, BotToken: "1:token", ChatID: "-100"}
+	if err := store.UpdateUser(ctx, got); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got.Version != 2 {
+		t.Fatalf("updated user version = %d, want 2", got.Version)
+	}
+
+	// The copy read before the update is now stale
+	user.NotificationPreferences.SMS = true
+	if err := store.UpdateUser(ctx, user); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateUser stale: got %v, want ErrConflict", err)
+	}
+
+	got, err = store.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	prefs := got.NotificationPreferences
+	if !prefs.Email || prefs.SMS || prefs.Phone != "+15555550100" || prefs.Telegram.ChatID != "-100" || prefs.Language != "de" || got.Version != 2 ||
+		prefs.QuietHours == nil || *prefs.QuietHours != (models.QuietHours{Start: "22:00", End: "07:00"}) {
+		t.Fatalf("user after stale update = %+v", got)
+	}
+}
+
+func testStocks(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+
+	aapl := createStock(t, store, "alice@example.com", "AAPL")
+	msft := createStock(t, store, "alice@example.com", "MSFT")
+	createStock(t, store, "bob@example.com", "GOOGL")
+
+	if aapl.StockID == "" || aapl.Version != 1 {
+		t.Fatalf("CreateStock did not assign ID and version: %+v", aapl)
+	}
+
+	dup := &models.Stock{StockID: aapl.StockID, UserID: aapl.UserID, Symbol: "AAPL"}
+	if err := store.CreateStock(ctx, dup); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateStock duplicate: got %v, want ErrConflict", err)
+	}
+
+	if _, err := store.GetStock(ctx, "alice@example.com", "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetStock missing: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetStock(ctx, "bob@example.com", aapl.StockID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetStock of another user's stock: got %v, want ErrNotFound", err)
+	}
+
+	stocks, err := store.GetUserStocks(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserStocks: %v", err)
+	}
+	want := []string{aapl.StockID, msft.StockID}
+	sort.Strings(want)
+	if got := stockIDs(stocks); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("GetUserStocks = %v, want %v in ID order", got, want)
+	}
+
+	got, err := store.GetStock(ctx, aapl.UserID, aapl.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	got.Name = "Apple Inc."
+	got.Quantity = 12.5
+	if err := store.UpdateStock(ctx, got); err != nil {
+		t.Fatalf("UpdateStock: %v", err)
+	}
+	if got.Version != 2 {
+		t.Fatalf("updated stock version = %d, want 2", got.Version)
+	}
+	if got, err := store.GetStock(ctx, aapl.UserID, aapl.StockID); err != nil || got.Quantity != 12.5 {
+		t.Fatalf("GetStock after update = %+v, %v; want 12.5 shares", got, err)
+	}
+	aapl.Name = "Stale"
+	if err := store.UpdateStock(ctx, aapl); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateStock stale: got %v, want ErrConflict", err)
+	}
+
+	// Removing a stock takes its triggers with it
+	trigger := createTrigger(t, store, msft, 400)
+	removed, err := store.RemoveStock(ctx, msft.UserID, msft.StockID)
+	if err != nil {
+		t.Fatalf("RemoveStock: %v", err)
+	}
+	if removed.Symbol != "MSFT" || fmt.Sprint(removed.Triggers) != fmt.Sprint([]string{trigger.TriggerID}) {
+		t.Fatalf("RemoveStock returned %+v, want MSFT with trigger %s", removed, trigger.TriggerID)
+	}
+	if _, err := store.GetStock(ctx, msft.UserID, msft.StockID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetStock after remove: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetTrigger(ctx, trigger.TriggerID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetTrigger after stock removed: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.RemoveStock(ctx, msft.UserID, msft.StockID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("RemoveStock twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func testStockPrice(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	stock := createStock(t, store, "alice@example.com", "AAPL")
+
+	// A price refresh based on a copy read before a trigger was added must
+	// not overwrite the trigger list
+	refresh, err := store.GetStock(ctx, stock.UserID, stock.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	trigger := createTrigger(t, store, stock, 150)
+
+	refresh.LastPrice = refresh.Price
+	refresh.Price = 155
+	refresh.LastUpdated = time.Now()
+	if err := store.UpdateStockPrice(ctx, refresh); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateStockPrice stale: got %v, want ErrConflict", err)
+	}
+
+	current, err := store.GetStock(ctx, stock.UserID, stock.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	version := current.Version
+	current.LastPrice = current.Price
+	current.Price = 155
+	current.LastUpdated = time.Now()
+	if err := store.UpdateStockPrice(ctx, current); err != nil {
+		t.Fatalf("UpdateStockPrice: %v", err)
+	}
+	if current.Version != version+1 {
+		t.Fatalf("UpdateStockPrice version = %d, want %d", current.Version, version+1)
+	}
+
+	got, err := store.GetStock(ctx, stock.UserID, stock.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	if got.Price != 155 || got.LastPrice != 100 {
+		t.Fatalf("price = %v, last price = %v, want 155 and 100", got.Price, got.LastPrice)
+	}
+	if fmt.Sprint(got.Triggers) != fmt.Sprint([]string{trigger.TriggerID}) {
+		t.Fatalf("triggers after price update = %v, want [%s]", got.Triggers, trigger.TriggerID)
+	}
+}
+
+func testUniqueStocks(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+
+	createStock(t, store, "alice@example.com", "AAPL")
+	createStock(t, store, "alice@example.com", "MSFT")
+	time.Sleep(10 * time.Millisecond)
+	latest := createStock(t, store, "bob@example.com", "AAPL")
+
+	stocks, err := store.GetAllUniqueStocks(ctx)
+	if err != nil {
+		t.Fatalf("GetAllUniqueStocks: %v", err)
+	}
+	bySymbol := make(map[string]models.Stock)
+	for _, s := range stocks {
+		if _, dup := bySymbol[s.Symbol]; dup {
+			t.Fatalf("GetAllUniqueStocks returned %s twice", s.Symbol)
+		}
+		bySymbol[s.Symbol] = s
+	}
+	if len(bySymbol) != 2 {
+		t.Fatalf("GetAllUniqueStocks returned %d symbols, want 2", len(bySymbol))
+	}
+	if bySymbol["AAPL"].StockID != latest.StockID {
+		t.Fatalf("GetAllUniqueStocks kept %s for AAPL, want the most recently added %s",
+			bySymbol["AAPL"].StockID, latest.StockID)
+	}
+
+	// ScanStocks visits every holding, with its trigger IDs
+	trigger := createTrigger(t, store, latest, 200)
+	var mu sync.Mutex
+	seen := make(map[string][]string)
+	err = store.ScanStocks(ctx, func(s models.Stock) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[s.StockID] = s.Triggers
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanStocks: %v", err)
+	}
+	if len(seen) != 3 {
+		t.Fatalf("ScanStocks visited %d stocks, want 3", len(seen))
+	}
+	if fmt.Sprint(seen[latest.StockID]) != fmt.Sprint([]string{trigger.TriggerID}) {
+		t.Fatalf("ScanStocks triggers for %s = %v, want [%s]", latest.StockID, seen[latest.StockID], trigger.TriggerID)
+	}
+}
+
+func testTriggers(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+	aapl := createStock(t, store, "alice@example.com", "AAPL")
+	bobAAPL := createStock(t, store, "bob@example.com", "AAPL")
+	msft := createStock(t, store, "alice@example.com", "MSFT")
+
+	first := createTrigger(t, store, aapl, 150)
+	second := createTrigger(t, store, aapl, 160)
+	bobs := createTrigger(t, store, bobAAPL, 170)
+	createTrigger(t, store, msft, 300)
+
+	if first.TriggerID == "" || first.Version != 1 || first.CreatedAt.IsZero() || !first.LastTrigger.IsZero() {
+		t.Fatalf("CreateTrigger did not initialise the trigger: %+v", first)
+	}
+
+	missing := &models.StockTrigger{StockID: "missing", UserID: "alice@example.com", Type: "PRICE_UPPER_LIMIT"}
+	if err := store.CreateTrigger(ctx, missing); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("CreateTrigger on missing stock: got %v, want ErrNotFound", err)
+	}
+
+	stock, err := store.GetStock(ctx, aapl.UserID, aapl.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	if fmt.Sprint(stock.Triggers) != fmt.Sprint([]string{first.TriggerID, second.TriggerID}) {
+		t.Fatalf("stock triggers = %v, want [%s %s]", stock.Triggers, first.TriggerID, second.TriggerID)
+	}
+	if stock.Version != 3 {
+		t.Fatalf("stock version after two triggers = %d, want 3", stock.Version)
+	}
+
+	got, err := store.GetTrigger(ctx, first.TriggerID)
+	if err != nil {
+		t.Fatalf("GetTrigger: %v", err)
+	}
+	if got.PriceThreshold != 150 || got.Symbol != "AAPL" || !got.IsActive ||
+		fmt.Sprint(got.NotificationChannels) != "[websocket]" {
+		t.Fatalf("GetTrigger = %+v", got)
+	}
+
+	bySymbol, err := store.GetTriggersBySymbol(ctx, "AAPL", "NASDAQ")
+	if err != nil {
+		t.Fatalf("GetTriggersBySymbol: %v", err)
+	}
+	if got, want := triggerIDs(bySymbol), sorted(first.TriggerID, second.TriggerID, bobs.TriggerID); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("GetTriggersBySymbol = %v, want %v", got, want)
+	}
+
+	byUser, err := store.GetTriggersByUser(ctx, "bob@example.com")
+	if err != nil {
+		t.Fatalf("GetTriggersByUser: %v", err)
+	}
+	if got := triggerIDs(byUser); fmt.Sprint(got) != fmt.Sprint([]string{bobs.TriggerID}) {
+		t.Fatalf("GetTriggersByUser = %v, want [%s]", got, bobs.TriggerID)
+	}
+
+	if err := store.DeleteTrigger(ctx, first.TriggerID); err != nil {
+		t.Fatalf("DeleteTrigger: %v", err)
+	}
+	if _, err := store.GetTrigger(ctx, first.TriggerID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetTrigger after delete: got %v, want ErrNotFound", err)
+	}
+	if err := store.DeleteTrigger(ctx, first.TriggerID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteTrigger twice: got %v, want ErrNotFound", err)
+	}
+
+	stock, err = store.GetStock(ctx, aapl.UserID, aapl.StockID)
+	if err != nil {
+		t.Fatalf("GetStock: %v", err)
+	}
+	if fmt.Sprint(stock.Triggers) != fmt.Sprint([]string{second.TriggerID}) {
+		t.Fatalf("stock triggers after delete = %v, want [%s]", stock.Triggers, second.TriggerID)
+	}
+}
+
+func testTriggerVersions(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	stock := createStock(t, store, "alice@example.com", "AAPL")
+	trigger := createTrigger(t, store, stock, 150)
+
+	stale, err := store.GetTrigger(ctx, trigger.TriggerID)
+	if err != nil {
+		t.Fatalf("GetTrigger: %v", err)
+	}
+
+	fired := time.Now().UTC().Truncate(time.Millisecond)
+	trigger.LastTrigger = fired
+	if err := store.UpdateTrigger(ctx, trigger); err != nil {
+		t.Fatalf("UpdateTrigger: %v", err)
+	}
+	if trigger.Version != 2 {
+		t.Fatalf("updated trigger version = %d, want 2", trigger.Version)
+	}
+
+	stale.PriceThreshold = 1
+	if err := store.UpdateTrigger(ctx, stale); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateTrigger stale: got %v, want ErrConflict", err)
+	}
+
+	got, err := store.GetTrigger(ctx, trigger.TriggerID)
+	if err != nil {
+		t.Fatalf("GetTrigger: %v", err)
+	}
+	if !got.LastTrigger.Equal(fired) || got.PriceThreshold != 150 || got.Version != 2 {
+		t.Fatalf("trigger after stale update = %+v", got)
+	}
+}
+
+func testPagination(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+
+	var wantStocks, wantTriggers []string
+	for i := 0; i < 5; i++ {
+		stock := createStock(t, store, "alice@example.com", fmt.Sprintf("SYM%d", i))
+		wantStocks = append(wantStocks, stock.StockID)
+		wantTriggers = append(wantTriggers, createTrigger(t, store, stock, float64(100+i)).TriggerID)
+	}
+	createTrigger(t, store, createStock(t, store, "bob@example.com", "SYM0"), 100)
+
+	// Page through with a limit that doesn't divide the total. Backends may
+	// return a final empty page, but never more than the limit.
+	var gotStocks []string
+	var aliceCursor string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("ListUserStocks did not terminate")
+		}
+		page, next, err := store.ListUserStocks(ctx, "alice@example.com", database.PageOptions{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListUserStocks: %v", err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("ListUserStocks returned %d stocks, want at most 2", len(page))
+		}
+		gotStocks = append(gotStocks, stockIDs(page)...)
+		if next == "" {
+			break
+		}
+		aliceCursor = next
+		cursor = next
+	}
+	if fmt.Sprint(sorted(gotStocks...)) != fmt.Sprint(sorted(wantStocks...)) {
+		t.Fatalf("paged stocks = %v, want %v", gotStocks, wantStocks)
+	}
+
+	var gotTriggers []string
+	cursor = ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("ListTriggersByUser did not terminate")
+		}
+		page, next, err := store.ListTriggersByUser(ctx, "alice@example.com", database.PageOptions{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListTriggersByUser: %v", err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("ListTriggersByUser returned %d triggers, want at most 2", len(page))
+		}
+		for _, trigger := range page {
+			gotTriggers = append(gotTriggers, trigger.TriggerID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if fmt.Sprint(sorted(gotTriggers...)) != fmt.Sprint(sorted(wantTriggers...)) {
+		t.Fatalf("paged triggers = %v, want %v", gotTriggers, wantTriggers)
+	}
+
+	// Cursors are bound to the user they were issued for
+	if _, _, err := store.ListUserStocks(ctx, "bob@example.com", database.PageOptions{Cursor: aliceCursor}); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Fatalf("ListUserStocks with another user's cursor = %v, want ErrInvalidCursor", err)
+	}
+	if _, _, err := store.ListTriggersByUser(ctx, "alice@example.com", database.PageOptions{Cursor: "not a cursor"}); !errors.Is(err, database.ErrInvalidCursor) {
+		t.Fatalf("ListTriggersByUser with a garbage cursor = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testTriggerFires(t *testing.T, store database.Store) {
+	ctx := context.Background()
+
+	if _, err := store.GetTriggerFire(ctx, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetTriggerFire missing: got %v, want ErrNotFound", err)
+	}
+
+	fired := time.Now().UTC().Truncate(time.Millisecond)
+	fire := &models.TriggerFire{
+		FireID:    "fire-1",
+		TriggerID: "trigger-1",
+		UserID:    "alice@example.com",
+		Symbol:    "AAPL",
+		Exchange:  "NASDAQ",
+		Price:     151.5,
+		Message:   "Price exceeded upper limit",
+		FiredAt:   fired,
+		Deliveries: []models.Delivery{
+			{Channel: models.ChannelEmail, Status: models.DeliveryFailed, Error: "relay down", At: fired},
+			{Channel: models.ChannelWebSocket, Status: models.DeliverySent, At: fired},
+		},
+	}
+	if err := store.SaveTriggerFire(ctx, fire); err != nil {
+		t.Fatalf("SaveTriggerFire: %v", err)
+	}
+
+	// Saving again replaces the deliveries
+	fire.Deliveries[0] = models.Delivery{Channel: models.ChannelEmail, Status: models.DeliverySent, At: fired.Add(time.Second)}
+	if err := store.SaveTriggerFire(ctx, fire); err != nil {
+		t.Fatalf("SaveTriggerFire again: %v", err)
+	}
+	fire.Deliveries[1].Status = models.DeliveryFailed // Must not reach the store
+
+	got, err := store.GetTriggerFire(ctx, "fire-1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if got.TriggerID != "trigger-1" || got.Price != 151.5 || !got.FiredAt.Equal(fired) {
+		t.Fatalf("fire = %+v", got)
+	}
+	if len(got.Deliveries) != 2 {
+		t.Fatalf("deliveries = %+v, want 2", got.Deliveries)
+	}
+	email, socket := got.Deliveries[0], got.Deliveries[1]
+	if email.Status != models.DeliverySent || email.Error != "" || !email.At.Equal(fired.Add(time.Second)) {
+		t.Errorf("email delivery = %+v, want sent on the second save", email)
+	}
+	if socket.Channel != models.ChannelWebSocket || socket.Status != models.DeliverySent {
+		t.Errorf("websocket delivery = %+v, want sent", socket)
+	}
+}
+
+func testUserTriggerFires(t *testing.T, store database.Store) {
+	ctx := context.Background()
+
+	// Fires are saved in other zones than they are asked for in
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	for _, f := range []struct {
+		id, userID string
+		at         time.Time
+	}{
+		{"fire-late", "alice@example.com", day.Add(20 * time.Hour).In(kolkata)},
+		{"fire-early", "alice@example.com", day.Add(500 * time.Millisecond)},
+		{"fire-before", "alice@example.com", day.Add(-time.Millisecond)},
+		{"fire-after", "alice@example.com", day.Add(24 * time.Hour)},
+		{"fire-bob", "bob@example.com", day.Add(time.Hour)},
+	} {
+		fire := &models.TriggerFire{FireID: f.id, TriggerID: "trigger-1", UserID: f.userID, Symbol: "AAPL", FiredAt: f.at}
+		if err := store.SaveTriggerFire(ctx, fire); err != nil {
+			t.Fatalf("SaveTriggerFire: %v", err)
+		}
+	}
+
+	fires, err := store.GetUserTriggerFires(ctx, "alice@example.com", day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetUserTriggerFires: %v", err)
+	}
+	var ids []string
+	for _, fire := range fires {
+		ids = append(ids, fire.FireID)
+	}
+	if want := []string{"fire-early", "fire-late"}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("GetUserTriggerFires = %v, want %v", ids, want)
+	}
+	if !fires[1].FiredAt.Equal(day.Add(20 * time.Hour)) {
+		t.Errorf("fire-late fired at %v, want %v", fires[1].FiredAt, day.Add(20*time.Hour))
+	}
+}
+
+func testTriggerFireOutbox(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	stock := createStock(t, store, "alice@example.com", "AAPL")
+	trigger := createTrigger(t, store, stock, 150)
+	stale, err := store.GetTrigger(ctx, trigger.TriggerID)
+	if err != nil {
+		t.Fatalf("GetTrigger: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	newFire := func(id string, due time.Time) *models.TriggerFire {
+		return &models.TriggerFire{
+			FireID: id, TriggerID: trigger.TriggerID, UserID: "alice@example.com", Symbol: "AAPL", Exchange: "NASDAQ",
+			Price: 151, PrevPrice: 149, FiredAt: now, Outbox: models.OutboxPending, NextAttemptAt: due,
+		}
+	}
+	trigger.LastTrigger = now
+	if err := store.FireTrigger(ctx, trigger, newFire("fire-1", now)); err != nil {
+		t.Fatalf("FireTrigger: %v", err)
+	}
+	if trigger.Version != 2 {
+		t.Fatalf("fired trigger version = %d, want 2", trigger.Version)
+	}
+
+	// A stale trigger writes neither itself nor its fire
+	if err := store.FireTrigger(ctx, stale, newFire("fire-stale", now)); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("FireTrigger stale: got %v, want ErrConflict", err)
+	}
+	if _, err := store.GetTriggerFire(ctx, "fire-stale"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("fire of a stale trigger: got %v, want ErrNotFound", err)
+	}
+	// Nor does a fire stored before
+	if err := store.FireTrigger(ctx, trigger, newFire("fire-1", now)); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("FireTrigger again: got %v, want ErrConflict", err)
+	}
+	got, err := store.GetTrigger(ctx, trigger.TriggerID)
+	if err != nil {
+		t.Fatalf("GetTrigger: %v", err)
+	}
+	if !got.LastTrigger.Equal(now) || got.Version != 2 {
+		t.Fatalf("trigger after failed fires = %+v", got)
+	}
+
+	if err := store.FireTrigger(ctx, trigger, newFire("fire-2", now.Add(-time.Minute))); err != nil {
+		t.Fatalf("FireTrigger: %v", err)
+	}
+	if err := store.FireTrigger(ctx, trigger, newFire("fire-later", now.Add(time.Hour))); err != nil {
+		t.Fatalf("FireTrigger: %v", err)
+	}
+	delivered := newFire("fire-delivered", time.Time{})
+	delivered.Outbox = ""
+	if err := store.SaveTriggerFire(ctx, delivered); err != nil {
+		t.Fatalf("SaveTriggerFire: %v", err)
+	}
+
+	due, err := store.GetDueTriggerFires(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("GetDueTriggerFires: %v", err)
+	}
+	var ids []string
+	for _, fire := range due {
+		ids = append(ids, fire.FireID)
+	}
+	if want := []string{"fire-2", "fire-1"}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("GetDueTriggerFires = %v, want %v", ids, want)
+	}
+	if due[1].PrevPrice != 149 || !due[1].NextAttemptAt.Equal(now) || due[1].Attempts != 0 {
+		t.Fatalf("due fire = %+v", due[1])
+	}
+	if limited, err := store.GetDueTriggerFires(ctx, now, 1); err != nil || len(limited) != 1 || limited[0].FireID != "fire-2" {
+		t.Fatalf("GetDueTriggerFires with a limit of 1 = %v, %v", limited, err)
+	}
+
+	// Only one of two attempts started from the same read gets the lease
+	fire, other := due[1], *due[1]
+	if err := store.LeaseTriggerFire(ctx, fire, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("LeaseTriggerFire: %v", err)
+	}
+	if fire.Attempts != 1 || !fire.NextAttemptAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("leased fire = %+v", fire)
+	}
+	if err := store.LeaseTriggerFire(ctx, &other, now.Add(2*time.Minute)); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("LeaseTriggerFire again: got %v, want ErrConflict", err)
+	}
+	if due, err := store.GetDueTriggerFires(ctx, now, 10); err != nil || len(due) != 1 || due[0].FireID != "fire-2" {
+		t.Fatalf("GetDueTriggerFires during the lease = %v, %v", due, err)
+	}
+
+	// An outcome from before the lease isn't recorded over it
+	other.Outbox = ""
+	if err := store.UpdateTriggerFire(ctx, &other); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateTriggerFire stale: got %v, want ErrConflict", err)
+	}
+
+	// Once delivered the fire leaves the outbox
+	fire.Outbox = ""
+	fire.Deliveries = []models.Delivery{{Channel: models.ChannelEmail, Status: models.DeliverySent, At: now}}
+	if err := store.UpdateTriggerFire(ctx, fire); err != nil {
+		t.Fatalf("UpdateTriggerFire: %v", err)
+	}
+	if fire.Version != 2 {
+		t.Fatalf("updated fire version = %d, want 2", fire.Version)
+	}
+	if due, err := store.GetDueTriggerFires(ctx, now.Add(time.Hour), 10); err != nil || len(due) != 2 {
+		t.Fatalf("GetDueTriggerFires after delivery = %v, %v, want fire-2 and fire-later", due, err)
+	}
+	if err := store.LeaseTriggerFire(ctx, fire, now.Add(time.Hour)); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("LeaseTriggerFire after delivery: got %v, want ErrConflict", err)
+	}
+	stored, err := store.GetTriggerFire(ctx, "fire-1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if stored.Outbox != "" || stored.Attempts != 1 || len(stored.Deliveries) != 1 || stored.Version != 2 {
+		t.Fatalf("delivered fire = %+v", stored)
+	}
+	if err := store.UpdateTriggerFire(ctx, newFire("fire-missing", now)); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateTriggerFire missing: got %v, want ErrConflict", err)
+	}
+}
+
+func testHeldTriggerFires(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	stock := createStock(t, store, "alice@example.com", "AAPL")
+	trigger := createTrigger(t, store, stock, 150)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, fire := range []*models.TriggerFire{
+		{FireID: "later", HeldUntil: now.Add(time.Hour)},
+		{FireID: "due", HeldUntil: now.Add(-time.Minute)},
+		{FireID: "longest-due", HeldUntil: now.Add(-time.Hour)},
+		{FireID: "not-held"},
+	} {
+		fire.TriggerID, fire.UserID, fire.Symbol, fire.Exchange, fire.FiredAt = trigger.TriggerID, "alice@example.com", "AAPL", "NASDAQ", now
+		if err := store.SaveTriggerFire(ctx, fire); err != nil {
+			t.Fatalf("SaveTriggerFire %s: %v", fire.FireID, err)
+		}
+	}
+
+	held, err := store.GetHeldTriggerFires(ctx, now, 10)
+	if err != nil {
+		t.Fatalf("GetHeldTriggerFires: %v", err)
+	}
+	if len(held) != 2 || held[0].FireID != "longest-due" || held[1].FireID != "due" || !held[1].HeldUntil.Equal(now.Add(-time.Minute)) {
+		t.Fatalf("GetHeldTriggerFires = %+v, want longest-due then due", held)
+	}
+	if held, _ := store.GetHeldTriggerFires(ctx, now, 1); len(held) != 1 || held[0].FireID != "longest-due" {
+		t.Fatalf("GetHeldTriggerFires with limit 1 = %+v, want longest-due", held)
+	}
+
+	// Released fires leave the index
+	held[0].HeldUntil = time.Time{}
+	if err := store.SaveTriggerFire(ctx, held[0]); err != nil {
+		t.Fatalf("SaveTriggerFire: %v", err)
+	}
+	if held, _ := store.GetHeldTriggerFires(ctx, now, 10); len(held) != 1 || held[0].FireID != "due" {
+		t.Fatalf("GetHeldTriggerFires after release = %+v, want due", held)
+	}
+}
+
+func testDigestClaims(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+
+	claim := &models.DigestClaim{UserID: "alice@example.com", DigestID: "daily:2026-03-02", ClaimedAt: time.Now()}
+	if err := store.ClaimDigest(ctx, claim); err != nil {
+		t.Fatalf("ClaimDigest: %v", err)
+	}
+	if err := store.ClaimDigest(ctx, claim); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("ClaimDigest twice: got %v, want ErrConflict", err)
+	}
+	other := &models.DigestClaim{UserID: "alice@example.com", DigestID: "weekly:2026-03-02", ClaimedAt: time.Now()}
+	if err := store.ClaimDigest(ctx, other); err != nil {
+		t.Fatalf("ClaimDigest of another digest: %v", err)
+	}
+
+	// A released digest can be claimed again
+	if err := store.ReleaseDigest(ctx, claim.UserID, claim.DigestID); err != nil {
+		t.Fatalf("ReleaseDigest: %v", err)
+	}
+	if err := store.ReleaseDigest(ctx, claim.UserID, claim.DigestID); err != nil {
+		t.Fatalf("ReleaseDigest twice: %v", err)
+	}
+	if err := store.ClaimDigest(ctx, claim); err != nil {
+		t.Fatalf("ClaimDigest after release: %v", err)
+	}
+}
+
+func testReleaseClaims(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+
+	claim := &models.ReleaseClaim{UserID: "alice@example.com", ReleaseID: "email:fire-1", ClaimedAt: time.Now()}
+	if err := store.ClaimRelease(ctx, claim); err != nil {
+		t.Fatalf("ClaimRelease: %v", err)
+	}
+	if err := store.ClaimRelease(ctx, claim); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("ClaimRelease twice: got %v, want ErrConflict", err)
+	}
+	other := &models.ReleaseClaim{UserID: "alice@example.com", ReleaseID: "sms:fire-1", ClaimedAt: time.Now()}
+	if err := store.ClaimRelease(ctx, other); err != nil {
+		t.Fatalf("ClaimRelease on another channel: %v", err)
+	}
+	// Digest claims are kept apart
+	if err := store.ClaimDigest(ctx, &models.DigestClaim{UserID: claim.UserID, DigestID: claim.ReleaseID, ClaimedAt: time.Now()}); err != nil {
+		t.Fatalf("ClaimDigest with a release's ID: %v", err)
+	}
+
+	// An unclaimed release can be claimed again
+	if err := store.UnclaimRelease(ctx, claim.UserID, claim.ReleaseID); err != nil {
+		t.Fatalf("UnclaimRelease: %v", err)
+	}
+	if err := store.UnclaimRelease(ctx, claim.UserID, claim.ReleaseID); err != nil {
+		t.Fatalf("UnclaimRelease twice: %v", err)
+	}
+	if err := store.ClaimRelease(ctx, claim); err != nil {
+		t.Fatalf("ClaimRelease after unclaim: %v", err)
+	}
+}
+
+func testPushSubscriptions(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+
+	created := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	for _, sub := range []*models.PushSubscription{
+		{UserID: "alice@example.com", SubscriptionID: "s2", Endpoint: "https://push.example.com/2", P256DH: "key2", Auth: "auth2", CreatedAt: created},
+		{UserID: "alice@example.com", SubscriptionID: "s1", Endpoint: "https://push.example.com/1", P256DH: "key1", Auth: "auth1", Device: "Firefox on Linux", CreatedAt: created},
+		{UserID: "bob@example.com", SubscriptionID: "s3", Endpoint: "https://push.example.com/3", P256DH: "key3", Auth: "auth3", CreatedAt: created},
+	} {
+		if err := store.SavePushSubscription(ctx, sub); err != nil {
+			t.Fatalf("SavePushSubscription(%s): %v", sub.SubscriptionID, err)
+		}
+	}
+
+	// Subscribing again replaces the keys
+	renewed := &models.PushSubscription{UserID: "alice@example.com", SubscriptionID: "s2", Endpoint: "https://push.example.com/2", P256DH: "key2b", Auth: "auth2b", CreatedAt: created.Add(time.Hour)}
+	if err := store.SavePushSubscription(ctx, renewed); err != nil {
+		t.Fatalf("SavePushSubscription again: %v", err)
+	}
+
+	subs, err := store.GetUserPushSubscriptions(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserPushSubscriptions: %v", err)
+	}
+	if len(subs) != 2 || subs[0].SubscriptionID != "s1" || subs[1].SubscriptionID != "s2" {
+		t.Fatalf("GetUserPushSubscriptions = %+v, want s1 and s2", subs)
+	}
+	if subs[0].Device != "Firefox on Linux" || subs[0].Endpoint != "https://push.example.com/1" || !subs[0].CreatedAt.Equal(created) {
+		t.Errorf("s1 = %+v", subs[0])
+	}
+	if subs[1].P256DH != "key2b" || subs[1].Auth != "auth2b" {
+		t.Errorf("s2 = %+v, want the renewed keys", subs[1])
+	}
+
+	if err := store.DeletePushSubscription(ctx, "alice@example.com", "s3"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeletePushSubscription of bob's subscription: got %v, want ErrNotFound", err)
+	}
+	if err := store.DeletePushSubscription(ctx, "alice@example.com", "s1"); err != nil {
+		t.Fatalf("DeletePushSubscription: %v", err)
+	}
+	if err := store.DeletePushSubscription(ctx, "alice@example.com", "s1"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeletePushSubscription twice: got %v, want ErrNotFound", err)
+	}
+	if subs, _ := store.GetUserPushSubscriptions(ctx, "alice@example.com"); len(subs) != 1 || subs[0].SubscriptionID != "s2" {
+		t.Errorf("subscriptions after delete = %+v, want s2", subs)
+	}
+	if subs, _ := store.GetUserPushSubscriptions(ctx, "bob@example.com"); len(subs) != 1 {
+		t.Errorf("bob's subscriptions = %+v, want s3", subs)
+	}
+}
+
+// createWebhook stores an enabled webhook for a user
+func createWebhook(t *testing.T, store database.Store, userID, url string) *models.Webhook {
+	t.Helper()
+	webhook := &models.Webhook{UserID: userID, URL: url, Secret: "whsec_test", Enabled: true}
+	if err := store.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatalf("CreateWebhook(%s): %v", url, err)
+	}
+	return webhook
+}
+
+func testWebhooks(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	createUser(t, store, "bob@example.com")
+
+	if _, err := store.GetWebhook(ctx, "alice@example.com", "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetWebhook missing: got %v, want ErrNotFound", err)
+	}
+
+	first := createWebhook(t, store, "alice@example.com", "https://example.com/a")
+	second := createWebhook(t, store, "alice@example.com", "https://example.com/b")
+	createWebhook(t, store, "bob@example.com", "https://example.com/c")
+	if first.WebhookID == "" || first.Version != 1 {
+		t.Fatalf("created webhook = %+v, want an ID at version 1", first)
+	}
+	if err := store.CreateWebhook(ctx, &models.Webhook{UserID: "alice@example.com", WebhookID: first.WebhookID, URL: "https://example.com/dup"}); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateWebhook with a taken ID = %v, want ErrConflict", err)
+	}
+
+	webhooks, err := store.GetUserWebhooks(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserWebhooks: %v", err)
+	}
+	var ids []string
+	for _, w := range webhooks {
+		ids = append(ids, w.WebhookID)
+	}
+	if want := sorted(first.WebhookID, second.WebhookID); fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("alice's webhooks = %v, want %v", ids, want)
+	}
+
+	// Results are counted without moving the version
+	for i, ok := range []bool{false, false, true, false} {
+		n, err := store.RecordWebhookResult(ctx, "alice@example.com", first.WebhookID, ok)
+		if err != nil {
+			t.Fatalf("RecordWebhookResult %d: %v", i, err)
+		}
+		if want := []int{1, 2, 0, 1}[i]; n != want {
+			t.Fatalf("failures after result %d = %d, want %d", i, n, want)
+		}
+	}
+	if _, err := store.RecordWebhookResult(ctx, "bob@example.com", first.WebhookID, false); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("RecordWebhookResult on another user's webhook = %v, want ErrNotFound", err)
+	}
+
+	got, err := store.GetWebhook(ctx, "alice@example.com", first.WebhookID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got.URL != "https://example.com/a" || got.Secret != "whsec_test" || !got.Enabled || got.Failures != 1 || got.Version != 1 || got.DisabledAt != nil {
+		t.Fatalf("webhook = %+v", got)
+	}
+
+	disabled := time.Now().UTC().Truncate(time.Millisecond)
+	got.Enabled = false
+	got.DisabledAt = &disabled
+	if err := store.UpdateWebhook(ctx, got); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	stale := *got
+	stale.Version = 1
+	if err := store.UpdateWebhook(ctx, &stale); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("UpdateWebhook at a stale version = %v, want ErrConflict", err)
+	}
+	got, err = store.GetWebhook(ctx, "alice@example.com", first.WebhookID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got.Enabled || got.DisabledAt == nil || !got.DisabledAt.Equal(disabled) || got.Version != 2 {
+		t.Fatalf("webhook after disabling = %+v", got)
+	}
+
+	if err := store.DeleteWebhook(ctx, "alice@example.com", first.WebhookID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	if err := store.DeleteWebhook(ctx, "alice@example.com", first.WebhookID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteWebhook again = %v, want ErrNotFound", err)
+	}
+}
+
+func testWebhookDeadLetters(t *testing.T, store database.Store) {
+	ctx := context.Background()
+	createUser(t, store, "alice@example.com")
+	kept := createWebhook(t, store, "alice@example.com", "https://example.com/a")
+	deleted := createWebhook(t, store, "alice@example.com", "https://example.com/b")
+
+	if _, err := store.GetWebhookDeadLetter(ctx, "alice@example.com", "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetWebhookDeadLetter missing: got %v, want ErrNotFound", err)
+	}
+
+	failed := time.Now().UTC().Truncate(time.Millisecond)
+	letter := &models.WebhookDeadLetter{
+		UserID:       "alice@example.com",
+		DeadLetterID: "fire-1:" + kept.WebhookID,
+		WebhookID:    kept.WebhookID,
+		FireID:       "fire-1",
+		Payload:      `{"trigger_id":"t1"}`,
+		Error:        "status 500",
+		Attempts:     5,
+		FailedAt:     failed,
+	}
+	if err := store.SaveWebhookDeadLetter(ctx, letter); err != nil {
+		t.Fatalf("SaveWebhookDeadLetter: %v", err)
+	}
+	// Saving again replaces the outcome
+	letter.Error, letter.Attempts = "status 502", 6
+	if err := store.SaveWebhookDeadLetter(ctx, letter); err != nil {
+		t.Fatalf("SaveWebhookDeadLetter again: %v", err)
+	}
+	other := *letter
+	other.DeadLetterID, other.WebhookID = "fire-1:"+deleted.WebhookID, deleted.WebhookID
+	if err := store.SaveWebhookDeadLetter(ctx, &other); err != nil {
+		t.Fatalf("SaveWebhookDeadLetter: %v", err)
+	}
+
+	got, err := store.GetWebhookDeadLetter(ctx, "alice@example.com", letter.DeadLetterID)
+	if err != nil {
+		t.Fatalf("GetWebhookDeadLetter: %v", err)
+	}
+	if got.Payload != letter.Payload || got.Error != "status 502" || got.Attempts != 6 || !got.FailedAt.Equal(failed) || got.FireID != "fire-1" {
+		t.Fatalf("dead letter = %+v", got)
+	}
+
+	// Deleting a webhook takes its dead letters with it
+	if err := store.DeleteWebhook(ctx, "alice@example.com", deleted.WebhookID); err != nil {
+		t.Fatalf("DeleteWebhook: %v", err)
+	}
+	letters, err := store.GetUserWebhookDeadLetters(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserWebhookDeadLetters: %v", err)
+	}
+	if len(letters) != 1 || letters[0].DeadLetterID != letter.DeadLetterID {
+		t.Fatalf("dead letters = %+v, want only the kept webhook's", letters)
+	}
+
+	if err := store.DeleteWebhookDeadLetter(ctx, "alice@example.com", letter.DeadLetterID); err != nil {
+		t.Fatalf("DeleteWebhookDeadLetter: %v", err)
+	}
+	if err := store.DeleteWebhookDeadLetter(ctx, "alice@example.com", letter.DeadLetterID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteWebhookDeadLetter again = %v, want ErrNotFound", err)
+	}
+}
+
+func stockIDs(stocks []models.Stock) []string {
+	ids := make([]string, 0, len(stocks))
+	for _, s := range stocks {
+		ids = append(ids, s.StockID)
+	}
+	return ids
+}
+
+func triggerIDs(triggers []*models.StockTrigger) []string {
+	ids := make([]string, 0, len(triggers))
+	for _, t := range triggers {
+		ids = append(ids, t.TriggerID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sorted(ids ...string) []string {
+	sort.Strings(ids)
+	return ids
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,99 +15,231 @@ import (
 	"github.com/google/uuid"
 )
 
-// CreateTrigger creates a new trigger in DynamoDB
+// CreateTrigger creates a new trigger in DynamoDB and appends its ID to the
+// owning stock's triggers list in a single transaction
 func (db *Database) CreateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
 	trigger.TriggerID = uuid.New().String()
 	trigger.CreatedAt = time.Now()
 	trigger.UpdatedAt = time.Now()
 	trigger.LastTrigger = time.Time{} // Zero time for new triggers
+	trigger.Version = 1
 
 	item, err := attributevalue.MarshalMap(trigger)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String("Triggers"),
-		Item:      item,
+	_, err = db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(db.tables.Triggers),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(trigger_id)"),
+				},
+			},
+			{
+				// The stock must still exist and belong to the trigger's user
+				Update: &types.Update{
+					TableName:           aws.String(db.tables.Stocks),
+					Key:                 stockKey(trigger.UserID, trigger.StockID),
+					UpdateExpression:    aws.String("SET triggers = list_append(if_not_exists(triggers, :empty), :ids), last_updated = :now ADD version :one"),
+					ConditionExpression: aws.String("attribute_exists(stock_id)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":empty": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+						":ids": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+							&types.AttributeValueMemberS{Value: trigger.TriggerID},
+						}},
+						":now": timeValue(trigger.UpdatedAt),
+						":one": numberValue(1),
+					},
+				},
+			},
+		},
 	})
-	return err
+	if isConditionFailure(err) {
+		return fmt.Errorf("stock %s: %w", trigger.StockID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create trigger: %w", err)
+	}
+	return nil
 }
 
 // GetTriggersBySymbol gets all triggers for a specific stock symbol
 func (db *Database) GetTriggersBySymbol(ctx context.Context, symbol, exchange string) ([]*models.StockTrigger, error) {
-	input := &dynamodb.QueryInput{
-		TableName:              aws.String("Triggers"),
+	return db.queryAllTriggers(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.Triggers),
 		IndexName:              aws.String("SymbolIndex"),
 		KeyConditionExpression: aws.String("symbol = :symbol AND exchange = :exchange"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":symbol":   &types.AttributeValueMemberS{Value: symbol},
 			":exchange": &types.AttributeValueMemberS{Value: exchange},
 		},
+	})
+}
+
+// GetTriggersByUser gets all triggers for a specific user
+func (db *Database) GetTriggersByUser(ctx context.Context, userID string) ([]*models.StockTrigger, error) {
+	return db.queryAllTriggers(ctx, db.userTriggersQuery(userID))
+}
+
+// ListTriggersByUser gets one page of a user's triggers
+func (db *Database) ListTriggersByUser(ctx context.Context, userID string, page PageOptions) ([]*models.StockTrigger, string, error) {
+	startKey, err := keyFromCursor(page.Cursor, map[string]string{"user_id": userID})
+	if err != nil {
+		return nil, "", err
 	}
 
+	input := db.userTriggersQuery(userID)
+	input.Limit = aws.Int32(int32(page.PageLimit()))
+	input.ExclusiveStartKey = startKey
+
 	result, err := db.client.Query(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, "", fmt.Errorf("failed to query triggers: %w", err)
 	}
 
 	var triggers []*models.StockTrigger
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &triggers)
-	return triggers, err
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &triggers); err != nil {
+		return nil, "", err
+	}
+
+	next, err := cursorFromKey(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return triggers, next, nil
 }
 
-// GetTriggersByUser gets all triggers for a specific user
-func (db *Database) GetTriggersByUser(ctx context.Context, userID string) ([]*models.StockTrigger, error) {
-	input := &dynamodb.QueryInput{
-		TableName:              aws.String("Triggers"),
+// userTriggersQuery selects every trigger owned by a user
+func (db *Database) userTriggersQuery(userID string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:              aws.String(db.tables.Triggers),
 		IndexName:              aws.String("UserIndex"),
 		KeyConditionExpression: aws.String("user_id = :user_id"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":user_id": &types.AttributeValueMemberS{Value: userID},
 		},
 	}
+}
 
-	result, err := db.client.Query(ctx, input)
-	if err != nil {
-		return nil, err
-	}
+// queryAllTriggers runs a query over the triggers table, following
+// LastEvaluatedKey until every page has been read
+func (db *Database) queryAllTriggers(ctx context.Context, input *dynamodb.QueryInput) ([]*models.StockTrigger, error) {
+	paginator := dynamodb.NewQueryPaginator(db.client, input)
 
 	var triggers []*models.StockTrigger
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &triggers)
-	return triggers, err
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var pageTriggers []*models.StockTrigger
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageTriggers); err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, pageTriggers...)
+	}
+	return triggers, nil
 }
 
-// UpdateTrigger updates an existing trigger
+// UpdateTrigger updates an existing trigger. The write only succeeds if the
+// stored trigger is still at trigger.Version; otherwise ErrConflict is returned.
 func (db *Database) UpdateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
+	expected := trigger.Version
 	trigger.UpdatedAt = time.Now()
+	trigger.Version = expected + 1
 
 	item, err := attributevalue.MarshalMap(trigger)
 	if err != nil {
+		trigger.Version = expected
 		return err
 	}
 
+	condition, values := versionCondition("trigger_id", expected)
 	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String("Triggers"),
-		Item:      item,
+		TableName:                 aws.String(db.tables.Triggers),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
 	})
-	return err
+	if err != nil {
+		trigger.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("trigger %s: %w", trigger.TriggerID, ErrConflict)
+		}
+		return err
+	}
+	return nil
 }
 
-// DeleteTrigger deletes a trigger
+// DeleteTrigger deletes a trigger and removes its ID from the owning stock's
+// triggers list in a single transaction. The list element is removed by
+// position, guarded by a condition that it still holds the trigger ID, so the
+// transaction is retried if the list changed after it was read.
 func (db *Database) DeleteTrigger(ctx context.Context, triggerID string) error {
-	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String("Triggers"),
-		Key: map[string]types.AttributeValue{
-			"trigger_id": &types.AttributeValueMemberS{Value: triggerID},
-		},
-	})
-	return err
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		trigger, err := db.GetTrigger(ctx, triggerID)
+		if err != nil {
+			return err
+		}
+
+		items := []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName: aws.String(db.tables.Triggers),
+					Key: map[string]types.AttributeValue{
+						"trigger_id": &types.AttributeValueMemberS{Value: triggerID},
+					},
+					ConditionExpression: aws.String("attribute_exists(trigger_id)"),
+				},
+			},
+		}
+
+		stock, err := db.GetStock(ctx, trigger.UserID, trigger.StockID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if stock != nil {
+			if i := indexOf(stock.Triggers, triggerID); i >= 0 {
+				path := fmt.Sprintf("triggers[%d]", i)
+				items = append(items, types.TransactWriteItem{
+					Update: &types.Update{
+						TableName:           aws.String(db.tables.Stocks),
+						Key:                 stockKey(trigger.UserID, trigger.StockID),
+						UpdateExpression:    aws.String("REMOVE " + path + " SET last_updated = :now ADD version :one"),
+						ConditionExpression: aws.String(path + " = :tid"),
+						ExpressionAttributeValues: map[string]types.AttributeValue{
+							":tid": &types.AttributeValueMemberS{Value: triggerID},
+							":now": timeValue(time.Now()),
+							":one": numberValue(1),
+						},
+					},
+				})
+			}
+		}
+
+		_, err = db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if err == nil {
+			return nil
+		}
+		if !isConditionFailure(err) {
+			return fmt.Errorf("failed to delete trigger: %w", err)
+		}
+		// The trigger or the stock's list changed underneath us; re-read and retry
+	}
+
+	return fmt.Errorf("failed to delete trigger %s: too much contention", triggerID)
 }
 
 // GetUserStockTriggers gets all triggers for a user's stocks
 func (db *Database) GetUserStockTriggers(ctx context.Context, userID string) (*models.UserStockTriggers, error) {
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String("UserStockTriggers"),
+		TableName: aws.String(db.tables.UserStockTriggers),
 		Key: map[string]types.AttributeValue{
 			"user_id": &types.AttributeValueMemberS{Value: userID},
 		},
@@ -132,7 +265,7 @@ func (db *Database) GetUserStockTriggers(ctx context.Context, userID string) (*m
 // 	}
 
 // 	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
-// 		TableName: aws.String("UserStockTriggers"),
+// 		TableName: aws.String(db.tables.UserStockTriggers),
 // 		Item:      item,
 // 	})
 // 	return err
@@ -141,17 +274,18 @@ func (db *Database) GetUserStockTriggers(ctx context.Context, userID string) (*m
 // GetTrigger retrieves a trigger by its ID
 func (db *Database) GetTrigger(ctx context.Context, triggerID string) (*models.StockTrigger, error) {
 	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String("Triggers"),
+		TableName: aws.String(db.tables.Triggers),
 		Key: map[string]types.AttributeValue{
 			"trigger_id": &types.AttributeValueMemberS{Value: triggerID},
 		},
+		ConsistentRead: aws.Bool(true),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	if result.Item == nil {
-		return nil, fmt.Errorf("trigger not found")
+		return nil, fmt.Errorf("trigger %s: %w", triggerID, ErrNotFound)
 	}
 
 	var trigger models.StockTrigger
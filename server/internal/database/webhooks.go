@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// webhookKey returns the primary key of a webhook item
+func webhookKey(userID, webhookID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"user_id":    &types.AttributeValueMemberS{Value: userID},
+		"webhook_id": &types.AttributeValueMemberS{Value: webhookID},
+	}
+}
+
+// deadLetterKey returns the primary key of a webhook dead letter item
+func deadLetterKey(userID, deadLetterID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"user_id":        &types.AttributeValueMemberS{Value: userID},
+		"dead_letter_id": &types.AttributeValueMemberS{Value: deadLetterID},
+	}
+}
+
+// CreateWebhook stores a new webhook. It fails with ErrConflict if the ID is
+// already taken.
+func (db *Database) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.WebhookID == "" {
+		webhook.WebhookID = uuid.New().String()
+	}
+	if webhook.CreatedAt.IsZero() {
+		webhook.CreatedAt = time.Now()
+	}
+	webhook.UpdatedAt = time.Now()
+	webhook.Version = 1
+
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook: %v", err)
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tables.Webhooks),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(webhook_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("webhook %s already exists: %w", webhook.WebhookID, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save webhook: %v", err)
+	}
+	return nil
+}
+
+// GetWebhook returns one of a user's webhooks
+func (db *Database) GetWebhook(ctx context.Context, userID, webhookID string) (*models.Webhook, error) {
+	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tables.Webhooks),
+		Key:            webhookKey(userID, webhookID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %v", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("webhook %s: %w", webhookID, ErrNotFound)
+	}
+
+	var webhook models.Webhook
+	if err := attributevalue.UnmarshalMap(result.Item, &webhook); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook: %v", err)
+	}
+	return &webhook, nil
+}
+
+// GetUserWebhooks returns all of a user's webhooks ordered by ID
+func (db *Database) GetUserWebhooks(ctx context.Context, userID string) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	if err := db.queryUserItems(ctx, db.tables.Webhooks, userID, &webhooks); err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces a webhook. The write only succeeds if the stored
+// webhook is still at webhook.Version; otherwise ErrConflict is returned.
+func (db *Database) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	expected := webhook.Version
+	webhook.UpdatedAt = time.Now()
+	webhook.Version = expected + 1
+
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		webhook.Version = expected
+		return fmt.Errorf("failed to marshal webhook: %v", err)
+	}
+
+	condition, values := versionCondition("webhook_id", expected)
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(db.tables.Webhooks),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		webhook.Version = expected
+		if isConditionFailure(err) {
+			return fmt.Errorf("webhook %s: %w", webhook.WebhookID, ErrConflict)
+		}
+		return fmt.Errorf("failed to update webhook: %v", err)
+	}
+	return nil
+}
+
+// DeleteWebhook deletes a webhook, then its dead letters
+func (db *Database) DeleteWebhook(ctx context.Context, userID, webhookID string) error {
+	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(db.tables.Webhooks),
+		Key:                 webhookKey(userID, webhookID),
+		ConditionExpression: aws.String("attribute_exists(webhook_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("webhook %s: %w", webhookID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	// Dead letters share the user's partition, so finding them is one query
+	letters, err := db.GetUserWebhookDeadLetters(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, letter := range letters {
+		if letter.WebhookID != webhookID {
+			continue
+		}
+		err := db.DeleteWebhookDeadLetter(ctx, userID, letter.DeadLetterID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordWebhookResult clears or counts a webhook's failures in a single
+// update
+func (db *Database) RecordWebhookResult(ctx context.Context, userID, webhookID string, ok bool) (int, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.tables.Webhooks),
+		Key:                 webhookKey(userID, webhookID),
+		UpdateExpression:    aws.String("ADD failures :one"),
+		ConditionExpression: aws.String("attribute_exists(webhook_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": numberValue(1),
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	}
+	if ok {
+		input.UpdateExpression = aws.String("SET failures = :zero")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{":zero": numberValue(0)}
+	}
+
+	result, err := db.client.UpdateItem(ctx, input)
+	if isConditionFailure(err) {
+		return 0, fmt.Errorf("webhook %s: %w", webhookID, ErrNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to record webhook result: %v", err)
+	}
+
+	failures, _ := result.Attributes["failures"].(*types.AttributeValueMemberN)
+	if failures == nil {
+		return 0, fmt.Errorf("failed to record webhook result: no failure count returned")
+	}
+	n, err := strconv.Atoi(failures.Value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse webhook failures: %v", err)
+	}
+	return n, nil
+}
+
+// SaveWebhookDeadLetter stores a dead letter, replacing any earlier one with
+// the same ID
+func (db *Database) SaveWebhookDeadLetter(ctx context.Context, letter *models.WebhookDeadLetter) error {
+	item, err := attributevalue.MarshalMap(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %v", err)
+	}
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.tables.WebhookDeadLetters),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save dead letter: %v", err)
+	}
+	return nil
+}
+
+// GetWebhookDeadLetter returns one of a user's dead letters
+func (db *Database) GetWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) (*models.WebhookDeadLetter, error) {
+	result, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tables.WebhookDeadLetters),
+		Key:            deadLetterKey(userID, deadLetterID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %v", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("dead letter %s: %w", deadLetterID, ErrNotFound)
+	}
+
+	var letter models.WebhookDeadLetter
+	if err := attributevalue.UnmarshalMap(result.Item, &letter); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead letter: %v", err)
+	}
+	return &letter, nil
+}
+
+// GetUserWebhookDeadLetters returns all of a user's dead letters ordered by
+// ID
+func (db *Database) GetUserWebhookDeadLetters(ctx context.Context, userID string) ([]*models.WebhookDeadLetter, error) {
+	var letters []*models.WebhookDeadLetter
+	if err := db.queryUserItems(ctx, db.tables.WebhookDeadLetters, userID, &letters); err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+// DeleteWebhookDeadLetter deletes a dead letter
+func (db *Database) DeleteWebhookDeadLetter(ctx context.Context, userID, deadLetterID string) error {
+	_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(db.tables.WebhookDeadLetters),
+		Key:                 deadLetterKey(userID, deadLetterID),
+		ConditionExpression: aws.String("attribute_exists(dead_letter_id)"),
+	})
+	if isConditionFailure(err) {
+		return fmt.Errorf("dead letter %s: %w", deadLetterID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter: %v", err)
+	}
+	return nil
+}
+
+// queryUserItems reads every item of table under a user's partition key
+// into out, a pointer to a slice, in sort key order
+func (db *Database) queryUserItems(ctx context.Context, table, userID string, out any) error {
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	var items []map[string]types.AttributeValue
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		items = append(items, page.Items...)
+	}
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
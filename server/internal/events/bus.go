@@ -0,0 +1,110 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultMaxAttempts is how often a message is delivered to a group
+	// before it is dead-lettered
+	DefaultMaxAttempts = 5
+	// DefaultRetryDelay is how long a failed message waits before it is
+	// delivered again
+	DefaultRetryDelay = 5 * time.Second
+)
+
+// MessageHandler processes one message
+type MessageHandler func(ctx context.Context, msg Message) error
+
+// Subscription describes a consumer of one event type
+type Subscription struct {
+	Group       string
+	Type        Type
+	Handler     MessageHandler
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+// Transport moves messages from publishers to subscribers
+type Transport interface {
+	// Publish hands a message to every group subscribed to its type
+	Publish(ctx context.Context, msg Message) error
+	// Subscribe delivers the messages of sub.Type published from now on to
+	// sub.Handler until ctx is cancelled. It returns once the subscription
+	// is registered and consumes in the background.
+	Subscribe(ctx context.Context, sub Subscription) error
+	// Unsubscribe deletes group from the subscribers of typ, together with
+	// the messages it hasn't handled. Its consumers should be stopped first.
+	Unsubscribe(ctx context.Context, typ Type, group string) error
+}
+
+// Bus publishes typed events over a transport
+type Bus struct {
+	transport Transport
+
+	MaxAttempts int           // Deliveries to a group before dead-lettering
+	RetryDelay  time.Duration // Wait before redelivering a failed message
+}
+
+// NewBus creates a bus over transport
+func NewBus(transport Transport) *Bus {
+	return &Bus{
+		transport:   transport,
+		MaxAttempts: DefaultMaxAttempts,
+		RetryDelay:  DefaultRetryDelay,
+	}
+}
+
+// Publish sends an event to its subscribers
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", event.EventType(), err)
+	}
+
+	msg := Message{
+		ID:          uuid.NewString(),
+		Type:        event.EventType(),
+		Payload:     payload,
+		PublishedAt: time.Now(),
+	}
+	if err := b.transport.Publish(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish %s event: %v", msg.Type, err)
+	}
+	return nil
+}
+
+// Subscribe registers handler for every event of type T as a member of
+// group. It consumes in the background until ctx is cancelled.
+func Subscribe[T Event](ctx context.Context, b *Bus, group string, handler func(ctx context.Context, event T) error) error {
+	var zero T
+	return b.transport.Subscribe(ctx, Subscription{
+		Group: group,
+		Type:  zero.EventType(),
+		Handler: func(ctx context.Context, msg Message) error {
+			var event T
+			if err := json.Unmarshal(msg.Payload, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal %s event %s: %v", msg.Type, msg.ID, err)
+			}
+			return handler(ctx, event)
+		},
+		MaxAttempts: b.MaxAttempts,
+		RetryDelay:  b.RetryDelay,
+	})
+}
+
+// Unsubscribe deletes group from the subscribers of events of type T. Events
+// published afterwards are not kept for it, and a later Subscribe to the
+// group starts from then.
+func Unsubscribe[T Event](ctx context.Context, b *Bus, group string) error {
+	var zero T
+	if err := b.transport.Unsubscribe(ctx, zero.EventType(), group); err != nil {
+		return fmt.Errorf("failed to unsubscribe %s: %v", group, err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport()
+	testTransport(t, transport, func(ctx context.Context) ([]DeadLetter, error) {
+		return transport.DeadLetters(), nil
+	})
+}
+
+// TestRedisTransport runs against the Redis server at REDIS_TEST_ADDR
+func TestRedisTransport(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	prefix := fmt.Sprintf("events_test:%d", time.Now().UnixNano())
+	defer func() {
+		keys, _ := client.Keys(context.Background(), prefix+":*").Result()
+		if len(keys) > 0 {
+			client.Del(context.Background(), keys...)
+		}
+	}()
+
+	transport := NewRedisTransport(client, prefix, "test-consumer")
+	transport.Block = 20 * time.Millisecond
+	testTransport(t, transport, func(ctx context.Context) ([]DeadLetter, error) {
+		return transport.DeadLetters(ctx, 10)
+	})
+}
+
+// recorder collects the events a subscriber handles
+type recorder struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, s)
+}
+
+func (r *recorder) sorted() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := append([]string(nil), r.seen...)
+	sort.Strings(out)
+	return out
+}
+
+// eventually polls cond until it holds or the deadline passes
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testTransport(t *testing.T, transport Transport, deadLetters func(context.Context) ([]DeadLetter, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewBus(transport)
+	bus.MaxAttempts = 3
+	bus.RetryDelay = 10 * time.Millisecond
+
+	t.Run("every group receives every event", func(t *testing.T) {
+		var triggers, websocket recorder
+		for group, r := range map[string]*recorder{"triggers": &triggers, "websocket": &websocket} {
+			err := Subscribe(ctx, bus, group, func(ctx context.Context, e PriceUpdated) error {
+				r.add(fmt.Sprintf("%s=%v", e.Symbol, e.Price))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+		}
+
+		for _, e := range []PriceUpdated{{Symbol: "AAPL", Price: 150}, {Symbol: "MSFT", Price: 310}} {
+			if err := bus.Publish(ctx, e); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+
+		want := "[AAPL=150 MSFT=310]"
+		eventually(t, "both groups", func() bool {
+			return fmt.Sprint(triggers.sorted()) == want && fmt.Sprint(websocket.sorted()) == want
+		})
+	})
+
+	t.Run("subscribers in a group share its events", func(t *testing.T) {
+		var first, second recorder
+		for _, r := range []*recorder{&first, &second} {
+			err := Subscribe(ctx, bus, "mailer", func(ctx context.Context, e UserSignedUp) error {
+				r.add(e.Email)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+		}
+
+		var want []string
+		for i := 0; i < 20; i++ {
+			email := fmt.Sprintf("user%02d@example.com", i)
+			want = append(want, email)
+			if err := bus.Publish(ctx, UserSignedUp{Email: email}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+
+		// Each event is handled once across the group
+		eventually(t, "the group to drain", func() bool {
+			all := append(first.sorted(), second.sorted()...)
+			sort.Strings(all)
+			return fmt.Sprint(all) == fmt.Sprint(want)
+		})
+	})
+
+	t.Run("unsubscribed groups are deleted", func(t *testing.T) {
+		var before, after recorder
+		subCtx, stop := context.WithCancel(ctx)
+		err := Subscribe(subCtx, bus, "websocket:old", func(ctx context.Context, e SocketPush) error {
+			before.add(e.UserID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		if err := bus.Publish(ctx, SocketPush{UserID: "alice"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		eventually(t, "the first event", func() bool { return len(before.sorted()) == 1 })
+
+		stop()
+		if err := Unsubscribe[SocketPush](ctx, bus, "websocket:old"); err != nil {
+			t.Fatalf("Unsubscribe: %v", err)
+		}
+		if err := bus.Publish(ctx, SocketPush{UserID: "bob"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+
+		// Nothing was kept for the group while it was gone
+		err = Subscribe(ctx, bus, "websocket:old", func(ctx context.Context, e SocketPush) error {
+			after.add(e.UserID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Subscribe again: %v", err)
+		}
+		if err := bus.Publish(ctx, SocketPush{UserID: "carol"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		eventually(t, "the event after resubscribing", func() bool { return len(after.sorted()) > 0 })
+		time.Sleep(50 * time.Millisecond)
+		if got := fmt.Sprint(after.sorted()); got != "[carol]" {
+			t.Errorf("resubscribed group got %s, want only the event published since", got)
+		}
+		if got := fmt.Sprint(before.sorted()); got != "[alice]" {
+			t.Errorf("stopped subscriber got %s", got)
+		}
+	})
+
+	t.Run("failed events are retried then dead-lettered", func(t *testing.T) {
+		var attempts recorder
+		err := Subscribe(ctx, bus, "notifier", func(ctx context.Context, e TriggerFired) error {
+			attempts.add(e.TriggerID)
+			if e.TriggerID == "flaky" && len(attempts.sorted()) < 2 {
+				return errors.New("temporarily unavailable")
+			}
+			if e.TriggerID == "broken" {
+				return errors.New("permanently broken")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+
+		if err := bus.Publish(ctx, TriggerFired{TriggerID: "flaky"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		eventually(t, "the flaky event to succeed", func() bool {
+			return fmt.Sprint(attempts.sorted()) == "[flaky flaky]"
+		})
+
+		if err := bus.Publish(ctx, TriggerFired{TriggerID: "broken"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		var letters []DeadLetter
+		eventually(t, "the broken event to be dead-lettered", func() bool {
+			letters, err = deadLetters(ctx)
+			return err == nil && len(letters) == 1
+		})
+
+		letter := letters[0]
+		if letter.Type != TypeTriggerFired || letter.Group != "notifier" || letter.Attempt != 3 || letter.Error != "permanently broken" {
+			t.Fatalf("dead letter = %+v", letter)
+		}
+		if got := fmt.Sprint(attempts.sorted()); got != "[broken broken broken flaky flaky]" {
+			t.Fatalf("attempts = %s, want 3 for broken", got)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+// Package events is a typed publish/subscribe bus that decouples the parts
+// of the server. Publishers don't know who consumes an event, and consumers
+// can run in the same binary, over the in-process transport, or in other
+// instances, over the Redis Streams transport.
+//
+// Delivery is at least once. Subscribers that share a group split an event
+// type's messages between them and every group receives every message. A
+// message whose handler fails is redelivered until it succeeds or runs out of
+// attempts, when it is dead-lettered, so handlers must be idempotent.
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Type names an event
+type Type string
+
+const (
+	TypePriceUpdated   Type = "PriceUpdated"
+	TypeTriggerFired   Type = "TriggerFired"
+	TypeTriggerChanged Type = "TriggerChanged"
+	TypeHoldingAdded   Type = "HoldingAdded"
+	TypeUserSignedUp   Type = "UserSignedUp"
+	TypeStocksViewed   Type = "StocksViewed"
+	TypeSocketPush     Type = "SocketPush"
+)
+
+// Event is a payload that can be published on the bus
+type Event interface {
+	EventType() Type
+}
+
+// PriceUpdated is published when a fresh price is fetched for a symbol
+type PriceUpdated struct {
+	Symbol    string    `json:"symbol"`
+	Exchange  string    `json:"exchange"`
+	Price     float64   `json:"price"`
+	PrevPrice float64   `json:"previous_price,omitempty"` // Price the poller fetched before, 0 if unknown
+	At        time.Time `json:"at"`
+}
+
+// TriggerFired is published when a price move sets off a user's trigger
+type TriggerFired struct {
+	FireID    string    `json:"fire_id"` // Identifies this firing for idempotent delivery
+	TriggerID string    `json:"trigger_id"`
+	UserID    string    `json:"user_id"`
+	Symbol    string    `json:"symbol"`
+	Exchange  string    `json:"exchange"`
+	Price     float64   `json:"price"`
+	PrevPrice float64   `json:"previous_price,omitempty"` // Price on the tick before, 0 if unknown
+	Message   string    `json:"message"`
+	At        time.Time `json:"at"`
+}
+
+// TriggerChanged is published when a trigger is created, written or
+// deleted, so every instance can bring its trigger index up to date
+type TriggerChanged struct {
+	TriggerID string    `json:"trigger_id"`
+	Symbol    string    `json:"symbol"`
+	Exchange  string    `json:"exchange"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// HoldingAdded is published when a user adds a stock to their portfolio
+type HoldingAdded struct {
+	UserID   string    `json:"user_id"`
+	StockID  string    `json:"stock_id"`
+	Symbol   string    `json:"symbol"`
+	Exchange string    `json:"exchange"`
+	Price    float64   `json:"price"`
+	At       time.Time `json:"at"`
+}
+
+// UserSignedUp is published when a new account is created
+type UserSignedUp struct {
+	UserID string    `json:"user_id"`
+	Email  string    `json:"email"`
+	At     time.Time `json:"at"`
+}
+
+// StocksViewed is published when a user looks at their holdings, so the
+// symbols on screen can be refreshed more often
+type StocksViewed struct {
+	UserID  string    `json:"user_id"`
+	Symbols []string  `json:"symbols"` // symbol:exchange keys
+	At      time.Time `json:"at"`
+}
+
+// SocketPush is published to send a message to a user's websocket on
+// whichever instance holds it
+type SocketPush struct {
+	UserID  string          `json:"user_id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (PriceUpdated) EventType() Type   { return TypePriceUpdated }
+func (TriggerFired) EventType() Type   { return TypeTriggerFired }
+func (TriggerChanged) EventType() Type { return TypeTriggerChanged }
+func (HoldingAdded) EventType() Type   { return TypeHoldingAdded }
+func (UserSignedUp) EventType() Type   { return TypeUserSignedUp }
+func (StocksViewed) EventType() Type   { return TypeStocksViewed }
+func (SocketPush) EventType() Type     { return TypeSocketPush }
+
+// Message is an event as carried by a transport
+type Message struct {
+	ID          string
+	Type        Type
+	Payload     json.RawMessage
+	PublishedAt time.Time
+	Attempt     int // 1 on first delivery, counting up on each redelivery
+}
+
+// DeadLetter is a message that a group gave up on
+type DeadLetter struct {
+	Message
+	Group    string
+	Error    string // The handler's last error
+	FailedAt time.Time
+}
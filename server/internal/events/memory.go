@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// MemoryTransport delivers messages within the process, for single-binary
+// deployments and tests. Messages are lost if the process exits.
+type MemoryTransport struct {
+	queues map[Type]map[string]*memoryQueue // type -> group -> queue
+	dead   []DeadLetter
+	mu     sync.Mutex
+}
+
+// memoryQueue holds one group's undelivered messages of one type. Every
+// subscriber of the group takes from the same queue.
+type memoryQueue struct {
+	pending []Message
+	ready   chan struct{} // signalled when pending becomes non-empty
+	mu      sync.Mutex
+}
+
+var _ Transport = (*MemoryTransport)(nil)
+
+// NewMemoryTransport creates an in-process transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{queues: make(map[Type]map[string]*memoryQueue)}
+}
+
+// Publish queues a message for every group subscribed to its type
+func (t *MemoryTransport) Publish(ctx context.Context, msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, q := range t.queues[msg.Type] {
+		q.push(msg)
+	}
+	return nil
+}
+
+// Subscribe starts a consumer of sub.Type in sub.Group
+func (t *MemoryTransport) Subscribe(ctx context.Context, sub Subscription) error {
+	t.mu.Lock()
+	groups, ok := t.queues[sub.Type]
+	if !ok {
+		groups = make(map[string]*memoryQueue)
+		t.queues[sub.Type] = groups
+	}
+	q, ok := groups[sub.Group]
+	if !ok {
+		q = &memoryQueue{ready: make(chan struct{}, 1)}
+		groups[sub.Group] = q
+	}
+	t.mu.Unlock()
+
+	go t.consume(ctx, q, sub)
+	return nil
+}
+
+// Unsubscribe stops queueing messages of typ for group
+func (t *MemoryTransport) Unsubscribe(ctx context.Context, typ Type, group string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.queues[typ], group)
+	return nil
+}
+
+// DeadLetters returns every message a group has given up on
+func (t *MemoryTransport) DeadLetters() []DeadLetter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]DeadLetter(nil), t.dead...)
+}
+
+func (t *MemoryTransport) consume(ctx context.Context, q *memoryQueue, sub Subscription) {
+	for {
+		msg, ok := q.pop(ctx)
+		if !ok {
+			return
+		}
+
+		msg.Attempt++
+		err := sub.Handler(ctx, msg)
+		if err == nil {
+			continue
+		}
+
+		if msg.Attempt >= sub.MaxAttempts {
+			log.Printf("Dead-lettering %s event %s for %s after %d attempts: %v", msg.Type, msg.ID, sub.Group, msg.Attempt, err)
+			t.mu.Lock()
+			t.dead = append(t.dead, DeadLetter{Message: msg, Group: sub.Group, Error: err.Error(), FailedAt: time.Now()})
+			t.mu.Unlock()
+			continue
+		}
+
+		// Requeue after the delay without holding up the rest of the queue
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(sub.RetryDelay):
+				q.push(msg)
+			}
+		}()
+	}
+}
+
+func (q *memoryQueue) push(msg Message) {
+	q.mu.Lock()
+	q.pending = append(q.pending, msg)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop takes the oldest message, waiting for one until ctx is cancelled
+func (q *memoryQueue) pop(ctx context.Context) (Message, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			msg := q.pending[0]
+			q.pending = q.pending[1:]
+			more := len(q.pending) > 0
+			q.mu.Unlock()
+			// Pass the wakeup on to another subscriber of the group
+			if more {
+				q.signal()
+			}
+			return msg, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, false
+		case <-q.ready:
+		}
+	}
+}
+
+func (q *memoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
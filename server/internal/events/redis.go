@@ -0,0 +1,260 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisPrefix prefixes the streams used by RedisTransport
+const DefaultRedisPrefix = "events"
+
+// redisBatchSize is how many messages a consumer reads at a time
+const redisBatchSize = 10
+
+// RedisTransport carries messages between instances on Redis Streams. Each
+// event type is a stream and each group a consumer group on it. A message
+// stays pending in its group until a handler acknowledges it; failed and
+// abandoned messages are claimed again once they have been idle for the
+// retry delay, and dead-lettered to a separate stream after MaxAttempts.
+type RedisTransport struct {
+	client   *redis.Client
+	prefix   string
+	consumer string
+
+	MaxLen int64         // Approximate number of messages kept per stream
+	Block  time.Duration // How long a read waits for new messages
+}
+
+var _ Transport = (*RedisTransport)(nil)
+
+// NewRedisTransport creates a transport whose streams are named after
+// prefix. consumer must be unique to the instance.
+func NewRedisTransport(client *redis.Client, prefix, consumer string) *RedisTransport {
+	return &RedisTransport{
+		client:   client,
+		prefix:   prefix,
+		consumer: consumer,
+		MaxLen:   100_000,
+		Block:    time.Second,
+	}
+}
+
+// Publish appends a message to its type's stream
+func (t *RedisTransport) Publish(ctx context.Context, msg Message) error {
+	err := t.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: t.streamKey(msg.Type),
+		MaxLen: t.MaxLen,
+		Approx: true,
+		Values: map[string]any{
+			"id":           msg.ID,
+			"type":         string(msg.Type),
+			"payload":      string(msg.Payload),
+			"published_at": msg.PublishedAt.Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to append to %s: %v", t.streamKey(msg.Type), err)
+	}
+	return nil
+}
+
+// Subscribe creates sub.Group on the type's stream if needed and starts
+// consuming it
+func (t *RedisTransport) Subscribe(ctx context.Context, sub Subscription) error {
+	stream := t.streamKey(sub.Type)
+	err := t.client.XGroupCreateMkStream(ctx, stream, sub.Group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create group %s on %s: %v", sub.Group, stream, err)
+	}
+
+	go t.consume(ctx, stream, sub)
+	return nil
+}
+
+// Unsubscribe destroys the consumer group, so the stream no longer keeps
+// its pending entries
+func (t *RedisTransport) Unsubscribe(ctx context.Context, typ Type, group string) error {
+	stream := t.streamKey(typ)
+	if err := t.client.XGroupDestroy(ctx, stream, group).Err(); err != nil {
+		return fmt.Errorf("failed to destroy group %s on %s: %v", group, stream, err)
+	}
+	return nil
+}
+
+// DeadLetters returns up to count of the most recently dead-lettered
+// messages, newest first
+func (t *RedisTransport) DeadLetters(ctx context.Context, count int64) ([]DeadLetter, error) {
+	entries, err := t.client.XRevRangeN(ctx, t.deadLetterKey(), "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %v", err)
+	}
+
+	letters := make([]DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		msg, err := decodeRedisMessage(entry)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attempt, _ = strconv.Atoi(fieldString(entry, "attempt"))
+		failedAt, _ := time.Parse(time.RFC3339Nano, fieldString(entry, "failed_at"))
+		letters = append(letters, DeadLetter{
+			Message:  msg,
+			Group:    fieldString(entry, "group"),
+			Error:    fieldString(entry, "error"),
+			FailedAt: failedAt,
+		})
+	}
+	return letters, nil
+}
+
+func (t *RedisTransport) consume(ctx context.Context, stream string, sub Subscription) {
+	for ctx.Err() == nil {
+		// First retry what failed or was abandoned by a crashed consumer
+		claimed, _, err := t.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    sub.Group,
+			Consumer: t.consumer,
+			MinIdle:  sub.RetryDelay,
+			Start:    "0-0",
+			Count:    redisBatchSize,
+		}).Result()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim pending messages on %s for %s: %v", stream, sub.Group, err)
+		}
+		for _, entry := range claimed {
+			t.handle(ctx, stream, sub, entry, true)
+		}
+
+		streams, err := t.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sub.Group,
+			Consumer: t.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    redisBatchSize,
+			Block:    t.Block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to read %s for %s: %v", stream, sub.Group, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(sub.RetryDelay):
+			}
+			continue
+		}
+		for _, s := range streams {
+			for _, entry := range s.Messages {
+				t.handle(ctx, stream, sub, entry, false)
+			}
+		}
+	}
+}
+
+// handle delivers one entry, acknowledging it once it succeeds or is
+// dead-lettered. A failed entry is left pending to be claimed again.
+func (t *RedisTransport) handle(ctx context.Context, stream string, sub Subscription, entry redis.XMessage, redelivered bool) {
+	msg, err := decodeRedisMessage(entry)
+	if err != nil {
+		// A malformed entry will never succeed
+		t.deadLetter(ctx, stream, sub, entry.ID, Message{ID: entry.ID}, err)
+		return
+	}
+
+	msg.Attempt = 1
+	if redelivered {
+		msg.Attempt = t.deliveries(ctx, stream, sub.Group, entry.ID)
+	}
+
+	if err := sub.Handler(ctx, msg); err != nil {
+		if msg.Attempt >= sub.MaxAttempts {
+			t.deadLetter(ctx, stream, sub, entry.ID, msg, err)
+		}
+		return
+	}
+	if err := t.client.XAck(ctx, stream, sub.Group, entry.ID).Err(); err != nil {
+		log.Printf("Failed to acknowledge %s on %s: %v", entry.ID, stream, err)
+	}
+}
+
+// deliveries returns how often an entry has been delivered to group,
+// including the current delivery
+func (t *RedisTransport) deliveries(ctx context.Context, stream, group, id string) int {
+	pending, err := t.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		// Assume a redelivery so the message still runs out of attempts
+		return 2
+	}
+	return int(pending[0].RetryCount)
+}
+
+// deadLetter moves an entry to the dead-letter stream and acknowledges it
+func (t *RedisTransport) deadLetter(ctx context.Context, stream string, sub Subscription, entryID string, msg Message, cause error) {
+	log.Printf("Dead-lettering %s event %s for %s after %d attempts: %v", msg.Type, msg.ID, sub.Group, msg.Attempt, cause)
+
+	err := t.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: t.deadLetterKey(),
+		MaxLen: t.MaxLen,
+		Approx: true,
+		Values: map[string]any{
+			"id":           msg.ID,
+			"type":         string(msg.Type),
+			"payload":      string(msg.Payload),
+			"published_at": msg.PublishedAt.Format(time.RFC3339Nano),
+			"attempt":      msg.Attempt,
+			"group":        sub.Group,
+			"error":        cause.Error(),
+			"failed_at":    time.Now().Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		// Leave the entry pending so it is retried rather than lost
+		log.Printf("Failed to dead-letter %s: %v", entryID, err)
+		return
+	}
+	if err := t.client.XAck(ctx, stream, sub.Group, entryID).Err(); err != nil {
+		log.Printf("Failed to acknowledge %s on %s: %v", entryID, stream, err)
+	}
+}
+
+func (t *RedisTransport) streamKey(eventType Type) string {
+	return t.prefix + ":" + string(eventType)
+}
+
+func (t *RedisTransport) deadLetterKey() string {
+	return t.prefix + ":dead"
+}
+
+func decodeRedisMessage(entry redis.XMessage) (Message, error) {
+	msg := Message{
+		ID:      fieldString(entry, "id"),
+		Type:    Type(fieldString(entry, "type")),
+		Payload: []byte(fieldString(entry, "payload")),
+	}
+	if msg.ID == "" || msg.Type == "" {
+		return Message{}, fmt.Errorf("stream entry %s is not an event", entry.ID)
+	}
+	msg.PublishedAt, _ = time.Parse(time.RFC3339Nano, fieldString(entry, "published_at"))
+	return msg, nil
+}
+
+func fieldString(entry redis.XMessage, field string) string {
+	s, _ := entry.Values[field].(string)
+	return s
+}
@@ -3,15 +3,31 @@ package auth
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
 	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/events"
 	"stockmarket/server/internal/models"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Service handles user registration and login
+type Service struct {
+	users database.UserRepository
+	bus   *events.Bus
+}
+
+// NewService creates a new auth service
+func NewService(users database.UserRepository, bus *events.Bus) *Service {
+	return &Service{
+		users: users,
+		bus:   bus,
+	}
+}
+
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -22,25 +38,36 @@ func HashPassword(password string) (string, error) {
 }
 
 // CreateUser creates a new user in the database.
-func CreateUser(ctx context.Context, email, password string) error {
+func (s *Service) CreateUser(ctx context.Context, email, password string) error {
 	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %v", err)
 	}
 
-	user := models.User{
+	user := &models.User{
 		UserID:       uuid.New().String(),
 		Email:        email,
 		PasswordHash: hashedPassword,
 		CreatedAt:    time.Now(),
 	}
+	// New accounts get alerts in the app and by email until they say otherwise
+	user.NotificationPreferences.WebSocket = true
+	user.NotificationPreferences.Email = true
 
-	return database.SaveUser(ctx, user)
+	if err := s.users.CreateUser(ctx, user); err != nil {
+		return err
+	}
+
+	err = s.bus.Publish(ctx, events.UserSignedUp{UserID: user.UserID, Email: user.Email, At: user.CreatedAt})
+	if err != nil {
+		log.Printf("Failed to publish signup of %s: %v", user.Email, err)
+	}
+	return nil
 }
 
 // ValidateUser validates user credentials and returns a JWT token if valid
-func ValidateUser(ctx context.Context, email, password string) (string, error) {
-	user, err := database.GetUserByEmail(ctx, email)
+func (s *Service) ValidateUser(ctx context.Context, email, password string) (string, error) {
+	user, err := s.users.GetUserByEmail(ctx, email)
 	if err != nil {
 		return "", fmt.Errorf("user not found")
 	}
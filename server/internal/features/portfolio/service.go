@@ -0,0 +1,202 @@
+package portfolio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/features/stock"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/tracking"
+)
+
+// Service handles operations on users' portfolios
+type Service struct {
+	repo    database.PortfolioRepository
+	symbols tracking.Registry
+	bus     *events.Bus
+}
+
+// NewService creates a new portfolio service
+func NewService(repo database.PortfolioRepository, symbols tracking.Registry, bus *events.Bus) *Service {
+	return &Service{
+		repo:    repo,
+		symbols: symbols,
+		bus:     bus,
+	}
+}
+
+// ErrInvalidQuantity is returned for a negative number of shares
+var ErrInvalidQuantity = errors.New("quantity must not be negative")
+
+// AddStock adds a stock to a user's portfolio with the number of shares
+// held, which is 0 for a stock that is only watched
+func (s *Service) AddStock(ctx context.Context, userID string, details *stock.StockDetails, quantity float64) (*models.Stock, error) {
+	if quantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	// Convert price from json.Number to float64
+	price, err := details.Price.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price: %v", err)
+	}
+
+	// Create the stock entry
+	entry := &models.Stock{
+		UserID:    userID,
+		Symbol:    details.Symbol,
+		Name:      details.Name,
+		Exchange:  details.Exchange,
+		Currency:  details.Currency,
+		Quantity:  quantity,
+		Price:     price,
+		LastPrice: price, // Initially same as current price
+	}
+	if err := s.repo.CreateStock(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	// Drift from a failure here is repaired by cmd/rebuildtracking
+	key := tracking.Key{Symbol: entry.Symbol, Exchange: entry.Exchange}
+	if err := s.symbols.Add(ctx, key, 1); err != nil {
+		log.Printf("Failed to track %s: %v", key, err)
+	}
+
+	err = s.bus.Publish(ctx, events.HoldingAdded{
+		UserID:   entry.UserID,
+		StockID:  entry.StockID,
+		Symbol:   entry.Symbol,
+		Exchange: entry.Exchange,
+		Price:    entry.Price,
+		At:       time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to publish holding %s: %v", entry.StockID, err)
+	}
+
+	return entry, nil
+}
+
+// SetQuantity changes the number of shares held of a stock in a user's
+// portfolio
+func (s *Service) SetQuantity(ctx context.Context, userID, stockID string, quantity float64) (*models.Stock, error) {
+	if quantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+	entry, err := s.repo.GetStock(ctx, userID, stockID)
+	if err != nil {
+		return nil, err
+	}
+	entry.Quantity = quantity
+	if err := s.repo.UpdateStock(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// GetUserStocks retrieves all stocks for a given user with current prices
+func (s *Service) GetUserStocks(ctx context.Context, userID string) ([]models.Stock, error) {
+	stocks, err := s.repo.GetUserStocks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.refreshPrices(stocks)
+	s.publishViewed(ctx, userID, stocks)
+	return stocks, nil
+}
+
+// ListUserStocks retrieves one page of a user's stocks with current prices,
+// and the cursor of the next page
+func (s *Service) ListUserStocks(ctx context.Context, userID string, page database.PageOptions) ([]models.Stock, string, error) {
+	stocks, next, err := s.repo.ListUserStocks(ctx, userID, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.refreshPrices(stocks)
+	s.publishViewed(ctx, userID, stocks)
+	return stocks, next, nil
+}
+
+// publishViewed announces which symbols a user is looking at
+func (s *Service) publishViewed(ctx context.Context, userID string, stocks []models.Stock) {
+	if len(stocks) == 0 {
+		return
+	}
+	symbols := make([]string, 0, len(stocks))
+	for _, st := range stocks {
+		symbols = append(symbols, tracking.Key{Symbol: st.Symbol, Exchange: st.Exchange}.String())
+	}
+	if err := s.bus.Publish(ctx, events.StocksViewed{UserID: userID, Symbols: symbols, At: time.Now()}); err != nil {
+		log.Printf("Failed to publish stocks viewed by %s: %v", userID, err)
+	}
+}
+
+// refreshPrices updates the prices of stocks in place and writes them back
+// in the background
+func (s *Service) refreshPrices(stocks []models.Stock) {
+	for i := range stocks {
+		details, err := stock.FetchStockDetails(stocks[i].Symbol)
+		if err != nil {
+			log.Printf("Failed to fetch price for %s: %v", stocks[i].Symbol, err)
+			continue
+		}
+
+		// Update price information
+		price, _ := details.Price.Float64()
+		stocks[i].LastPrice = stocks[i].Price
+		stocks[i].Price = price
+		stocks[i].LastUpdated = time.Now()
+
+		// Write the new price back in the background using a background context
+		go func(st models.Stock) {
+			if err := s.repo.UpdateStockPrice(context.Background(), &st); err != nil {
+				log.Printf("Failed to update stock price in DB: %v", err)
+			}
+		}(stocks[i])
+	}
+}
+
+// RemoveStock removes a stock and its triggers from a user's portfolio.
+// Removing a stock that is already gone is not an error.
+func (s *Service) RemoveStock(ctx context.Context, userID, stockID string) error {
+	removed, err := s.repo.RemoveStock(ctx, userID, stockID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Release the holding's reference and those of its triggers
+	key := tracking.Key{Symbol: removed.Symbol, Exchange: removed.Exchange}
+	if err := s.symbols.Add(ctx, key, -tracking.StockRefs(*removed)); err != nil {
+		log.Printf("Failed to untrack %s: %v", key, err)
+	}
+
+	// Every instance drops the removed triggers from its trigger index
+	for _, triggerID := range removed.Triggers {
+		err := s.bus.Publish(ctx, events.TriggerChanged{
+			TriggerID: triggerID,
+			Symbol:    removed.Symbol,
+			Exchange:  removed.Exchange,
+			Deleted:   true,
+			At:        time.Now(),
+		})
+		if err != nil {
+			log.Printf("Failed to publish removal of trigger %s: %v", triggerID, err)
+		}
+	}
+	return nil
+}
+
+// GetAllUniqueStocks returns one stock for every symbol held by any user
+func (s *Service) GetAllUniqueStocks(ctx context.Context) ([]models.Stock, error) {
+	return s.repo.GetAllUniqueStocks(ctx)
+}
@@ -243,3 +243,53 @@ func FetchStockDetails(symbol string) (*StockDetails, error) {
 
 	return details, nil
 }
+
+// FetchEarnings fetches the dates a company reports earnings on from one
+// date to another, both included
+func FetchEarnings(symbol string, from, to time.Time) ([]time.Time, error) {
+	apiKey := os.Getenv("TWELVEDATA_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("TWELVEDATA_API_KEY not set")
+	}
+
+	url := fmt.Sprintf("https://api.twelvedata.com/earnings?symbol=%s&start_date=%s&end_date=%s&apikey=%s",
+		symbol, from.Format("2006-01-02"), to.Format("2006-01-02"), apiKey)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("API error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %v", err)
+	}
+
+	var result struct {
+		Earnings []struct {
+			Date string `json:"date"`
+		} `json:"earnings"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal API response: %v", err)
+	}
+	if result.Status == "error" {
+		return nil, fmt.Errorf("API returned error: %s", result.Message)
+	}
+
+	var dates []time.Time
+	for _, e := range result.Earnings {
+		date, err := time.Parse("2006-01-02", e.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid earnings date %q: %v", e.Date, err)
+		}
+		dates = append(dates, date)
+	}
+	return dates, nil
+}
@@ -2,47 +2,72 @@ package triggers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
 	"time"
 
 	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/events"
 	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/tracking"
 	ws "stockmarket/server/internal/websocket"
+
+	"github.com/google/uuid"
 )
 
 // Service handles all trigger-related operations
 type Service struct {
-	db         *database.Database
+	db         database.Store
 	ws         *ws.MarketWebSocket
+	symbols    tracking.Registry
+	bus        *events.Bus
+	index      *ThresholdIndex
 	priceCache map[string]float64 // symbol:exchange -> price
 	mu         sync.RWMutex
 }
 
 // NewService creates a new trigger service
-func NewService(db *database.Database, ws *ws.MarketWebSocket) *Service {
+func NewService(db database.Store, ws *ws.MarketWebSocket, symbols tracking.Registry, bus *events.Bus) *Service {
 	return &Service{
 		db:         db,
 		ws:         ws,
+		symbols:    symbols,
+		bus:        bus,
+		index:      NewThresholdIndex(),
 		priceCache: make(map[string]float64),
 	}
 }
 
 // CreateTrigger creates a new trigger
 func (s *Service) CreateTrigger(ctx context.Context, trigger *models.StockTrigger) error {
-	// Create the trigger
-	if err := s.db.CreateTrigger(ctx, trigger); err != nil {
+	stock, err := s.db.GetStock(ctx, trigger.UserID, trigger.StockID)
+	if err != nil {
 		return err
 	}
 
-	// Update the stock's triggers list
-	stock, err := s.db.GetStock(ctx, trigger.StockID)
-	if err != nil {
+	// Copy the symbol onto the trigger so it can be found by symbol
+	trigger.Symbol = stock.Symbol
+	trigger.Exchange = stock.Exchange
+
+	// Create the trigger and link it to the stock atomically
+	if err := s.db.CreateTrigger(ctx, trigger); err != nil {
 		return err
 	}
 
-	stock.Triggers = append(stock.Triggers, trigger.TriggerID)
-	return s.db.UpdateStock(ctx, stock)
+	// Every trigger keeps its symbol tracked
+	tracked := tracking.Key{Symbol: stock.Symbol, Exchange: stock.Exchange}
+	if err := s.symbols.Add(ctx, tracked, 1); err != nil {
+		log.Printf("Failed to track %s: %v", tracked, err)
+	}
+
+	// Symbols that have not been loaded yet pick the trigger up on their next tick
+	key := IndexKey(stock.Symbol, stock.Exchange)
+	if s.index.Loaded(key) {
+		s.index.Add(key, trigger)
+	}
+	s.publishChanged(ctx, trigger, false)
+	return nil
 }
 
 // GetUserTriggers gets all triggers for a user
@@ -50,68 +75,259 @@ func (s *Service) GetUserTriggers(ctx context.Context, userID string) ([]*models
 	return s.db.GetTriggersByUser(ctx, userID)
 }
 
+// ListUserTriggers gets one page of a user's triggers and the cursor of the
+// next page
+func (s *Service) ListUserTriggers(ctx context.Context, userID string, page database.PageOptions) ([]*models.StockTrigger, string, error) {
+	return s.db.ListTriggersByUser(ctx, userID, page)
+}
+
 // UpdatePrice updates the current price and evaluates triggers
 func (s *Service) UpdatePrice(ctx context.Context, symbol, exchange string, price float64) error {
+	return s.UpdatePriceFrom(ctx, symbol, exchange, 0, price)
+}
+
+// UpdatePriceFrom evaluates triggers for a move from prevPrice to price.
+// prevPrice is the price the poller fetched before, which may have been
+// evaluated by another instance; if it is 0 the last price this instance
+// saw is used.
+func (s *Service) UpdatePriceFrom(ctx context.Context, symbol, exchange string, prevPrice, price float64) error {
 	// Update price cache
-	key := symbol + ":" + exchange
+	key := IndexKey(symbol, exchange)
 	s.mu.Lock()
+	lastPrice, seen := s.priceCache[key]
 	s.priceCache[key] = price
 	s.mu.Unlock()
+	if prevPrice > 0 {
+		lastPrice, seen = prevPrice, true
+	}
 
 	// Only evaluate triggers if market is open
 	if !s.ws.IsMarketOpen(exchange) {
 		return nil
 	}
 
-	// Get all triggers for this symbol
-	triggers, err := s.db.GetTriggersBySymbol(ctx, symbol, exchange)
-	if err != nil {
-		return err
+	// Load the symbol's triggers into the index the first time it is seen
+	if !s.index.Loaded(key) {
+		triggers, err := s.db.GetTriggersBySymbol(ctx, symbol, exchange)
+		if err != nil {
+			return err
+		}
+		s.index.Load(key, triggers)
+	}
+
+	// Only the price levels crossed since the last tick need evaluating.
+	// Without a previous price every trigger is evaluated at its level.
+	move := priceMove{key: key, symbol: symbol, exchange: exchange, from: lastPrice, to: price, seen: seen}
+	var candidates []*models.StockTrigger
+	if seen {
+		candidates = append(s.index.Crossed(key, lastPrice, price), s.index.Others(key)...)
+	} else {
+		candidates = s.index.All(key)
 	}
 
 	// Evaluate each trigger
-	for _, trigger := range triggers {
+	for _, trigger := range candidates {
+		s.fireTrigger(ctx, move, trigger)
+	}
+
+	return nil
+}
+
+// maxFireAttempts bounds how many times a trigger that keeps being edited
+// while it fires is reloaded and evaluated again
+const maxFireAttempts = 3
+
+// priceMove is a price change triggers are evaluated against
+type priceMove struct {
+	key, symbol, exchange string
+	from, to              float64
+	seen                  bool // Whether from is known
+}
+
+// crosses reports whether the move is one trigger must be evaluated
+// against, as ThresholdIndex.Crossed would select it. Triggers that are not
+// price levels are evaluated on every move, and without a previous price
+// every trigger is evaluated at its level.
+func (m priceMove) crosses(trigger *models.StockTrigger) bool {
+	if !m.seen {
+		return true
+	}
+	switch TriggerType(trigger.Type) {
+	case PriceUpperLimit:
+		return m.from < trigger.PriceThreshold && trigger.PriceThreshold <= m.to
+	case PriceLowerLimit:
+		return m.to <= trigger.PriceThreshold && trigger.PriceThreshold < m.from
+	}
+	return true
+}
+
+// fireTrigger evaluates a trigger against a move and, if it is set off,
+// stores the fire and announces it. The indexed trigger is only replaced
+// once the fire is stored.
+func (s *Service) fireTrigger(ctx context.Context, move priceMove, trigger *models.StockTrigger) {
+	for attempt := 1; ; attempt++ {
 		if !trigger.IsActive {
-			continue
+			return
 		}
 
 		// Check cooldown period
 		if time.Since(trigger.LastTrigger).Minutes() < float64(trigger.CooldownMinutes) {
-			continue
+			return
+		}
+
+		// Evaluate trigger conditions
+		evaluation := s.evaluateTrigger(trigger, move.symbol, move.exchange, move.to)
+		if move.seen {
+			evaluation.PrevPrice = move.from
+		}
+		if !evaluation.Triggered {
+			return
+		}
+
+		// Update last trigger time on a copy, storing the fire with it for
+		// the notification service to deliver
+		fired := *trigger
+		fired.LastTrigger = time.Now()
+		fire := newFire(evaluation)
+		err := s.db.FireTrigger(ctx, &fired, fire)
+		if err == nil {
+			s.SyncTrigger(nil, &fired)
+			s.notifyTrigger(ctx, fire)
+			s.publishChanged(ctx, &fired, false)
+			return
+		}
+		if !errors.Is(err, database.ErrConflict) || attempt == maxFireAttempts {
+			log.Printf("Error firing trigger %s: %v", trigger.TriggerID, err)
+			return
+		}
+
+		// The trigger was edited since it was indexed; evaluate its new
+		// version against the same move
+		trigger = s.reloadTrigger(ctx, move.key, trigger.TriggerID)
+		if trigger == nil || !move.crosses(trigger) {
+			return
 		}
+	}
+}
 
-		// Get the associated stock to get the exchange
-		stock, err := s.db.GetStock(ctx, trigger.StockID)
+// ThresholdDistance returns how far price is, as a fraction of price, from
+// the closest active price-level trigger on a symbol. ok is false if the
+// symbol has none.
+func (s *Service) ThresholdDistance(ctx context.Context, symbol, exchange string, price float64) (float64, bool, error) {
+	key := IndexKey(symbol, exchange)
+	if !s.index.Loaded(key) {
+		triggers, err := s.db.GetTriggersBySymbol(ctx, symbol, exchange)
 		if err != nil {
-			log.Printf("Error getting stock for trigger %s: %v", trigger.TriggerID, err)
+			return 0, false, err
+		}
+		s.index.Load(key, triggers)
+	}
+
+	distance, ok := s.index.Nearest(key, price)
+	if !ok || price <= 0 {
+		return 0, false, nil
+	}
+	return distance / price, true, nil
+}
+
+// Reshard brings the index in line with a new share of the symbols when
+// instances join or leave. Triggers of released symbols are dropped, with
+// their last prices, and those of newly owned symbols are read afresh.
+func (s *Service) Reshard(ctx context.Context, owns func(key string) bool) error {
+	for _, key := range s.index.Keys() {
+		if !owns(key) {
+			s.index.Unload(key)
+			s.mu.Lock()
+			delete(s.priceCache, key)
+			s.mu.Unlock()
+		}
+	}
+
+	entries, err := s.symbols.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key := IndexKey(entry.Symbol, entry.Exchange)
+		if !owns(key) || s.index.Loaded(key) {
 			continue
 		}
+		triggers, err := s.db.GetTriggersBySymbol(ctx, entry.Symbol, entry.Exchange)
+		if err != nil {
+			return err
+		}
+		s.index.Load(key, triggers)
+	}
+	return nil
+}
 
-		// Evaluate trigger conditions
-		evaluation := s.evaluateTrigger(trigger, stock, price)
-		if evaluation.Triggered {
-			// Update last trigger time
-			trigger.LastTrigger = time.Now()
-			if err := s.db.UpdateTrigger(ctx, trigger); err != nil {
-				log.Printf("Error updating trigger: %v", err)
-				continue
-			}
+// reloadTrigger replaces an indexed trigger with its stored version and
+// returns it, or nil if it can't be read
+func (s *Service) reloadTrigger(ctx context.Context, key, triggerID string) *models.StockTrigger {
+	trigger, err := s.db.GetTrigger(ctx, triggerID)
+	if err != nil {
+		log.Printf("Error reloading trigger %s: %v", triggerID, err)
+		s.index.Remove(triggerID)
+		return nil
+	}
+	s.index.Add(key, trigger)
+	return trigger
+}
 
-			// Send notification
-			s.notifyTrigger(evaluation)
+// SyncTrigger applies a trigger write made elsewhere, e.g. by another
+// instance, to the index. old is nil for a new trigger and new is nil for a
+// deleted one. Stale versions are ignored, so replaying a change is safe.
+func (s *Service) SyncTrigger(old, new *models.StockTrigger) {
+	if new == nil {
+		if old != nil {
+			s.index.Remove(old.TriggerID)
 		}
+		return
+	}
+
+	if indexed := s.index.Get(new.TriggerID); indexed != nil && indexed.Version > new.Version {
+		return
+	}
+
+	// Symbols that have not been loaded yet read the trigger on their next tick
+	key := IndexKey(new.Symbol, new.Exchange)
+	if s.index.Loaded(key) {
+		s.index.Add(key, new)
+	}
+}
+
+// ApplyTriggerChange brings the index up to date with a trigger write
+// announced on the bus. The stored trigger is read rather than trusted from
+// the event, so changes arriving out of order settle on the latest version.
+func (s *Service) ApplyTriggerChange(ctx context.Context, e events.TriggerChanged) error {
+	if e.Deleted {
+		s.index.Remove(e.TriggerID)
+		return nil
 	}
 
+	// Symbols that have not been loaded yet read the trigger on their next tick
+	if !s.index.Loaded(IndexKey(e.Symbol, e.Exchange)) {
+		return nil
+	}
+	trigger, err := s.db.GetTrigger(ctx, e.TriggerID)
+	if errors.Is(err, database.ErrNotFound) {
+		s.index.Remove(e.TriggerID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.SyncTrigger(nil, trigger)
 	return nil
 }
 
 // evaluateTrigger evaluates a single trigger against current price
-func (s *Service) evaluateTrigger(trigger *models.StockTrigger, stock *models.Stock, price float64) TriggerEvaluation {
+func (s *Service) evaluateTrigger(trigger *models.StockTrigger, symbol, exchange string, price float64) TriggerEvaluation {
 	evaluation := TriggerEvaluation{
 		TriggerID:    trigger.TriggerID,
 		UserID:       trigger.UserID,
-		Symbol:       stock.Symbol,
-		Exchange:     stock.Exchange,
+		Symbol:       symbol,
+		Exchange:     exchange,
 		CurrentPrice: price,
 		Timestamp:    time.Now(),
 	}
@@ -138,44 +354,76 @@ func (s *Service) evaluateTrigger(trigger *models.StockTrigger, stock *models.St
 	return evaluation
 }
 
-// notifyTrigger sends notifications for triggered alerts
-func (s *Service) notifyTrigger(evaluation TriggerEvaluation) {
-	// Send websocket notification
-	if err := s.ws.SendMessage(evaluation.UserID, evaluation); err != nil {
-		log.Printf("Error sending websocket notification: %v", err)
+// newFire records a trigger firing as pending delivery
+func newFire(evaluation TriggerEvaluation) *models.TriggerFire {
+	return &models.TriggerFire{
+		FireID:        uuid.New().String(),
+		TriggerID:     evaluation.TriggerID,
+		UserID:        evaluation.UserID,
+		Symbol:        evaluation.Symbol,
+		Exchange:      evaluation.Exchange,
+		Price:         evaluation.CurrentPrice,
+		PrevPrice:     evaluation.PrevPrice,
+		Message:       evaluation.Message,
+		FiredAt:       evaluation.Timestamp,
+		Outbox:        models.OutboxPending,
+		NextAttemptAt: evaluation.Timestamp,
 	}
+}
 
-	// TODO: Send email notification
-	// This would be implemented in a separate email service
+// notifyTrigger announces a stored fire so the notification service
+// delivers it straight away. The fire is in the outbox already, so if the
+// announcement is lost it is still delivered when the outbox is next
+// polled.
+func (s *Service) notifyTrigger(ctx context.Context, fire *models.TriggerFire) {
+	err := s.bus.Publish(ctx, events.TriggerFired{
+		FireID:    fire.FireID,
+		TriggerID: fire.TriggerID,
+		UserID:    fire.UserID,
+		Symbol:    fire.Symbol,
+		Exchange:  fire.Exchange,
+		Price:     fire.Price,
+		PrevPrice: fire.PrevPrice,
+		Message:   fire.Message,
+		At:        fire.FiredAt,
+	})
+	if err != nil {
+		log.Printf("Error publishing trigger %s: %v", fire.TriggerID, err)
+	}
 }
 
 // DeleteTrigger deletes a trigger
 func (s *Service) DeleteTrigger(ctx context.Context, triggerID string) error {
-	// Get the trigger to find its stock
 	trigger, err := s.db.GetTrigger(ctx, triggerID)
 	if err != nil {
 		return err
 	}
 
-	// Remove trigger from stock's triggers list
-	stock, err := s.db.GetStock(ctx, trigger.StockID)
-	if err != nil {
+	// Delete the trigger and unlink it from its stock atomically. Only the
+	// caller whose delete succeeds releases the symbol reference.
+	if err := s.db.DeleteTrigger(ctx, triggerID); err != nil {
 		return err
 	}
 
-	// Remove trigger ID from stock's triggers
-	for i, id := range stock.Triggers {
-		if id == triggerID {
-			stock.Triggers = append(stock.Triggers[:i], stock.Triggers[i+1:]...)
-			break
-		}
+	s.index.Remove(triggerID)
+	s.publishChanged(ctx, trigger, true)
+	tracked := tracking.Key{Symbol: trigger.Symbol, Exchange: trigger.Exchange}
+	if err := s.symbols.Add(ctx, tracked, -1); err != nil {
+		log.Printf("Failed to untrack %s: %v", tracked, err)
 	}
+	return nil
+}
 
-	// Update stock
-	if err := s.db.UpdateStock(ctx, stock); err != nil {
-		return err
+// publishChanged tells the other instances to pick up a trigger write
+func (s *Service) publishChanged(ctx context.Context, trigger *models.StockTrigger, deleted bool) {
+	err := s.bus.Publish(ctx, events.TriggerChanged{
+		TriggerID: trigger.TriggerID,
+		Symbol:    trigger.Symbol,
+		Exchange:  trigger.Exchange,
+		Deleted:   deleted,
+		At:        time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error publishing change to trigger %s: %v", trigger.TriggerID, err)
 	}
-
-	// Delete the trigger
-	return s.db.DeleteTrigger(ctx, triggerID)
 }
@@ -5,24 +5,23 @@ import (
 	"testing"
 	"time"
 
-	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/marketcalendar"
 	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/tracking"
 	ws "stockmarket/server/internal/websocket"
-
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
-// mockDynamoDBClient is a mock implementation of the DynamoDB client
-type mockDynamoDBClient struct {
-	dynamodb.Client
-}
-
 func TestTriggerSystem(t *testing.T) {
-	// Initialize services with mock client
-	mockClient := &mockDynamoDBClient{}
-	db := database.NewDatabase(mockClient)
-	ws := ws.NewMarketWebSocket()
-	service := NewService(db, ws)
+	// Initialize services with an in-memory store
+	db := memory.NewStore()
+	calendar, err := marketcalendar.Default()
+	if err != nil {
+		t.Fatalf("Failed to load market calendar: %v", err)
+	}
+	ws := ws.NewMarketWebSocket(calendar)
+	service := NewService(db, ws, tracking.NewMemoryRegistry(), events.NewBus(events.NewMemoryTransport()))
 
 	ctx := context.Background()
 
@@ -31,6 +30,7 @@ func TestTriggerSystem(t *testing.T) {
 		// Create a test stock
 		stock := &models.Stock{
 			StockID:   "test-stock-1",
+			UserID:    "test-user-1",
 			Symbol:    "AAPL",
 			Exchange:  "NASDAQ",
 			Triggers:  []string{},
@@ -91,6 +91,7 @@ func TestTriggerSystem(t *testing.T) {
 		// Create a test stock
 		stock := &models.Stock{
 			StockID:   "test-stock-2",
+			UserID:    "test-user-2",
 			Symbol:    "GOOGL",
 			Exchange:  "NASDAQ",
 			Triggers:  []string{},
@@ -156,6 +157,7 @@ func TestTriggerSystem(t *testing.T) {
 		// Create a test stock
 		stock := &models.Stock{
 			StockID:   "test-stock-3",
+			UserID:    "test-user-3",
 			Symbol:    "MSFT",
 			Exchange:  "NASDAQ",
 			Triggers:  []string{},
@@ -195,3 +197,67 @@ func TestTriggerSystem(t *testing.T) {
 		t.Log("Trigger successfully deleted")
 	})
 }
+
+func TestFireTriggerReevaluatesEditedTrigger(t *testing.T) {
+	ctx := context.Background()
+	key := IndexKey("AAPL", "NASDAQ")
+	move := priceMove{key: key, symbol: "AAPL", exchange: "NASDAQ", from: 155, to: 165, seen: true}
+
+	for _, tc := range []struct {
+		name      string
+		threshold float64 // After the edit
+		wantFires int
+	}{
+		{"still crossed", 158, 1},
+		{"no longer crossed", 170, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := memory.NewStore()
+			stock := &models.Stock{StockID: "stock-1", UserID: "user-1", Symbol: "AAPL", Exchange: "NASDAQ", Triggers: []string{}}
+			if err := db.CreateStock(ctx, stock); err != nil {
+				t.Fatal(err)
+			}
+			trigger := &models.StockTrigger{
+				StockID: stock.StockID, UserID: "user-1", Symbol: "AAPL", Exchange: "NASDAQ",
+				Type: string(PriceUpperLimit), IsActive: true, PriceThreshold: 160,
+			}
+			if err := db.CreateTrigger(ctx, trigger); err != nil {
+				t.Fatal(err)
+			}
+
+			service := NewService(db, nil, tracking.NewMemoryRegistry(), events.NewBus(events.NewMemoryTransport()))
+			indexed, err := db.GetTrigger(ctx, trigger.TriggerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			service.index.Load(key, []*models.StockTrigger{indexed})
+
+			// The trigger is edited after it was indexed
+			edited := *indexed
+			edited.PriceThreshold = tc.threshold
+			if err := db.UpdateTrigger(ctx, &edited); err != nil {
+				t.Fatal(err)
+			}
+
+			service.fireTrigger(ctx, move, indexed)
+
+			fires, err := db.GetUserTriggerFires(ctx, "user-1", time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fires) != tc.wantFires {
+				t.Fatalf("got %d fires, want %d", len(fires), tc.wantFires)
+			}
+			if !indexed.LastTrigger.IsZero() {
+				t.Fatalf("the stale trigger was changed: LastTrigger = %v", indexed.LastTrigger)
+			}
+			current := service.index.Get(trigger.TriggerID)
+			if current == nil || current.PriceThreshold != tc.threshold {
+				t.Fatalf("indexed trigger = %+v, want the edited version", current)
+			}
+			if fired := !current.LastTrigger.IsZero(); fired != (tc.wantFires > 0) {
+				t.Fatalf("indexed LastTrigger = %v after %d fires", current.LastTrigger, tc.wantFires)
+			}
+		})
+	}
+}
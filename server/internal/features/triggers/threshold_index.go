@@ -0,0 +1,358 @@
+package triggers
+
+import (
+	"sort"
+	"sync"
+
+	"stockmarket/server/internal/models"
+)
+
+// thresholdEntry is a single price-level trigger in a threshold book
+type thresholdEntry struct {
+	threshold float64
+	trigger   *models.StockTrigger
+}
+
+// thresholdBook holds the triggers for one symbol:exchange key.
+// upper and lower are kept sorted by threshold (ascending) so that the
+// triggers crossed by a price move can be found with two binary searches.
+type thresholdBook struct {
+	upper []thresholdEntry
+	lower []thresholdEntry
+	other map[string]*models.StockTrigger // triggers that are not price levels
+}
+
+// thresholdRef records where a trigger lives in the index
+type thresholdRef struct {
+	key       string
+	threshold float64
+}
+
+// ThresholdIndex is a per-symbol ordered index of price-level triggers.
+// A move from p0 to p1 returns exactly the PRICE_UPPER_LIMIT triggers in
+// (p0, p1] when the price rises, and the PRICE_LOWER_LIMIT triggers in
+// [p1, p0) when it falls, in O(log n + k).
+type ThresholdIndex struct {
+	books map[string]*thresholdBook // symbol:exchange -> book
+	refs  map[string]thresholdRef   // triggerID -> location in the index
+	mu    sync.RWMutex
+}
+
+// NewThresholdIndex creates an empty threshold index
+func NewThresholdIndex() *ThresholdIndex {
+	return &ThresholdIndex{
+		books: make(map[string]*thresholdBook),
+		refs:  make(map[string]thresholdRef),
+	}
+}
+
+// IndexKey returns the key a symbol is indexed under
+func IndexKey(symbol, exchange string) string {
+	return symbol + ":" + exchange
+}
+
+// Loaded reports whether the triggers for key have been loaded into the index
+func (x *ThresholdIndex) Loaded(key string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.books[key]
+	return ok
+}
+
+// Load replaces all triggers indexed under key
+func (x *ThresholdIndex) Load(key string, triggers []*models.StockTrigger) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if old, ok := x.books[key]; ok {
+		for _, e := range old.upper {
+			delete(x.refs, e.trigger.TriggerID)
+		}
+		for _, e := range old.lower {
+			delete(x.refs, e.trigger.TriggerID)
+		}
+		for id := range old.other {
+			delete(x.refs, id)
+		}
+	}
+
+	book := &thresholdBook{other: make(map[string]*models.StockTrigger)}
+	for _, t := range triggers {
+		switch TriggerType(t.Type) {
+		case PriceUpperLimit:
+			book.upper = append(book.upper, thresholdEntry{threshold: t.PriceThreshold, trigger: t})
+		case PriceLowerLimit:
+			book.lower = append(book.lower, thresholdEntry{threshold: t.PriceThreshold, trigger: t})
+		default:
+			book.other[t.TriggerID] = t
+		}
+		x.refs[t.TriggerID] = thresholdRef{key: key, threshold: t.PriceThreshold}
+	}
+	sortEntries(book.upper)
+	sortEntries(book.lower)
+	x.books[key] = book
+}
+
+// Unload drops every trigger indexed under key, so they are read again
+// from the store if the key is next loaded
+func (x *ThresholdIndex) Unload(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	book, ok := x.books[key]
+	if !ok {
+		return
+	}
+	for _, e := range book.upper {
+		delete(x.refs, e.trigger.TriggerID)
+	}
+	for _, e := range book.lower {
+		delete(x.refs, e.trigger.TriggerID)
+	}
+	for id := range book.other {
+		delete(x.refs, id)
+	}
+	delete(x.books, key)
+}
+
+// Keys returns the keys whose triggers are loaded
+func (x *ThresholdIndex) Keys() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	keys := make([]string, 0, len(x.books))
+	for key := range x.books {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Add inserts or replaces a trigger under key
+func (x *ThresholdIndex) Add(key string, trigger *models.StockTrigger) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(trigger.TriggerID)
+
+	book, ok := x.books[key]
+	if !ok {
+		book = &thresholdBook{other: make(map[string]*models.StockTrigger)}
+		x.books[key] = book
+	}
+
+	entry := thresholdEntry{threshold: trigger.PriceThreshold, trigger: trigger}
+	switch TriggerType(trigger.Type) {
+	case PriceUpperLimit:
+		book.upper = insertEntry(book.upper, entry)
+	case PriceLowerLimit:
+		book.lower = insertEntry(book.lower, entry)
+	default:
+		book.other[trigger.TriggerID] = trigger
+	}
+	x.refs[trigger.TriggerID] = thresholdRef{key: key, threshold: trigger.PriceThreshold}
+}
+
+// Get returns the indexed version of a trigger, or nil if it is not indexed
+func (x *ThresholdIndex) Get(triggerID string) *models.StockTrigger {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ref, ok := x.refs[triggerID]
+	if !ok {
+		return nil
+	}
+	book := x.books[ref.key]
+	if t, ok := book.other[triggerID]; ok {
+		return t
+	}
+	for _, entries := range [][]thresholdEntry{book.upper, book.lower} {
+		if i := findEntry(entries, ref.threshold, triggerID); i >= 0 {
+			return entries[i].trigger
+		}
+	}
+	return nil
+}
+
+// Remove deletes a trigger from the index
+func (x *ThresholdIndex) Remove(triggerID string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(triggerID)
+}
+
+// removeLocked deletes a trigger; the caller must hold the write lock
+func (x *ThresholdIndex) removeLocked(triggerID string) {
+	ref, ok := x.refs[triggerID]
+	if !ok {
+		return
+	}
+	delete(x.refs, triggerID)
+
+	book := x.books[ref.key]
+	if _, ok := book.other[triggerID]; ok {
+		delete(book.other, triggerID)
+		return
+	}
+	book.upper = removeEntry(book.upper, ref.threshold, triggerID)
+	book.lower = removeEntry(book.lower, ref.threshold, triggerID)
+}
+
+// Crossed returns the price-level triggers under key crossed by a move from
+// one price to another. Upper limits in (from, to] are returned for a rising
+// price and lower limits in [to, from) for a falling price.
+func (x *ThresholdIndex) Crossed(key string, from, to float64) []*models.StockTrigger {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	book, ok := x.books[key]
+	if !ok || from == to {
+		return nil
+	}
+
+	var crossed []*models.StockTrigger
+	if to > from {
+		// First entry with threshold > from, up to the first entry with threshold > to
+		lo := sort.Search(len(book.upper), func(i int) bool { return book.upper[i].threshold > from })
+		hi := sort.Search(len(book.upper), func(i int) bool { return book.upper[i].threshold > to })
+		for _, e := range book.upper[lo:hi] {
+			crossed = append(crossed, e.trigger)
+		}
+	} else {
+		// First entry with threshold >= to, up to the first entry with threshold >= from
+		lo := sort.Search(len(book.lower), func(i int) bool { return book.lower[i].threshold >= to })
+		hi := sort.Search(len(book.lower), func(i int) bool { return book.lower[i].threshold >= from })
+		for _, e := range book.lower[lo:hi] {
+			crossed = append(crossed, e.trigger)
+		}
+	}
+	return crossed
+}
+
+// Nearest returns how far price is from the closest active price level
+// under key that it could still cross: an upper limit at or above it or a
+// lower limit at or below it. ok is false if there is none.
+func (x *ThresholdIndex) Nearest(key string, price float64) (distance float64, ok bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	book, found := x.books[key]
+	if !found {
+		return 0, false
+	}
+
+	i := sort.Search(len(book.upper), func(i int) bool { return book.upper[i].threshold >= price })
+	for ; i < len(book.upper); i++ {
+		if book.upper[i].trigger.IsActive {
+			distance, ok = book.upper[i].threshold-price, true
+			break
+		}
+	}
+
+	j := sort.Search(len(book.lower), func(i int) bool { return book.lower[i].threshold > price }) - 1
+	for ; j >= 0; j-- {
+		if book.lower[j].trigger.IsActive {
+			if d := price - book.lower[j].threshold; !ok || d < distance {
+				distance, ok = d, true
+			}
+			break
+		}
+	}
+	return distance, ok
+}
+
+// Others returns the triggers under key that are not price levels and so
+// must be evaluated on every tick
+func (x *ThresholdIndex) Others(key string) []*models.StockTrigger {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	book, ok := x.books[key]
+	if !ok {
+		return nil
+	}
+	others := make([]*models.StockTrigger, 0, len(book.other))
+	for _, t := range book.other {
+		others = append(others, t)
+	}
+	return others
+}
+
+// All returns every trigger indexed under key
+func (x *ThresholdIndex) All(key string) []*models.StockTrigger {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	book, ok := x.books[key]
+	if !ok {
+		return nil
+	}
+	all := make([]*models.StockTrigger, 0, len(book.upper)+len(book.lower)+len(book.other))
+	for _, e := range book.upper {
+		all = append(all, e.trigger)
+	}
+	for _, e := range book.lower {
+		all = append(all, e.trigger)
+	}
+	for _, t := range book.other {
+		all = append(all, t)
+	}
+	return all
+}
+
+// Len returns the number of triggers indexed under key
+func (x *ThresholdIndex) Len(key string) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	book, ok := x.books[key]
+	if !ok {
+		return 0
+	}
+	return len(book.upper) + len(book.lower) + len(book.other)
+}
+
+// sortEntries sorts entries by threshold, breaking ties by trigger ID so the
+// order is deterministic
+func sortEntries(entries []thresholdEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entryLess(entries[i], entries[j])
+	})
+}
+
+// insertEntry inserts e into the sorted slice entries
+func insertEntry(entries []thresholdEntry, e thresholdEntry) []thresholdEntry {
+	i := sort.Search(len(entries), func(i int) bool { return !entryLess(entries[i], e) })
+	entries = append(entries, thresholdEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	return entries
+}
+
+// findEntry returns the index of the entry for triggerID in the sorted
+// slice entries, or -1 if it isn't there
+func findEntry(entries []thresholdEntry, threshold float64, triggerID string) int {
+	i := sort.Search(len(entries), func(i int) bool {
+		e := entries[i]
+		return e.threshold > threshold || (e.threshold == threshold && e.trigger.TriggerID >= triggerID)
+	})
+	if i < len(entries) && entries[i].threshold == threshold && entries[i].trigger.TriggerID == triggerID {
+		return i
+	}
+	return -1
+}
+
+// removeEntry removes the entry for triggerID from the sorted slice entries
+func removeEntry(entries []thresholdEntry, threshold float64, triggerID string) []thresholdEntry {
+	if i := findEntry(entries, threshold, triggerID); i >= 0 {
+		return append(entries[:i], entries[i+1:]...)
+	}
+	return entries
+}
+
+func entryLess(a, b thresholdEntry) bool {
+	if a.threshold != b.threshold {
+		return a.threshold < b.threshold
+	}
+	return a.trigger.TriggerID < b.trigger.TriggerID
+}
@@ -0,0 +1,224 @@
+package triggers
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"stockmarket/server/internal/models"
+)
+
+func newPriceTrigger(id string, triggerType TriggerType, threshold float64) *models.StockTrigger {
+	return &models.StockTrigger{
+		TriggerID:      id,
+		Type:           string(triggerType),
+		IsActive:       true,
+		PriceThreshold: threshold,
+	}
+}
+
+func triggerIDs(triggers []*models.StockTrigger) []string {
+	ids := make([]string, 0, len(triggers))
+	for _, t := range triggers {
+		ids = append(ids, t.TriggerID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestThresholdIndexCrossed(t *testing.T) {
+	key := IndexKey("AAPL", "NASDAQ")
+	index := NewThresholdIndex()
+	index.Load(key, []*models.StockTrigger{
+		newPriceTrigger("up-150", PriceUpperLimit, 150),
+		newPriceTrigger("up-155", PriceUpperLimit, 155),
+		newPriceTrigger("up-160", PriceUpperLimit, 160),
+		newPriceTrigger("down-140", PriceLowerLimit, 140),
+		newPriceTrigger("down-145", PriceLowerLimit, 145),
+		newPriceTrigger("volume", VolumeSpike, 0),
+	})
+
+	tests := []struct {
+		name     string
+		from, to float64
+		want     []string
+	}{
+		{"rise excludes start, includes end", 150, 155, []string{"up-155"}},
+		{"rise across several levels", 149, 170, []string{"up-150", "up-155", "up-160"}},
+		{"rise below all levels", 100, 120, []string{}},
+		{"fall excludes start, includes end", 145, 140, []string{"down-140"}},
+		{"fall across several levels", 150, 100, []string{"down-140", "down-145"}},
+		{"no move", 150, 150, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := triggerIDs(index.Crossed(key, tt.from, tt.to))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("Crossed(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+
+	if others := index.Others(key); len(others) != 1 || others[0].TriggerID != "volume" {
+		t.Fatalf("Others() = %v, want [volume]", triggerIDs(others))
+	}
+}
+
+func TestThresholdIndexAddRemove(t *testing.T) {
+	key := IndexKey("MSFT", "NASDAQ")
+	index := NewThresholdIndex()
+	index.Add(key, newPriceTrigger("a", PriceUpperLimit, 310))
+	index.Add(key, newPriceTrigger("b", PriceUpperLimit, 310))
+	index.Add(key, newPriceTrigger("c", PriceUpperLimit, 305))
+
+	if got := triggerIDs(index.Crossed(key, 300, 310)); fmt.Sprint(got) != "[a b c]" {
+		t.Fatalf("Crossed after Add = %v, want [a b c]", got)
+	}
+
+	index.Remove("a")
+	if got := triggerIDs(index.Crossed(key, 300, 310)); fmt.Sprint(got) != "[b c]" {
+		t.Fatalf("Crossed after Remove = %v, want [b c]", got)
+	}
+
+	// Re-adding an existing trigger moves it to its new threshold
+	index.Add(key, newPriceTrigger("b", PriceUpperLimit, 320))
+	if got := triggerIDs(index.Crossed(key, 300, 310)); fmt.Sprint(got) != "[c]" {
+		t.Fatalf("Crossed after re-Add = %v, want [c]", got)
+	}
+	if index.Len(key) != 2 {
+		t.Fatalf("Len() = %d, want 2", index.Len(key))
+	}
+	if got := index.Get("b"); got == nil || got.PriceThreshold != 320 {
+		t.Fatalf("Get(b) = %v, want the trigger at 320", got)
+	}
+	if got := index.Get("a"); got != nil {
+		t.Fatalf("Get(a) = %v, want nil after Remove", got)
+	}
+}
+
+func TestThresholdIndexUnload(t *testing.T) {
+	msft, aapl := IndexKey("MSFT", "NASDAQ"), IndexKey("AAPL", "NASDAQ")
+	index := NewThresholdIndex()
+	index.Load(msft, []*models.StockTrigger{newPriceTrigger("a", PriceUpperLimit, 310)})
+	index.Load(aapl, []*models.StockTrigger{newPriceTrigger("b", PriceLowerLimit, 150)})
+
+	index.Unload(msft)
+	if index.Loaded(msft) || index.Get("a") != nil {
+		t.Fatalf("MSFT is still loaded after Unload")
+	}
+	if got := index.Keys(); fmt.Sprint(got) != "[AAPL:NASDAQ]" {
+		t.Fatalf("Keys() = %v, want [AAPL:NASDAQ]", got)
+	}
+	if index.Get("b") == nil {
+		t.Fatalf("Unload dropped another key's trigger")
+	}
+}
+
+func TestThresholdIndexNearest(t *testing.T) {
+	key := IndexKey("AAPL", "NASDAQ")
+	index := NewThresholdIndex()
+	inactive := newPriceTrigger("up-151", PriceUpperLimit, 151)
+	inactive.IsActive = false
+	index.Load(key, []*models.StockTrigger{
+		inactive,
+		newPriceTrigger("up-160", PriceUpperLimit, 160),
+		newPriceTrigger("up-140", PriceUpperLimit, 140), // already passed
+		newPriceTrigger("down-147", PriceLowerLimit, 147),
+	})
+
+	tests := []struct {
+		price  float64
+		want   float64
+		wantOK bool
+	}{
+		{150, 3, true},  // down-147 beats up-160; up-151 is inactive
+		{158, 2, true},  // up-160
+		{147, 0, true},  // sitting on down-147
+		{146, 14, true}, // only up-160 is left to cross
+		{170, 23, true}, // down-147
+	}
+	for _, tt := range tests {
+		got, ok := index.Nearest(key, tt.price)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("Nearest(%v) = %v, %v; want %v, %v", tt.price, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if _, ok := index.Nearest(IndexKey("MSFT", "NASDAQ"), 300); ok {
+		t.Error("Nearest() on an unloaded key should report no level")
+	}
+}
+
+// loadBenchmarkIndex fills an index with n upper and n lower limits spread
+// uniformly between 0 and 1000
+func loadBenchmarkIndex(key string, n int) (*ThresholdIndex, []*models.StockTrigger) {
+	r := rand.New(rand.NewSource(1))
+	triggers := make([]*models.StockTrigger, 0, 2*n)
+	for i := 0; i < n; i++ {
+		triggers = append(triggers,
+			newPriceTrigger(fmt.Sprintf("up-%d", i), PriceUpperLimit, r.Float64()*1000),
+			newPriceTrigger(fmt.Sprintf("down-%d", i), PriceLowerLimit, r.Float64()*1000),
+		)
+	}
+	index := NewThresholdIndex()
+	index.Load(key, triggers)
+	return index, triggers
+}
+
+var benchmarkSizes = []int{1_000, 10_000, 100_000}
+
+// BenchmarkThresholdIndexCrossed measures matching a small tick against the index
+func BenchmarkThresholdIndexCrossed(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			key := IndexKey("AAPL", "NASDAQ")
+			index, _ := loadBenchmarkIndex(key, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				from := float64(i % 1000)
+				index.Crossed(key, from, from+0.05)
+			}
+		})
+	}
+}
+
+// BenchmarkLinearScan is the baseline the index replaces: checking every
+// trigger's threshold on every tick
+func BenchmarkLinearScan(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			_, triggers := loadBenchmarkIndex(IndexKey("AAPL", "NASDAQ"), n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				price := float64(i%1000) + 0.05
+				var crossed []*models.StockTrigger
+				for _, t := range triggers {
+					if TriggerType(t.Type) == PriceUpperLimit && price >= t.PriceThreshold {
+						crossed = append(crossed, t)
+					}
+				}
+				_ = crossed
+			}
+		})
+	}
+}
+
+// BenchmarkThresholdIndexAddRemove measures keeping the index up to date as
+// triggers are created and deleted
+func BenchmarkThresholdIndexAddRemove(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			key := IndexKey("AAPL", "NASDAQ")
+			index, _ := loadBenchmarkIndex(key, n)
+			r := rand.New(rand.NewSource(2))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				t := newPriceTrigger("bench", PriceUpperLimit, r.Float64()*1000)
+				index.Add(key, t)
+				index.Remove(t.TriggerID)
+			}
+		})
+	}
+}
@@ -26,6 +26,7 @@ type TriggerEvaluation struct {
 	Exchange     string    `json:"exchange"`
 	Triggered    bool      `json:"triggered"`
 	CurrentPrice float64   `json:"current_price"`
+	PrevPrice    float64   `json:"previous_price,omitempty"` // Price on the tick before, if known
 	Timestamp    time.Time `json:"timestamp"`
 	Message      string    `json:"message"`
 }
@@ -0,0 +1,167 @@
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Role is an instance's part in the election
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
+// Status describes an instance's role for the status endpoint
+type Status struct {
+	Instance string    `json:"instance"`
+	Role     Role      `json:"role"`
+	Leader   string    `json:"leader,omitempty"` // Who holds the lease, if known
+	Since    time.Time `json:"since"`            // When the instance took its role
+}
+
+// Elector campaigns for a lease on behalf of one instance and runs the
+// leader's work while it holds it
+type Elector struct {
+	lease Lease
+	id    string
+
+	TTL           time.Duration // How long the lease lasts without renewal
+	RetryInterval time.Duration // Wait between attempts to take the lease
+
+	role  Role
+	since time.Time
+	mu    sync.RWMutex
+}
+
+// NewElector creates an elector for the instance id. With the defaults a
+// dead leader is replaced within about 20 seconds.
+func NewElector(lease Lease, id string) *Elector {
+	return &Elector{
+		lease:         lease,
+		id:            id,
+		TTL:           15 * time.Second,
+		RetryInterval: 5 * time.Second,
+		role:          RoleFollower,
+		since:         time.Now(),
+	}
+}
+
+// IsLeader reports whether this instance currently leads
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.role == RoleLeader
+}
+
+// Status reports this instance's role and the current leader
+func (e *Elector) Status(ctx context.Context) Status {
+	e.mu.RLock()
+	status := Status{Instance: e.id, Role: e.role, Since: e.since}
+	e.mu.RUnlock()
+
+	if status.Role == RoleLeader {
+		status.Leader = e.id
+		return status
+	}
+	holder, err := e.lease.Holder(ctx)
+	if err != nil {
+		log.Printf("Failed to look up leader: %v", err)
+	}
+	status.Leader = holder
+	return status
+}
+
+// Run campaigns until ctx is cancelled. Each time the instance is elected
+// it calls lead with a context that is cancelled when leadership is lost,
+// and waits for lead to return before campaigning again. On shutdown the
+// lease is released so another instance takes over straight away.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	for {
+		acquired, err := e.lease.Acquire(ctx, e.id, e.TTL)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to campaign for leadership: %v", err)
+		}
+		if acquired {
+			e.lead(ctx, lead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.RetryInterval):
+		}
+	}
+}
+
+// lead runs the leader's work, renewing the lease until it is lost or ctx
+// is cancelled
+func (e *Elector) lead(ctx context.Context, lead func(ctx context.Context)) {
+	e.setRole(RoleLeader)
+	log.Printf("Instance %s is now the leader", e.id)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+
+		// Let a successor in without waiting for the lease to expire
+		releaseCtx, cancelRelease := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelRelease()
+		if err := e.lease.Release(releaseCtx, e.id); err != nil {
+			log.Printf("Failed to release leadership: %v", err)
+		}
+
+		e.setRole(RoleFollower)
+		log.Printf("Instance %s stepped down", e.id)
+	}()
+
+	// Renew three times per TTL so one failed renewal doesn't lose the lease
+	renewEvery := e.TTL / 3
+	ticker := time.NewTicker(renewEvery)
+	defer ticker.Stop()
+	renewed := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := e.lease.Renew(ctx, e.id, e.TTL)
+		if err == nil && ok {
+			renewed = time.Now()
+			continue
+		}
+		if err == nil {
+			log.Printf("Instance %s lost its lease", e.id)
+			return
+		}
+
+		// Stop before the lease could expire under us and let someone else
+		// lead at the same time
+		log.Printf("Failed to renew leadership: %v", err)
+		if time.Since(renewed)+renewEvery >= e.TTL {
+			return
+		}
+	}
+}
+
+func (e *Elector) setRole(role Role) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.role = role
+	e.since = time.Now()
+}
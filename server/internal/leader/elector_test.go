@@ -0,0 +1,199 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryLease(t *testing.T) {
+	testLease(t, NewMemoryLease())
+}
+
+// TestRedisLease runs against the Redis server at REDIS_TEST_ADDR
+func TestRedisLease(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	key := fmt.Sprintf("leader_test:%d", time.Now().UnixNano())
+	defer client.Del(context.Background(), key)
+	testLease(t, NewRedisLease(client, key))
+}
+
+func testLease(t *testing.T, lease Lease) {
+	ctx := context.Background()
+	ttl := 100 * time.Millisecond
+
+	expect := func(what string, got bool, err error, want bool) {
+		t.Helper()
+		if err != nil || got != want {
+			t.Fatalf("%s = %v, %v; want %v", what, got, err, want)
+		}
+	}
+	expectHolder := func(want string) {
+		t.Helper()
+		if got, err := lease.Holder(ctx); err != nil || got != want {
+			t.Fatalf("Holder() = %q, %v; want %q", got, err, want)
+		}
+	}
+
+	ok, err := lease.Acquire(ctx, "a", ttl)
+	expect("a acquires", ok, err, true)
+	ok, err = lease.Acquire(ctx, "b", ttl)
+	expect("b acquires a held lease", ok, err, false)
+	ok, err = lease.Renew(ctx, "a", ttl)
+	expect("a renews", ok, err, true)
+	ok, err = lease.Renew(ctx, "b", ttl)
+	expect("b renews a's lease", ok, err, false)
+	expectHolder("a")
+
+	// Only the holder can release
+	if err := lease.Release(ctx, "b"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	expectHolder("a")
+	if err := lease.Release(ctx, "a"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	expectHolder("")
+	ok, err = lease.Acquire(ctx, "b", ttl)
+	expect("b acquires a released lease", ok, err, true)
+
+	// An expired lease is free for anyone
+	time.Sleep(ttl + 50*time.Millisecond)
+	expectHolder("")
+	ok, err = lease.Renew(ctx, "b", ttl)
+	expect("b renews an expired lease", ok, err, false)
+	ok, err = lease.Acquire(ctx, "a", ttl)
+	expect("a acquires an expired lease", ok, err, true)
+}
+
+// newTestElector creates an elector with intervals short enough for tests
+func newTestElector(lease Lease, id string) *Elector {
+	e := NewElector(lease, id)
+	e.TTL = 90 * time.Millisecond
+	e.RetryInterval = 10 * time.Millisecond
+	return e
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestElectorRunsOneLeaderAndFailsOver(t *testing.T) {
+	lease := NewMemoryLease()
+
+	var leading, maxLeading atomic.Int32
+	lead := func(ctx context.Context) {
+		n := leading.Add(1)
+		for {
+			m := maxLeading.Load()
+			if n <= m || maxLeading.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-ctx.Done()
+		leading.Add(-1)
+	}
+
+	electors := make([]*Elector, 3)
+	cancels := make([]context.CancelFunc, 3)
+	var wg sync.WaitGroup
+	for i := range electors {
+		electors[i] = newTestElector(lease, fmt.Sprintf("instance-%d", i))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			electors[i].Run(ctx, lead)
+		}()
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		wg.Wait()
+	}()
+
+	current := func() int {
+		for i, e := range electors {
+			if e.IsLeader() {
+				return i
+			}
+		}
+		return -1
+	}
+	waitFor(t, "a leader", func() bool { return current() >= 0 })
+
+	// Leadership holds across several renewals
+	first := current()
+	time.Sleep(300 * time.Millisecond)
+	if got := current(); got != first {
+		t.Fatalf("leader changed from %d to %d without a failure", first, got)
+	}
+
+	// Stopping the leader hands over to another instance
+	cancels[first]()
+	waitFor(t, "a new leader", func() bool {
+		next := current()
+		return next >= 0 && next != first
+	})
+
+	status := electors[first].Status(context.Background())
+	if status.Role != RoleFollower || status.Leader == "" || status.Leader == status.Instance {
+		t.Fatalf("old leader's status = %+v", status)
+	}
+	if got := maxLeading.Load(); got != 1 {
+		t.Fatalf("%d instances led at once, want 1", got)
+	}
+}
+
+func TestElectorStepsDownWhenLeaseIsLost(t *testing.T) {
+	lease := NewMemoryLease()
+	elector := newTestElector(lease, "a")
+	// Keep a from winning the lease straight back
+	elector.RetryInterval = time.Hour
+
+	stopped := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go elector.Run(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	waitFor(t, "a to lead", elector.IsLeader)
+
+	// Another instance takes the lease, e.g. after a long pause in a
+	lease.Release(context.Background(), "a")
+	lease.Acquire(context.Background(), "b", time.Minute)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("leader's work was not cancelled")
+	}
+	waitFor(t, "a to step down", func() bool { return !elector.IsLeader() })
+
+	status := elector.Status(context.Background())
+	if status.Role != RoleFollower || status.Leader != "b" {
+		t.Fatalf("status = %+v, want follower of b", status)
+	}
+}
@@ -0,0 +1,85 @@
+// Package leader elects one instance among the replicas of the server to do
+// work that must not be duplicated, such as polling prices. The leader holds
+// a lease with a short TTL and renews it while it runs. If it dies, the lease
+// expires and another instance takes over.
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lease is a named lock that expires unless its holder renews it
+type Lease interface {
+	// Acquire takes the lease for holder if it is free or has expired
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Renew extends the lease, failing if holder no longer has it
+	Renew(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives the lease up early if holder still has it
+	Release(ctx context.Context, holder string) error
+	// Holder returns who holds the lease, or "" if nobody does
+	Holder(ctx context.Context) (string, error)
+}
+
+// MemoryLease is a Lease within one process, for single-instance runs and
+// tests
+type MemoryLease struct {
+	holder  string
+	expires time.Time
+	mu      sync.Mutex
+}
+
+var _ Lease = (*MemoryLease)(nil)
+
+// NewMemoryLease creates a free lease
+func NewMemoryLease() *MemoryLease {
+	return &MemoryLease{}
+}
+
+// Acquire takes the lease if it is free, expired or already held by holder
+func (l *MemoryLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != "" && l.holder != holder && time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.holder = holder
+	l.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+// Renew extends the lease if holder still has it
+func (l *MemoryLease) Renew(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != holder || !time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+// Release frees the lease if holder has it
+func (l *MemoryLease) Release(ctx context.Context, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder == holder {
+		l.holder = ""
+	}
+	return nil
+}
+
+// Holder returns the current holder
+func (l *MemoryLease) Holder(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !time.Now().Before(l.expires) {
+		return "", nil
+	}
+	return l.holder, nil
+}
@@ -0,0 +1,86 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisKey is the key of the price poller's lease
+const DefaultRedisKey = "leader:poller"
+
+// renewScript extends the lease only if it is still held by the caller, so
+// a leader that stalled past its TTL can't extend a successor's lease
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is still held by the caller
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisLease is a Lease shared by every instance, stored as a key holding
+// the holder's name and expiring with the lease
+type RedisLease struct {
+	client *redis.Client
+	key    string
+}
+
+var _ Lease = (*RedisLease)(nil)
+
+// NewRedisLease creates a lease stored at key
+func NewRedisLease(client *redis.Client, key string) *RedisLease {
+	return &RedisLease{client: client, key: key}
+}
+
+// Acquire takes the lease if nobody holds it, or renews it if holder does
+func (l *RedisLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	ok, err := l.client.SetNX(ctx, l.key, holder, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %v", l.key, err)
+	}
+	if ok {
+		return true, nil
+	}
+	// A restarted holder picks its own lease back up
+	return l.Renew(ctx, holder, ttl)
+}
+
+// Renew extends the lease if holder still has it
+func (l *RedisLease) Renew(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	n, err := renewScript.Run(ctx, l.client, []string{l.key}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lease %s: %v", l.key, err)
+	}
+	return n == 1, nil
+}
+
+// Release frees the lease if holder has it
+func (l *RedisLease) Release(ctx context.Context, holder string) error {
+	if err := releaseScript.Run(ctx, l.client, []string{l.key}, holder).Err(); err != nil {
+		return fmt.Errorf("failed to release lease %s: %v", l.key, err)
+	}
+	return nil
+}
+
+// Holder returns the current holder
+func (l *RedisLease) Holder(ctx context.Context) (string, error) {
+	holder, err := l.client.Get(ctx, l.key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read lease %s: %v", l.key, err)
+	}
+	return holder, nil
+}
@@ -0,0 +1,266 @@
+// Package marketcalendar knows when exchanges trade. Exchange hours and
+// holiday and early close calendars are loaded from data files, so a new
+// year's holidays or a new exchange are a data change rather than a code
+// change. The files for the exchanges users hold stocks on are embedded.
+package marketcalendar
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed data
+var data embed.FS
+
+// ErrUnknownExchange is returned for an exchange the calendar has no
+// definition for
+var ErrUnknownExchange = errors.New("unknown exchange")
+
+// Session is the trading state of an exchange
+type Session string
+
+const (
+	SessionPre     Session = "pre"
+	SessionRegular Session = "regular"
+	SessionPost    Session = "post"
+	SessionClosed  Session = "closed"
+)
+
+// dateLayout is the layout of dates in calendar files
+const dateLayout = "2006-01-02"
+
+// searchDays bounds how far ahead NextOpen and NextClose look. No exchange
+// closes for this long.
+const searchDays = 30
+
+// exchangeFile is an entry of exchanges.json. Times are "HH:MM" in the
+// exchange's timezone. Exchanges without extended hours leave PreOpen and
+// PostClose empty.
+type exchangeFile struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Timezone  string `json:"timezone"`
+	PreOpen   string `json:"pre_open"`
+	Open      string `json:"open"`
+	Close     string `json:"close"`
+	PostClose string `json:"post_close"`
+	Calendar  string `json:"calendar"`
+}
+
+// calendarFile is a file under calendars/, shared by exchanges that close
+// on the same days. Through is the last date its holidays and early closes
+// are complete for.
+type calendarFile struct {
+	Through  string `json:"through"`
+	Holidays []struct {
+		Date string `json:"date"`
+		Name string `json:"name"`
+	} `json:"holidays"`
+	EarlyCloses []struct {
+		Date  string `json:"date"`
+		Close string `json:"close"`
+		Name  string `json:"name"`
+	} `json:"early_closes"`
+}
+
+// earlyClose is a day the regular session ends early
+type earlyClose struct {
+	close int // minutes after midnight
+	name  string
+}
+
+// exchange is a loaded exchange definition. Times are minutes after
+// midnight in location.
+type exchange struct {
+	code      string
+	name      string
+	location  *time.Location
+	preOpen   int
+	open      int
+	close     int
+	postClose int
+
+	holidays    map[string]string // date -> name
+	earlyCloses map[string]earlyClose
+	through     string      // Last date the calendar covers, empty if it has none
+	warned      atomic.Bool // Whether a date past through was looked up
+}
+
+// Calendar answers session questions for a set of exchanges
+type Calendar struct {
+	exchanges map[string]*exchange
+}
+
+// Default returns a calendar of the embedded exchange data
+func Default() (*Calendar, error) {
+	sub, err := fs.Sub(data, "data")
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load reads exchanges.json and the calendars it names from fsys
+func Load(fsys fs.FS) (*Calendar, error) {
+	raw, err := fs.ReadFile(fsys, "exchanges.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchanges: %v", err)
+	}
+	var files []exchangeFile
+	if err := json.Unmarshal(raw, &files); err != nil {
+		return nil, fmt.Errorf("failed to parse exchanges: %v", err)
+	}
+
+	c := &Calendar{exchanges: make(map[string]*exchange, len(files))}
+	calendars := make(map[string]*calendarFile)
+	for _, f := range files {
+		ex, err := loadExchange(f)
+		if err != nil {
+			return nil, fmt.Errorf("exchange %s: %v", f.Code, err)
+		}
+
+		if f.Calendar != "" {
+			cal, ok := calendars[f.Calendar]
+			if !ok {
+				if cal, err = readCalendar(fsys, f.Calendar); err != nil {
+					return nil, err
+				}
+				calendars[f.Calendar] = cal
+			}
+			if err := ex.addCalendar(cal); err != nil {
+				return nil, fmt.Errorf("calendar %s: %v", f.Calendar, err)
+			}
+		}
+		c.exchanges[ex.code] = ex
+	}
+	return c, nil
+}
+
+func loadExchange(f exchangeFile) (*exchange, error) {
+	if f.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	loc, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone: %v", err)
+	}
+
+	ex := &exchange{
+		code:        strings.ToUpper(f.Code),
+		name:        f.Name,
+		location:    loc,
+		holidays:    make(map[string]string),
+		earlyCloses: make(map[string]earlyClose),
+	}
+	if ex.open, err = parseClock(f.Open); err != nil {
+		return nil, fmt.Errorf("open: %v", err)
+	}
+	if ex.close, err = parseClock(f.Close); err != nil {
+		return nil, fmt.Errorf("close: %v", err)
+	}
+	ex.preOpen, ex.postClose = ex.open, ex.close
+	if f.PreOpen != "" {
+		if ex.preOpen, err = parseClock(f.PreOpen); err != nil {
+			return nil, fmt.Errorf("pre_open: %v", err)
+		}
+	}
+	if f.PostClose != "" {
+		if ex.postClose, err = parseClock(f.PostClose); err != nil {
+			return nil, fmt.Errorf("post_close: %v", err)
+		}
+	}
+	if !(ex.preOpen <= ex.open && ex.open < ex.close && ex.close <= ex.postClose) {
+		return nil, fmt.Errorf("sessions are out of order")
+	}
+	return ex, nil
+}
+
+func readCalendar(fsys fs.FS, name string) (*calendarFile, error) {
+	raw, err := fs.ReadFile(fsys, path.Join("calendars", name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calendar %s: %v", name, err)
+	}
+	var cal calendarFile
+	if err := json.Unmarshal(raw, &cal); err != nil {
+		return nil, fmt.Errorf("failed to parse calendar %s: %v", name, err)
+	}
+	return &cal, nil
+}
+
+func (ex *exchange) addCalendar(cal *calendarFile) error {
+	for _, h := range cal.Holidays {
+		if _, err := time.Parse(dateLayout, h.Date); err != nil {
+			return fmt.Errorf("holiday %q: %v", h.Date, err)
+		}
+		ex.holidays[h.Date] = h.Name
+	}
+	for _, e := range cal.EarlyCloses {
+		if _, err := time.Parse(dateLayout, e.Date); err != nil {
+			return fmt.Errorf("early close %q: %v", e.Date, err)
+		}
+		closeAt, err := parseClock(e.Close)
+		if err != nil {
+			return fmt.Errorf("early close %s: %v", e.Date, err)
+		}
+		if closeAt <= ex.open || closeAt >= ex.close {
+			return fmt.Errorf("early close %s is outside regular hours", e.Date)
+		}
+		ex.earlyCloses[e.Date] = earlyClose{close: closeAt, name: e.Name}
+	}
+	if _, err := time.Parse(dateLayout, cal.Through); err != nil {
+		return fmt.Errorf("through %q: %v", cal.Through, err)
+	}
+	ex.through = cal.Through
+	return nil
+}
+
+// checkCovered logs, once, that date is past the end of the exchange's
+// calendar. Its holidays aren't known then, so every weekday is taken to
+// be a trading day until the calendar file is extended.
+func (ex *exchange) checkCovered(date string) {
+	if ex.through == "" || date <= ex.through || !ex.warned.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("The %s calendar ends on %s; treating %s and later weekdays as trading days", ex.code, ex.through, date)
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Exchanges returns the codes of the exchanges the calendar knows, sorted
+func (c *Calendar) Exchanges() []string {
+	return slices.Sorted(maps.Keys(c.exchanges))
+}
+
+func (c *Calendar) lookup(code string) (*exchange, error) {
+	ex, ok := c.exchanges[strings.ToUpper(code)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExchange, code)
+	}
+	return ex, nil
+}
@@ -0,0 +1,271 @@
+package marketcalendar
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func defaultCalendar(t *testing.T) *Calendar {
+	t.Helper()
+	c, err := Default()
+	if err != nil {
+		t.Fatalf("Default: %v", err)
+	}
+	return c
+}
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	return loc
+}
+
+func TestDefaultExchanges(t *testing.T) {
+	c := defaultCalendar(t)
+	got := c.Exchanges()
+	want := []string{"BSE", "LSE", "NASDAQ", "NSE", "NYSE"}
+	if len(got) != len(want) {
+		t.Fatalf("Exchanges() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Exchanges() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := defaultCalendar(t)
+	ny := mustLocation(t, "America/New_York")
+	kolkata := mustLocation(t, "Asia/Kolkata")
+	london := mustLocation(t, "Europe/London")
+
+	tests := []struct {
+		name     string
+		exchange string
+		at       time.Time
+		want     Session
+	}{
+		{"before pre-market", "NASDAQ", time.Date(2025, 6, 9, 3, 59, 0, 0, ny), SessionClosed},
+		{"pre-market opens", "NASDAQ", time.Date(2025, 6, 9, 4, 0, 0, 0, ny), SessionPre},
+		{"the bell", "NASDAQ", time.Date(2025, 6, 9, 9, 30, 0, 0, ny), SessionRegular},
+		{"last minute", "NASDAQ", time.Date(2025, 6, 9, 15, 59, 0, 0, ny), SessionRegular},
+		{"the close", "NASDAQ", time.Date(2025, 6, 9, 16, 0, 0, 0, ny), SessionPost},
+		{"post-market ends", "NASDAQ", time.Date(2025, 6, 9, 20, 0, 0, 0, ny), SessionClosed},
+		{"saturday", "NYSE", time.Date(2025, 6, 7, 12, 0, 0, 0, ny), SessionClosed},
+		// Friday evening in New York is already Saturday in UTC
+		{"friday evening", "NYSE", time.Date(2025, 6, 6, 19, 30, 0, 0, ny), SessionPost},
+		{"holiday", "NYSE", time.Date(2025, 7, 4, 12, 0, 0, 0, ny), SessionClosed},
+		{"after an early close", "NYSE", time.Date(2025, 11, 28, 13, 30, 0, 0, ny), SessionPost},
+		{"post-market after an early close", "NYSE", time.Date(2025, 11, 28, 17, 0, 0, 0, ny), SessionClosed},
+		{"lowercase code", "nyse", time.Date(2025, 6, 9, 12, 0, 0, 0, ny), SessionRegular},
+		{"india pre-open", "NSE", time.Date(2025, 6, 9, 9, 5, 0, 0, kolkata), SessionPre},
+		{"diwali", "BSE", time.Date(2025, 10, 21, 11, 0, 0, 0, kolkata), SessionClosed},
+		{"holi 2026", "NSE", time.Date(2026, 3, 3, 11, 0, 0, 0, kolkata), SessionClosed},
+		{"diwali 2026", "BSE", time.Date(2026, 11, 10, 11, 0, 0, 0, kolkata), SessionClosed},
+		{"london open", "LSE", time.Date(2025, 6, 9, 8, 0, 0, 0, london), SessionRegular},
+		{"london has no post-market", "LSE", time.Date(2025, 6, 9, 16, 30, 0, 0, london), SessionClosed},
+		{"boxing day", "LSE", time.Date(2025, 12, 26, 10, 0, 0, 0, london), SessionClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Session(tt.exchange, tt.at)
+			if err != nil {
+				t.Fatalf("Session: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Session(%s, %s) = %s, want %s", tt.exchange, tt.at, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := c.Session("XETRA", time.Now()); !errors.Is(err, ErrUnknownExchange) {
+		t.Errorf("unknown exchange error = %v, want ErrUnknownExchange", err)
+	}
+}
+
+func TestHours(t *testing.T) {
+	c := defaultCalendar(t)
+	ny := mustLocation(t, "America/New_York")
+	tokyo := mustLocation(t, "Asia/Tokyo")
+
+	// The date is read as is, even where it is already the next day in New
+	// York's terms
+	hours, err := c.Hours("NYSE", time.Date(2025, 11, 28, 1, 0, 0, 0, tokyo))
+	if err != nil {
+		t.Fatalf("Hours: %v", err)
+	}
+	if !hours.Trading || !hours.Open.Equal(time.Date(2025, 11, 28, 9, 30, 0, 0, ny)) || !hours.Close.Equal(time.Date(2025, 11, 28, 13, 0, 0, 0, ny)) {
+		t.Errorf("Hours on an early close = %+v, want 09:30 to 13:00", hours)
+	}
+
+	hours, err = c.Hours("NYSE", time.Date(2025, 7, 4, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Hours: %v", err)
+	}
+	if hours.Trading || hours.Holiday != "Independence Day" {
+		t.Errorf("Hours on a holiday = %+v, want closed for Independence Day", hours)
+	}
+
+	if hours, _ := c.Hours("NSE", time.Date(2025, 6, 7, 0, 0, 0, 0, time.UTC)); hours.Trading || hours.Holiday != "" {
+		t.Errorf("Hours on a Saturday = %+v, want closed", hours)
+	}
+	if _, err := c.Hours("XETRA", time.Now()); !errors.Is(err, ErrUnknownExchange) {
+		t.Errorf("unknown exchange error = %v, want ErrUnknownExchange", err)
+	}
+}
+
+func TestNextOpenAndClose(t *testing.T) {
+	c := defaultCalendar(t)
+	ny := mustLocation(t, "America/New_York")
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		{
+			"during the session",
+			time.Date(2025, 6, 9, 12, 0, 0, 0, ny),
+			time.Date(2025, 6, 10, 9, 30, 0, 0, ny),
+			time.Date(2025, 6, 9, 16, 0, 0, 0, ny),
+		},
+		{
+			"before the bell",
+			time.Date(2025, 6, 9, 8, 0, 0, 0, ny),
+			time.Date(2025, 6, 9, 9, 30, 0, 0, ny),
+			time.Date(2025, 6, 9, 16, 0, 0, 0, ny),
+		},
+		{
+			"over a weekend",
+			time.Date(2025, 6, 6, 17, 0, 0, 0, ny),
+			time.Date(2025, 6, 9, 9, 30, 0, 0, ny),
+			time.Date(2025, 6, 9, 16, 0, 0, 0, ny),
+		},
+		{
+			"over thanksgiving to a half day",
+			time.Date(2025, 11, 26, 17, 0, 0, 0, ny),
+			time.Date(2025, 11, 28, 9, 30, 0, 0, ny),
+			time.Date(2025, 11, 28, 13, 0, 0, 0, ny),
+		},
+		{
+			"across the DST change",
+			time.Date(2025, 3, 7, 17, 0, 0, 0, ny),
+			time.Date(2025, 3, 10, 9, 30, 0, 0, ny),
+			time.Date(2025, 3, 10, 16, 0, 0, 0, ny),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, err := c.NextOpen("NYSE", tt.at)
+			if err != nil {
+				t.Fatalf("NextOpen: %v", err)
+			}
+			if !open.Equal(tt.nextOpen) {
+				t.Errorf("NextOpen = %s, want %s", open, tt.nextOpen)
+			}
+			closeAt, err := c.NextClose("NYSE", tt.at)
+			if err != nil {
+				t.Fatalf("NextClose: %v", err)
+			}
+			if !closeAt.Equal(tt.nextClose) {
+				t.Errorf("NextClose = %s, want %s", closeAt, tt.nextClose)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	c := defaultCalendar(t)
+	ny := mustLocation(t, "America/New_York")
+
+	status, err := c.Status("NYSE", time.Date(2025, 12, 24, 11, 0, 0, 0, ny))
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.IsOpen || status.Session != SessionRegular {
+		t.Errorf("status = %+v, want open", status)
+	}
+	wantClose := time.Date(2025, 12, 24, 13, 0, 0, 0, ny)
+	if status.EarlyClose == nil || !status.EarlyClose.Equal(wantClose) {
+		t.Errorf("EarlyClose = %v, want %s", status.EarlyClose, wantClose)
+	}
+	if !status.NextClose.Equal(wantClose) {
+		t.Errorf("NextClose = %s, want %s", status.NextClose, wantClose)
+	}
+	// Christmas is skipped
+	if want := time.Date(2025, 12, 26, 9, 30, 0, 0, ny); !status.NextOpen.Equal(want) {
+		t.Errorf("NextOpen = %s, want %s", status.NextOpen, want)
+	}
+
+	status, err = c.Status("NYSE", time.Date(2025, 12, 25, 11, 0, 0, 0, ny))
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.IsOpen || status.Holiday != "Christmas Day" || status.EarlyClose != nil {
+		t.Errorf("status on a holiday = %+v", status)
+	}
+}
+
+func TestLoadRejectsBadData(t *testing.T) {
+	exchanges := `[{"code": "X", "timezone": "UTC", "open": "09:00", "close": "17:00", "calendar": "x"}]`
+	tests := []struct {
+		name     string
+		calendar string
+	}{
+		{"bad date", `{"through": "2025-12-31", "holidays": [{"date": "2025-13-01"}]}`},
+		{"early close after the close", `{"through": "2025-12-31", "early_closes": [{"date": "2025-12-24", "close": "18:00"}]}`},
+		{"bad time", `{"through": "2025-12-31", "early_closes": [{"date": "2025-12-24", "close": "noon"}]}`},
+		{"no end", `{"holidays": [{"date": "2025-12-25"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"exchanges.json":   {Data: []byte(exchanges)},
+				"calendars/x.json": {Data: []byte(tt.calendar)},
+			}
+			if _, err := Load(fsys); err == nil {
+				t.Error("Load succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := Load(fstest.MapFS{"exchanges.json": {Data: []byte(exchanges)}}); err == nil {
+		t.Error("Load succeeded without the calendar file, want an error")
+	}
+}
+
+func TestDatesPastTheCalendarAreLogged(t *testing.T) {
+	var logged bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	c := defaultCalendar(t)
+	kolkata := mustLocation(t, "Asia/Kolkata")
+	if _, err := c.Session("NSE", time.Date(2026, 12, 31, 11, 0, 0, 0, kolkata)); err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	if logged.Len() != 0 {
+		t.Fatalf("logged %q for a date the calendar covers", logged.String())
+	}
+
+	for range 2 {
+		session, err := c.Session("NSE", time.Date(2028, 1, 3, 11, 0, 0, 0, kolkata))
+		if err != nil || session != SessionRegular {
+			t.Fatalf("Session past the calendar = %s, %v, want the weekday traded", session, err)
+		}
+	}
+	if got := logged.String(); strings.Count(got, "NSE calendar ends on") != 1 {
+		t.Errorf("logged %q, want one warning", got)
+	}
+}
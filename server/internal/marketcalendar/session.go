@@ -0,0 +1,184 @@
+package marketcalendar
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status describes an exchange's session at a time
+type Status struct {
+	Exchange   string     `json:"exchange"`
+	Name       string     `json:"name"`
+	Timezone   string     `json:"timezone"`
+	Session    Session    `json:"session"`
+	IsOpen     bool       `json:"is_open"`
+	NextOpen   time.Time  `json:"next_open"`
+	NextClose  time.Time  `json:"next_close"`
+	Holiday    string     `json:"holiday,omitempty"`     // Today's holiday, if the exchange is shut for one
+	EarlyClose *time.Time `json:"early_close,omitempty"` // Today's close, if it is early
+}
+
+// Hours are an exchange's regular session on one date
+type Hours struct {
+	Trading bool      // False on weekends and holidays
+	Holiday string    // The holiday the exchange is shut for
+	Open    time.Time // Zero when not trading
+	Close   time.Time // Early on early close days
+}
+
+// day is an exchange's sessions on one local date. A day without trading
+// has trading false.
+type day struct {
+	trading   bool
+	holiday   string
+	early     bool
+	preOpen   time.Time
+	open      time.Time
+	close     time.Time
+	postClose time.Time
+}
+
+// day returns the sessions on the local date of at. On an early close the
+// post-market session keeps its usual length.
+func (ex *exchange) day(at time.Time) day {
+	local := at.In(ex.location)
+	y, m, d := local.Date()
+	date := local.Format(dateLayout)
+	ex.checkCovered(date)
+
+	if name, ok := ex.holidays[date]; ok {
+		return day{holiday: name}
+	}
+	if wd := local.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return day{}
+	}
+
+	clock := func(minutes int) time.Time {
+		return time.Date(y, m, d, minutes/60, minutes%60, 0, 0, ex.location)
+	}
+	closeAt, postClose := ex.close, ex.postClose
+	early, ok := ex.earlyCloses[date]
+	if ok {
+		postClose = early.close + (ex.postClose - ex.close)
+		closeAt = early.close
+	}
+	return day{
+		trading:   true,
+		early:     ok,
+		preOpen:   clock(ex.preOpen),
+		open:      clock(ex.open),
+		close:     clock(closeAt),
+		postClose: clock(postClose),
+	}
+}
+
+// session returns the session at a time within d
+func (d day) session(at time.Time) Session {
+	switch {
+	case !d.trading:
+		return SessionClosed
+	case !at.Before(d.open) && at.Before(d.close):
+		return SessionRegular
+	case !at.Before(d.preOpen) && at.Before(d.open):
+		return SessionPre
+	case !at.Before(d.close) && at.Before(d.postClose):
+		return SessionPost
+	}
+	return SessionClosed
+}
+
+// Session returns the session an exchange is in at a time
+func (c *Calendar) Session(exchange string, at time.Time) (Session, error) {
+	ex, err := c.lookup(exchange)
+	if err != nil {
+		return "", err
+	}
+	return ex.day(at).session(at), nil
+}
+
+// IsOpen reports whether an exchange is in its regular session at a time
+func (c *Calendar) IsOpen(exchange string, at time.Time) (bool, error) {
+	session, err := c.Session(exchange, at)
+	return session == SessionRegular, err
+}
+
+// NextOpen returns when the exchange's next regular session starts after at
+func (c *Calendar) NextOpen(exchange string, at time.Time) (time.Time, error) {
+	ex, err := c.lookup(exchange)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ex.next(at, func(d day) time.Time { return d.open })
+}
+
+// NextClose returns when the exchange's regular session next ends after at.
+// While the exchange is open that is today's close.
+func (c *Calendar) NextClose(exchange string, at time.Time) (time.Time, error) {
+	ex, err := c.lookup(exchange)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ex.next(at, func(d day) time.Time { return d.close })
+}
+
+// Hours returns an exchange's regular session on a calendar date. Only the
+// year, month and day of date are used, so it names the same date in any
+// location.
+func (c *Calendar) Hours(exchange string, date time.Time) (Hours, error) {
+	ex, err := c.lookup(exchange)
+	if err != nil {
+		return Hours{}, err
+	}
+	y, m, d := date.Date()
+	sessions := ex.day(time.Date(y, m, d, 12, 0, 0, 0, ex.location))
+	if !sessions.trading {
+		return Hours{Holiday: sessions.holiday}, nil
+	}
+	return Hours{Trading: true, Open: sessions.open, Close: sessions.close}, nil
+}
+
+// next returns the first of the times picked from each trading day that is
+// after at
+func (ex *exchange) next(at time.Time, pick func(day) time.Time) (time.Time, error) {
+	local := at.In(ex.location)
+	for i := 0; i < searchDays; i++ {
+		// Noon is clear of DST transitions, which happen overnight
+		y, m, d := local.Date()
+		noon := time.Date(y, m, d+i, 12, 0, 0, 0, ex.location)
+		if sessions := ex.day(noon); sessions.trading {
+			if t := pick(sessions); t.After(at) {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s does not trade within %d days of %s", ex.code, searchDays, at.Format(time.RFC3339))
+}
+
+// Status describes an exchange's session at a time
+func (c *Calendar) Status(exchange string, at time.Time) (Status, error) {
+	ex, err := c.lookup(exchange)
+	if err != nil {
+		return Status{}, err
+	}
+
+	today := ex.day(at)
+	status := Status{
+		Exchange: ex.code,
+		Name:     ex.name,
+		Timezone: ex.location.String(),
+		Session:  today.session(at),
+		Holiday:  today.holiday,
+	}
+	status.IsOpen = status.Session == SessionRegular
+	if today.early {
+		closeAt := today.close
+		status.EarlyClose = &closeAt
+	}
+	if status.NextOpen, err = ex.next(at, func(d day) time.Time { return d.open }); err != nil {
+		return Status{}, err
+	}
+	if status.NextClose, err = ex.next(at, func(d day) time.Time { return d.close }); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import "time"
+
+// Notification channels a trigger can be delivered on
+const (
+	ChannelEmail     = "email"
+	ChannelWebSocket = "websocket"
+	ChannelSMS       = "sms"
+	ChannelWebhook   = "webhook"
+	ChannelPush      = "push"
+	ChannelSlack     = "slack"
+	ChannelDiscord   = "discord"
+	ChannelTelegram  = "telegram"
+)
+
+// Delivery statuses
+const (
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+	DeliverySkipped = "skipped" // Not attempted, e.g. because the user turned the channel off
+	DeliveryDead    = "dead"    // Failed for good and kept to be replayed by hand
+	DeliveryHeld    = "held"    // Waiting for the user's quiet hours to end
+)
+
+// OutboxPending marks a fire whose notifications are still to be delivered
+const OutboxPending = "pending"
+
+// Digest frequencies
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestClaim records that a user's digest for a period went out, so it is
+// never sent twice
+type DigestClaim struct {
+	UserID    string    `dynamodbav:"user_id"`
+	DigestID  string    `dynamodbav:"digest_id"` // Frequency and last day covered, e.g. daily:2026-03-02
+	ClaimedAt time.Time `dynamodbav:"claimed_at"`
+	ExpiresAt int64     `dynamodbav:"expires_at"` // Unix seconds after which DynamoDB may drop the claim
+}
+
+// ReleaseClaim records that alerts held through a user's quiet hours are
+// being sent on a channel, so one instance sends them
+type ReleaseClaim struct {
+	UserID    string    `dynamodbav:"user_id"`
+	ReleaseID string    `dynamodbav:"release_id"` // Channel and first fire released, e.g. email:<fire_id>
+	ClaimedAt time.Time `dynamodbav:"claimed_at"`
+	ExpiresAt int64     `dynamodbav:"expires_at"` // Unix seconds after which DynamoDB may drop the claim
+}
+
+// TriggerFire records one firing of a trigger and how it was delivered on
+// each channel. It is written together with the trigger, and serves as the
+// outbox entry its notifications are delivered from.
+type TriggerFire struct {
+	FireID     string     `dynamodbav:"fire_id"`
+	TriggerID  string     `dynamodbav:"trigger_id"`
+	UserID     string     `dynamodbav:"user_id"`
+	Symbol     string     `dynamodbav:"symbol"`
+	Exchange   string     `dynamodbav:"exchange"`
+	Price      float64    `dynamodbav:"price"`
+	PrevPrice  float64    `dynamodbav:"prev_price"` // 0 if the price before the fire wasn't seen
+	Message    string     `dynamodbav:"message"`
+	FiredAt    time.Time  `dynamodbav:"fired_at"`
+	Deliveries []Delivery `dynamodbav:"deliveries"`
+
+	// Outbox is OutboxPending until every channel is settled, and empty
+	// after. It is left out when empty, so only pending fires are indexed.
+	Outbox        string    `dynamodbav:"outbox,omitempty"`
+	Attempts      int       `dynamodbav:"attempts"`                  // Delivery attempts started
+	NextAttemptAt time.Time `dynamodbav:"next_attempt_at,omitempty"` // When a pending fire is due, or its current attempt expires
+
+	// HeldUntil is when the earliest of the deliveries held through quiet
+	// hours is due to be released, and zero when none is held. Only fires
+	// with held deliveries are indexed by it.
+	HeldUntil time.Time `dynamodbav:"held_until,omitempty"`
+
+	Version int64 `dynamodbav:"version"` // Incremented on every update for optimistic locking
+}
+
+// Delivery is the outcome of notifying a user on one channel
+type Delivery struct {
+	Channel string    `dynamodbav:"channel" json:"channel"`
+	Status  string    `dynamodbav:"status" json:"status"`
+	Error   string    `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	At      time.Time `dynamodbav:"at" json:"at"`
+
+	HeldUntil time.Time `dynamodbav:"held_until,omitempty" json:"held_until,omitzero"` // When a held delivery's quiet hours end
+}
+
+// NextRelease returns when the earliest of the fire's held deliveries is
+// due to be released, or the zero time if none is held
+func (f *TriggerFire) NextRelease() time.Time {
+	var next time.Time
+	for _, d := range f.Deliveries {
+		if d.Status == DeliveryHeld && (next.IsZero() || d.HeldUntil.Before(next)) {
+			next = d.HeldUntil
+		}
+	}
+	return next
+}
+
+// ChannelEnabled reports whether the user accepts notifications on channel
+func (u *User) ChannelEnabled(channel string) bool {
+	prefs := u.NotificationPreferences
+	switch channel {
+	case ChannelEmail:
+		return prefs.Email && u.Email != ""
+	case ChannelWebSocket:
+		return prefs.WebSocket
+	case ChannelSMS:
+		return prefs.SMS && prefs.Phone != "" && prefs.PhoneVerified
+	case ChannelWebhook:
+		return prefs.Webhook
+	case ChannelPush:
+		return prefs.Push
+	case ChannelSlack:
+		return prefs.Slack.Enabled && prefs.Slack.WebhookURL != ""
+	case ChannelDiscord:
+		return prefs.Discord.Enabled && prefs.Discord.WebhookURL != ""
+	case ChannelTelegram:
+		return prefs.Telegram.Enabled && prefs.Telegram.BotToken != "" && prefs.Telegram.ChatID != ""
+	}
+	return false
+}
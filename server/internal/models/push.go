@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PushSubscription is a browser a user allowed to show alerts, as its
+// PushManager subscribed it with the Web Push service
+type PushSubscription struct {
+	UserID         string    `dynamodbav:"user_id"`          // Partition key; the owner's email
+	SubscriptionID string    `dynamodbav:"subscription_id"`  // Derived from the endpoint, so a browser subscribing again replaces its subscription
+	Endpoint       string    `dynamodbav:"endpoint"`         // The push service URL messages are posted to
+	P256DH         string    `dynamodbav:"p256dh"`           // The browser's public key, base64url
+	Auth           string    `dynamodbav:"auth"`             // The browser's authentication secret, base64url
+	Device         string    `dynamodbav:"device,omitempty"` // A name for the browser the user recognises it by
+	CreatedAt      time.Time `dynamodbav:"created_at"`
+}
@@ -9,11 +9,13 @@ type Stock struct {
 	Name        string    `dynamodbav:"name"`
 	Exchange    string    `dynamodbav:"exchange"`
 	Currency    string    `dynamodbav:"currency"`
+	Quantity    float64   `dynamodbav:"quantity"`   // Shares held; 0 if the stock is only watched
 	Price       float64   `dynamodbav:"price"`      // Current price
 	LastPrice   float64   `dynamodbav:"last_price"` // Previous price for calculating change
 	AddedAt     time.Time `dynamodbav:"added_at"`
 	LastUpdated time.Time `dynamodbav:"last_updated"`
-	Triggers    []string  `dynamodbav:"triggers"` // List of trigger IDs associated with this stock
+	Triggers    []string  `dynamodbav:"triggers,omitempty"` // List of trigger IDs associated with this stock
+	Version     int64     `dynamodbav:"version"`            // Incremented on every write for optimistic locking
 }
 
 // StockPrice represents the minimal stock information (symbol and price)
@@ -27,6 +29,8 @@ type StockTrigger struct {
 	TriggerID   string    `dynamodbav:"trigger_id"`
 	StockID     string    `dynamodbav:"stock_id"` // Foreign key to Stock
 	UserID      string    `dynamodbav:"user_id"`  // Foreign key to User
+	Symbol      string    `dynamodbav:"symbol"`   // Copied from Stock for the SymbolIndex
+	Exchange    string    `dynamodbav:"exchange"` // Copied from Stock for the SymbolIndex
 	Type        string    `dynamodbav:"type"`     // PRICE_UPPER, PRICE_LOWER, etc.
 	IsActive    bool      `dynamodbav:"is_active"`
 	CreatedAt   time.Time `dynamodbav:"created_at"`
@@ -38,6 +42,8 @@ type StockTrigger struct {
 	VolumeMultiplier     float64  `dynamodbav:"volume_multiplier,omitempty"`
 	NotificationChannels []string `dynamodbav:"notification_channels"`
 	CooldownMinutes      int      `dynamodbav:"cooldown_minutes"`
+
+	Version int64 `dynamodbav:"version"` // Incremented on every write for optimistic locking
 }
 
 // UserStockTriggers represents all triggers for a user's stocks
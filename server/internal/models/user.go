@@ -9,14 +9,62 @@ type User struct {
 	CreatedAt    time.Time `dynamodbav:"created_at"`
 	UpdatedAt    time.Time `dynamodbav:"updated_at"`
 
-	// Notification preferences
-	NotificationPreferences struct {
-		Email     bool   `dynamodbav:"email"`
-		WebSocket bool   `dynamodbav:"websocket"`
-		SMS       bool   `dynamodbav:"sms"`
-		Phone     string `dynamodbav:"phone,omitempty"`
-	} `dynamodbav:"notification_preferences"`
+	NotificationPreferences NotificationPreferences `dynamodbav:"notification_preferences"`
 
 	// Active triggers count
 	ActiveTriggers int `dynamodbav:"active_triggers"`
+
+	Version int64 `dynamodbav:"version"` // Incremented on every write for optimistic locking
+}
+
+// NotificationPreferences are how a user wants to be notified
+type NotificationPreferences struct {
+	Email         bool   `dynamodbav:"email"`
+	WebSocket     bool   `dynamodbav:"websocket"`
+	SMS           bool   `dynamodbav:"sms"`
+	Phone         string `dynamodbav:"phone,omitempty"`
+	PhoneVerified bool   `dynamodbav:"phone_verified"`     // Phone proved it receives texts
+	Webhook       bool   `dynamodbav:"webhook"`            // Post to the user's webhooks
+	Push          bool   `dynamodbav:"push"`               // Push to the user's subscribed browsers
+	Language      string `dynamodbav:"language,omitempty"` // Notifications are written in it; English if empty
+	Timezone      string `dynamodbav:"timezone,omitempty"` // IANA name digests and quiet hours are in; UTC if empty
+	Digest        string `dynamodbav:"digest,omitempty"`   // DigestDaily, DigestWeekly, or empty for none
+
+	// Alerts are held while it is quiet, nil for never
+	QuietHours *QuietHours `dynamodbav:"quiet_hours,omitempty"`
+
+	// Chat apps to post alerts to
+	Slack    ChatDestination `dynamodbav:"slack"`
+	Discord  ChatDestination `dynamodbav:"discord"`
+	Telegram ChatDestination `dynamodbav:"telegram"`
+
+	// A phone number waiting for its one-time code
+	PhoneVerification *PhoneVerification `dynamodbav:"phone_verification,omitempty"`
+}
+
+// QuietHours is the time of day, in the user's timezone, when alerts are
+// held to be sent together afterwards. It runs past midnight when End is
+// not after Start.
+type QuietHours struct {
+	Start string `dynamodbav:"start"` // HH:MM
+	End   string `dynamodbav:"end"`   // HH:MM
+}
+
+// PhoneVerification is a one-time code sent to a phone number the user
+// wants texts on
+type PhoneVerification struct {
+	Phone     string    `dynamodbav:"phone"`
+	CodeHash  string    `dynamodbav:"code_hash"` // SHA-256 of the user ID and code
+	ExpiresAt time.Time `dynamodbav:"expires_at"`
+	Attempts  int       `dynamodbav:"attempts"` // Wrong codes entered so far
+}
+
+// ChatDestination is where alerts are posted in a chat app: an incoming
+// webhook for Slack and Discord, or a bot and the chat it posts to for
+// Telegram
+type ChatDestination struct {
+	Enabled    bool   `dynamodbav:"enabled"`
+	WebhookURL string `dynamodbav:"webhook_url,omitempty"`
+	BotToken   string `dynamodbav:"bot_token,omitempty"`
+	ChatID     string `dynamodbav:"chat_id,omitempty"`
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint a user registered to receive trigger fires on
+type Webhook struct {
+	UserID     string     `dynamodbav:"user_id"` // Partition key; the owner's email
+	WebhookID  string     `dynamodbav:"webhook_id"`
+	URL        string     `dynamodbav:"url"`
+	Secret     string     `dynamodbav:"secret"` // Signs every delivery
+	Enabled    bool       `dynamodbav:"enabled"`
+	Failures   int        `dynamodbav:"failures"` // Deliveries failed in a row
+	DisabledAt *time.Time `dynamodbav:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `dynamodbav:"created_at"`
+	UpdatedAt  time.Time  `dynamodbav:"updated_at"`
+	Version    int64      `dynamodbav:"version"` // Incremented on every write for optimistic locking
+}
+
+// WebhookDeadLetter is a delivery to a webhook that failed every attempt.
+// It keeps the payload so it can be sent again.
+type WebhookDeadLetter struct {
+	UserID       string    `dynamodbav:"user_id"` // Partition key; the owner's email
+	DeadLetterID string    `dynamodbav:"dead_letter_id"`
+	WebhookID    string    `dynamodbav:"webhook_id"`
+	FireID       string    `dynamodbav:"fire_id"`
+	Payload      string    `dynamodbav:"payload"` // The JSON body that was posted
+	Error        string    `dynamodbav:"error"`   // Why the last attempt failed
+	Attempts     int       `dynamodbav:"attempts"`
+	FailedAt     time.Time `dynamodbav:"failed_at"`
+}
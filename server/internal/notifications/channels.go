@@ -0,0 +1,122 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/models"
+)
+
+// EmailNotifier emails alerts to the user's address
+type EmailNotifier struct {
+	email *EmailService
+}
+
+// NewEmailNotifier creates an email notifier
+func NewEmailNotifier(email *EmailService) *EmailNotifier {
+	return &EmailNotifier{email: email}
+}
+
+func (n *EmailNotifier) Channel() string { return models.ChannelEmail }
+
+// Notify emails alert to user
+func (n *EmailNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	return n.email.SendTriggerNotification(ctx, triggerNotification(user, alert))
+}
+
+// NotifyBatch emails user the alerts together
+func (n *EmailNotifier) NotifyBatch(ctx context.Context, user *models.User, alerts []Alert) error {
+	return n.email.SendHeldAlerts(ctx, heldNotification(user, alerts))
+}
+
+// heldNotification is alerts as the templates for user see them
+func heldNotification(user *models.User, alerts []Alert) HeldAlertsNotification {
+	notification := HeldAlertsNotification{
+		UserID:   user.UserID,
+		Email:    user.Email,
+		Language: user.NotificationPreferences.Language,
+	}
+	for _, alert := range alerts {
+		notification.Alerts = append(notification.Alerts, triggerNotification(user, alert))
+	}
+	return notification
+}
+
+// triggerNotification is alert as the templates for user see it
+func triggerNotification(user *models.User, alert Alert) TriggerNotification {
+	return TriggerNotification{
+		Symbol:      alert.Symbol,
+		Exchange:    alert.Exchange,
+		Currency:    alert.Currency,
+		Price:       alert.Price,
+		PrevPrice:   alert.PrevPrice,
+		TriggerType: alert.TriggerType,
+		Message:     alert.Message,
+		At:          alert.At,
+		UserID:      user.UserID,
+		Email:       user.Email,
+		Language:    user.NotificationPreferences.Language,
+	}
+}
+
+// evaluationPayload is the JSON websocket clients and webhooks receive for a
+// fire. It keeps the shape of triggers.TriggerEvaluation, which clients were
+// sent before alerts were routed through notifiers.
+type evaluationPayload struct {
+	TriggerID    string    `json:"trigger_id"`
+	UserID       string    `json:"user_id"`
+	Symbol       string    `json:"symbol"`
+	Exchange     string    `json:"exchange"`
+	Triggered    bool      `json:"triggered"`
+	CurrentPrice float64   `json:"current_price"`
+	PrevPrice    float64   `json:"previous_price,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	Message      string    `json:"message"`
+	Test         bool      `json:"test,omitempty"` // A sample sent when the user asked, not a real fire
+}
+
+// evaluationJSON encodes alert as a trigger evaluation
+func evaluationJSON(alert Alert) ([]byte, error) {
+	return json.Marshal(newEvaluationPayload(alert))
+}
+
+// newEvaluationPayload returns alert as a trigger evaluation
+func newEvaluationPayload(alert Alert) evaluationPayload {
+	return evaluationPayload{
+		TriggerID:    alert.TriggerID,
+		UserID:       alert.UserID,
+		Symbol:       alert.Symbol,
+		Exchange:     alert.Exchange,
+		Triggered:    true,
+		CurrentPrice: alert.Price,
+		PrevPrice:    alert.PrevPrice,
+		Timestamp:    alert.At,
+		Message:      alert.Message,
+		Test:         alert.Test,
+	}
+}
+
+// WebSocketNotifier pushes alerts to the user's open websocket. The user may
+// be connected to any instance, so the push goes over the event bus; it
+// succeeds once the bus has accepted it, whether or not the user is online.
+type WebSocketNotifier struct {
+	bus *events.Bus
+}
+
+// NewWebSocketNotifier creates a websocket notifier
+func NewWebSocketNotifier(bus *events.Bus) *WebSocketNotifier {
+	return &WebSocketNotifier{bus: bus}
+}
+
+func (n *WebSocketNotifier) Channel() string { return models.ChannelWebSocket }
+
+// Notify pushes alert to user's websocket
+func (n *WebSocketNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	payload, err := evaluationJSON(alert)
+	if err != nil {
+		return err
+	}
+	return n.bus.Publish(ctx, events.SocketPush{UserID: alert.UserID, Payload: payload})
+}
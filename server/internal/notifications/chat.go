@@ -0,0 +1,152 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// Errors configuring chat destinations
+var (
+	ErrUnknownChatChannel     = errors.New("unknown chat channel")
+	ErrInvalidChatDestination = errors.New("invalid chat destination")
+)
+
+// ChatChannels are the chat apps alerts can be posted to
+var ChatChannels = []string{models.ChannelSlack, models.ChannelDiscord, models.ChannelTelegram}
+
+var (
+	telegramToken  = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]{30,}$`)
+	telegramChatID = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z][A-Za-z0-9_]{4,})$`)
+)
+
+// ValidateChatDestination checks dest can be posted to on channel: Slack and
+// Discord need one of their incoming webhook URLs, Telegram a bot token and
+// a chat ID or @channel name
+func ValidateChatDestination(channel string, dest models.ChatDestination) error {
+	switch channel {
+	case models.ChannelSlack:
+		u, err := url.Parse(dest.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host != "hooks.slack.com" || !strings.HasPrefix(u.Path, "/services/") {
+			return fmt.Errorf("%w: Slack needs an incoming webhook URL, https://hooks.slack.com/services/...", ErrInvalidChatDestination)
+		}
+	case models.ChannelDiscord:
+		u, err := url.Parse(dest.WebhookURL)
+		hosts := []string{"discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com"}
+		if err != nil || u.Scheme != "https" || !slices.Contains(hosts, u.Host) || !strings.HasPrefix(u.Path, "/api/webhooks/") {
+			return fmt.Errorf("%w: Discord needs a channel webhook URL, https://discord.com/api/webhooks/...", ErrInvalidChatDestination)
+		}
+	case models.ChannelTelegram:
+		if !telegramToken.MatchString(dest.BotToken) {
+			return fmt.Errorf("%w: Telegram needs the bot token BotFather gave you", ErrInvalidChatDestination)
+		}
+		if !telegramChatID.MatchString(dest.ChatID) {
+			return fmt.Errorf("%w: Telegram chat ID must be numeric or an @channel name", ErrInvalidChatDestination)
+		}
+	default:
+		return ErrUnknownChatChannel
+	}
+	return nil
+}
+
+// chatAlert is an alert as the chat notifiers show it
+type chatAlert struct {
+	Title     string // Symbol and exchange
+	Symbol    string
+	Price     string
+	Change    string // Empty when the previous price is unknown
+	Direction int    // 1 up, -1 down, 0 flat or unknown
+	Message   string
+	Link      string // Empty without an app URL
+	At        time.Time
+
+	// Labels in the user's language
+	PriceLabel  string
+	ChangeLabel string
+	ViewLabel   string // Button to Link
+}
+
+// newChatAlert formats alert in lang, linking to the symbol's page under
+// appURL
+func newChatAlert(alert Alert, lang, appURL string) chatAlert {
+	c := chatAlert{
+		Title:   alert.Symbol,
+		Symbol:  alert.Symbol,
+		Price:   formatMoney(lang, alert.Price, alert.Currency),
+		Change:  formatChange(lang, alert.Price, alert.PrevPrice, alert.Currency),
+		Message: alertMessage(lang, alert.TriggerType, alert.Message),
+		At:      alert.At.UTC(),
+	}
+	c.PriceLabel, _ = translate(lang, "label.price")
+	c.ChangeLabel, _ = translate(lang, "label.change")
+	c.ViewLabel, _ = translate(lang, "chat.view", alert.Symbol)
+	if alert.Exchange != "" {
+		c.Title += " (" + alert.Exchange + ")"
+	}
+	if alert.PrevPrice > 0 {
+		switch {
+		case alert.Price > alert.PrevPrice:
+			c.Direction = 1
+		case alert.Price < alert.PrevPrice:
+			c.Direction = -1
+		}
+	}
+	if appURL != "" {
+		c.Link = strings.TrimRight(appURL, "/") + "/stocks/" + url.PathEscape(alert.Symbol)
+	}
+	return c
+}
+
+// Fallback is the alert as one line of plain text
+func (c chatAlert) Fallback() string {
+	return fmt.Sprintf("%s %s: %s", c.Title, c.Price, c.Message)
+}
+
+// postChatJSON posts payload to endpoint and returns the response body. A
+// response other than 2xx is an error naming service.
+func postChatJSON(ctx context.Context, client *http.Client, service, endpoint string, payload any) ([]byte, error) {
+	// Messages carry markup, which is easier to read unescaped
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(payload); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s URL", service)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL holds the webhook's or bot's secret, so it is left out
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("failed to post to %s: %v", service, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail := strings.TrimSpace(string(respBody))
+		if len(detail) > 200 {
+			detail = detail[:200] + "..."
+		}
+		return respBody, fmt.Errorf("%s responded %s: %s", service, resp.Status, detail)
+	}
+	return respBody, nil
+}
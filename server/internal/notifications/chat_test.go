@@ -0,0 +1,287 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const telegramTestToken = "123456:ABCdefGHIjklMNOpqrSTUvwxYZ0123456789"
+
+// chatStandIn answers like the chat service's API and records the last
+// request it was sent
+type chatStandIn struct {
+	*httptest.Server
+	path string
+	body []byte
+}
+
+func newChatStandIn(t *testing.T, status int, response string) *chatStandIn {
+	s := &chatStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.path = req.URL.Path
+		s.body, _ = io.ReadAll(req.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// checkGolden compares the JSON body to testdata/name.golden.json
+func checkGolden(t *testing.T, name string, body []byte) {
+	t.Helper()
+	var got bytes.Buffer
+	if err := json.Indent(&got, bytes.TrimSpace(body), "", "  "); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, body)
+	}
+	got.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v (run with -update to create it)", path, err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("payload differs from %s:\n got: %s\nwant: %s", path, got.Bytes(), want)
+	}
+}
+
+var chatAlertRising = Alert{
+	FireID:    "fire-1",
+	TriggerID: "trigger-1",
+	UserID:    "dave@example.com",
+	Symbol:    "AAPL",
+	Exchange:  "NASDAQ",
+	Price:     201,
+	PrevPrice: 197.5,
+	Message:   "Price exceeded upper limit",
+	At:        time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC),
+}
+
+var sapRising = Alert{
+	FireID:      "fire-2",
+	TriggerID:   "trigger-2",
+	TriggerType: "PRICE_UPPER_LIMIT",
+	UserID:      "dave@example.com",
+	Symbol:      "SAP",
+	Exchange:    "XETR",
+	Currency:    "EUR",
+	Price:       1234.5,
+	PrevPrice:   1200,
+	Message:     "Price exceeded upper limit",
+	At:          time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC),
+}
+
+func TestChatPayloads(t *testing.T) {
+	falling := chatAlertRising
+	falling.Price, falling.Message = 195.25, "Price fell below <lower> limit & kept going"
+	first := chatAlertRising
+	first.PrevPrice = 0
+
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		notifier func(appURL, serverURL string) Notifier
+		dest     func(serverURL string) (string, models.ChatDestination)
+		alert    Alert
+		appURL   string
+		lang     string
+	}{
+		{
+			name: "slack", status: http.StatusOK, response: "ok",
+			notifier: func(appURL, _ string) Notifier { return NewSlackNotifier(appURL) },
+			dest: func(serverURL string) (string, models.ChatDestination) {
+				return models.ChannelSlack, models.ChatDestination{Enabled: true, WebhookURL: serverURL + "/services/T000/B000/XXXX"}
+			},
+			alert: chatAlertRising, appURL: "https://app.example.com/",
+		},
+		{
+			name: "slack_falling_no_link", status: http.StatusOK, response: "ok",
+			notifier: func(appURL, _ string) Notifier { return NewSlackNotifier(appURL) },
+			dest: func(serverURL string) (string, models.ChatDestination) {
+				return models.ChannelSlack, models.ChatDestination{Enabled: true, WebhookURL: serverURL + "/services/T000/B000/XXXX"}
+			},
+			alert: falling,
+		},
+		{
+			name: "discord", status: http.StatusNoContent,
+			notifier: func(appURL, _ string) Notifier { return NewDiscordNotifier(appURL) },
+			dest: func(serverURL string) (string, models.ChatDestination) {
+				return models.ChannelDiscord, models.ChatDestination{Enabled: true, WebhookURL: serverURL + "/api/webhooks/1/token"}
+			},
+			alert: falling, appURL: "https://app.example.com",
+		},
+		{
+			name: "discord_first_price", status: http.StatusNoContent,
+			notifier: func(appURL, _ string) Notifier { return NewDiscordNotifier(appURL) },
+			dest: func(serverURL string) (string, models.ChatDestination) {
+				return models.ChannelDiscord, models.ChatDestination{Enabled: true, WebhookURL: serverURL + "/api/webhooks/1/token"}
+			},
+			alert: first, appURL: "https://app.example.com",
+		},
+		{
+			name: "telegram", status: http.StatusOK, response: `{"ok":true,"result":{"message_id":7}}`,
+			notifier: func(appURL, serverURL string) Notifier {
+				n := NewTelegramNotifier(appURL)
+				n.APIURL = serverURL
+				return n
+			},
+			dest: func(string) (string, models.ChatDestination) {
+				return models.ChannelTelegram, models.ChatDestination{Enabled: true, BotToken: telegramTestToken, ChatID: "-1001234567890"}
+			},
+			alert: chatAlertRising, appURL: "https://app.example.com",
+		},
+		{
+			name: "telegram_falling_no_link", status: http.StatusOK, response: `{"ok":true,"result":{"message_id":8}}`,
+			notifier: func(appURL, serverURL string) Notifier {
+				n := NewTelegramNotifier(appURL)
+				n.APIURL = serverURL
+				return n
+			},
+			dest: func(string) (string, models.ChatDestination) {
+				return models.ChannelTelegram, models.ChatDestination{Enabled: true, BotToken: telegramTestToken, ChatID: "@stock_alerts"}
+			},
+			alert: falling,
+		},
+		{
+			name: "telegram_de_eur", status: http.StatusOK, response: `{"ok":true,"result":{"message_id":9}}`,
+			notifier: func(appURL, serverURL string) Notifier {
+				n := NewTelegramNotifier(appURL)
+				n.APIURL = serverURL
+				return n
+			},
+			dest: func(string) (string, models.ChatDestination) {
+				return models.ChannelTelegram, models.ChatDestination{Enabled: true, BotToken: telegramTestToken, ChatID: "42"}
+			},
+			alert: sapRising, appURL: "https://app.example.com", lang: "de",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newChatStandIn(t, tt.status, tt.response)
+			user := &models.User{UserID: "id-dave", Email: "dave@example.com"}
+			user.NotificationPreferences.Language = tt.lang
+			channel, dest := tt.dest(server.URL)
+			switch channel {
+			case models.ChannelSlack:
+				user.NotificationPreferences.Slack = dest
+			case models.ChannelDiscord:
+				user.NotificationPreferences.Discord = dest
+			case models.ChannelTelegram:
+				user.NotificationPreferences.Telegram = dest
+			}
+
+			d := NewDispatcher(tt.notifier(tt.appURL, server.URL))
+			deliveries := d.Dispatch(context.Background(), user, []string{channel}, tt.alert)
+			if deliveries[0].Status != models.DeliverySent {
+				t.Fatalf("delivery = %+v, want sent", deliveries[0])
+			}
+			if channel == models.ChannelTelegram && server.path != "/bot"+telegramTestToken+"/sendMessage" {
+				t.Errorf("posted to %s, want the bot's sendMessage", server.path)
+			}
+			checkGolden(t, tt.name, server.body)
+		})
+	}
+}
+
+func TestChatErrors(t *testing.T) {
+	user := &models.User{Email: "dave@example.com"}
+	user.NotificationPreferences.Telegram = models.ChatDestination{Enabled: true, BotToken: telegramTestToken, ChatID: "42"}
+
+	refused := newChatStandIn(t, http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+	n := NewTelegramNotifier("")
+	n.APIURL = refused.URL
+	if err := n.Notify(context.Background(), user, chatAlertRising); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Notify = %v, want Telegram's description", err)
+	}
+
+	// Connection errors leave out the URL, which holds the bot token
+	refused.Close()
+	if err := n.Notify(context.Background(), user, chatAlertRising); err == nil || strings.Contains(err.Error(), telegramTestToken) {
+		t.Errorf("Notify = %v, want an error without the bot token", err)
+	}
+
+	gone := newChatStandIn(t, http.StatusNotFound, "no_service")
+	user.NotificationPreferences.Slack = models.ChatDestination{Enabled: true, WebhookURL: gone.URL + "/services/x"}
+	if err := NewSlackNotifier("").Notify(context.Background(), user, chatAlertRising); err == nil || !strings.Contains(err.Error(), "no_service") {
+		t.Errorf("Notify = %v, want Slack's reason", err)
+	}
+}
+
+func TestChatPreferences(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	if err := store.CreateUser(ctx, &models.User{UserID: "id-dave", Email: "dave@example.com"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	prefs := NewPreferences(store)
+
+	invalid := []struct {
+		channel string
+		dest    models.ChatDestination
+	}{
+		{models.ChannelSlack, models.ChatDestination{WebhookURL: "https://example.com/services/x"}},
+		{models.ChannelSlack, models.ChatDestination{WebhookURL: "http://hooks.slack.com/services/x"}},
+		{models.ChannelDiscord, models.ChatDestination{WebhookURL: "https://discord.com/channels/1"}},
+		{models.ChannelTelegram, models.ChatDestination{BotToken: "not-a-token", ChatID: "42"}},
+		{models.ChannelTelegram, models.ChatDestination{BotToken: telegramTestToken, ChatID: "my chat"}},
+	}
+	for _, tt := range invalid {
+		if err := prefs.SetChat(ctx, "dave@example.com", tt.channel, tt.dest); !errors.Is(err, ErrInvalidChatDestination) {
+			t.Errorf("SetChat(%s, %+v) = %v, want ErrInvalidChatDestination", tt.channel, tt.dest, err)
+		}
+	}
+	if err := prefs.SetChat(ctx, "dave@example.com", "irc", models.ChatDestination{}); !errors.Is(err, ErrUnknownChatChannel) {
+		t.Errorf("SetChat(irc) = %v, want ErrUnknownChatChannel", err)
+	}
+
+	err := prefs.SetChat(ctx, "dave@example.com", models.ChannelSlack, models.ChatDestination{WebhookURL: "https://hooks.slack.com/services/T0/B0/abc", BotToken: "ignored"})
+	if err != nil {
+		t.Fatalf("SetChat(slack): %v", err)
+	}
+	err = prefs.SetChat(ctx, "dave@example.com", models.ChannelTelegram, models.ChatDestination{BotToken: telegramTestToken, ChatID: "-100123"})
+	if err != nil {
+		t.Fatalf("SetChat(telegram): %v", err)
+	}
+	user, _ := store.GetUserByEmail(ctx, "dave@example.com")
+	if !user.ChannelEnabled(models.ChannelSlack) || !user.ChannelEnabled(models.ChannelTelegram) || user.ChannelEnabled(models.ChannelDiscord) {
+		t.Errorf("prefs = %+v, want Slack and Telegram on", user.NotificationPreferences)
+	}
+	if user.NotificationPreferences.Slack.BotToken != "" {
+		t.Errorf("Slack destination kept a bot token: %+v", user.NotificationPreferences.Slack)
+	}
+	if got := Channels(user, nil); strings.Join(got, ",") != "slack,telegram" {
+		t.Errorf("Channels = %v, want slack and telegram", got)
+	}
+
+	if err := prefs.RemoveChat(ctx, "dave@example.com", models.ChannelSlack); err != nil {
+		t.Fatalf("RemoveChat: %v", err)
+	}
+	user, _ = store.GetUserByEmail(ctx, "dave@example.com")
+	if user.ChannelEnabled(models.ChannelSlack) || user.NotificationPreferences.Slack.WebhookURL != "" {
+		t.Errorf("Slack destination after RemoveChat = %+v", user.NotificationPreferences.Slack)
+	}
+}
@@ -0,0 +1,81 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Counter counts events per key over fixed windows
+type Counter interface {
+	// Increment adds one to key and returns the new count. A key's count
+	// starts again from zero once window has passed since its first
+	// increment.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// MemoryCounter is a Counter for a single instance
+type MemoryCounter struct {
+	counts map[string]*windowCount
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+type windowCount struct {
+	n       int64
+	expires time.Time
+}
+
+// NewMemoryCounter creates an empty counter
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{counts: make(map[string]*windowCount), now: time.Now}
+}
+
+// Increment adds one to key
+func (c *MemoryCounter) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	count, ok := c.counts[key]
+	if !ok || !now.Before(count.expires) {
+		// Drop expired keys while we're here so the map doesn't grow forever
+		for k, v := range c.counts {
+			if !now.Before(v.expires) {
+				delete(c.counts, k)
+			}
+		}
+		count = &windowCount{expires: now.Add(window)}
+		c.counts[key] = count
+	}
+	count.n++
+	return count.n, nil
+}
+
+// RedisCounter is a Counter shared by every instance
+type RedisCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+// DefaultCounterPrefix prefixes the keys of counters in Redis
+const DefaultCounterPrefix = "counter:"
+
+// NewRedisCounter creates a counter whose keys are stored under prefix
+func NewRedisCounter(client *redis.Client, prefix string) *RedisCounter {
+	return &RedisCounter{client: client, prefix: prefix}
+}
+
+// Increment adds one to key
+func (c *RedisCounter) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	pipe := c.client.TxPipeline()
+	incr := pipe.Incr(ctx, c.prefix+key)
+	pipe.ExpireNX(ctx, c.prefix+key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to increment %s: %v", key, err)
+	}
+	return incr.Val(), nil
+}
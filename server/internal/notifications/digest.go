@@ -0,0 +1,402 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/models"
+)
+
+// claimTTL is how long digest claims are kept. Only the last two days'
+// digests are ever sent, so older claims are never checked.
+const claimTTL = 7 * 24 * time.Hour
+
+// DigestStore is the storage digests are put together from and recorded in
+type DigestStore interface {
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	ScanStocks(ctx context.Context, fn func(models.Stock) error) error
+	GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error)
+	ClaimDigest(ctx context.Context, claim *models.DigestClaim) error
+	ReleaseDigest(ctx context.Context, userID, digestID string) error
+}
+
+// Quote is a symbol's latest price and its close on the trading day before
+type Quote struct {
+	Price     float64
+	PrevClose float64
+}
+
+// MarketData supplies the prices and earnings dates digests report
+type MarketData interface {
+	Quote(ctx context.Context, symbol string) (Quote, error)
+	// Earnings returns the dates of a symbol's earnings reports from one
+	// date to another, both included
+	Earnings(ctx context.Context, symbol string, from, to time.Time) ([]time.Time, error)
+}
+
+// Digester emails opted-in users a summary of their portfolio after the
+// markets they hold stocks on close. A daily digest goes out on every day
+// one of those markets trades, a weekly one on the last such day of the
+// week. Each is sent at most once: it is claimed in the store before it is
+// sent, and only released again if sending fails.
+type Digester struct {
+	store    DigestStore
+	calendar *marketcalendar.Calendar
+	market   MarketData
+	email    *EmailService
+	now      func() time.Time
+
+	SendHour     int // Local hour in the user's timezone from which digests go out
+	Movers       int // Number of biggest movers listed
+	EarningsDays int // Days ahead earnings reports are listed for
+}
+
+// NewDigester creates a digester that sends from 18:00 in each user's
+// timezone
+func NewDigester(store DigestStore, calendar *marketcalendar.Calendar, market MarketData, email *EmailService) *Digester {
+	return &Digester{
+		store:        store,
+		calendar:     calendar,
+		market:       market,
+		email:        email,
+		now:          time.Now,
+		SendHour:     18,
+		Movers:       3,
+		EarningsDays: 7,
+	}
+}
+
+// Run sends the digests that are due every interval until ctx is done
+func (d *Digester) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sent, err := d.SendDue(ctx)
+		if err != nil {
+			log.Printf("Failed to send digests: %v", err)
+		} else if sent > 0 {
+			log.Printf("Sent %d digests", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends every digest that is due and hasn't been sent, and returns
+// how many it sent. A failure for one user doesn't stop the others; the
+// first error is returned once all have been tried.
+func (d *Digester) SendDue(ctx context.Context) (int, error) {
+	now := d.now()
+
+	// Digests are only for users holding stocks, so their portfolios are
+	// where the users are found
+	portfolios, err := scanPortfolios(ctx, d.store.ScanStocks)
+	if err != nil {
+		return 0, err
+	}
+
+	run := &digestRun{Digester: d, quotes: make(map[string]Quote), earnings: make(map[string][]time.Time)}
+	sent := 0
+	var firstErr error
+	for _, userID := range slices.Sorted(maps.Keys(portfolios)) {
+		n, err := run.sendUser(ctx, userID, portfolios[userID], now)
+		sent += n
+		if err != nil {
+			log.Printf("Failed to send digest to %s: %v", userID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return sent, firstErr
+}
+
+// digestRun caches what users share within one SendDue
+type digestRun struct {
+	*Digester
+	quotes   map[string]Quote       // By symbol
+	earnings map[string][]time.Time // By symbol and first date
+}
+
+// sendUser sends a user the digests of yesterday and today that are due
+func (r *digestRun) sendUser(ctx context.Context, userID string, stocks []models.Stock, now time.Time) (int, error) {
+	user, err := r.store.GetUserByEmail(ctx, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	prefs := user.NotificationPreferences
+	if prefs.Digest != models.DigestDaily && prefs.Digest != models.DigestWeekly {
+		return 0, nil
+	}
+	loc := userLocation(prefs.Timezone)
+
+	sent := 0
+	today := now.In(loc)
+	for _, date := range []time.Time{today.AddDate(0, 0, -1), today} {
+		date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+		if !r.due(prefs.Digest, stocks, date, now) {
+			continue
+		}
+
+		claim := &models.DigestClaim{
+			UserID:    userID,
+			DigestID:  prefs.Digest + ":" + date.Format("2006-01-02"),
+			ClaimedAt: now,
+			ExpiresAt: now.Add(claimTTL).Unix(),
+		}
+		err := r.store.ClaimDigest(ctx, claim)
+		if errors.Is(err, database.ErrConflict) {
+			continue // Already sent
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		if err := r.send(ctx, user, prefs.Digest, stocks, date); err != nil {
+			if releaseErr := r.store.ReleaseDigest(ctx, userID, claim.DigestID); releaseErr != nil {
+				log.Printf("Failed to release digest %s of %s: %v", claim.DigestID, userID, releaseErr)
+			}
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// due reports whether the digest for a local date is to be sent at now. It
+// is from the send hour, once every exchange that traded that day has
+// closed, until the send hour a day later; after that prices have moved
+// on. No digest is sent for a day none of the exchanges traded, and a
+// weekly one only for the last trading day of the week.
+func (r *digestRun) due(frequency string, stocks []models.Stock, date, now time.Time) bool {
+	sendAt := time.Date(date.Year(), date.Month(), date.Day(), r.SendHour, 0, 0, 0, date.Location())
+	if now.Before(sendAt) || !now.Before(sendAt.AddDate(0, 0, 1)) {
+		return false
+	}
+
+	trading, closed := r.traded(stocks, date, now)
+	if !trading || !closed {
+		return false
+	}
+	if frequency == models.DigestWeekly {
+		// Weeks run Monday to Sunday
+		daysLeft := (7 - int(date.Weekday())) % 7
+		for i := 1; i <= daysLeft; i++ {
+			if trading, _ := r.traded(stocks, date.AddDate(0, 0, i), now); trading {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// traded reports whether any of the exchanges the stocks are on traded on
+// a date, and whether all that did have closed by now. Exchanges the
+// calendar doesn't know are taken to trade on weekdays if none of the
+// stocks are on one it knows.
+func (r *digestRun) traded(stocks []models.Stock, date, now time.Time) (trading, closed bool) {
+	known := false
+	closed = true
+	for _, exchange := range exchanges(stocks) {
+		hours, err := r.calendar.Hours(exchange, date)
+		if err != nil {
+			continue
+		}
+		known = true
+		if hours.Trading {
+			trading = true
+			closed = closed && !now.Before(hours.Close)
+		}
+	}
+	if !known {
+		weekday := date.Weekday()
+		return weekday != time.Saturday && weekday != time.Sunday, true
+	}
+	return trading, closed
+}
+
+// send puts a user's digest for a local date together and emails it
+func (r *digestRun) send(ctx context.Context, user *models.User, frequency string, stocks []models.Stock, date time.Time) error {
+	prefs := user.NotificationPreferences
+	digest := DigestNotification{
+		UserID:    user.UserID,
+		Email:     user.Email,
+		Language:  prefs.Language,
+		Frequency: frequency,
+		Date:      date,
+	}
+
+	currencies := make(map[string]string) // By symbol, for the fires
+	values := make(map[string]*DigestValue)
+	for _, stock := range stocks {
+		quote := r.quote(ctx, stock)
+		holding := DigestHolding{
+			Symbol:    stock.Symbol,
+			Name:      stock.Name,
+			Exchange:  stock.Exchange,
+			Currency:  stock.Currency,
+			Quantity:  stock.Quantity,
+			Price:     quote.Price,
+			PrevClose: quote.PrevClose,
+		}
+		digest.Holdings = append(digest.Holdings, holding)
+		currencies[stock.Symbol] = stock.Currency
+
+		if stock.Quantity > 0 {
+			value, ok := values[stock.Currency]
+			if !ok {
+				value = &DigestValue{Currency: stock.Currency}
+				values[stock.Currency] = value
+			}
+			prevClose := quote.PrevClose
+			if prevClose <= 0 {
+				prevClose = quote.Price
+			}
+			value.Value += stock.Quantity * quote.Price
+			value.PrevValue += stock.Quantity * prevClose
+		}
+	}
+	slices.SortFunc(digest.Holdings, func(a, b DigestHolding) int { return strings.Compare(a.Symbol, b.Symbol) })
+	for _, currency := range slices.Sorted(maps.Keys(values)) {
+		digest.Values = append(digest.Values, *values[currency])
+	}
+	digest.Movers = biggestMovers(digest.Holdings, r.Movers)
+
+	since := date
+	if frequency == models.DigestWeekly {
+		since = date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7)) // Monday
+	}
+	fires, err := r.store.GetUserTriggerFires(ctx, user.Email, since, date.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	for _, fire := range fires {
+		digest.Fires = append(digest.Fires, DigestFire{
+			Symbol:   fire.Symbol,
+			Currency: currencies[fire.Symbol],
+			Price:    fire.Price,
+			Message:  fire.Message,
+			At:       fire.FiredAt,
+		})
+	}
+
+	from, to := date.AddDate(0, 0, 1), date.AddDate(0, 0, r.EarningsDays)
+	for _, symbol := range slices.Sorted(maps.Keys(currencies)) {
+		for _, day := range r.upcomingEarnings(ctx, symbol, from, to) {
+			digest.Earnings = append(digest.Earnings, DigestEarnings{Symbol: symbol, Date: day})
+		}
+	}
+	slices.SortStableFunc(digest.Earnings, func(a, b DigestEarnings) int { return a.Date.Compare(b.Date) })
+
+	if err := r.email.SendDigest(ctx, digest); err != nil {
+		return fmt.Errorf("failed to email digest: %v", err)
+	}
+	return nil
+}
+
+// quote returns a stock's quote, or its last stored price if there is none
+func (r *digestRun) quote(ctx context.Context, stock models.Stock) Quote {
+	if quote, ok := r.quotes[stock.Symbol]; ok {
+		return quote
+	}
+	quote, err := r.market.Quote(ctx, stock.Symbol)
+	if err != nil {
+		log.Printf("No quote for %s in digests, using the last price: %v", stock.Symbol, err)
+		quote = Quote{Price: stock.Price}
+	}
+	r.quotes[stock.Symbol] = quote
+	return quote
+}
+
+// upcomingEarnings returns a symbol's earnings dates from one date to
+// another, or none if they can't be fetched
+func (r *digestRun) upcomingEarnings(ctx context.Context, symbol string, from, to time.Time) []time.Time {
+	key := symbol + "|" + from.Format("2006-01-02")
+	if dates, ok := r.earnings[key]; ok {
+		return dates
+	}
+	dates, err := r.market.Earnings(ctx, symbol, from, to)
+	if err != nil {
+		log.Printf("No earnings dates for %s in digests: %v", symbol, err)
+	}
+	r.earnings[key] = dates
+	return dates
+}
+
+// biggestMovers returns up to n holdings that moved on the day, the largest
+// percentage moves first
+func biggestMovers(holdings []DigestHolding, n int) []DigestHolding {
+	move := func(h DigestHolding) float64 { return math.Abs(h.Price/h.PrevClose - 1) }
+	var movers []DigestHolding
+	for _, h := range holdings {
+		if h.PrevClose > 0 && h.Price != h.PrevClose {
+			movers = append(movers, h)
+		}
+	}
+	slices.SortStableFunc(movers, func(a, b DigestHolding) int {
+		if c := move(b) - move(a); c != 0 {
+			return int(math.Copysign(1, c))
+		}
+		return 0
+	})
+	if len(movers) > n {
+		movers = movers[:n]
+	}
+	return movers
+}
+
+// scanPortfolios returns every user's stocks by user ID
+func scanPortfolios(ctx context.Context, scan func(ctx context.Context, fn func(models.Stock) error) error) (map[string][]models.Stock, error) {
+	var mu sync.Mutex
+	portfolios := make(map[string][]models.Stock)
+	err := scan(ctx, func(stock models.Stock) error {
+		mu.Lock()
+		defer mu.Unlock()
+		portfolios[stock.UserID] = append(portfolios[stock.UserID], stock)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return portfolios, nil
+}
+
+// exchanges returns the distinct exchanges stocks are on
+func exchanges(stocks []models.Stock) []string {
+	var codes []string
+	for _, stock := range stocks {
+		if stock.Exchange != "" && !slices.Contains(codes, stock.Exchange) {
+			codes = append(codes, stock.Exchange)
+		}
+	}
+	return codes
+}
+
+// userLocation returns the timezone a user picked, or UTC
+func userLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,229 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/models"
+)
+
+// fakeMarket serves fixed quotes and earnings dates
+type fakeMarket struct {
+	quotes   map[string]Quote
+	earnings map[string][]time.Time
+}
+
+func (m fakeMarket) Quote(ctx context.Context, symbol string) (Quote, error) {
+	quote, ok := m.quotes[symbol]
+	if !ok {
+		return Quote{}, errors.New("no quote")
+	}
+	return quote, nil
+}
+
+func (m fakeMarket) Earnings(ctx context.Context, symbol string, from, to time.Time) ([]time.Time, error) {
+	var dates []time.Time
+	for _, date := range m.earnings[symbol] {
+		if !date.Before(from) && !date.After(to) {
+			dates = append(dates, date)
+		}
+	}
+	return dates, nil
+}
+
+// flakyMailer fails the first fails emails, then records the rest
+type flakyMailer struct {
+	fakeMailer
+	mu    sync.Mutex
+	fails int
+}
+
+func (f *flakyMailer) Send(ctx context.Context, email Email) error {
+	f.mu.Lock()
+	if f.fails > 0 {
+		f.fails--
+		f.mu.Unlock()
+		return errors.New("relay unavailable")
+	}
+	f.mu.Unlock()
+	return f.fakeMailer.Send(ctx, email)
+}
+
+func setupDigests(t *testing.T, frequency, timezone string, mailer Mailer) *Digester {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := &models.User{UserID: "id-alice", Email: "alice@example.com"}
+	user.NotificationPreferences.Digest = frequency
+	user.NotificationPreferences.Timezone = timezone
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for _, stock := range []models.Stock{
+		{StockID: "s1", UserID: "alice@example.com", Symbol: "AAPL", Name: "Apple Inc", Exchange: "NASDAQ", Currency: "USD", Price: 190, Quantity: 10},
+		{StockID: "s2", UserID: "alice@example.com", Symbol: "KO", Name: "Coca-Cola", Exchange: "NYSE", Currency: "USD", Price: 60, Quantity: 5},
+		{StockID: "s3", UserID: "alice@example.com", Symbol: "IBM", Name: "IBM", Exchange: "NYSE", Currency: "USD", Price: 250},
+	} {
+		if err := store.CreateStock(ctx, &stock); err != nil {
+			t.Fatalf("CreateStock: %v", err)
+		}
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	for i, at := range []time.Time{
+		time.Date(2025, 6, 2, 11, 0, 0, 0, ny),
+		time.Date(2025, 6, 3, 14, 0, 0, 0, ny),
+	} {
+		fire := &models.TriggerFire{
+			FireID: string(rune('a' + i)), TriggerID: "t1", UserID: "alice@example.com",
+			Symbol: "AAPL", Exchange: "NASDAQ", Price: 200 + float64(i), Message: "Price exceeded upper limit", FiredAt: at,
+		}
+		if err := store.SaveTriggerFire(ctx, fire); err != nil {
+			t.Fatalf("SaveTriggerFire: %v", err)
+		}
+	}
+
+	calendar, err := marketcalendar.Default()
+	if err != nil {
+		t.Fatalf("Default calendar: %v", err)
+	}
+	market := fakeMarket{
+		quotes: map[string]Quote{
+			"AAPL": {Price: 200, PrevClose: 190},
+			"KO":   {Price: 59, PrevClose: 60},
+			"IBM":  {Price: 250, PrevClose: 250},
+		},
+		earnings: map[string][]time.Time{
+			"KO": {time.Date(2025, 6, 5, 0, 0, 0, 0, time.UTC), time.Date(2025, 7, 22, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	return NewDigester(store, calendar, market, NewEmailService(mailer))
+}
+
+func sendDueAt(t *testing.T, d *Digester, now time.Time) int {
+	t.Helper()
+	d.now = func() time.Time { return now }
+	sent, err := d.SendDue(context.Background())
+	if err != nil {
+		t.Fatalf("SendDue at %s: %v", now, err)
+	}
+	return sent
+}
+
+func TestDigestSentOnceAfterTheClose(t *testing.T) {
+	mailer := &fakeMailer{}
+	d := setupDigests(t, models.DigestDaily, "America/New_York", mailer)
+	ny, _ := time.LoadLocation("America/New_York")
+
+	// Before the send hour only yesterday's digest is due
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 3, 17, 0, 0, 0, ny)); sent != 1 {
+		t.Fatalf("sent %d digests before the send hour, want yesterday's", sent)
+	}
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 3, 18, 30, 0, 0, ny)); sent != 1 {
+		t.Fatalf("sent %d digests after the close, want 1", sent)
+	}
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 3, 23, 0, 0, 0, ny)); sent != 0 {
+		t.Fatalf("sent the digest again")
+	}
+
+	if !strings.Contains(mailer.emails[0].Subject, "2025-06-02") {
+		t.Errorf("first digest has subject %q, want yesterday's", mailer.emails[0].Subject)
+	}
+	email := mailer.emails[1]
+	if email.To.Email != "alice@example.com" || !strings.Contains(email.Subject, "2025-06-03") {
+		t.Errorf("digest went to %s with subject %q", email.To.Email, email.Subject)
+	}
+	for _, want := range []string{
+		"$2,295.00",        // 10 AAPL at 200 and 5 KO at 59
+		"+$95.00 (+4.32%)", // from 2,200.00 at the previous close
+		"AAPL",             // the biggest mover
+		"$201.00",          // today's fire, not yesterday's
+		"KO", "2025-06-05", // earnings within the week
+	} {
+		if !strings.Contains(email.Body, want) {
+			t.Errorf("digest doesn't contain %q:\n%s", want, email.Body)
+		}
+	}
+	for _, unwanted := range []string{"$200.00: ", "2025-07-22"} {
+		if strings.Contains(email.Body, unwanted) {
+			t.Errorf("digest contains %q:\n%s", unwanted, email.Body)
+		}
+	}
+}
+
+func TestDigestSkipsHolidays(t *testing.T) {
+	mailer := &fakeMailer{}
+	d := setupDigests(t, models.DigestDaily, "America/New_York", mailer)
+	ny, _ := time.LoadLocation("America/New_York")
+
+	// Independence Day, after the early close the day before has expired
+	if sent := sendDueAt(t, d, time.Date(2025, 7, 4, 19, 0, 0, 0, ny)); sent != 0 {
+		t.Errorf("sent %d digests on a holiday", sent)
+	}
+	// Saturday
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 7, 19, 0, 0, 0, ny)); sent != 0 {
+		t.Errorf("sent %d digests on a Saturday", sent)
+	}
+}
+
+func TestWeeklyDigestOnTheLastTradingDay(t *testing.T) {
+	mailer := &fakeMailer{}
+	d := setupDigests(t, models.DigestWeekly, "America/New_York", mailer)
+	ny, _ := time.LoadLocation("America/New_York")
+
+	if sent := sendDueAt(t, d, time.Date(2025, 7, 2, 19, 0, 0, 0, ny)); sent != 0 {
+		t.Errorf("sent %d weekly digests on a Wednesday", sent)
+	}
+	// Friday is a holiday, so the week ends on Thursday
+	if sent := sendDueAt(t, d, time.Date(2025, 7, 3, 19, 0, 0, 0, ny)); sent != 1 {
+		t.Fatalf("sent %d weekly digests on the last trading day, want 1", sent)
+	}
+	if !strings.Contains(mailer.emails[0].Subject, "2025-07-03") {
+		t.Errorf("weekly digest has subject %q", mailer.emails[0].Subject)
+	}
+}
+
+func TestDigestFollowsTheUsersTimezone(t *testing.T) {
+	mailer := &fakeMailer{}
+	d := setupDigests(t, models.DigestDaily, "Asia/Kolkata", mailer)
+	ist, _ := time.LoadLocation("Asia/Kolkata")
+
+	// 18:30 in India is before New York opens
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 3, 18, 30, 0, 0, ist)); sent != 0 {
+		t.Errorf("sent %d digests before New York closed", sent)
+	}
+	// New York closed at 01:30 in India
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 4, 2, 0, 0, 0, ist)); sent != 1 {
+		t.Fatalf("sent %d digests after New York closed, want 1", sent)
+	}
+	if !strings.Contains(mailer.emails[0].Subject, "2025-06-03") {
+		t.Errorf("digest has subject %q, want the Indian date of the session", mailer.emails[0].Subject)
+	}
+}
+
+func TestDigestRetriedAfterAFailedSend(t *testing.T) {
+	mailer := &flakyMailer{fails: 1}
+	d := setupDigests(t, models.DigestDaily, "", mailer)
+	now := time.Date(2025, 6, 3, 21, 0, 0, 0, time.UTC)
+
+	d.now = func() time.Time { return now }
+	if sent, err := d.SendDue(context.Background()); err == nil || sent != 0 {
+		t.Fatalf("SendDue with a failing mailer = %d, %v", sent, err)
+	}
+	if sent := sendDueAt(t, d, now.Add(5*time.Minute)); sent != 1 {
+		t.Errorf("sent %d digests once the mailer recovered, want 1", sent)
+	}
+}
+
+func TestDigestNotSentWithoutOptIn(t *testing.T) {
+	mailer := &fakeMailer{}
+	d := setupDigests(t, "", "", mailer)
+	if sent := sendDueAt(t, d, time.Date(2025, 6, 3, 21, 0, 0, 0, time.UTC)); sent != 0 {
+		t.Errorf("sent %d digests to a user who didn't opt in", sent)
+	}
+}
@@ -0,0 +1,91 @@
+package notifications
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// Embed colours by price direction
+const (
+	discordGreen = 0x2ECC71
+	discordRed   = 0xE74C3C
+	discordGrey  = 0x95A5A6
+)
+
+// DiscordNotifier posts alerts as embeds to the user's Discord channel
+// webhook
+type DiscordNotifier struct {
+	client *http.Client
+	appURL string
+}
+
+// NewDiscordNotifier creates a Discord notifier. Embeds link to the
+// symbol's page under appURL, or have no link if it is empty.
+func NewDiscordNotifier(appURL string) *DiscordNotifier {
+	return &DiscordNotifier{client: &http.Client{Timeout: 10 * time.Second}, appURL: appURL}
+}
+
+func (n *DiscordNotifier) Channel() string { return models.ChannelDiscord }
+
+// Notify posts alert to user's Discord webhook
+func (n *DiscordNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	_, err := postChatJSON(ctx, n.client, "Discord", user.NotificationPreferences.Discord.WebhookURL, discordMessage(newChatAlert(alert, user.NotificationPreferences.Language, n.appURL)))
+	return err
+}
+
+// Execute Webhook, https://discord.com/developers/docs/resources/webhook
+type discordPayload struct {
+	Username        string                 `json:"username"`
+	Embeds          []discordEmbed         `json:"embeds"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"` // ISO 8601, shown in each reader's timezone
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"` // Empty, so nothing in an alert pings anyone
+}
+
+// discordMessage lays an alert out as one embed, titled with the symbol and
+// coloured by which way the price moved
+func discordMessage(c chatAlert) discordPayload {
+	color := discordGrey
+	switch c.Direction {
+	case 1:
+		color = discordGreen
+	case -1:
+		color = discordRed
+	}
+	fields := []discordField{{Name: c.PriceLabel, Value: c.Price, Inline: true}}
+	if c.Change != "" {
+		fields = append(fields, discordField{Name: c.ChangeLabel, Value: c.Change, Inline: true})
+	}
+	return discordPayload{
+		Username: "Stockmarket",
+		Embeds: []discordEmbed{{
+			Title:       c.Title,
+			URL:         c.Link,
+			Description: c.Message,
+			Color:       color,
+			Fields:      fields,
+			Timestamp:   c.At.Format(time.RFC3339),
+		}},
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}
+}
@@ -0,0 +1,107 @@
+// Package egress makes HTTP requests to URLs that users supply, such as
+// webhooks and push endpoints, without letting them reach the server's own
+// network.
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned for addresses that aren't publicly routable
+var ErrBlocked = errors.New("address is not publicly routable")
+
+// blockedPrefixes are ranges that aren't publicly routable but that netip
+// doesn't classify
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // This network
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, which can reach private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001::/32"),       // Teredo, which can embed private IPv4
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which can embed private IPv4
+	netip.MustParsePrefix("fec0::/10"),       // Deprecated site-local
+}
+
+// Blocked reports whether addr isn't publicly routable: loopback, private,
+// link-local (which includes cloud metadata services at 169.254.169.254),
+// multicast, unspecified or otherwise reserved
+func Blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL rejects a URL whose host is obviously not public: localhost or
+// an address that is Blocked. Names are only resolved when dialed, where
+// the client from NewClient checks the addresses they resolve to.
+func CheckURL(u *url.URL) error {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%s: %w", host, ErrBlocked)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && Blocked(addr) {
+		return fmt.Errorf("%s: %w", host, ErrBlocked)
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client for user-supplied URLs with the given
+// timeout. It doesn't follow redirects, which could lead anywhere, and
+// doesn't use a proxy. Unless allowPrivate reports true it refuses to
+// connect to an address that is Blocked. The address is checked as the
+// connection is dialed, after names are resolved, so a name that resolves
+// to a public address when it is registered and a private one later can't
+// get around it.
+func NewClient(timeout time.Duration, allowPrivate func() bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%s: %w", address, ErrBlocked)
+			}
+			if Blocked(addrPort.Addr()) {
+				return fmt.Errorf("%s: %w", addrPort.Addr(), ErrBlocked)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBlocked(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":         true,
+		"10.1.2.3":          true,
+		"172.16.0.1":        true,
+		"192.168.1.1":       true,
+		"169.254.169.254":   true,
+		"100.64.0.1":        true,
+		"0.0.0.0":           true,
+		"255.255.255.255":   true,
+		"224.0.0.1":         true,
+		"::1":               true,
+		"fe80::1":           true,
+		"fd00::1":           true,
+		"::ffff:127.0.0.1":  true,
+		"::ffff:10.0.0.1":   true,
+		"64:ff9b::a00:1":    true,
+		"93.184.216.34":     false,
+		"8.8.8.8":           false,
+		"2606:4700::1111":   false,
+		"::ffff:8.8.8.8":    false,
+		"2a00:1450:4001::1": false,
+	} {
+		if got := Blocked(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Blocked(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, blocked := range map[string]bool{
+		"https://localhost/hook":         true,
+		"https://api.localhost./hook":    true,
+		"https://127.0.0.1:8443/hook":    true,
+		"https://[::1]/hook":             true,
+		"http://169.254.169.254/latest":  true,
+		"https://hooks.example.com/hook": false,
+		"https://8.8.8.8/hook":           false,
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckURL(u); errors.Is(err, ErrBlocked) != blocked {
+			t.Errorf("CheckURL(%s) = %v, want blocked %v", raw, err, blocked)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	allow := false
+	client := NewClient(time.Second, func() bool { return allow })
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("Get on loopback = %v, want ErrBlocked", err)
+	}
+
+	allow = true
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get on loopback once allowed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hook" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+			return
+		}
+		t.Errorf("redirect to %s followed", r.URL.Path)
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second, func() bool { return true })
+	resp, err := client.Get(server.URL + "/hook")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status = %d, want the redirect itself", resp.StatusCode)
+	}
+}
@@ -5,114 +5,153 @@ import (
 	"fmt"
 	"time"
 
-	"stockmarket/server/internal/config"
+	"stockmarket/server/internal/models"
 )
 
-// EmailService handles email notifications
+// States of a user's email subscription
+const (
+	SubscriptionConfirmed = "confirmed"
+	SubscriptionPending   = "pending" // Waiting for the user to confirm it
+	SubscriptionNone      = ""
+)
+
+// EmailService sends each user their own notifications by email
 type EmailService struct {
-	topicArn string
-	config   *config.Config
+	mailer Mailer
+
+	Links *UnsubscribeLinks // Signs the unsubscribe link in every email; nil for none
 }
 
 // NewEmailService creates a new email service
-func NewEmailService(cfg *config.Config) (*EmailService, error) {
-	if cfg.SNSTopicName == "" {
-		return nil, fmt.Errorf("SNS topic name not configured")
-	}
+func NewEmailService(mailer Mailer) *EmailService {
+	return &EmailService{mailer: mailer}
+}
 
-	// Create topic if it doesn't exist
-	topicArn, err := CreateTopic(context.Background(), cfg.SNSTopicName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create topic: %v", err)
+// SubscribeUser subscribes a user to email notifications. Only mailers that
+// deliver through subscriptions, such as SNS, need it; for others it does
+// nothing.
+func (s *EmailService) SubscribeUser(ctx context.Context, to Recipient) error {
+	subscriber, ok := s.mailer.(interface {
+		Subscribe(ctx context.Context, to Recipient) (string, error)
+	})
+	if !ok {
+		return nil
+	}
+	if _, err := subscriber.Subscribe(ctx, to); err != nil {
+		return fmt.Errorf("failed to subscribe user: %v", err)
 	}
+	return nil
+}
 
-	return &EmailService{
-		topicArn: topicArn,
-		config:   cfg,
-	}, nil
+// UnsubscribeUser stops a mailer that delivers through subscriptions from
+// emailing a user. For other mailers it does nothing; the user's
+// preferences keep them from being emailed.
+func (s *EmailService) UnsubscribeUser(ctx context.Context, to Recipient) error {
+	unsubscriber, ok := s.mailer.(interface {
+		Unsubscribe(ctx context.Context, to Recipient) error
+	})
+	if !ok {
+		return nil
+	}
+	if err := unsubscriber.Unsubscribe(ctx, to); err != nil {
+		return fmt.Errorf("failed to unsubscribe user: %v", err)
+	}
+	return nil
 }
 
-// SubscribeUser subscribes a user to email notifications
-func (s *EmailService) SubscribeUser(ctx context.Context, email string) error {
-	_, err := SubscribeEmail(ctx, s.topicArn, email)
+// SubscriptionStatus returns the state of a user's email subscription:
+// SubscriptionConfirmed, SubscriptionPending until they confirm it, or
+// SubscriptionNone. Mailers that don't deliver through subscriptions need
+// no confirmation, so every user is confirmed.
+func (s *EmailService) SubscriptionStatus(ctx context.Context, to Recipient) (string, error) {
+	lister, ok := s.mailer.(interface {
+		SubscriptionStatus(ctx context.Context, to Recipient) (string, error)
+	})
+	if !ok {
+		return SubscriptionConfirmed, nil
+	}
+	status, err := lister.SubscriptionStatus(ctx, to)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe user: %v", err)
+		return "", fmt.Errorf("failed to look up subscription: %v", err)
 	}
-	return nil
+	return status, nil
 }
 
 // SendTriggerNotification sends a notification when a trigger is activated
 func (s *EmailService) SendTriggerNotification(ctx context.Context, notification TriggerNotification) error {
-	subject := fmt.Sprintf("Stock Alert: %s", notification.Symbol)
-	message := fmt.Sprintf(
-		"Your stock alert for %s has been triggered!\n\n"+
-			"Symbol: %s\n"+
-			"Current Price: $%.2f\n"+
-			"Trigger Type: %s\n"+
-			"Time: %s\n\n"+
-			"This is an automated message from your Stock Market Alert System.",
-		notification.Symbol,
-		notification.Symbol,
-		notification.Price,
-		notification.TriggerType,
-		time.Now().Format(time.RFC1123),
-	)
-
-	return PublishMessage(ctx, s.topicArn, subject, message)
+	if notification.At.IsZero() {
+		notification.At = time.Now()
+	}
+	notification.Message = alertMessage(notification.Language, notification.TriggerType, notification.Message)
+	to := Recipient{UserID: notification.UserID, Email: notification.Email, Language: notification.Language}
+	return s.send(ctx, to, NotificationTypeTrigger, notification)
 }
 
 // SendWelcomeEmail sends a welcome email to new users
 func (s *EmailService) SendWelcomeEmail(ctx context.Context, notification WelcomeNotification) error {
-	subject := "Welcome to Stock Market Alert System"
-	message := fmt.Sprintf(
-		"Welcome to the Stock Market Alert System!\n\n"+
-			"Hello %s,\n\n"+
-			"Thank you for joining our Stock Market Alert System. You can now:\n"+
-			"- Set up stock price alerts\n"+
-			"- Monitor volume spikes\n"+
-			"- Track technical indicators\n"+
-			"- Receive real-time notifications\n\n"+
-			"Get started by setting up your first alert!\n\n"+
-			"Best regards,\n"+
-			"Stock Market Alert Team",
-		notification.Username,
-	)
-
-	return PublishMessage(ctx, s.topicArn, subject, message)
+	to := Recipient{UserID: notification.UserID, Email: notification.Email, Language: notification.Language}
+	return s.send(ctx, to, NotificationTypeWelcome, notification)
 }
 
-// SendPriceAlert sends a price alert notification
-func (s *EmailService) SendPriceAlert(ctx context.Context, symbol string, currentPrice, targetPrice float64, email string) error {
-	subject := fmt.Sprintf("Price Alert: %s", symbol)
-	message := fmt.Sprintf(
-		"Price Alert for %s\n\n"+
-			"Current Price: $%.2f\n"+
-			"Target Price: $%.2f\n"+
-			"Time: %s\n\n"+
-			"This is an automated message from your Stock Market Alert System.",
-		symbol,
-		currentPrice,
-		targetPrice,
-		time.Now().Format(time.RFC1123),
-	)
-
-	return PublishMessage(ctx, s.topicArn, subject, message)
+// SendPriceAlert sends a price alert notification. currency is the ISO 4217
+// code of the prices.
+func (s *EmailService) SendPriceAlert(ctx context.Context, symbol, currency string, currentPrice, targetPrice float64, to Recipient) error {
+	return s.send(ctx, to, NotificationTypePriceAlert, PriceAlertNotification{
+		Symbol:       symbol,
+		Currency:     currency,
+		CurrentPrice: currentPrice,
+		TargetPrice:  targetPrice,
+		At:           time.Now(),
+	})
 }
 
 // SendVolumeAlert sends a volume alert notification
-func (s *EmailService) SendVolumeAlert(ctx context.Context, symbol string, currentVolume, averageVolume float64, email string) error {
-	subject := fmt.Sprintf("Volume Alert: %s", symbol)
-	message := fmt.Sprintf(
-		"Unusual Volume Alert for %s\n\n"+
-			"Current Volume: %.0f\n"+
-			"Average Volume: %.0f\n"+
-			"Time: %s\n\n"+
-			"This is an automated message from your Stock Market Alert System.",
-		symbol,
-		currentVolume,
-		averageVolume,
-		time.Now().Format(time.RFC1123),
-	)
-
-	return PublishMessage(ctx, s.topicArn, subject, message)
+func (s *EmailService) SendVolumeAlert(ctx context.Context, symbol string, currentVolume, averageVolume float64, to Recipient) error {
+	return s.send(ctx, to, NotificationTypeVolumeAlert, VolumeAlertNotification{
+		Symbol:        symbol,
+		CurrentVolume: currentVolume,
+		AverageVolume: averageVolume,
+		At:            time.Now(),
+	})
+}
+
+// SendWebhookDisabled tells a user one of their webhooks was turned off
+// after failing too many deliveries in a row
+func (s *EmailService) SendWebhookDisabled(ctx context.Context, notification WebhookDisabledNotification) error {
+	if notification.At.IsZero() {
+		notification.At = time.Now()
+	}
+	to := Recipient{UserID: notification.UserID, Email: notification.Email, Language: notification.Language}
+	return s.send(ctx, to, NotificationTypeWebhookDisabled, notification)
+}
+
+// SendDigest sends a user their portfolio digest
+func (s *EmailService) SendDigest(ctx context.Context, notification DigestNotification) error {
+	to := Recipient{UserID: notification.UserID, Email: notification.Email, Language: notification.Language}
+	return s.send(ctx, to, NotificationTypeDigest, notification)
+}
+
+// SendHeldAlerts sends a user the alerts held through their quiet hours in
+// one email
+func (s *EmailService) SendHeldAlerts(ctx context.Context, notification HeldAlertsNotification) error {
+	for i, alert := range notification.Alerts {
+		notification.Alerts[i].Message = alertMessage(notification.Language, alert.TriggerType, alert.Message)
+	}
+	to := Recipient{UserID: notification.UserID, Email: notification.Email, Language: notification.Language}
+	return s.send(ctx, to, NotificationTypeHeld, notification)
+}
+
+// send writes a notification of the given type out in the recipient's
+// language, as plain text and HTML, and emails it with a link to
+// unsubscribe
+func (s *EmailService) send(ctx context.Context, to Recipient, kind NotificationType, data any) error {
+	var unsubscribe string
+	if s.Links != nil {
+		unsubscribe = s.Links.URL(to.Email)
+	}
+	msg, err := templates.render(models.ChannelEmail, kind, to.Language, data, unsubscribe)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, Email{To: to, Subject: msg.Subject, Body: msg.Text, HTML: msg.HTML, Unsubscribe: unsubscribe})
 }
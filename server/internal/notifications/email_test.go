@@ -0,0 +1,274 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+
+	"stockmarket/server/internal/notifications/smtptest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+var (
+	alice = Recipient{UserID: "user-alice", Email: "alice@example.com"}
+	bob   = Recipient{UserID: "user-bob", Email: "bob@example.com"}
+)
+
+func TestSMTPMailerSendsOnlyToTheRecipient(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer server.Close()
+
+	ctx := context.Background()
+	service := NewEmailService(NewSMTPMailer(server.Addr, "alerts@example.com", "", ""))
+	if err := service.SendPriceAlert(ctx, "AAPL", "USD", 190, 200, alice); err != nil {
+		t.Fatalf("SendPriceAlert: %v", err)
+	}
+	notification := TriggerNotification{Symbol: "INFY", Price: 1500, TriggerType: "PRICE_UPPER_LIMIT", UserID: bob.UserID, Email: bob.Email}
+	if err := service.SendTriggerNotification(ctx, notification); err != nil {
+		t.Fatalf("SendTriggerNotification: %v", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("server received %d messages, want 2", len(messages))
+	}
+	for i, want := range []struct {
+		to      string
+		subject string
+		symbol  string
+	}{
+		{alice.Email, "Price Alert: AAPL", "AAPL"},
+		{bob.Email, "Stock Alert: INFY", "INFY"},
+	} {
+		msg := messages[i]
+		if len(msg.To) != 1 || msg.To[0] != want.to {
+			t.Errorf("message %d envelope recipients = %v, want only %s", i, msg.To, want.to)
+		}
+		parsed, err := msg.Parse()
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if got := parsed.Header.Get("To"); got != want.to {
+			t.Errorf("message %d To = %q, want %q", i, got, want.to)
+		}
+		if got := parsed.Header.Get("Subject"); got != want.subject {
+			t.Errorf("message %d Subject = %q, want %q", i, got, want.subject)
+		}
+		parts := readAlternatives(t, parsed)
+		for _, contentType := range []string{"text/plain", "text/html"} {
+			if !strings.Contains(parts[contentType], want.symbol) {
+				t.Errorf("message %d %s part doesn't mention %s:\n%s", i, contentType, want.symbol, parts[contentType])
+			}
+		}
+	}
+}
+
+// readAlternatives returns the decoded parts of a multipart/alternative
+// message by content type
+func readAlternatives(t *testing.T, msg *mail.Message) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("reading %s part: %v", contentType, err)
+		}
+		parts[contentType] = string(body)
+	}
+}
+
+func TestSMTPMailerRejectsBadRecipients(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer server.Close()
+
+	mailer := NewSMTPMailer(server.Addr, "alerts@example.com", "", "")
+	for _, email := range []Email{
+		{Subject: "No recipient"},
+		{To: Recipient{Email: "not an address"}, Subject: "Hi"},
+		{To: alice, Subject: "Hi\r\nBcc: everyone@example.com"},
+	} {
+		if err := mailer.Send(context.Background(), email); err == nil {
+			t.Errorf("Send(%+v) succeeded, want an error", email)
+		}
+	}
+	if got := len(server.Messages()); got != 0 {
+		t.Errorf("server received %d messages, want 0", got)
+	}
+}
+
+// fakeSNS records the requests made to it
+type fakeSNS struct {
+	published     []*sns.PublishInput
+	subscribed    []*sns.SubscribeInput
+	unsubscribed  []string
+	subscriptions []types.Subscription
+}
+
+func (f *fakeSNS) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.published = append(f.published, params)
+	return &sns.PublishOutput{}, nil
+}
+
+func (f *fakeSNS) Subscribe(ctx context.Context, params *sns.SubscribeInput, optFns ...func(*sns.Options)) (*sns.SubscribeOutput, error) {
+	f.subscribed = append(f.subscribed, params)
+	return &sns.SubscribeOutput{SubscriptionArn: aws.String("arn:sub:" + aws.ToString(params.Endpoint))}, nil
+}
+
+func (f *fakeSNS) Unsubscribe(ctx context.Context, params *sns.UnsubscribeInput, optFns ...func(*sns.Options)) (*sns.UnsubscribeOutput, error) {
+	f.unsubscribed = append(f.unsubscribed, aws.ToString(params.SubscriptionArn))
+	return &sns.UnsubscribeOutput{}, nil
+}
+
+// ListSubscriptionsByTopic returns the subscriptions one page at a time
+func (f *fakeSNS) ListSubscriptionsByTopic(ctx context.Context, params *sns.ListSubscriptionsByTopicInput, optFns ...func(*sns.Options)) (*sns.ListSubscriptionsByTopicOutput, error) {
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(*params.NextToken)
+	}
+	if page >= len(f.subscriptions) {
+		return &sns.ListSubscriptionsByTopicOutput{}, nil
+	}
+	output := &sns.ListSubscriptionsByTopicOutput{Subscriptions: f.subscriptions[page : page+1]}
+	if page+1 < len(f.subscriptions) {
+		output.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return output, nil
+}
+
+func TestSNSMailerFiltersOnUserID(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeSNS{}
+	mailer := NewSNSMailer(client, "arn:topic")
+	service := NewEmailService(mailer)
+
+	if err := service.SubscribeUser(ctx, alice); err != nil {
+		t.Fatalf("SubscribeUser: %v", err)
+	}
+	if len(client.subscribed) != 1 {
+		t.Fatalf("made %d subscriptions, want 1", len(client.subscribed))
+	}
+	var policy map[string][]string
+	if err := json.Unmarshal([]byte(client.subscribed[0].Attributes["FilterPolicy"]), &policy); err != nil {
+		t.Fatalf("parsing filter policy: %v", err)
+	}
+	if ids := policy[userIDAttribute]; len(ids) != 1 || ids[0] != alice.UserID {
+		t.Errorf("filter policy = %v, want only %s", policy, alice.UserID)
+	}
+
+	if err := service.SendVolumeAlert(ctx, "AAPL", 3e6, 1e6, alice); err != nil {
+		t.Fatalf("SendVolumeAlert: %v", err)
+	}
+	if len(client.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(client.published))
+	}
+	attr := client.published[0].MessageAttributes[userIDAttribute]
+	if got := aws.ToString(attr.StringValue); got != alice.UserID {
+		t.Errorf("message user_id = %q, want %q", got, alice.UserID)
+	}
+
+	// A message without a user ID would reach every unfiltered subscription
+	if err := mailer.Send(ctx, Email{To: Recipient{Email: bob.Email}, Subject: "Hi"}); err == nil {
+		t.Error("Send without a user ID succeeded, want an error")
+	}
+	if len(client.published) != 1 {
+		t.Errorf("published %d messages, want 1", len(client.published))
+	}
+}
+
+func TestSNSMailerUnsubscribes(t *testing.T) {
+	ctx := context.Background()
+	sub := func(arn, protocol, endpoint string) types.Subscription {
+		return types.Subscription{SubscriptionArn: aws.String(arn), Protocol: aws.String(protocol), Endpoint: aws.String(endpoint)}
+	}
+	client := &fakeSNS{subscriptions: []types.Subscription{
+		sub("arn:sub:1", "email", "alice@example.com"),
+		sub("arn:sub:2", "email", bob.Email),
+		sub("arn:sub:3", "sms", "alice@example.com"),
+		sub(pendingConfirmation, "email", "Alice@Example.com"),
+		sub("arn:sub:4", "email", "alice@example.com"),
+	}}
+	service := NewEmailService(NewSNSMailer(client, "arn:topic"))
+
+	if status, err := service.SubscriptionStatus(ctx, alice); err != nil || status != SubscriptionConfirmed {
+		t.Errorf("SubscriptionStatus = %q, %v, want confirmed", status, err)
+	}
+	if err := service.UnsubscribeUser(ctx, alice); err != nil {
+		t.Fatalf("UnsubscribeUser: %v", err)
+	}
+	if strings.Join(client.unsubscribed, " ") != "arn:sub:1 arn:sub:4" {
+		t.Errorf("unsubscribed %v, want only alice's confirmed email subscriptions", client.unsubscribed)
+	}
+
+	client.subscriptions = client.subscriptions[1:4]
+	if status, err := service.SubscriptionStatus(ctx, alice); err != nil || status != SubscriptionPending {
+		t.Errorf("SubscriptionStatus before confirming = %q, %v, want pending", status, err)
+	}
+	if status, err := service.SubscriptionStatus(ctx, Recipient{Email: "carol@example.com"}); err != nil || status != SubscriptionNone {
+		t.Errorf("SubscriptionStatus without a subscription = %q, %v, want none", status, err)
+	}
+}
+
+func TestEmailsLinkToUnsubscribe(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer server.Close()
+
+	service := NewEmailService(NewSMTPMailer(server.Addr, "alerts@example.com", "", ""))
+	service.Links = NewUnsubscribeLinks("secret", "https://stocks.example.com/")
+	if err := service.SendPriceAlert(context.Background(), "AAPL", "USD", 190, 200, alice); err != nil {
+		t.Fatalf("SendPriceAlert: %v", err)
+	}
+
+	parsed, err := server.Messages()[0].Parse()
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	link := service.Links.URL(alice.Email)
+	if !strings.HasPrefix(link, "https://stocks.example.com/unsubscribe?token=") {
+		t.Fatalf("link = %q", link)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe"); got != "<"+link+">" {
+		t.Errorf("List-Unsubscribe = %q, want <%s>", got, link)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+	parts := readAlternatives(t, parsed)
+	if !strings.Contains(parts["text/plain"], "Unsubscribe from these emails: "+link) {
+		t.Errorf("text doesn't link to unsubscribe:\n%s", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], `href="`+strings.ReplaceAll(link, "&", "&amp;")+`"`) {
+		t.Errorf("HTML doesn't link to unsubscribe:\n%s", parts["text/html"])
+	}
+}
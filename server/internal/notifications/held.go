@@ -0,0 +1,247 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// releaseBatch is how many held fires are released per poll
+const releaseBatch = 100
+
+// releaseClaimTTL is how long release claims are kept. A release is sent
+// within minutes of its claim, and its fires are no longer held after, so
+// older claims are never checked.
+const releaseClaimTTL = 24 * time.Hour
+
+// maxHeldWrites is how many times a change to a fire's held deliveries is
+// tried against a fire written meanwhile
+const maxHeldWrites = 3
+
+// RunReleases releases held alerts every interval until ctx is done
+func (s *Service) RunReleases(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sent, err := s.ReleaseHeld(ctx)
+		if err != nil {
+			log.Printf("Failed to release held alerts: %v", err)
+		} else if sent > 0 {
+			log.Printf("Released held alerts in %d messages", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseHeld sends the alerts held through quiet hours that have ended,
+// together on each channel, and returns how many channels it sent on. Each
+// release is claimed first, so only one instance sends it; if any alert
+// fails it stays held and the release is tried again next time. A failure
+// for one user doesn't stop the others; the first error is returned once
+// all have been tried.
+func (s *Service) ReleaseHeld(ctx context.Context) (int, error) {
+	now := s.now()
+	fires, err := s.store.GetHeldTriggerFires(ctx, now, releaseBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	// Triggers reference their owner by email
+	byUser := make(map[string][]*models.TriggerFire)
+	for _, fire := range fires {
+		byUser[fire.UserID] = append(byUser[fire.UserID], fire)
+	}
+
+	sent := 0
+	var firstErr error
+	for _, userID := range slices.Sorted(maps.Keys(byUser)) {
+		n, err := s.releaseUser(ctx, userID, byUser[userID], now)
+		sent += n
+		if err != nil {
+			log.Printf("Failed to release held alerts of %s: %v", userID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return sent, firstErr
+}
+
+// releaseUser releases a user's held fires, oldest first, unless they are
+// in quiet hours again, when the fires are held until those end
+func (s *Service) releaseUser(ctx context.Context, userID string, fires []*models.TriggerFire, now time.Time) (int, error) {
+	user, err := s.store.GetUserByEmail(ctx, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		// Nobody is left to release them to
+		return 0, s.holdUntil(ctx, fires, time.Time{})
+	}
+	if err != nil {
+		return 0, err
+	}
+	if until := quietUntil(user.NotificationPreferences, now); !until.IsZero() {
+		return 0, s.holdUntil(ctx, fires, until)
+	}
+
+	slices.SortFunc(fires, func(a, b *models.TriggerFire) int { return a.FiredAt.Compare(b.FiredAt) })
+	held := make(map[string][]*models.TriggerFire) // By channel, oldest first
+	for _, fire := range fires {
+		for _, d := range fire.Deliveries {
+			if d.Status == models.DeliveryHeld {
+				held[d.Channel] = append(held[d.Channel], fire)
+			}
+		}
+	}
+
+	sent := 0
+	for _, channel := range slices.Sorted(maps.Keys(held)) {
+		ok, err := s.release(ctx, user, channel, held[channel], now)
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// holdUntil moves the release of fires' held deliveries to until, or, if
+// until is zero, stops looking to release them
+func (s *Service) holdUntil(ctx context.Context, fires []*models.TriggerFire, until time.Time) error {
+	for _, fire := range fires {
+		err := s.updateHeld(ctx, fire, func(fire *models.TriggerFire) {
+			for i, d := range fire.Deliveries {
+				if d.Status == models.DeliveryHeld {
+					fire.Deliveries[i].HeldUntil = until
+				}
+			}
+			fire.HeldUntil = until
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release sends fires held on one channel and records how each went. It
+// reports whether it sent them, rather than finding them claimed by another
+// instance.
+func (s *Service) release(ctx context.Context, user *models.User, channel string, fires []*models.TriggerFire, now time.Time) (bool, error) {
+	// Once released the fires are no longer held, so the next release on
+	// the channel starts from a different fire
+	claim := &models.ReleaseClaim{
+		UserID:    user.Email,
+		ReleaseID: channel + ":" + fires[0].FireID,
+		ClaimedAt: now,
+		ExpiresAt: now.Add(releaseClaimTTL).Unix(),
+	}
+	err := s.store.ClaimRelease(ctx, claim)
+	if errors.Is(err, database.ErrConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	alerts := make([]Alert, len(fires))
+	for i, fire := range fires {
+		if alerts[i], err = s.heldAlert(ctx, fire); err != nil {
+			s.unclaim(ctx, claim)
+			return false, err
+		}
+	}
+	deliveries := s.dispatcher.DispatchHeld(ctx, user, channel, alerts)
+
+	var failure error
+	for i, fire := range fires {
+		if deliveries[i].Status == models.DeliveryFailed {
+			// Stays held for the next release
+			failure = errors.New(deliveries[i].Error)
+			continue
+		}
+		err := s.updateHeld(ctx, fire, func(fire *models.TriggerFire) {
+			for j, d := range fire.Deliveries {
+				if d.Channel == channel {
+					fire.Deliveries[j] = deliveries[i]
+				}
+			}
+			fire.HeldUntil = fire.NextRelease()
+		})
+		if err != nil {
+			return true, err
+		}
+	}
+	if failure != nil {
+		s.unclaim(ctx, claim)
+		return false, failure
+	}
+	return true, nil
+}
+
+// updateHeld applies change to a fire and stores it. A delivery attempt at
+// the fire's other channels may write it meanwhile, so the change is
+// applied again to what is stored then, rather than writing over the
+// attempt.
+func (s *Service) updateHeld(ctx context.Context, fire *models.TriggerFire, change func(fire *models.TriggerFire)) error {
+	for attempt := 1; ; attempt++ {
+		change(fire)
+		err := s.store.UpdateTriggerFire(ctx, fire)
+		if !errors.Is(err, database.ErrConflict) || attempt == maxHeldWrites {
+			return err
+		}
+		if fire, err = s.store.GetTriggerFire(ctx, fire.FireID); err != nil {
+			return err
+		}
+	}
+}
+
+// unclaim lets a release that didn't go out be tried again
+func (s *Service) unclaim(ctx context.Context, claim *models.ReleaseClaim) {
+	if err := s.store.UnclaimRelease(ctx, claim.UserID, claim.ReleaseID); err != nil {
+		log.Printf("Failed to unclaim release %s of %s: %v", claim.ReleaseID, claim.UserID, err)
+	}
+}
+
+// heldAlert rebuilds the alert of a held fire from the fire and its
+// trigger.
+func (s *Service) heldAlert(ctx context.Context, fire *models.TriggerFire) (Alert, error) {
+	alert := Alert{
+		FireID:    fire.FireID,
+		TriggerID: fire.TriggerID,
+		UserID:    fire.UserID,
+		Symbol:    fire.Symbol,
+		Exchange:  fire.Exchange,
+		Price:     fire.Price,
+		PrevPrice: fire.PrevPrice,
+		Message:   fire.Message,
+		At:        fire.FiredAt,
+	}
+	trigger, err := s.store.GetTrigger(ctx, fire.TriggerID)
+	if errors.Is(err, database.ErrNotFound) {
+		return alert, nil
+	}
+	if err != nil {
+		return alert, err
+	}
+	alert.TriggerType = trigger.Type
+	stock, err := s.store.GetStock(ctx, trigger.UserID, trigger.StockID)
+	switch {
+	case err == nil:
+		alert.Currency = stock.Currency
+	case !errors.Is(err, database.ErrNotFound):
+		return alert, err
+	}
+	return alert, nil
+}
@@ -0,0 +1,221 @@
+package notifications
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultLanguage is used for users who haven't picked one
+const DefaultLanguage = "en"
+
+// ErrUnsupportedLanguage is returned for languages notifications can't be
+// written in
+var ErrUnsupportedLanguage = errors.New("unsupported language")
+
+// locale is how numbers are written in a language
+type locale struct {
+	decimal       string
+	group         string
+	minGrouping   int  // Digits ahead of the last three needed before they are grouped
+	indian        bool // Group in twos above the thousands: 12,34,567
+	currencyAfter bool // 1.234,56 € rather than €1,234.56
+}
+
+var locales = map[string]locale{
+	"en": {decimal: ".", group: ",", minGrouping: 1},
+	"de": {decimal: ",", group: ".", minGrouping: 1, currencyAfter: true},
+	"es": {decimal: ",", group: ".", minGrouping: 2, currencyAfter: true},
+	"fr": {decimal: ",", group: "\u202f", minGrouping: 1, currencyAfter: true},
+	"hi": {decimal: ".", group: ",", minGrouping: 1, indian: true},
+}
+
+// SupportedLanguages are the languages notifications can be written in
+var SupportedLanguages = []string{"en", "de", "es", "fr", "hi"}
+
+// ValidateLanguage checks notifications can be written in lang
+func ValidateLanguage(lang string) error {
+	if _, ok := locales[lang]; !ok {
+		return fmt.Errorf("%w %q, pick one of %s", ErrUnsupportedLanguage, lang, strings.Join(SupportedLanguages, ", "))
+	}
+	return nil
+}
+
+// language returns lang if it is supported and the default otherwise
+func language(lang string) string {
+	if _, ok := locales[lang]; ok {
+		return lang
+	}
+	return DefaultLanguage
+}
+
+// currency is how an ISO 4217 currency is shown
+type currency struct {
+	symbol   string
+	decimals int
+}
+
+var currencies = map[string]currency{
+	"USD": {"$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"INR": {"₹", 2},
+	"JPY": {"¥", 0},
+	"CNY": {"CN¥", 2},
+	"HKD": {"HK$", 2},
+	"CAD": {"CA$", 2},
+	"AUD": {"A$", 2},
+	"SGD": {"S$", 2},
+	"KRW": {"₩", 0},
+	"CHF": {"CHF", 2},
+}
+
+// formatNumber writes v with the given number of decimals the way lang
+// does
+func formatNumber(lang string, v float64, decimals int) string {
+	loc := locales[language(lang)]
+	digits := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(digits, ".")
+
+	var b strings.Builder
+	if v < 0 && strings.Trim(digits, "0.") != "" {
+		b.WriteString("-")
+	}
+	if len(whole)-3 < loc.minGrouping {
+		b.WriteString(whole)
+	} else {
+		// The last three digits form one group, the rest groups of three,
+		// or of two in the Indian system
+		head, tail := whole[:len(whole)-3], whole[len(whole)-3:]
+		size := 3
+		if loc.indian {
+			size = 2
+		}
+		var groups []string
+		for len(head) > size {
+			groups = append([]string{head[len(head)-size:]}, groups...)
+			head = head[:len(head)-size]
+		}
+		groups = append([]string{head}, groups...)
+		b.WriteString(strings.Join(append(groups, tail), loc.group))
+	}
+	if frac != "" {
+		b.WriteString(loc.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// formatQuantity writes a number of shares with the decimals it has, up to
+// four
+func formatQuantity(lang string, v float64) string {
+	decimals := 0
+	for ; decimals < 4; decimals++ {
+		scaled := v * math.Pow10(decimals)
+		if math.Abs(scaled-math.Round(scaled)) < 1e-9 {
+			break
+		}
+	}
+	return formatNumber(lang, v, decimals)
+}
+
+// formatMoney writes amount in the ISO 4217 currency code the way lang
+// does. Without a currency only the amount is written.
+func formatMoney(lang string, amount float64, code string) string {
+	if code == "" {
+		return formatNumber(lang, amount, 2)
+	}
+	cur, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		cur = currency{symbol: strings.ToUpper(code), decimals: 2}
+	}
+	number := formatNumber(lang, math.Abs(amount), cur.decimals)
+	sign := ""
+	if amount < 0 && math.Round(-amount*math.Pow10(cur.decimals)) != 0 {
+		sign = "-"
+	}
+	if locales[language(lang)].currencyAfter {
+		return sign + number + "\u00a0" + cur.symbol
+	}
+	// Codes used as symbols are kept apart from the amount
+	if last := []rune(cur.symbol); unicode.IsLetter(last[len(last)-1]) {
+		return sign + cur.symbol + "\u00a0" + number
+	}
+	return sign + cur.symbol + number
+}
+
+// formatChange writes the move from prev to price as an amount and a
+// percentage, both signed, e.g. +3.50 (+1.77%). It is empty when prev is
+// unknown.
+func formatChange(lang string, price, prev float64, code string) string {
+	if prev <= 0 {
+		return ""
+	}
+	change := price - prev
+	sign := ""
+	if change > 0 {
+		sign = "+"
+	}
+	percent := formatNumber(lang, change/prev*100, 2)
+	if change > 0 {
+		percent = "+" + percent
+	}
+	return fmt.Sprintf("%s%s (%s%%)", sign, formatMoney(lang, change, code), percent)
+}
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// catalogs holds the strings of each language by key
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	catalogs := make(map[string]map[string]string)
+	for _, lang := range SupportedLanguages {
+		data, err := localeFS.ReadFile(path.Join("locales", lang+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("missing catalog for %s: %v", lang, err))
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("invalid catalog for %s: %v", lang, err))
+		}
+		catalogs[lang] = catalog
+	}
+	return catalogs
+}
+
+// translate returns the string for key in lang, or in the default language
+// if lang doesn't have it, formatted with args. ok is false if no language
+// has it.
+func translate(lang, key string, args ...any) (s string, ok bool) {
+	format, ok := catalogs[language(lang)][key]
+	if !ok {
+		format, ok = catalogs[DefaultLanguage][key]
+	}
+	if !ok {
+		return key, false
+	}
+	if len(args) == 0 {
+		return format, true
+	}
+	return fmt.Sprintf(format, args...), true
+}
+
+// alertMessage describes what set a trigger off in lang. Triggers word
+// their messages in English, so other languages describe the trigger type
+// instead where they can.
+func alertMessage(lang, triggerType, message string) string {
+	if language(lang) != DefaultLanguage || message == "" {
+		if s, ok := translate(lang, "trigger_type."+triggerType); ok {
+			return s
+		}
+	}
+	return message
+}
@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+func TestFormatMoney(t *testing.T) {
+	for _, tt := range []struct {
+		lang     string
+		amount   float64
+		currency string
+		want     string
+	}{
+		{"en", 1234.5, "USD", "$1,234.50"},
+		{"en", -0.004, "USD", "$0.00"},
+		{"en", -12.5, "GBP", "-£12.50"},
+		{"en", 1234.5, "", "1,234.50"},
+		{"en", 150000.4, "JPY", "¥150,000"},
+		{"en", 99.5, "CHF", "CHF\u00a099.50"},
+		{"en", 12, "XYZ", "XYZ\u00a012.00"},
+		{"de", 1234.5, "EUR", "1.234,50\u00a0€"},
+		{"de", 1234567.891, "usd", "1.234.567,89\u00a0$"},
+		{"es", 1234, "EUR", "1234,00\u00a0€"},
+		{"es", 12345, "EUR", "12.345,00\u00a0€"},
+		{"fr", 1234.5, "EUR", "1\u202f234,50\u00a0€"},
+		{"hi", 1234567.8, "INR", "₹12,34,567.80"},
+		{"hi", 999, "INR", "₹999.00"},
+		{"xx", 1234.5, "USD", "$1,234.50"},
+	} {
+		if got := formatMoney(tt.lang, tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatMoney(%q, %v, %q) = %q, want %q", tt.lang, tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestFormatChange(t *testing.T) {
+	for _, tt := range []struct {
+		lang        string
+		price, prev float64
+		currency    string
+		want        string
+	}{
+		{"en", 201, 197.5, "", "+3.50 (+1.77%)"},
+		{"en", 195, 200, "USD", "-$5.00 (-2.50%)"},
+		{"de", 1234.5, 1200, "EUR", "+34,50\u00a0€ (+2,88%)"},
+		{"en", 201, 0, "USD", ""},
+	} {
+		if got := formatChange(tt.lang, tt.price, tt.prev, tt.currency); got != tt.want {
+			t.Errorf("formatChange(%q, %v, %v, %q) = %q, want %q", tt.lang, tt.price, tt.prev, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestFormatQuantity(t *testing.T) {
+	for _, tt := range []struct {
+		lang string
+		v    float64
+		want string
+	}{
+		{"en", 10, "10"},
+		{"en", 1500, "1,500"},
+		{"en", 12.5, "12.5"},
+		{"de", 0.1234, "0,1234"},
+		{"en", 1.23456, "1.2346"},
+	} {
+		if got := formatQuantity(tt.lang, tt.v); got != tt.want {
+			t.Errorf("formatQuantity(%q, %v) = %q, want %q", tt.lang, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestValidateLanguage(t *testing.T) {
+	for _, lang := range SupportedLanguages {
+		if err := ValidateLanguage(lang); err != nil {
+			t.Errorf("ValidateLanguage(%q) = %v", lang, err)
+		}
+	}
+	for _, lang := range []string{"", "EN", "pt"} {
+		if err := ValidateLanguage(lang); !errors.Is(err, ErrUnsupportedLanguage) {
+			t.Errorf("ValidateLanguage(%q) = %v, want ErrUnsupportedLanguage", lang, err)
+		}
+	}
+}
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for _, lang := range SupportedLanguages {
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := catalogs[lang][key]; !ok {
+				t.Errorf("%s catalog is missing %s", lang, key)
+			}
+		}
+		for key := range catalogs[lang] {
+			if _, ok := catalogs[DefaultLanguage][key]; !ok {
+				t.Errorf("%s catalog has %s, which en doesn't", lang, key)
+			}
+		}
+	}
+}
+
+func TestEmailTemplatesRenderInEveryLanguage(t *testing.T) {
+	at := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	notifications := map[NotificationType]any{
+		NotificationTypeTrigger: TriggerNotification{
+			Symbol: "SAP", Exchange: "XETR", Currency: "EUR", Price: 1234.5, PrevPrice: 1200,
+			TriggerType: "PRICE_UPPER_LIMIT", Message: "Price exceeded upper limit", At: at,
+		},
+		NotificationTypePriceAlert:      PriceAlertNotification{Symbol: "SAP", Currency: "EUR", CurrentPrice: 1234.5, TargetPrice: 1200, At: at},
+		NotificationTypeVolumeAlert:     VolumeAlertNotification{Symbol: "SAP", CurrentVolume: 3e6, AverageVolume: 1e6, At: at},
+		NotificationTypeWelcome:         WelcomeNotification{Email: "dave@example.com", Username: "dave"},
+		NotificationTypeWebhookDisabled: WebhookDisabledNotification{URL: "https://hooks.example.com/alerts", Failures: 10, LastError: "HTTP 500", At: at},
+		NotificationTypeDigest: DigestNotification{
+			Frequency: models.DigestWeekly, Date: at,
+			Values:   []DigestValue{{Currency: "EUR", Value: 12345, PrevValue: 12000}},
+			Holdings: []DigestHolding{{Symbol: "SAP", Exchange: "XETR", Currency: "EUR", Quantity: 10, Price: 1234.5, PrevClose: 1200}},
+			Movers:   []DigestHolding{{Symbol: "SAP", Exchange: "XETR", Currency: "EUR", Quantity: 10, Price: 1234.5, PrevClose: 1200}},
+			Fires:    []DigestFire{{Symbol: "SAP", Currency: "EUR", Price: 1234.5, Message: "Price exceeded upper limit", At: at}},
+			Earnings: []DigestEarnings{{Symbol: "SAP", Date: at.AddDate(0, 0, 3)}},
+		},
+	}
+	for _, lang := range SupportedLanguages {
+		for kind, data := range notifications {
+			rendered, err := templates.render(models.ChannelEmail, kind, lang, data, "")
+			if err != nil {
+				t.Errorf("render(%s, %s): %v", kind, lang, err)
+				continue
+			}
+			if rendered.Subject == "" || rendered.Text == "" {
+				t.Errorf("%s %s email has subject %q and text %q", lang, kind, rendered.Subject, rendered.Text)
+			}
+			if !strings.Contains(rendered.HTML, `lang="`+lang+`"`) {
+				t.Errorf("%s %s HTML isn't marked as %s:\n%s", lang, kind, lang, rendered.HTML)
+			}
+			// A key shown as is has no string in any catalog
+			for _, body := range []string{rendered.Subject, rendered.Text, rendered.HTML} {
+				for _, prefix := range []string{"label.", "trigger.", "welcome.", "webhook_disabled.", "price_alert.", "volume_alert.", "digest.", "%!"} {
+					if strings.Contains(body, prefix) {
+						t.Errorf("%s %s email contains %q:\n%s", lang, kind, prefix, body)
+					}
+				}
+			}
+		}
+	}
+}
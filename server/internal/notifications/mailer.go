@@ -0,0 +1,66 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"stockmarket/server/internal/config"
+)
+
+// Recipient is the user an email is addressed to
+type Recipient struct {
+	UserID   string
+	Email    string
+	Language string // The email is written in it; the default if empty
+}
+
+// Email is a message for one recipient, in plain text and optionally HTML
+type Email struct {
+	To      Recipient
+	Subject string
+	Body    string // Plain text
+	HTML    string // Alternative to Body for clients that show HTML
+
+	Unsubscribe string // One-click unsubscribe link for mail clients, if any
+}
+
+// validate rejects emails that can't be delivered to exactly their
+// recipient, including header injection through the address or subject
+func (e Email) validate() error {
+	if e.To.Email == "" {
+		return fmt.Errorf("recipient email is required")
+	}
+	if _, err := mail.ParseAddress(e.To.Email); err != nil {
+		return fmt.Errorf("invalid recipient %q: %v", e.To.Email, err)
+	}
+	if strings.ContainsAny(e.To.Email+e.Subject+e.Unsubscribe, "\r\n") {
+		return fmt.Errorf("recipient, subject and unsubscribe link must be a single line")
+	}
+	return nil
+}
+
+// Mailer delivers an email to its recipient only
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// NewMailer creates the mailer selected by EMAIL_BACKEND
+func NewMailer(ctx context.Context, cfg *config.Config) (Mailer, error) {
+	switch cfg.EmailBackend {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPAddr, cfg.EmailFrom, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	case "sns":
+		if err := InitSNS(cfg); err != nil {
+			return nil, err
+		}
+		topicArn, err := CreateTopic(ctx, cfg.SNSTopicName)
+		if err != nil {
+			return nil, err
+		}
+		return NewSNSMailer(GetSNSClient(), topicArn), nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_BACKEND %q", cfg.EmailBackend)
+	}
+}
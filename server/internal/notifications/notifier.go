@@ -0,0 +1,240 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// Alert is a trigger fire to tell a user about
+type Alert struct {
+	FireID      string
+	TriggerID   string
+	TriggerType string
+	UserID      string // The owner as the API identifies them
+	Symbol      string
+	Exchange    string
+	Currency    string // ISO 4217 code of the stock's prices, if known
+	Price       float64
+	PrevPrice   float64 // Price on the tick before, 0 if unknown
+	Message     string
+	At          time.Time
+	Test        bool // A sample the user asked for, which no policy or retry applies to
+}
+
+// Notifier delivers alerts on one channel
+type Notifier interface {
+	// Channel returns the models.Channel* constant the notifier serves
+	Channel() string
+	// Notify delivers alert to user
+	Notify(ctx context.Context, user *models.User, alert Alert) error
+}
+
+// BatchNotifier is a Notifier that can also deliver several alerts as one
+// message, as it does with those held through quiet hours
+type BatchNotifier interface {
+	Notifier
+	// NotifyBatch delivers alerts to user together
+	NotifyBatch(ctx context.Context, user *models.User, alerts []Alert) error
+}
+
+// Dispatcher routes alerts to the notifiers of the channels a trigger asks
+// for and its owner accepts
+type Dispatcher struct {
+	notifiers map[string]Notifier
+
+	Timeout time.Duration // Bounds each channel's delivery
+	Policy  *Policy       // Quiet hours, deduplication and rate limits; nil for none
+}
+
+// NewDispatcher creates a dispatcher over one notifier per channel
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		notifiers: make(map[string]Notifier, len(notifiers)),
+		Timeout:   30 * time.Second,
+	}
+	for _, n := range notifiers {
+		d.notifiers[n.Channel()] = n
+	}
+	return d
+}
+
+// Channels returns the channels to notify user on for a trigger that asks
+// for requested. A trigger without channels uses every channel the user has
+// turned on.
+func Channels(user *models.User, requested []string) []string {
+	if len(requested) > 0 {
+		var channels []string
+		for _, channel := range requested {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		return channels
+	}
+	var channels []string
+	for _, channel := range append([]string{models.ChannelWebSocket, models.ChannelEmail, models.ChannelSMS, models.ChannelWebhook, models.ChannelPush}, ChatChannels...) {
+		if user.ChannelEnabled(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// Dispatch notifies user of alert on each channel concurrently and returns
+// the outcome per channel, in the order of channels. Channels the user has
+// turned off, or that have no notifier, are skipped, as are those whose
+// notifier returns a SkipError. A DeadLetterError is recorded as dead.
+// Before any channel sends, the policy may drop the alert as a duplicate,
+// hold it through quiet hours or skip it over a rate limit, unless it is a
+// test.
+func (d *Dispatcher) Dispatch(ctx context.Context, user *models.User, channels []string, alert Alert) []models.Delivery {
+	policy := d.Policy
+	if alert.Test {
+		policy = nil
+	}
+	var duplicateOf string
+	var policyErr error
+	if policy != nil {
+		duplicateOf, policyErr = policy.duplicateOf(ctx, alert)
+	}
+
+	deliveries := make([]models.Delivery, len(channels))
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		deliveries[i] = models.Delivery{Channel: channel}
+
+		notifier, ok := d.notifiers[channel]
+		switch {
+		case !user.ChannelEnabled(channel):
+			deliveries[i].Status, deliveries[i].Error = models.DeliverySkipped, "turned off by user"
+		case !ok:
+			deliveries[i].Status, deliveries[i].Error = models.DeliverySkipped, "channel not available"
+		case policyErr != nil:
+			deliveries[i].Status, deliveries[i].Error = models.DeliveryFailed, policyErr.Error()
+		case duplicateOf != "":
+			deliveries[i].Status, deliveries[i].Error = models.DeliverySkipped, duplicateReason+duplicateOf
+		default:
+			if policy != nil {
+				status, reason, heldUntil := policy.admit(ctx, user, alert.FireID, channel)
+				if status != "" {
+					deliveries[i].Status, deliveries[i].Error, deliveries[i].HeldUntil = status, reason, heldUntil
+					break
+				}
+			}
+			wg.Add(1)
+			go func(delivery *models.Delivery) {
+				defer wg.Done()
+				d.deliver(ctx, alert.UserID, "trigger "+alert.TriggerID, delivery, func(ctx context.Context) error {
+					return notifier.Notify(ctx, user, alert)
+				})
+			}(&deliveries[i])
+			continue
+		}
+		deliveries[i].At = time.Now()
+	}
+	wg.Wait()
+	return deliveries
+}
+
+// DispatchHeld delivers alerts that were held through user's quiet hours on
+// one channel and returns the outcome per alert. They go as one message if
+// the channel's notifier is a BatchNotifier, and one after the other if
+// not. Releases don't pass the policy, so they aren't counted against the
+// rate limits.
+func (d *Dispatcher) DispatchHeld(ctx context.Context, user *models.User, channel string, alerts []Alert) []models.Delivery {
+	deliveries := make([]models.Delivery, len(alerts))
+	notifier, ok := d.notifiers[channel]
+	skip := ""
+	switch {
+	case !user.ChannelEnabled(channel):
+		skip = "turned off by user"
+	case !ok:
+		skip = "channel not available"
+	}
+	if skip != "" {
+		for i := range deliveries {
+			deliveries[i] = models.Delivery{Channel: channel, Status: models.DeliverySkipped, Error: skip, At: time.Now()}
+		}
+		return deliveries
+	}
+
+	if batcher, ok := notifier.(BatchNotifier); ok {
+		delivery := models.Delivery{Channel: channel}
+		d.deliver(ctx, user.Email, "held alerts", &delivery, func(ctx context.Context) error {
+			return batcher.NotifyBatch(ctx, user, alerts)
+		})
+		for i := range deliveries {
+			deliveries[i] = delivery
+		}
+		return deliveries
+	}
+	for i, alert := range alerts {
+		deliveries[i] = models.Delivery{Channel: channel}
+		d.deliver(ctx, alert.UserID, "trigger "+alert.TriggerID, &deliveries[i], func(ctx context.Context) error {
+			return notifier.Notify(ctx, user, alert)
+		})
+	}
+	return deliveries
+}
+
+// deliver notifies on one channel through notify and records the outcome
+// in delivery
+func (d *Dispatcher) deliver(ctx context.Context, userID, what string, delivery *models.Delivery, notify func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	err := safeNotify(ctx, notify)
+	delivery.At = time.Now()
+	var final statusError
+	if errors.As(err, &final) {
+		delivery.Status, delivery.Error = final.DeliveryStatus(), err.Error()
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to notify %s of %s by %s: %v", userID, what, delivery.Channel, err)
+		delivery.Status, delivery.Error = models.DeliveryFailed, err.Error()
+		return
+	}
+	delivery.Status = models.DeliverySent
+}
+
+// statusError is an error that settles a delivery with a status other than
+// failed, so it is not retried
+type statusError interface {
+	error
+	DeliveryStatus() string
+}
+
+// SkipError is returned by a notifier that decided not to deliver an alert
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string          { return e.Reason }
+func (e *SkipError) DeliveryStatus() string { return models.DeliverySkipped }
+
+// DeadLetterError is returned by a notifier that gave up on an alert after
+// keeping it to be replayed by hand
+type DeadLetterError struct {
+	Reason string
+}
+
+func (e *DeadLetterError) Error() string          { return e.Reason }
+func (e *DeadLetterError) DeliveryStatus() string { return models.DeliveryDead }
+
+// safeNotify keeps a panicking notifier from taking the others down with it
+func safeNotify(ctx context.Context, notify func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("notifier panicked: %v", r)
+		}
+	}()
+	return notify(ctx)
+}
@@ -0,0 +1,222 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/models"
+)
+
+// fakeNotifier records the alerts it is asked to deliver
+type fakeNotifier struct {
+	channel string
+	err     error
+	barrier *sync.WaitGroup // If set, Notify waits until every notifier sharing it has been called
+	panics  bool
+
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (f *fakeNotifier) Channel() string { return f.channel }
+
+func (f *fakeNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	if f.barrier != nil {
+		f.barrier.Done()
+		done := make(chan struct{})
+		go func() {
+			f.barrier.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.panics {
+		panic("boom")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.alerts = append(f.alerts, alert)
+	return f.err
+}
+
+func (f *fakeNotifier) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.alerts)
+}
+
+func newUser(email, sms bool) *models.User {
+	user := &models.User{UserID: "id-alice", Email: "alice@example.com"}
+	user.NotificationPreferences.WebSocket = true
+	user.NotificationPreferences.Email = email
+	user.NotificationPreferences.SMS = sms
+	user.NotificationPreferences.Phone = "+15555550100"
+	user.NotificationPreferences.PhoneVerified = sms
+	return user
+}
+
+func TestChannels(t *testing.T) {
+	user := newUser(true, false)
+	if got := Channels(user, nil); len(got) != 2 || got[0] != models.ChannelWebSocket || got[1] != models.ChannelEmail {
+		t.Errorf("default channels = %v, want the user's enabled channels", got)
+	}
+	got := Channels(user, []string{models.ChannelSMS, models.ChannelEmail, models.ChannelSMS})
+	if len(got) != 2 || got[0] != models.ChannelSMS || got[1] != models.ChannelEmail {
+		t.Errorf("requested channels = %v, want [sms email]", got)
+	}
+}
+
+func TestDispatcherFansOutAndReportsPerChannel(t *testing.T) {
+	// Neither notifier returns until both have been called, so delivering
+	// one channel after the other would time out
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	socket := &fakeNotifier{channel: models.ChannelWebSocket, barrier: &barrier}
+	email := &fakeNotifier{channel: models.ChannelEmail, err: errors.New("relay down"), barrier: &barrier}
+	sms := &fakeNotifier{channel: models.ChannelSMS}
+	d := NewDispatcher(socket, email, sms)
+	d.Timeout = time.Second
+
+	channels := []string{models.ChannelWebSocket, models.ChannelEmail, models.ChannelSMS, "pigeon"}
+	deliveries := d.Dispatch(context.Background(), newUser(true, false), channels, Alert{TriggerID: "t1"})
+
+	want := []struct{ channel, status string }{
+		{models.ChannelWebSocket, models.DeliverySent},
+		{models.ChannelEmail, models.DeliveryFailed},
+		{models.ChannelSMS, models.DeliverySkipped}, // turned off by the user
+		{"pigeon", models.DeliverySkipped},
+	}
+	if len(deliveries) != len(want) {
+		t.Fatalf("got %d deliveries, want %d", len(deliveries), len(want))
+	}
+	for i, w := range want {
+		got := deliveries[i]
+		if got.Channel != w.channel || got.Status != w.status || got.At.IsZero() {
+			t.Errorf("delivery %d = %+v, want %s %s", i, got, w.channel, w.status)
+		}
+	}
+	if deliveries[1].Error != "relay down" {
+		t.Errorf("email error = %q, want the notifier's error", deliveries[1].Error)
+	}
+	if sms.count() != 0 {
+		t.Error("notified on a channel the user turned off")
+	}
+}
+
+func TestDispatcherRecoversFromPanics(t *testing.T) {
+	socket := &fakeNotifier{channel: models.ChannelWebSocket, panics: true}
+	email := &fakeNotifier{channel: models.ChannelEmail}
+	d := NewDispatcher(socket, email)
+
+	deliveries := d.Dispatch(context.Background(), newUser(true, false), []string{models.ChannelWebSocket, models.ChannelEmail}, Alert{})
+	if deliveries[0].Status != models.DeliveryFailed || deliveries[1].Status != models.DeliverySent {
+		t.Errorf("deliveries = %+v, want the panic recorded as a failure", deliveries)
+	}
+}
+
+func TestDeliverTriggerFireRecordsAndRetriesFailedChannels(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := newUser(true, false)
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stock := &models.Stock{UserID: user.Email, Symbol: "AAPL", Exchange: "NASDAQ"}
+	if err := store.CreateStock(ctx, stock); err != nil {
+		t.Fatalf("CreateStock: %v", err)
+	}
+	trigger := &models.StockTrigger{StockID: stock.StockID, UserID: user.Email, Symbol: "AAPL", Exchange: "NASDAQ", Type: "PRICE_UPPER_LIMIT", IsActive: true}
+	if err := store.CreateTrigger(ctx, trigger); err != nil {
+		t.Fatalf("CreateTrigger: %v", err)
+	}
+
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	email := &fakeNotifier{channel: models.ChannelEmail, err: errors.New("relay down")}
+	service := NewService(store, NewDispatcher(socket, email))
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	fired := events.TriggerFired{
+		FireID:    "fire-1",
+		TriggerID: trigger.TriggerID,
+		UserID:    user.Email,
+		Symbol:    "AAPL",
+		Exchange:  "NASDAQ",
+		Price:     201,
+		Message:   "Price exceeded upper limit",
+		At:        now,
+	}
+	err := store.SaveTriggerFire(ctx, &models.TriggerFire{
+		FireID: fired.FireID, TriggerID: fired.TriggerID, UserID: fired.UserID, Symbol: fired.Symbol, Exchange: fired.Exchange,
+		Price: fired.Price, Message: fired.Message, FiredAt: fired.At, Outbox: models.OutboxPending, NextAttemptAt: fired.At,
+	})
+	if err != nil {
+		t.Fatalf("SaveTriggerFire: %v", err)
+	}
+	if err := service.DeliverTriggerFire(ctx, fired); err != nil {
+		t.Fatalf("DeliverTriggerFire: %v", err)
+	}
+	fire, err := store.GetTriggerFire(ctx, "fire-1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if len(fire.Deliveries) != 2 || fire.Deliveries[0].Status != models.DeliverySent || fire.Deliveries[1].Status != models.DeliveryFailed {
+		t.Fatalf("deliveries after the first attempt = %+v", fire.Deliveries)
+	}
+	if fire.Outbox != models.OutboxPending || !fire.NextAttemptAt.Equal(now.Add(service.RetryDelay)) {
+		t.Fatalf("fire after a failed attempt = %+v, want it retried after the delay", fire)
+	}
+
+	// The redelivered event waits for the retry, which only retries email
+	email.err = nil
+	if err := service.DeliverTriggerFire(ctx, fired); err != nil {
+		t.Fatalf("DeliverTriggerFire again: %v", err)
+	}
+	if email.count() != 1 {
+		t.Fatalf("email notified %d times before the retry was due, want 1", email.count())
+	}
+	now = now.Add(service.RetryDelay)
+	if attempted, err := service.DeliverDue(ctx); err != nil || attempted != 1 {
+		t.Fatalf("DeliverDue = %d, %v, want the fire retried", attempted, err)
+	}
+	if socket.count() != 1 || email.count() != 2 {
+		t.Errorf("websocket notified %d times and email %d times, want 1 and 2", socket.count(), email.count())
+	}
+	fire, err = store.GetTriggerFire(ctx, "fire-1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	for _, d := range fire.Deliveries {
+		if d.Status != models.DeliverySent {
+			t.Errorf("delivery after the retry = %+v, want sent", d)
+		}
+	}
+	if fire.Outbox != "" || fire.Attempts != 2 {
+		t.Errorf("fire after the retry = %+v, want it out of the outbox after 2 attempts", fire)
+	}
+	if alert := email.alerts[1]; alert.TriggerType != "PRICE_UPPER_LIMIT" || alert.Price != 201 || alert.FireID != "fire-1" {
+		t.Errorf("alert = %+v", alert)
+	}
+}
+
+func TestDeliverTriggerFireAcksUnknownFires(t *testing.T) {
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	_, service, _, trigger := setupPolicy(t, newUser(false, false), socket)
+
+	fired := events.TriggerFired{FireID: "missing", TriggerID: trigger.TriggerID, UserID: trigger.UserID, At: time.Now()}
+	if err := service.DeliverTriggerFire(context.Background(), fired); err != nil {
+		t.Fatalf("DeliverTriggerFire = %v, want the unknown fire acked", err)
+	}
+	if socket.count() != 0 {
+		t.Errorf("notified %d times of an unknown fire", socket.count())
+	}
+}
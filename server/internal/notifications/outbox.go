@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+)
+
+// outboxBatch is how many due fires are attempted per poll
+const outboxBatch = 100
+
+// RunOutbox delivers due fires every interval until ctx is done
+func (s *Service) RunOutbox(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		attempted, err := s.DeliverDue(ctx)
+		if err != nil {
+			log.Printf("Failed to deliver trigger fires: %v", err)
+		} else if attempted > 0 {
+			log.Printf("Attempted %d trigger fires from the outbox", attempted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts the pending fires that are due and returns how many
+// it attempted. Fires attempted by another instance meanwhile are left to
+// it. A failure for one fire doesn't stop the others; the first error is
+// returned once all have been tried.
+func (s *Service) DeliverDue(ctx context.Context) (int, error) {
+	fires, err := s.store.GetDueTriggerFires(ctx, s.now(), outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	var firstErr error
+	for _, fire := range fires {
+		if err := s.attempt(ctx, fire); err != nil {
+			log.Printf("Failed to deliver trigger fire %s: %v", fire.FireID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return len(fires), firstErr
+}
+
+// attempt leases a pending fire, so no other instance attempts it at the
+// same time, delivers it and records the outcome. The fire leaves the
+// outbox once no channel failed. Otherwise it is due again after a backoff,
+// or, after the last attempt, its failed channels are dead-lettered. The
+// outcome is only recorded if the fire wasn't written since the lease, by a
+// release or by another instance once the lease ran out; otherwise it is
+// dropped, and the fire is attempted again from what is stored.
+func (s *Service) attempt(ctx context.Context, fire *models.TriggerFire) error {
+	now := s.now()
+	err := s.store.LeaseTriggerFire(ctx, fire, now.Add(s.Lease))
+	if errors.Is(err, database.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// If delivering fails the lease runs out and the fire is attempted
+	// again
+	failed, err := s.deliver(ctx, fire)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case failed == 0:
+		fire.Outbox = ""
+		fire.NextAttemptAt = time.Time{}
+	case fire.Attempts >= s.MaxAttempts:
+		for i, d := range fire.Deliveries {
+			if d.Status == models.DeliveryFailed {
+				fire.Deliveries[i].Status = models.DeliveryDead
+				fire.Deliveries[i].Error = fmt.Sprintf("%s (gave up after %d attempts)", d.Error, fire.Attempts)
+			}
+		}
+		fire.Outbox = ""
+		fire.NextAttemptAt = time.Time{}
+		log.Printf("Gave up on %d channels of trigger fire %s after %d attempts", failed, fire.FireID, fire.Attempts)
+	default:
+		fire.NextAttemptAt = now.Add(s.retryDelay(fire.Attempts))
+	}
+	err = s.store.UpdateTriggerFire(ctx, fire)
+	if errors.Is(err, database.ErrConflict) {
+		log.Printf("Dropped the outcome of delivering trigger fire %s, which was written meanwhile", fire.FireID)
+		return nil
+	}
+	return err
+}
+
+// retryDelay returns the wait after the given attempt: the base delay
+// doubled for each attempt before, capped
+func (s *Service) retryDelay(attempt int) time.Duration {
+	d := s.RetryDelay << (attempt - 1)
+	if d <= 0 || d > s.MaxRetryDelay {
+		d = s.MaxRetryDelay
+	}
+	return d
+}
@@ -0,0 +1,175 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+)
+
+// fireTrigger stores a fire of trigger as the trigger service does, without
+// announcing it
+func fireTrigger(t *testing.T, store *memory.Store, trigger *models.StockTrigger, id string, at time.Time) {
+	t.Helper()
+	fire := &models.TriggerFire{
+		FireID: id, TriggerID: trigger.TriggerID, UserID: trigger.UserID, Symbol: "AAPL", Exchange: "NASDAQ",
+		Price: 201, Message: "Price exceeded upper limit", FiredAt: at, Outbox: models.OutboxPending, NextAttemptAt: at,
+	}
+	trigger.LastTrigger = at
+	if err := store.FireTrigger(context.Background(), trigger, fire); err != nil {
+		t.Fatalf("FireTrigger: %v", err)
+	}
+}
+
+func TestOutboxDeliversFiresThatWereNeverAnnounced(t *testing.T) {
+	ctx := context.Background()
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	store, service, _, trigger := setupPolicy(t, newUser(false, false), socket)
+
+	fireTrigger(t, store, trigger, "f1", time.Now())
+	if attempted, err := service.DeliverDue(ctx); err != nil || attempted != 1 {
+		t.Fatalf("DeliverDue = %d, %v, want 1", attempted, err)
+	}
+	if attempted, err := service.DeliverDue(ctx); err != nil || attempted != 0 {
+		t.Fatalf("DeliverDue again = %d, %v, want nothing left", attempted, err)
+	}
+	if socket.count() != 1 || socket.alerts[0].TriggerType != "PRICE_UPPER_LIMIT" {
+		t.Fatalf("alerts = %+v, want the fire once", socket.alerts)
+	}
+	fire, err := store.GetTriggerFire(ctx, "f1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if fire.Outbox != "" || len(fire.Deliveries) != 1 || fire.Deliveries[0].Status != models.DeliverySent {
+		t.Errorf("fire = %+v, want it sent and out of the outbox", fire)
+	}
+}
+
+func TestOutboxBacksOffAndGivesUp(t *testing.T) {
+	ctx := context.Background()
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	email := &fakeNotifier{channel: models.ChannelEmail, err: errors.New("relay down")}
+	store, service, _, trigger := setupPolicy(t, newUser(true, false), socket, email)
+	service.MaxAttempts = 3
+
+	now := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	fireTrigger(t, store, trigger, "f1", now)
+
+	// Attempts are 30 seconds, then a minute apart
+	for _, wait := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		now = now.Add(wait - time.Second)
+		if attempted, err := service.DeliverDue(ctx); err != nil || (wait > 0 && attempted != 0) {
+			t.Fatalf("DeliverDue before the attempt is due = %d, %v", attempted, err)
+		}
+		now = now.Add(time.Second)
+		if attempted, err := service.DeliverDue(ctx); err != nil || attempted != 1 {
+			t.Fatalf("DeliverDue %v later = %d, %v, want 1", wait, attempted, err)
+		}
+	}
+	if socket.count() != 1 || email.count() != 3 {
+		t.Errorf("websocket notified %d times and email %d times, want 1 and 3", socket.count(), email.count())
+	}
+
+	fire, err := store.GetTriggerFire(ctx, "f1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if fire.Outbox != "" || fire.Attempts != 3 {
+		t.Fatalf("fire = %+v, want it out of the outbox after 3 attempts", fire)
+	}
+	if d := fire.Deliveries[1]; d.Status != models.DeliveryDead || !strings.Contains(d.Error, "relay down (gave up after 3 attempts)") {
+		t.Errorf("email delivery = %+v, want dead", d)
+	}
+	if d := fire.Deliveries[0]; d.Status != models.DeliverySent {
+		t.Errorf("websocket delivery = %+v, want sent", d)
+	}
+}
+
+func TestOutboxDeliversEachFireOnceAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	store, first, _, trigger := setupPolicy(t, newUser(false, false), socket)
+	second := NewService(store, NewDispatcher(socket))
+
+	start := time.Now().Add(-time.Minute)
+	for i := range 20 {
+		fireTrigger(t, store, trigger, fmt.Sprintf("f%02d", i), start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	var wg sync.WaitGroup
+	for _, service := range []*Service{first, second, first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.DeliverDue(ctx); err != nil {
+				t.Errorf("DeliverDue: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]int)
+	for _, alert := range socket.alerts {
+		seen[alert.FireID]++
+	}
+	if len(seen) != 20 || len(socket.alerts) != 20 {
+		t.Errorf("delivered %d alerts for %d fires, want each of the 20 once", len(socket.alerts), len(seen))
+	}
+}
+
+// slowNotifier runs during on its first notification, standing in for what
+// happens elsewhere while it is in flight, and then times out
+type slowNotifier struct {
+	fakeNotifier
+	during func()
+}
+
+func (n *slowNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	if during := n.during; during != nil {
+		n.during = nil
+		during()
+		return errors.New("timed out")
+	}
+	return n.fakeNotifier.Notify(ctx, user, alert)
+}
+
+func TestOutboxDropsTheOutcomeOfAnExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	email := &slowNotifier{fakeNotifier: fakeNotifier{channel: models.ChannelEmail}}
+	store, service, _, trigger := setupPolicy(t, newUser(true, false), email)
+	other := NewService(store, NewDispatcher(email))
+
+	now := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	other.now = func() time.Time { return now.Add(service.Lease + time.Second) }
+	fireTrigger(t, store, trigger, "f1", now)
+
+	// The lease runs out while the first attempt hangs, and another
+	// instance delivers the fire
+	email.during = func() {
+		if attempted, err := other.DeliverDue(ctx); err != nil || attempted != 1 {
+			t.Errorf("DeliverDue on the other instance = %d, %v, want 1", attempted, err)
+		}
+	}
+	if _, err := service.DeliverDue(ctx); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	fire, err := store.GetTriggerFire(ctx, "f1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if d := fire.Deliveries[1]; fire.Outbox != "" || d.Status != models.DeliverySent {
+		t.Errorf("fire = %+v, want the other instance's delivery kept", fire)
+	}
+	if email.count() != 1 {
+		t.Errorf("emailed %d times, want 1", email.count())
+	}
+}
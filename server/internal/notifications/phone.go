@@ -0,0 +1,142 @@
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// Errors confirming a phone number
+var (
+	ErrNoPendingVerification = errors.New("no phone number is waiting to be verified")
+	ErrCodeExpired           = errors.New("verification code has expired")
+	ErrWrongCode             = errors.New("verification code is wrong")
+	ErrTooManyAttempts       = errors.New("too many wrong verification codes")
+	ErrSMSLimit              = errors.New("daily SMS limit reached")
+)
+
+const (
+	codeDigits  = 6
+	codeTTL     = 10 * time.Minute
+	maxAttempts = 5
+)
+
+// UserStore is the storage the phone verifier uses
+type UserStore interface {
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+}
+
+// PhoneVerifier proves users can receive texts on a number before SMS alerts
+// are sent to it, by texting it a one-time code they enter back
+type PhoneVerifier struct {
+	users   UserStore
+	sender  SMSSender
+	limiter *SMSLimiter
+	now     func() time.Time
+}
+
+// NewPhoneVerifier creates a verifier. Codes count towards the daily SMS
+// cap of limiter, which may be nil for no cap.
+func NewPhoneVerifier(users UserStore, sender SMSSender, limiter *SMSLimiter) *PhoneVerifier {
+	return &PhoneVerifier{users: users, sender: sender, limiter: limiter, now: time.Now}
+}
+
+// Start texts a new code to phone for the user with the given email. A
+// number the user already verified keeps receiving alerts until the new one
+// is confirmed.
+func (v *PhoneVerifier) Start(ctx context.Context, userID, phone string) error {
+	if err := ValidatePhone(phone); err != nil {
+		return err
+	}
+	user, err := v.users.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	ok, err := v.limiter.Allow(ctx, userID, v.now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSMSLimit
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+	user.NotificationPreferences.PhoneVerification = &models.PhoneVerification{
+		Phone:     phone,
+		CodeHash:  hashCode(user, code),
+		ExpiresAt: v.now().Add(codeTTL),
+	}
+	if err := v.users.UpdateUser(ctx, user); err != nil {
+		return fmt.Errorf("failed to save verification: %v", err)
+	}
+	text := fmt.Sprintf("Your stockmarket verification code is %s. It expires in %d minutes.", code, int(codeTTL.Minutes()))
+	return v.sender.SendSMS(ctx, phone, text)
+}
+
+// Confirm checks code against the one last sent to the user and, if it
+// matches, turns on SMS alerts to that number
+func (v *PhoneVerifier) Confirm(ctx context.Context, userID, code string) error {
+	user, err := v.users.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	prefs := &user.NotificationPreferences
+	pending := prefs.PhoneVerification
+	if pending == nil {
+		return ErrNoPendingVerification
+	}
+
+	var result error
+	switch {
+	case !v.now().Before(pending.ExpiresAt):
+		prefs.PhoneVerification, result = nil, ErrCodeExpired
+	case subtle.ConstantTimeCompare([]byte(hashCode(user, code)), []byte(pending.CodeHash)) != 1:
+		pending.Attempts++
+		result = ErrWrongCode
+		if pending.Attempts >= maxAttempts {
+			// Make them ask for a new code rather than keep guessing
+			prefs.PhoneVerification, result = nil, ErrTooManyAttempts
+		}
+	default:
+		prefs.Phone = pending.Phone
+		prefs.PhoneVerified = true
+		prefs.SMS = true
+		prefs.PhoneVerification = nil
+	}
+	if err := v.users.UpdateUser(ctx, user); err != nil {
+		return fmt.Errorf("failed to save verification: %v", err)
+	}
+	return result
+}
+
+// newCode returns a random numeric code
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for range codeDigits {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %v", err)
+	}
+	return fmt.Sprintf("%0*d", codeDigits, n), nil
+}
+
+// hashCode hashes code with the user's ID so stored hashes can't be matched
+// across users
+func hashCode(user *models.User, code string) string {
+	sum := sha256.Sum256([]byte(user.UserID + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
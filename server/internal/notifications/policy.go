@@ -0,0 +1,176 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// QuietChannels are the channels that interrupt the user, which hold alerts
+// through quiet hours. The app and webhooks get alerts straight away.
+var QuietChannels = []string{models.ChannelEmail, models.ChannelSMS, models.ChannelPush, models.ChannelSlack, models.ChannelDiscord, models.ChannelTelegram}
+
+// duplicateReason starts the error recorded for a delivery skipped as a
+// duplicate, followed by the ID of the fire it duplicates
+const duplicateReason = "duplicate of fire "
+
+// PolicyStore is the storage the policy finds recent fires in
+type PolicyStore interface {
+	GetUserTriggerFires(ctx context.Context, userID string, since, until time.Time) ([]*models.TriggerFire, error)
+}
+
+// Policy decides, for every channel before it sends, whether an alert goes
+// out now, is held through the user's quiet hours, or is dropped as a
+// duplicate or over a rate limit. Rate limits are counted in a Counter, so
+// they hold across instances when it is shared.
+type Policy struct {
+	store   PolicyStore
+	counter Counter
+	now     func() time.Time
+
+	DedupeWindow time.Duration // An alert identical to one fired this recently is dropped; 0 for no deduplication
+	UserLimit    int           // Alerts per user per hour across channels; 0 for no limit
+	ChannelLimit int           // Alerts per user per hour on each channel; 0 for no limit
+}
+
+// NewPolicy creates a policy that drops identical alerts within 10 minutes
+// and sends each user at most 30 alerts an hour, 10 on any one channel
+func NewPolicy(store PolicyStore, counter Counter) *Policy {
+	return &Policy{
+		store:        store,
+		counter:      counter,
+		now:          time.Now,
+		DedupeWindow: 10 * time.Minute,
+		UserLimit:    30,
+		ChannelLimit: 10,
+	}
+}
+
+// duplicateOf returns the ID of an earlier fire of the user's within the
+// dedupe window with the same symbol, exchange and message as alert, or ""
+// if there is none. Fires that were themselves dropped as duplicates don't
+// count, so an alert repeating for longer than the window is still sent
+// once per window.
+func (p *Policy) duplicateOf(ctx context.Context, alert Alert) (string, error) {
+	if p.DedupeWindow <= 0 {
+		return "", nil
+	}
+	fires, err := p.store.GetUserTriggerFires(ctx, alert.UserID, alert.At.Add(-p.DedupeWindow), alert.At.Add(time.Nanosecond))
+	if err != nil {
+		return "", fmt.Errorf("failed to look for duplicates: %v", err)
+	}
+	for _, fire := range fires {
+		// Of two fires at the same time the one with the lower ID is sent
+		earlier := fire.FiredAt.Before(alert.At) || (fire.FiredAt.Equal(alert.At) && fire.FireID < alert.FireID)
+		if !earlier || fire.FireID == alert.FireID || isDuplicate(fire) {
+			continue
+		}
+		if fire.Symbol == alert.Symbol && fire.Exchange == alert.Exchange && fire.Message == alert.Message {
+			return fire.FireID, nil
+		}
+	}
+	return "", nil
+}
+
+// isDuplicate reports whether fire was dropped as a duplicate. That is
+// decided for the whole alert, so it shows on any channel that could have
+// sent it.
+func isDuplicate(fire *models.TriggerFire) bool {
+	for _, d := range fire.Deliveries {
+		if d.Status == models.DeliverySkipped && strings.HasPrefix(d.Error, duplicateReason) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit decides whether the alert of fire fireID goes out to user on
+// channel now. It returns the status to record instead, with the reason, or
+// "" to send. heldUntil is when held alerts are due to be released. Each
+// fire is counted once per channel, so retries of a failed channel don't
+// use up the limits.
+func (p *Policy) admit(ctx context.Context, user *models.User, fireID, channel string) (status, reason string, heldUntil time.Time) {
+	if slices.Contains(QuietChannels, channel) {
+		if until := quietUntil(user.NotificationPreferences, p.now()); !until.IsZero() {
+			return models.DeliveryHeld, "quiet hours until " + until.UTC().Format(time.RFC3339), until
+		}
+	}
+
+	if fireID != "" && (p.ChannelLimit > 0 || p.UserLimit > 0) {
+		n, err := p.counter.Increment(ctx, "notify:fire:"+fireID+":"+channel, time.Hour)
+		if err != nil {
+			return models.DeliveryFailed, err.Error(), time.Time{}
+		}
+		if n > 1 {
+			return "", "", time.Time{}
+		}
+	}
+
+	// The channel is counted first, so alerts dropped for it don't use up
+	// the user's allowance on other channels
+	if p.ChannelLimit > 0 {
+		n, err := p.counter.Increment(ctx, "notify:"+user.Email+":"+channel, time.Hour)
+		if err != nil {
+			return models.DeliveryFailed, err.Error(), time.Time{}
+		}
+		if n > int64(p.ChannelLimit) {
+			return models.DeliverySkipped, "hourly limit for " + channel + " reached", time.Time{}
+		}
+	}
+	if p.UserLimit > 0 {
+		n, err := p.counter.Increment(ctx, "notify:"+user.Email, time.Hour)
+		if err != nil {
+			return models.DeliveryFailed, err.Error(), time.Time{}
+		}
+		if n > int64(p.UserLimit) {
+			return models.DeliverySkipped, "hourly notification limit reached", time.Time{}
+		}
+	}
+	return "", "", time.Time{}
+}
+
+// quietUntil returns when the quiet hours prefs are in at now end, or the
+// zero time if they aren't in quiet hours
+func quietUntil(prefs models.NotificationPreferences, now time.Time) time.Time {
+	if prefs.QuietHours == nil {
+		return time.Time{}
+	}
+	start, err := parseClock(prefs.QuietHours.Start)
+	if err != nil {
+		return time.Time{}
+	}
+	end, err := parseClock(prefs.QuietHours.End)
+	if err != nil || start == end {
+		return time.Time{}
+	}
+
+	local := now.In(userLocation(prefs.Timezone))
+	minute := local.Hour()*60 + local.Minute()
+	endOn := func(days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, end/60, end%60, 0, 0, local.Location())
+	}
+	switch {
+	case start < end && minute >= start && minute < end:
+		return endOn(0)
+	case start > end && minute >= start:
+		// Runs past midnight into tomorrow
+		return endOn(1)
+	case start > end && minute < end:
+		return endOn(0)
+	}
+	return time.Time{}
+}
+
+// parseClock returns the minutes since midnight of a time of day written
+// HH:MM
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("time of day must be HH:MM, not %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
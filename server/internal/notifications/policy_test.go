@@ -0,0 +1,349 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/models"
+)
+
+// fakeBatchNotifier records alerts delivered one at a time and in batches
+type fakeBatchNotifier struct {
+	fakeNotifier
+	batchMu sync.Mutex
+	batches [][]Alert
+}
+
+func (f *fakeBatchNotifier) NotifyBatch(ctx context.Context, user *models.User, alerts []Alert) error {
+	f.batchMu.Lock()
+	defer f.batchMu.Unlock()
+	f.batches = append(f.batches, alerts)
+	return f.err
+}
+
+func TestQuietUntil(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, tt := range []struct {
+		name       string
+		start, end string
+		timezone   string
+		now        time.Time
+		want       time.Time // Zero if not quiet
+	}{
+		{"overnight, evening", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 2, 23, 0, 0, 0, ny), time.Date(2026, 3, 3, 7, 0, 0, 0, ny)},
+		{"overnight, morning", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 3, 6, 59, 0, 0, ny), time.Date(2026, 3, 3, 7, 0, 0, 0, ny)},
+		{"overnight, at the end", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 3, 7, 0, 0, 0, ny), time.Time{}},
+		{"overnight, daytime", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 3, 12, 0, 0, 0, ny), time.Time{}},
+		{"same day", "12:00", "13:30", "", time.Date(2026, 3, 3, 12, 15, 0, 0, time.UTC), time.Date(2026, 3, 3, 13, 30, 0, 0, time.UTC)},
+		{"user's timezone", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 3, 3, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 7, 0, 0, 0, ny)},
+		{"across the clocks going forward", "22:00", "07:00", "America/New_York", time.Date(2026, 3, 7, 23, 0, 0, 0, ny), time.Date(2026, 3, 8, 7, 0, 0, 0, ny)},
+		{"empty", "10:00", "10:00", "", time.Date(2026, 3, 3, 10, 0, 0, 0, time.UTC), time.Time{}},
+	} {
+		prefs := models.NotificationPreferences{Timezone: tt.timezone, QuietHours: &models.QuietHours{Start: tt.start, End: tt.end}}
+		if got := quietUntil(prefs, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: quietUntil = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := quietUntil(models.NotificationPreferences{}, time.Now()); !got.IsZero() {
+		t.Errorf("quietUntil without quiet hours = %v", got)
+	}
+}
+
+// setupPolicy stores a user with a trigger and returns a service that
+// delivers to the notifiers through a policy without limits
+func setupPolicy(t *testing.T, user *models.User, notifiers ...Notifier) (*memory.Store, *Service, *Policy, *models.StockTrigger) {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stock := &models.Stock{UserID: user.Email, Symbol: "AAPL", Exchange: "NASDAQ", Currency: "USD"}
+	if err := store.CreateStock(ctx, stock); err != nil {
+		t.Fatalf("CreateStock: %v", err)
+	}
+	trigger := &models.StockTrigger{StockID: stock.StockID, UserID: user.Email, Symbol: "AAPL", Exchange: "NASDAQ", Type: "PRICE_UPPER_LIMIT", IsActive: true}
+	if err := store.CreateTrigger(ctx, trigger); err != nil {
+		t.Fatalf("CreateTrigger: %v", err)
+	}
+
+	dispatcher := NewDispatcher(notifiers...)
+	policy := NewPolicy(store, NewMemoryCounter())
+	policy.DedupeWindow, policy.UserLimit, policy.ChannelLimit = 0, 0, 0
+	dispatcher.Policy = policy
+	return store, NewService(store, dispatcher), policy, trigger
+}
+
+// fire stores a fire of trigger as the trigger service does and returns its
+// announcement. The price rose by one since the tick before.
+func fire(t *testing.T, store *memory.Store, trigger *models.StockTrigger, id string, price float64, message string, at time.Time) events.TriggerFired {
+	t.Helper()
+	err := store.SaveTriggerFire(context.Background(), &models.TriggerFire{
+		FireID: id, TriggerID: trigger.TriggerID, UserID: trigger.UserID, Symbol: "AAPL", Exchange: "NASDAQ",
+		Price: price, PrevPrice: price - 1, Message: message, FiredAt: at, Outbox: models.OutboxPending, NextAttemptAt: at,
+	})
+	if err != nil {
+		t.Fatalf("SaveTriggerFire: %v", err)
+	}
+	return events.TriggerFired{
+		FireID: id, TriggerID: trigger.TriggerID, UserID: trigger.UserID,
+		Symbol: "AAPL", Exchange: "NASDAQ", Price: price, PrevPrice: price - 1, Message: message, At: at,
+	}
+}
+
+func TestPolicyDropsDuplicateAlerts(t *testing.T) {
+	ctx := context.Background()
+	email := &fakeNotifier{channel: models.ChannelEmail}
+	store, service, policy, trigger := setupPolicy(t, newUser(true, false), email)
+	policy.DedupeWindow = 10 * time.Minute
+
+	start := time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+	for _, e := range []events.TriggerFired{
+		fire(t, store, trigger, "f1", 201, "Price exceeded upper limit", start),
+		fire(t, store, trigger, "f2", 202, "Price exceeded upper limit", start.Add(time.Minute)),
+		fire(t, store, trigger, "f3", 199, "Price fell below lower limit", start.Add(2*time.Minute)),
+		fire(t, store, trigger, "f4", 203, "Price exceeded upper limit", start.Add(11*time.Minute)),
+	} {
+		if err := service.DeliverTriggerFire(ctx, e); err != nil {
+			t.Fatalf("DeliverTriggerFire %s: %v", e.FireID, err)
+		}
+	}
+
+	if email.count() != 3 {
+		t.Errorf("emailed %d alerts, want 3", email.count())
+	}
+	f2, err := store.GetTriggerFire(ctx, "f2")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if d := f2.Deliveries[1]; d.Status != models.DeliverySkipped || d.Error != "duplicate of fire f1" {
+		t.Errorf("duplicate delivery = %+v", d)
+	}
+	if email.alerts[2].FireID != "f4" {
+		t.Errorf("last alert emailed is %s, want f4 once the window has passed", email.alerts[2].FireID)
+	}
+}
+
+func TestPolicyRateLimits(t *testing.T) {
+	ctx := context.Background()
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	email := &fakeNotifier{channel: models.ChannelEmail}
+	store, service, policy, trigger := setupPolicy(t, newUser(true, false), socket, email)
+	policy.ChannelLimit, policy.UserLimit = 2, 3
+
+	start := time.Now()
+	for i := range 3 {
+		e := fire(t, store, trigger, fmt.Sprintf("f%d", i+1), 201, "Price exceeded upper limit", start.Add(time.Duration(i)*time.Second))
+		if err := service.DeliverTriggerFire(ctx, e); err != nil {
+			t.Fatalf("DeliverTriggerFire: %v", err)
+		}
+	}
+
+	want := map[string][]string{
+		"f1": {models.DeliverySent, models.DeliverySent},
+		"f2": {models.DeliverySent, models.DeliverySkipped},    // over the user's limit
+		"f3": {models.DeliverySkipped, models.DeliverySkipped}, // over both channels' limits
+	}
+	for id, statuses := range want {
+		f, err := store.GetTriggerFire(ctx, id)
+		if err != nil {
+			t.Fatalf("GetTriggerFire: %v", err)
+		}
+		for i, status := range statuses {
+			if f.Deliveries[i].Status != status {
+				t.Errorf("%s %s = %+v, want %s", id, f.Deliveries[i].Channel, f.Deliveries[i], status)
+			}
+		}
+	}
+	if socket.count() != 2 || email.count() != 1 {
+		t.Errorf("sent %d to the websocket and %d by email, want 2 and 1", socket.count(), email.count())
+	}
+}
+
+func TestPolicyCountsRetriesOnce(t *testing.T) {
+	ctx := context.Background()
+	email := &fakeNotifier{channel: models.ChannelEmail, err: errors.New("relay down")}
+	store, service, policy, trigger := setupPolicy(t, newUser(true, false), email)
+	policy.ChannelLimit = 1
+
+	start := time.Now()
+	service.now = func() time.Time { return start }
+	if err := service.DeliverTriggerFire(ctx, fire(t, store, trigger, "f1", 201, "Price exceeded upper limit", start)); err != nil {
+		t.Fatalf("DeliverTriggerFire: %v", err)
+	}
+
+	// The retry is the same alert, so it is still within the limit
+	email.err = nil
+	service.now = func() time.Time { return start.Add(service.RetryDelay) }
+	if _, err := service.DeliverDue(ctx); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+	if f, _ := store.GetTriggerFire(ctx, "f1"); f.Deliveries[1].Status != models.DeliverySent {
+		t.Fatalf("retried delivery = %+v, want sent", f.Deliveries[1])
+	}
+
+	e := fire(t, store, trigger, "f2", 195, "Price fell below lower limit", start.Add(time.Minute))
+	if err := service.DeliverTriggerFire(ctx, e); err != nil {
+		t.Fatalf("DeliverTriggerFire: %v", err)
+	}
+	if f, _ := store.GetTriggerFire(ctx, "f2"); f.Deliveries[1].Status != models.DeliverySkipped {
+		t.Errorf("second alert = %+v, want skipped over the limit", f.Deliveries[1])
+	}
+}
+
+func TestQuietHoursHoldAlertsAndReleaseThemTogether(t *testing.T) {
+	ctx := context.Background()
+	ny, _ := time.LoadLocation("America/New_York")
+	user := newUser(true, false)
+	user.NotificationPreferences.Timezone = "America/New_York"
+	user.NotificationPreferences.QuietHours = &models.QuietHours{Start: "22:00", End: "07:00"}
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	email := &fakeBatchNotifier{fakeNotifier: fakeNotifier{channel: models.ChannelEmail}}
+	store, service, policy, trigger := setupPolicy(t, user, socket, email)
+
+	night := time.Date(2026, 3, 2, 23, 0, 0, 0, ny)
+	policy.now = func() time.Time { return night }
+	for i := range 2 {
+		e := fire(t, store, trigger, fmt.Sprintf("f%d", i+1), 201+float64(i), "Price exceeded upper limit", night.Add(time.Duration(i)*time.Minute))
+		if err := service.DeliverTriggerFire(ctx, e); err != nil {
+			t.Fatalf("DeliverTriggerFire: %v", err)
+		}
+	}
+	if socket.count() != 2 || email.count() != 0 {
+		t.Fatalf("sent %d to the websocket and %d by email during quiet hours, want 2 and 0", socket.count(), email.count())
+	}
+	f1, _ := store.GetTriggerFire(ctx, "f1")
+	if d := f1.Deliveries[1]; d.Status != models.DeliveryHeld || !strings.Contains(d.Error, "2026-03-03T12:00:00Z") {
+		t.Errorf("email delivery during quiet hours = %+v, want held until 07:00 New York time", d)
+	}
+
+	service.now = func() time.Time { return night.Add(30 * time.Minute) }
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 0 {
+		t.Fatalf("ReleaseHeld during quiet hours = %d, %v", sent, err)
+	}
+
+	service.now = func() time.Time { return time.Date(2026, 3, 3, 7, 5, 0, 0, ny) }
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 1 {
+		t.Fatalf("ReleaseHeld after quiet hours = %d, %v, want 1", sent, err)
+	}
+	if len(email.batches) != 1 || len(email.batches[0]) != 2 || email.batches[0][0].FireID != "f1" || email.batches[0][1].TriggerType != "PRICE_UPPER_LIMIT" || email.batches[0][0].PrevPrice != 200 {
+		t.Fatalf("batches = %+v, want both alerts in one", email.batches)
+	}
+	for _, id := range []string{"f1", "f2"} {
+		f, _ := store.GetTriggerFire(ctx, id)
+		if d := f.Deliveries[1]; d.Status != models.DeliverySent {
+			t.Errorf("%s email delivery after release = %+v, want sent", id, d)
+		}
+	}
+
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 0 {
+		t.Errorf("ReleaseHeld again = %d, %v, want nothing left to send", sent, err)
+	}
+}
+
+func TestHeldAlertsWaitForQuietHoursMovedLater(t *testing.T) {
+	ctx := context.Background()
+	user := newUser(true, false)
+	user.NotificationPreferences.QuietHours = &models.QuietHours{Start: "22:00", End: "07:00"}
+	email := &fakeBatchNotifier{fakeNotifier: fakeNotifier{channel: models.ChannelEmail}}
+	store, service, policy, trigger := setupPolicy(t, user, email)
+
+	night := time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC)
+	policy.now = func() time.Time { return night }
+	if err := service.DeliverTriggerFire(ctx, fire(t, store, trigger, "f1", 201, "Price exceeded upper limit", night)); err != nil {
+		t.Fatalf("DeliverTriggerFire: %v", err)
+	}
+	if f, _ := store.GetTriggerFire(ctx, "f1"); !f.HeldUntil.Equal(time.Date(2026, 3, 3, 7, 0, 0, 0, time.UTC)) {
+		t.Fatalf("HeldUntil = %v, want 07:00", f.HeldUntil)
+	}
+
+	// The user sleeps in, so the alert waits for the new end of quiet hours
+	user.NotificationPreferences.QuietHours.End = "09:00"
+	if err := store.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	service.now = func() time.Time { return time.Date(2026, 3, 3, 7, 5, 0, 0, time.UTC) }
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 0 {
+		t.Fatalf("ReleaseHeld during the longer quiet hours = %d, %v", sent, err)
+	}
+	if f, _ := store.GetTriggerFire(ctx, "f1"); !f.HeldUntil.Equal(time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("HeldUntil = %v, want 09:00", f.HeldUntil)
+	}
+
+	service.now = func() time.Time { return time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC) }
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 1 {
+		t.Fatalf("ReleaseHeld after quiet hours = %d, %v, want 1", sent, err)
+	}
+	if f, _ := store.GetTriggerFire(ctx, "f1"); !f.HeldUntil.IsZero() || f.Deliveries[1].Status != models.DeliverySent {
+		t.Fatalf("released fire = %+v, want sent and no longer held", f)
+	}
+}
+
+func TestReleaseDuringARetryIsKept(t *testing.T) {
+	ctx := context.Background()
+	user := newUser(true, false)
+	user.NotificationPreferences.QuietHours = &models.QuietHours{Start: "22:00", End: "07:00"}
+	socket := &slowNotifier{fakeNotifier: fakeNotifier{channel: models.ChannelWebSocket, err: errors.New("down")}}
+	email := &fakeBatchNotifier{fakeNotifier: fakeNotifier{channel: models.ChannelEmail}}
+	store, service, policy, trigger := setupPolicy(t, user, socket, email)
+
+	night := time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC)
+	policy.now = func() time.Time { return night }
+	service.now = func() time.Time { return night }
+	if err := service.DeliverTriggerFire(ctx, fire(t, store, trigger, "f1", 201, "Price exceeded upper limit", night)); err != nil {
+		t.Fatalf("DeliverTriggerFire: %v", err)
+	}
+
+	// The held email is released while the websocket is being retried
+	morning := time.Date(2026, 3, 3, 7, 5, 0, 0, time.UTC)
+	service.now = func() time.Time { return morning }
+	socket.err = nil
+	socket.during = func() {
+		if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 1 {
+			t.Errorf("ReleaseHeld during the retry = %d, %v, want 1", sent, err)
+		}
+	}
+	if _, err := service.DeliverDue(ctx); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	f, err := store.GetTriggerFire(ctx, "f1")
+	if err != nil {
+		t.Fatalf("GetTriggerFire: %v", err)
+	}
+	if d := f.Deliveries[1]; d.Status != models.DeliverySent || !f.HeldUntil.IsZero() {
+		t.Fatalf("fire = %+v, want the released email kept", f)
+	}
+	if sent, err := service.ReleaseHeld(ctx); err != nil || sent != 0 || len(email.batches) != 1 {
+		t.Errorf("ReleaseHeld again = %d, %v after %d batches, want nothing left to send", sent, err, len(email.batches))
+	}
+}
+
+func TestHeldAlertsRender(t *testing.T) {
+	at := time.Date(2026, 3, 2, 23, 0, 0, 0, time.UTC)
+	notification := HeldAlertsNotification{Language: "de", Alerts: []TriggerNotification{
+		{Symbol: "SAP", Exchange: "XETR", Currency: "EUR", Price: 1234.5, Message: "Kurs über Ihrem Limit", At: at},
+		{Symbol: "SAP", Exchange: "XETR", Currency: "EUR", Price: 1240, At: at.Add(time.Minute)},
+	}}
+	email, err := templates.render(models.ChannelEmail, NotificationTypeHeld, "de", notification, "")
+	if err != nil {
+		t.Fatalf("render email: %v", err)
+	}
+	if email.Subject != "2 Alarme aus Ihren Ruhezeiten" || !strings.Contains(email.Text, "SAP (XETR) 1.234,50 €: Kurs über Ihrem Limit") {
+		t.Errorf("email has subject %q and text:\n%s", email.Subject, email.Text)
+	}
+	sms, err := templates.render(models.ChannelSMS, NotificationTypeHeld, "en", notification, "")
+	if err != nil {
+		t.Fatalf("render sms: %v", err)
+	}
+	if got := strings.TrimSpace(sms.Text); got != "2 alerts during quiet hours: SAP €1,234.50; SAP €1,240.00" {
+		t.Errorf("sms = %q", got)
+	}
+}
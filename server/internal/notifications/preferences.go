@@ -0,0 +1,254 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// Errors setting up channels, digests and quiet hours
+var (
+	ErrUnknownChannel    = errors.New("unknown notification channel")
+	ErrChannelNotSetUp   = errors.New("notification channel is not set up")
+	ErrInvalidDigest     = errors.New("invalid digest settings")
+	ErrInvalidQuietHours = errors.New("invalid quiet hours")
+)
+
+// Preferences changes how users want to be notified
+type Preferences struct {
+	users UserStore
+
+	Email *EmailService // Subscribes users to email when they turn it on; nil when there is no mailer
+}
+
+// NewPreferences creates preferences over the user store
+func NewPreferences(users UserStore) *Preferences {
+	return &Preferences{users: users}
+}
+
+// Get returns the notification preferences of the user with the given
+// email
+func (p *Preferences) Get(ctx context.Context, userID string) (*models.User, error) {
+	return p.users.GetUserByEmail(ctx, userID)
+}
+
+// SetChannels turns the channels in the map on or off for the user with
+// the given email, leaving the others as they are. SMS needs a verified
+// phone number and the chat apps a destination before they can be turned
+// on. Turning email on subscribes the user with mailers that need it, and
+// turning it off unsubscribes them.
+func (p *Preferences) SetChannels(ctx context.Context, userID string, channels map[string]bool) error {
+	for channel := range channels {
+		if channel != models.ChannelEmail && channel != models.ChannelWebSocket && channel != models.ChannelSMS &&
+			channel != models.ChannelWebhook && channel != models.ChannelPush && !slices.Contains(ChatChannels, channel) {
+			return fmt.Errorf("%w %q", ErrUnknownChannel, channel)
+		}
+	}
+
+	user, err := p.users.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	prefs := &user.NotificationPreferences
+	wasEmailed := prefs.Email
+	for channel, on := range channels {
+		switch channel {
+		case models.ChannelEmail:
+			prefs.Email = on
+		case models.ChannelWebSocket:
+			prefs.WebSocket = on
+		case models.ChannelSMS:
+			prefs.SMS = on
+		case models.ChannelWebhook:
+			prefs.Webhook = on
+		case models.ChannelPush:
+			prefs.Push = on
+		default:
+			chatDestination(prefs, channel).Enabled = on
+		}
+		if on && !user.ChannelEnabled(channel) {
+			return fmt.Errorf("%w: %s", ErrChannelNotSetUp, setUpFirst(channel))
+		}
+	}
+	if err := p.users.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+
+	switch {
+	case p.Email == nil || prefs.Email == wasEmailed:
+		return nil
+	case prefs.Email:
+		return p.Email.SubscribeUser(ctx, recipient(user))
+	default:
+		return p.Email.UnsubscribeUser(ctx, recipient(user))
+	}
+}
+
+// EmailSubscription returns the state of user's email subscription with
+// mailers that need one, SubscriptionNone if there is no mailer
+func (p *Preferences) EmailSubscription(ctx context.Context, user *models.User) (string, error) {
+	if p.Email == nil {
+		return SubscriptionNone, nil
+	}
+	return p.Email.SubscriptionStatus(ctx, recipient(user))
+}
+
+// setUpFirst says what channel needs before it can be turned on
+func setUpFirst(channel string) string {
+	switch channel {
+	case models.ChannelSMS:
+		return "verify a phone number first"
+	case models.ChannelTelegram:
+		return "set a bot token and chat ID first"
+	default:
+		return "set a " + channel + " webhook URL first"
+	}
+}
+
+// UnsubscribeEmail stops all email to the user with the given email, as
+// the link in every email does: alerts and digests are turned off and the
+// user is unsubscribed with mailers that need it
+func (p *Preferences) UnsubscribeEmail(ctx context.Context, userID string) error {
+	user, err := p.users.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	user.NotificationPreferences.Email = false
+	user.NotificationPreferences.Digest = ""
+	if err := p.users.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	if p.Email == nil {
+		return nil
+	}
+	return p.Email.UnsubscribeUser(ctx, recipient(user))
+}
+
+// recipient addresses emails to user
+func recipient(user *models.User) Recipient {
+	return Recipient{UserID: user.UserID, Email: user.Email, Language: user.NotificationPreferences.Language}
+}
+
+// SetLanguage sets the language notifications to the user with the given
+// email are written in
+func (p *Preferences) SetLanguage(ctx context.Context, userID, lang string) error {
+	if err := ValidateLanguage(lang); err != nil {
+		return err
+	}
+	return p.update(ctx, userID, func(prefs *models.NotificationPreferences) {
+		prefs.Language = lang
+	})
+}
+
+// SetDigest sets how often the user is emailed a portfolio digest, daily,
+// weekly or "" for never, and the IANA timezone it is scheduled in. An
+// empty timezone keeps the user's current one, which is UTC if never set.
+func (p *Preferences) SetDigest(ctx context.Context, userID, frequency, timezone string) error {
+	switch frequency {
+	case models.DigestDaily, models.DigestWeekly, "":
+	default:
+		return fmt.Errorf("%w: frequency must be %s, %s or empty", ErrInvalidDigest, models.DigestDaily, models.DigestWeekly)
+	}
+	if err := validateTimezone(timezone); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDigest, err)
+	}
+	return p.update(ctx, userID, func(prefs *models.NotificationPreferences) {
+		prefs.Digest = frequency
+		if timezone != "" {
+			prefs.Timezone = timezone
+		}
+	})
+}
+
+// SetQuietHours sets the time of day, from start to end as HH:MM in the
+// IANA timezone, when alerts to the user are held. Empty start and end turn
+// quiet hours off. An empty timezone keeps the user's current one, which is
+// UTC if never set.
+func (p *Preferences) SetQuietHours(ctx context.Context, userID, start, end, timezone string) error {
+	var quiet *models.QuietHours
+	if start != "" || end != "" {
+		for _, clock := range []string{start, end} {
+			if _, err := parseClock(clock); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidQuietHours, err)
+			}
+		}
+		if start == end {
+			return fmt.Errorf("%w: start and end are the same", ErrInvalidQuietHours)
+		}
+		quiet = &models.QuietHours{Start: start, End: end}
+	}
+	if err := validateTimezone(timezone); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuietHours, err)
+	}
+	return p.update(ctx, userID, func(prefs *models.NotificationPreferences) {
+		prefs.QuietHours = quiet
+		if timezone != "" {
+			prefs.Timezone = timezone
+		}
+	})
+}
+
+// validateTimezone checks an IANA timezone name, or "" for none
+func validateTimezone(name string) error {
+	// "Local" would be the server's timezone rather than the user's
+	if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		return fmt.Errorf("unknown timezone %q", name)
+	}
+	return nil
+}
+
+// SetChat validates dest and turns on alerts to it on a chat channel
+func (p *Preferences) SetChat(ctx context.Context, userID, channel string, dest models.ChatDestination) error {
+	if err := ValidateChatDestination(channel, dest); err != nil {
+		return err
+	}
+	// Only the fields the channel uses are kept
+	switch channel {
+	case models.ChannelTelegram:
+		dest = models.ChatDestination{BotToken: dest.BotToken, ChatID: dest.ChatID}
+	default:
+		dest = models.ChatDestination{WebhookURL: dest.WebhookURL}
+	}
+	dest.Enabled = true
+	return p.update(ctx, userID, func(prefs *models.NotificationPreferences) {
+		*chatDestination(prefs, channel) = dest
+	})
+}
+
+// RemoveChat forgets the user's destination on a chat channel and turns it
+// off
+func (p *Preferences) RemoveChat(ctx context.Context, userID, channel string) error {
+	if !slices.Contains(ChatChannels, channel) {
+		return ErrUnknownChatChannel
+	}
+	return p.update(ctx, userID, func(prefs *models.NotificationPreferences) {
+		*chatDestination(prefs, channel) = models.ChatDestination{}
+	})
+}
+
+// chatDestination returns the field of prefs that holds the destination
+// on a chat channel
+func chatDestination(prefs *models.NotificationPreferences, channel string) *models.ChatDestination {
+	switch channel {
+	case models.ChannelSlack:
+		return &prefs.Slack
+	case models.ChannelDiscord:
+		return &prefs.Discord
+	default:
+		return &prefs.Telegram
+	}
+}
+
+// update applies change to the user's preferences
+func (p *Preferences) update(ctx context.Context, userID string, change func(prefs *models.NotificationPreferences)) error {
+	user, err := p.users.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+	change(&user.NotificationPreferences)
+	return p.users.UpdateUser(ctx, user)
+}
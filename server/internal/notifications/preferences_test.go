@@ -0,0 +1,85 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+func TestSetChannels(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := newUser(false, false)
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	client := &fakeSNS{}
+	prefs := NewPreferences(store)
+	prefs.Email = NewEmailService(NewSNSMailer(client, "arn:topic"))
+
+	if err := prefs.SetChannels(ctx, user.Email, map[string]bool{"pager": true}); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("SetChannels(pager) = %v, want ErrUnknownChannel", err)
+	}
+	for _, channel := range []string{models.ChannelSMS, models.ChannelSlack} {
+		if err := prefs.SetChannels(ctx, user.Email, map[string]bool{channel: true}); !errors.Is(err, ErrChannelNotSetUp) {
+			t.Errorf("SetChannels(%s) = %v, want ErrChannelNotSetUp", channel, err)
+		}
+	}
+
+	err := prefs.SetChannels(ctx, user.Email, map[string]bool{models.ChannelEmail: true, models.ChannelWebSocket: false, models.ChannelWebhook: true})
+	if err != nil {
+		t.Fatalf("SetChannels: %v", err)
+	}
+	stored, _ := store.GetUserByEmail(ctx, user.Email)
+	p := stored.NotificationPreferences
+	if !p.Email || p.WebSocket || !p.Webhook || p.SMS {
+		t.Errorf("prefs = %+v, want email and webhook on only", p)
+	}
+	if len(client.subscribed) != 1 || aws.ToString(client.subscribed[0].Endpoint) != user.Email {
+		t.Errorf("subscriptions = %+v, want the user subscribed once email is on", client.subscribed)
+	}
+
+	// Leaving email on doesn't subscribe again; turning it off unsubscribes
+	if err := prefs.SetChannels(ctx, user.Email, map[string]bool{models.ChannelEmail: true}); err != nil {
+		t.Fatalf("SetChannels: %v", err)
+	}
+	client.subscriptions = []types.Subscription{{SubscriptionArn: aws.String("arn:sub:1"), Protocol: aws.String("email"), Endpoint: aws.String(user.Email)}}
+	if err := prefs.SetChannels(ctx, user.Email, map[string]bool{models.ChannelEmail: false}); err != nil {
+		t.Fatalf("SetChannels: %v", err)
+	}
+	if len(client.subscribed) != 1 || len(client.unsubscribed) != 1 {
+		t.Errorf("subscribed %d times and unsubscribed %d, want once each", len(client.subscribed), len(client.unsubscribed))
+	}
+}
+
+func TestUnsubscribeEmail(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := newUser(true, false)
+	user.NotificationPreferences.Digest = models.DigestDaily
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	client := &fakeSNS{subscriptions: []types.Subscription{
+		{SubscriptionArn: aws.String("arn:sub:1"), Protocol: aws.String("email"), Endpoint: aws.String(user.Email)},
+	}}
+	prefs := NewPreferences(store)
+	prefs.Email = NewEmailService(NewSNSMailer(client, "arn:topic"))
+
+	if err := prefs.UnsubscribeEmail(ctx, user.Email); err != nil {
+		t.Fatalf("UnsubscribeEmail: %v", err)
+	}
+	stored, _ := store.GetUserByEmail(ctx, user.Email)
+	if p := stored.NotificationPreferences; p.Email || p.Digest != "" || !p.WebSocket {
+		t.Errorf("prefs = %+v, want email and digests off and the app left on", p)
+	}
+	if len(client.unsubscribed) != 1 || client.unsubscribed[0] != "arn:sub:1" {
+		t.Errorf("unsubscribed %v, want the user's subscription", client.unsubscribed)
+	}
+}
@@ -0,0 +1,196 @@
+package notifications
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications/egress"
+	"stockmarket/server/internal/notifications/webpush"
+)
+
+// ErrInvalidPushSubscription is returned when registering a subscription
+// that isn't one a browser's PushManager would report
+var ErrInvalidPushSubscription = errors.New("invalid push subscription")
+
+// PushStore is the storage the push notifier uses
+type PushStore interface {
+	UserStore
+	database.PushSubscriptionRepository
+}
+
+// PushNotifier shows alerts as browser notifications through Web Push. Each
+// alert goes to every browser the user subscribed, and subscriptions the
+// push service reports as gone are forgotten.
+type PushNotifier struct {
+	store  PushStore
+	sender *webpush.Sender
+	appURL string
+
+	AllowHTTP bool // Accept plain http push endpoints, e.g. a local push service in tests
+
+	now func() time.Time
+}
+
+// NewPushNotifier creates a push notifier sending with sender. Notifications
+// open the symbol's page under appURL, or the app's start page if it is
+// empty.
+func NewPushNotifier(store PushStore, sender *webpush.Sender, appURL string) *PushNotifier {
+	return &PushNotifier{store: store, sender: sender, appURL: appURL, now: time.Now}
+}
+
+func (n *PushNotifier) Channel() string { return models.ChannelPush }
+
+// pushMessage is the JSON the app's service worker receives and shows
+type pushMessage struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Tag   string            `json:"tag"`           // Replaces a notification with the same tag, so a retried alert shows once
+	URL   string            `json:"url,omitempty"` // Opened when the notification is clicked
+	Alert evaluationPayload `json:"alert"`
+}
+
+// Notify pushes alert to each of user's subscribed browsers. It succeeds if
+// every browser still subscribed accepted it.
+func (n *PushNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	subs, err := n.store.GetUserPushSubscriptions(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return &SkipError{Reason: "no subscribed browsers"}
+	}
+
+	c := newChatAlert(alert, user.NotificationPreferences.Language, n.appURL)
+	message := pushMessage{
+		Title: c.Title + " " + c.Price,
+		Body:  c.Message,
+		Tag:   alert.FireID,
+		URL:   c.Link,
+		Alert: newEvaluationPayload(alert),
+	}
+	if c.Change != "" {
+		message.Body += "\n" + c.ChangeLabel + ": " + c.Change
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(subs))
+	var wg sync.WaitGroup
+	for i, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = n.sender.Send(ctx, pushSubscription(sub), payload)
+		}()
+	}
+	wg.Wait()
+
+	var gone int
+	var failed []error
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, webpush.ErrGone):
+			gone++
+			n.forget(ctx, subs[i])
+		case err != nil:
+			failed = append(failed, fmt.Errorf("%s: %v", subs[i].SubscriptionID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Join(failed...)
+	}
+	if gone == len(subs) {
+		return &SkipError{Reason: "every subscribed browser has unsubscribed"}
+	}
+	return nil
+}
+
+// forget deletes a subscription the push service no longer knows
+func (n *PushNotifier) forget(ctx context.Context, sub *models.PushSubscription) {
+	err := n.store.DeletePushSubscription(context.WithoutCancel(ctx), sub.UserID, sub.SubscriptionID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		log.Printf("Failed to delete gone push subscription %s of %s: %v", sub.SubscriptionID, sub.UserID, err)
+		return
+	}
+	log.Printf("Deleted push subscription %s of %s, which its push service reports as gone", sub.SubscriptionID, sub.UserID)
+}
+
+// Register adds a browser's subscription for the user with the given email
+// and turns on push alerts. A browser subscribing again replaces its
+// earlier subscription, as the ID is derived from the endpoint. Endpoints
+// on loopback or private addresses are refused unless the sender allows
+// them.
+func (n *PushNotifier) Register(ctx context.Context, userID string, sub webpush.Subscription, device string) (*models.PushSubscription, error) {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Host == "" || u.User != nil || (u.Scheme != "https" && !(n.AllowHTTP && u.Scheme == "http")) {
+		return nil, fmt.Errorf("%w: endpoint must be an absolute https URL", ErrInvalidPushSubscription)
+	}
+	if !n.sender.AllowPrivate {
+		if err := egress.CheckURL(u); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPushSubscription, err)
+		}
+	}
+	if err := sub.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPushSubscription, err)
+	}
+
+	id := sha256.Sum256([]byte(sub.Endpoint))
+	subscription := &models.PushSubscription{
+		UserID:         userID,
+		SubscriptionID: hex.EncodeToString(id[:16]),
+		Endpoint:       sub.Endpoint,
+		P256DH:         sub.P256DH,
+		Auth:           sub.Auth,
+		Device:         device,
+		CreatedAt:      n.now(),
+	}
+	if err := n.store.SavePushSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+
+	user, err := n.store.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.NotificationPreferences.Push {
+		user.NotificationPreferences.Push = true
+		if err := n.store.UpdateUser(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to turn on push alerts: %v", err)
+		}
+	}
+	return subscription, nil
+}
+
+// Subscriptions returns the browsers the user subscribed
+func (n *PushNotifier) Subscriptions(ctx context.Context, userID string) ([]*models.PushSubscription, error) {
+	return n.store.GetUserPushSubscriptions(ctx, userID)
+}
+
+// Delete removes one of the user's subscriptions, as when they sign out of
+// a browser
+func (n *PushNotifier) Delete(ctx context.Context, userID, subscriptionID string) error {
+	return n.store.DeletePushSubscription(ctx, userID, subscriptionID)
+}
+
+// PublicKey returns the VAPID key browsers subscribe with, their
+// applicationServerKey
+func (n *PushNotifier) PublicKey() string {
+	return n.sender.PublicKey()
+}
+
+// pushSubscription returns the stored subscription as webpush sends to it
+func pushSubscription(sub *models.PushSubscription) webpush.Subscription {
+	return webpush.Subscription{Endpoint: sub.Endpoint, P256DH: sub.P256DH, Auth: sub.Auth}
+}
@@ -0,0 +1,160 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications/pushtest"
+	"stockmarket/server/internal/notifications/webpush"
+)
+
+// setupPush stores a user and returns a push notifier sending to a local
+// push service
+func setupPush(t *testing.T) (*memory.Store, *PushNotifier, *pushtest.Server) {
+	t.Helper()
+	store := memory.NewStore()
+	if err := store.CreateUser(context.Background(), newUser(false, false)); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	keys, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := pushtest.NewServer()
+	t.Cleanup(server.Close)
+
+	sender := webpush.NewSender(keys, "mailto:ops@example.com")
+	sender.AllowPrivate = true // The push service listens on loopback
+	notifier := NewPushNotifier(store, sender, "https://app.example.com")
+	notifier.AllowHTTP = true
+	return store, notifier, server
+}
+
+func subscribeBrowser(t *testing.T, notifier *PushNotifier, server *pushtest.Server, device string) webpush.Subscription {
+	t.Helper()
+	sub, err := server.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := notifier.Register(context.Background(), "alice@example.com", sub, device); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return sub
+}
+
+func TestPushNotifierSendsEncryptedAlerts(t *testing.T) {
+	ctx := context.Background()
+	store, notifier, server := setupPush(t)
+	subscribeBrowser(t, notifier, server, "Firefox")
+	subscribeBrowser(t, notifier, server, "Chrome on Android")
+
+	user, _ := store.GetUserByEmail(ctx, "alice@example.com")
+	if !user.ChannelEnabled(models.ChannelPush) {
+		t.Fatal("registering a browser didn't turn on push alerts")
+	}
+	alert := Alert{
+		FireID: "f1", TriggerID: "t1", TriggerType: "PRICE_UPPER_LIMIT", UserID: "alice@example.com",
+		Symbol: "AAPL", Exchange: "NASDAQ", Currency: "USD", Price: 201.5, PrevPrice: 199, At: time.Now(),
+	}
+	if err := notifier.Notify(ctx, user, alert); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("push service got %d messages, want one per browser", len(messages))
+	}
+	for _, m := range messages {
+		if m.Key != notifier.PublicKey() || m.Urgency != "high" || m.TTL != "86400" {
+			t.Errorf("message signed with %q, urgency %q, TTL %q", m.Key, m.Urgency, m.TTL)
+		}
+		var got pushMessage
+		if err := json.Unmarshal(m.Payload, &got); err != nil {
+			t.Fatalf("payload %s: %v", m.Payload, err)
+		}
+		if got.Title != "AAPL (NASDAQ) $201.50" || got.Tag != "f1" || got.URL != "https://app.example.com/stocks/AAPL" ||
+			got.Body != "Price rose above your limit\nChange: +$2.50 (+1.26%)" || got.Alert.TriggerID != "t1" {
+			t.Errorf("message = %+v", got)
+		}
+	}
+}
+
+func TestPushNotifierForgetsGoneSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	store, notifier, server := setupPush(t)
+	gone := subscribeBrowser(t, notifier, server, "Old laptop")
+	subscribeBrowser(t, notifier, server, "Phone")
+	server.Expire(gone)
+
+	user, _ := store.GetUserByEmail(ctx, "alice@example.com")
+	alert := sampleAlert("alice@example.com", time.Now())
+	if err := notifier.Notify(ctx, user, alert); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	subs, _ := notifier.Subscriptions(ctx, "alice@example.com")
+	if len(subs) != 1 || subs[0].Device != "Phone" {
+		t.Fatalf("subscriptions = %+v, want the gone one deleted", subs)
+	}
+	if n := len(server.Messages()); n != 1 {
+		t.Errorf("push service got %d messages, want 1", n)
+	}
+
+	server.Expire(pushSubscription(subs[0]))
+	var skip *SkipError
+	if err := notifier.Notify(ctx, user, alert); !errors.As(err, &skip) {
+		t.Errorf("Notify with every browser gone = %v, want a SkipError", err)
+	}
+	if subs, _ := notifier.Subscriptions(ctx, "alice@example.com"); len(subs) != 0 {
+		t.Errorf("subscriptions = %+v, want none", subs)
+	}
+}
+
+func TestPushNotifierRefusesPrivateAddresses(t *testing.T) {
+	ctx := context.Background()
+	store, notifier, server := setupPush(t)
+	subscribeBrowser(t, notifier, server, "Firefox")
+
+	// The endpoint's address is checked when the message connects
+	notifier.sender.AllowPrivate = false
+	user, _ := store.GetUserByEmail(ctx, "alice@example.com")
+	if err := notifier.Notify(ctx, user, sampleAlert("alice@example.com", time.Now())); err == nil || !strings.Contains(err.Error(), "not publicly routable") {
+		t.Fatalf("Notify = %v, want the loopback endpoint refused", err)
+	}
+	if n := len(server.Messages()); n != 0 {
+		t.Errorf("push service got %d messages, want none", n)
+	}
+}
+
+func TestPushRegisterValidatesSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	_, notifier, server := setupPush(t)
+	sub, _ := server.Subscribe()
+
+	again := subscribeBrowser(t, notifier, server, "Firefox")
+	if _, err := notifier.Register(ctx, "alice@example.com", again, "Firefox, renamed"); err != nil {
+		t.Fatalf("Register again: %v", err)
+	}
+	if subs, _ := notifier.Subscriptions(ctx, "alice@example.com"); len(subs) != 1 || subs[0].Device != "Firefox, renamed" {
+		t.Errorf("subscriptions = %+v, want the browser's subscription replaced", subs)
+	}
+
+	notifier.AllowHTTP = false
+	notifier.sender.AllowPrivate = false
+	for name, bad := range map[string]webpush.Subscription{
+		"http endpoint":     sub,
+		"loopback endpoint": {Endpoint: "https://127.0.0.1/push/1", P256DH: sub.P256DH, Auth: sub.Auth},
+		"metadata endpoint": {Endpoint: "https://169.254.169.254/latest", P256DH: sub.P256DH, Auth: sub.Auth},
+		"bad key":           {Endpoint: "https://push.example.com/1", P256DH: "bm90IGEga2V5", Auth: sub.Auth},
+		"short auth":        {Endpoint: "https://push.example.com/1", P256DH: sub.P256DH, Auth: "c2hvcnQ"},
+	} {
+		if _, err := notifier.Register(ctx, "alice@example.com", bad, ""); !errors.Is(err, ErrInvalidPushSubscription) {
+			t.Errorf("Register with %s = %v, want ErrInvalidPushSubscription", name, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package pushtest is a local push service stand-in. It hands out
+// subscriptions as a browser would, checks the VAPID signature of every
+// message and decrypts it with the subscription's keys, so Web Push can be
+// tested without a browser or a real push service.
+package pushtest
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/notifications/webpush"
+)
+
+// Message is a message the server accepted
+type Message struct {
+	Endpoint string
+	Key      string // The VAPID public key the message was signed with
+	TTL      string
+	Urgency  string
+	Payload  []byte // Decrypted
+}
+
+// browser is what a browser keeps for one of its subscriptions
+type browser struct {
+	private    *ecdh.PrivateKey
+	authSecret []byte
+	gone       bool
+}
+
+// Server is a push service listening on a local port
+type Server struct {
+	URL string
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	browsers map[string]*browser // By endpoint
+	messages []Message
+	nextID   int
+}
+
+// NewServer starts a server on a free local port. Callers should Close it
+// when done.
+func NewServer() *Server {
+	s := &Server{browsers: make(map[string]*browser)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.push))
+	s.URL = s.server.URL
+	return s
+}
+
+// Subscribe creates a subscription as a browser's PushManager would
+func (s *Server) Subscribe() (webpush.Subscription, error) {
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return webpush.Subscription{}, err
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		return webpush.Subscription{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	endpoint := fmt.Sprintf("%s/push/%d", s.URL, s.nextID)
+	s.browsers[endpoint] = &browser{private: private, authSecret: authSecret}
+	return webpush.Subscription{
+		Endpoint: endpoint,
+		P256DH:   base64.RawURLEncoding.EncodeToString(private.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(authSecret),
+	}, nil
+}
+
+// Expire makes the push service answer messages to sub with 410 Gone, as it
+// does once the user revokes permission or the browser drops the
+// subscription
+func (s *Server) Expire(sub webpush.Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.browsers[sub.Endpoint]; ok {
+		b.gone = true
+	}
+}
+
+// Messages returns the messages accepted so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// push accepts a message as RFC 8030 describes, answering 201 Created
+func (s *Server) push(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/push/") {
+		http.NotFound(w, r)
+		return
+	}
+	endpoint := s.URL + r.URL.Path
+
+	s.mu.Lock()
+	b, ok := s.browsers[endpoint]
+	gone := ok && b.gone
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+		return
+	case gone:
+		http.Error(w, "subscription has expired", http.StatusGone)
+		return
+	}
+
+	key, err := webpush.VerifyAuthorization(r.Header.Get("Authorization"), endpoint, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("TTL") == "" {
+		http.Error(w, "TTL is required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		http.Error(w, "content must be aes128gcm", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4097))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > 4096 {
+		http.Error(w, "payload is over 4096 bytes", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := webpush.Decrypt(body, b.private, b.authSecret)
+	if err != nil {
+		// A real push service can't tell; the browser would drop it
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.messages = append(s.messages, Message{
+		Endpoint: endpoint,
+		Key:      key,
+		TTL:      r.Header.Get("TTL"),
+		Urgency:  r.Header.Get("Urgency"),
+		Payload:  payload,
+	})
+	n := len(s.messages)
+	s.mu.Unlock()
+	w.Header().Set("Location", fmt.Sprintf("%s/messages/%d", endpoint, n))
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,45 @@
+package notifications
+
+import (
+	"context"
+	"time"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// sampleTriggerType is the trigger type of test alerts, which the locales
+// describe as a test
+const sampleTriggerType = "TEST"
+
+// sampleAlert is the alert sent to the user with the given email when they
+// ask to see what their alerts look like
+func sampleAlert(userID string, at time.Time) Alert {
+	return Alert{
+		FireID:      "test-" + uuid.New().String(),
+		TriggerType: sampleTriggerType,
+		UserID:      userID,
+		Symbol:      "AAPL",
+		Exchange:    "NASDAQ",
+		Currency:    "USD",
+		Price:       201.5,
+		PrevPrice:   198.25,
+		Message:     "This is a test notification",
+		At:          at,
+		Test:        true,
+	}
+}
+
+// SendTest sends the user with the given email a sample alert on the
+// channels asked for, or on every channel they have turned on if none
+// are, and returns the outcome per channel. Tests skip quiet hours,
+// deduplication and rate limits, though texts still count towards the
+// daily SMS cap, and nothing is recorded or retried.
+func (s *Service) SendTest(ctx context.Context, userID string, channels []string) ([]models.Delivery, error) {
+	user, err := s.store.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.dispatcher.Dispatch(ctx, user, Channels(user, channels), sampleAlert(userID, s.now())), nil
+}
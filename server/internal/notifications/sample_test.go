@@ -0,0 +1,45 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+func TestSendTestSkipsThePolicyAndRecordsNothing(t *testing.T) {
+	ctx := context.Background()
+	user := newUser(true, false)
+	user.NotificationPreferences.QuietHours = &models.QuietHours{Start: "00:00", End: "23:59"}
+	socket := &fakeNotifier{channel: models.ChannelWebSocket}
+	email := &fakeNotifier{channel: models.ChannelEmail}
+	store, service, policy, _ := setupPolicy(t, user, socket, email)
+	policy.ChannelLimit = 1
+
+	for range 2 {
+		deliveries, err := service.SendTest(ctx, user.Email, nil)
+		if err != nil {
+			t.Fatalf("SendTest: %v", err)
+		}
+		if len(deliveries) != 2 || deliveries[0].Status != models.DeliverySent || deliveries[1].Status != models.DeliverySent {
+			t.Fatalf("deliveries = %+v, want both channels sent despite quiet hours and limits", deliveries)
+		}
+	}
+	if alert := email.alerts[0]; !alert.Test || alert.TriggerType != sampleTriggerType || alert.FireID == email.alerts[1].FireID {
+		t.Errorf("alert = %+v, want a test with its own ID", alert)
+	}
+
+	deliveries, err := service.SendTest(ctx, user.Email, []string{models.ChannelSMS})
+	if err != nil {
+		t.Fatalf("SendTest(sms): %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySkipped {
+		t.Errorf("deliveries = %+v, want SMS skipped as it is off", deliveries)
+	}
+
+	fires, err := store.GetUserTriggerFires(ctx, user.Email, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil || len(fires) != 0 {
+		t.Errorf("fires = %v, %v, want none recorded", fires, err)
+	}
+}
@@ -0,0 +1,154 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/events"
+	"stockmarket/server/internal/models"
+)
+
+// Store is the storage the notification service uses
+type Store interface {
+	GetTrigger(ctx context.Context, triggerID string) (*models.StockTrigger, error)
+	GetStock(ctx context.Context, userID, stockID string) (*models.Stock, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	database.TriggerFireRepository
+	database.ReleaseClaimRepository
+}
+
+// Service notifies users of their trigger fires and records how each
+// notification went. Fires are stored with their triggers as pending; the
+// service delivers them from there, retrying failed channels with backoff.
+type Service struct {
+	store      Store
+	dispatcher *Dispatcher
+	now        func() time.Time
+
+	MaxAttempts   int           // Attempts at a fire before its failed channels are dead-lettered
+	RetryDelay    time.Duration // Wait after the first failed attempt, doubled for each one after
+	MaxRetryDelay time.Duration
+	Lease         time.Duration // How long an attempt has before the fire may be attempted again
+}
+
+// NewService creates a new notification service that makes 8 attempts at
+// each fire over about an hour
+func NewService(store Store, dispatcher *Dispatcher) *Service {
+	// The lease outlasts the dispatcher's timeout on every channel
+	return &Service{
+		store:         store,
+		dispatcher:    dispatcher,
+		now:           time.Now,
+		MaxAttempts:   8,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: 30 * time.Minute,
+		Lease:         2 * time.Minute,
+	}
+}
+
+// DeliverTriggerFire delivers a fire as soon as it is announced, rather
+// than when the outbox is next polled. Failed channels are retried from the
+// outbox, so it only fails if the fire couldn't be read or recorded. A fire
+// that isn't stored has nothing to deliver.
+func (s *Service) DeliverTriggerFire(ctx context.Context, e events.TriggerFired) error {
+	fire, err := s.store.GetTriggerFire(ctx, e.FireID)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("Not delivering unknown fire %s", e.FireID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Delivered, being attempted, or waiting for a retry
+	if fire.Outbox != models.OutboxPending || (fire.Attempts > 0 && fire.NextAttemptAt.After(s.now())) {
+		return nil
+	}
+	return s.attempt(ctx, fire)
+}
+
+// deliver notifies a fire's owner on the trigger's channels that aren't
+// settled yet and records every delivery against the fire. Channels that an
+// earlier attempt delivered to, skipped, dead-lettered or held are not
+// notified again. It returns how many channels failed; a deleted trigger or
+// user has no channels left to notify.
+func (s *Service) deliver(ctx context.Context, fire *models.TriggerFire) (int, error) {
+	trigger, err := s.store.GetTrigger(ctx, fire.TriggerID)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("Not notifying of deleted trigger %s", fire.TriggerID)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Triggers reference their owner by email
+	user, err := s.store.GetUserByEmail(ctx, fire.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		log.Printf("Not notifying deleted user %s", fire.UserID)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Prices are shown in the stock's currency, if it is still held
+	var currency string
+	stock, err := s.store.GetStock(ctx, trigger.UserID, trigger.StockID)
+	switch {
+	case err == nil:
+		currency = stock.Currency
+	case !errors.Is(err, database.ErrNotFound):
+		return 0, err
+	}
+
+	// Only failed channels, and those not tried yet, are tried again
+	settled := make(map[string]models.Delivery)
+	for _, d := range fire.Deliveries {
+		if d.Status != models.DeliveryFailed {
+			settled[d.Channel] = d
+		}
+	}
+	channels := Channels(user, trigger.NotificationChannels)
+	var pending []string
+	for _, channel := range channels {
+		if _, ok := settled[channel]; !ok {
+			pending = append(pending, channel)
+		}
+	}
+
+	alert := Alert{
+		FireID:      fire.FireID,
+		TriggerID:   fire.TriggerID,
+		TriggerType: trigger.Type,
+		UserID:      fire.UserID,
+		Symbol:      fire.Symbol,
+		Exchange:    fire.Exchange,
+		Currency:    currency,
+		Price:       fire.Price,
+		PrevPrice:   fire.PrevPrice,
+		Message:     fire.Message,
+		At:          fire.FiredAt,
+	}
+	results := s.dispatcher.Dispatch(ctx, user, pending, alert)
+
+	fire.Deliveries = fire.Deliveries[:0]
+	failed := 0
+	for _, channel := range channels {
+		if d, ok := settled[channel]; ok {
+			fire.Deliveries = append(fire.Deliveries, d)
+			continue
+		}
+		d := results[0]
+		results = results[1:]
+		if d.Status == models.DeliveryFailed {
+			failed++
+		}
+		fire.Deliveries = append(fire.Deliveries, d)
+	}
+	// Held deliveries are released once the user's quiet hours end
+	fire.HeldUntil = fire.NextRelease()
+	return failed, nil
+}
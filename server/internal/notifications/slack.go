@@ -0,0 +1,93 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// SlackNotifier posts alerts as Block Kit messages to the user's Slack
+// incoming webhook
+type SlackNotifier struct {
+	client *http.Client
+	appURL string
+}
+
+// NewSlackNotifier creates a Slack notifier. Messages link to the symbol's
+// page under appURL, or have no link if it is empty.
+func NewSlackNotifier(appURL string) *SlackNotifier {
+	return &SlackNotifier{client: &http.Client{Timeout: 10 * time.Second}, appURL: appURL}
+}
+
+func (n *SlackNotifier) Channel() string { return models.ChannelSlack }
+
+// Notify posts alert to user's Slack webhook
+func (n *SlackNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	_, err := postChatJSON(ctx, n.client, "Slack", user.NotificationPreferences.Slack.WebhookURL, slackMessage(newChatAlert(alert, user.NotificationPreferences.Language, n.appURL)))
+	return err
+}
+
+// Block Kit, https://api.slack.com/block-kit
+type slackPayload struct {
+	Text   string       `json:"text"` // Shown in notifications and where blocks can't be
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Fields   []slackText `json:"fields,omitempty"`
+	Elements []any       `json:"elements,omitempty"` // slackText or slackButton
+}
+
+type slackText struct {
+	Type string `json:"type"` // plain_text or mrkdwn
+	Text string `json:"text"`
+}
+
+type slackButton struct {
+	Type string    `json:"type"`
+	Text slackText `json:"text"`
+	URL  string    `json:"url"`
+}
+
+// slackMessage lays an alert out as a header, the message with price and
+// change fields, when it fired and a button to the app
+func slackMessage(c chatAlert) slackPayload {
+	fields := []slackText{{Type: "mrkdwn", Text: "*" + c.PriceLabel + "*\n" + c.Price}}
+	if c.Change != "" {
+		trend := ":heavy_minus_sign:"
+		switch c.Direction {
+		case 1:
+			trend = ":chart_with_upwards_trend:"
+		case -1:
+			trend = ":chart_with_downwards_trend:"
+		}
+		fields = append(fields, slackText{Type: "mrkdwn", Text: "*" + c.ChangeLabel + "*\n" + trend + " " + c.Change})
+	}
+
+	// Slack shows the time in each reader's own timezone
+	at := fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", c.At.Unix(), c.At.Format("2006-01-02 15:04 UTC"))
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: c.Title}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: slackEscape(c.Message)}, Fields: fields},
+		{Type: "context", Elements: []any{slackText{Type: "mrkdwn", Text: at}}},
+	}
+	if c.Link != "" {
+		blocks = append(blocks, slackBlock{Type: "actions", Elements: []any{slackButton{
+			Type: "button",
+			Text: slackText{Type: "plain_text", Text: c.ViewLabel},
+			URL:  c.Link,
+		}}})
+	}
+	return slackPayload{Text: slackEscape(c.Fallback()), Blocks: blocks}
+}
+
+// slackEscape escapes the characters Slack reads as markup in mrkdwn text
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,278 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"stockmarket/server/internal/config"
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// ErrInvalidPhone is returned for phone numbers that aren't in E.164 format
+var ErrInvalidPhone = errors.New("phone number must be in E.164 format, e.g. +14155550100")
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// ValidatePhone checks that phone is an E.164 number
+func ValidatePhone(phone string) error {
+	if !e164.MatchString(phone) {
+		return ErrInvalidPhone
+	}
+	return nil
+}
+
+// SMSSender sends text messages to phone numbers
+type SMSSender interface {
+	SendSMS(ctx context.Context, phone, text string) error
+}
+
+// NewSMSSender creates the sender for texting alerts through SNS
+func NewSMSSender(cfg *config.Config) (SMSSender, error) {
+	if snsClient == nil {
+		if err := InitSNS(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return NewSNSSMSSender(GetSNSClient(), cfg.SMSSenderID), nil
+}
+
+// SNSSMSSender sends texts by publishing straight to the phone number,
+// without a topic
+type SNSSMSSender struct {
+	client   SNSAPI
+	senderID string
+}
+
+// NewSNSSMSSender creates a sender. senderID is shown as the sender where
+// carriers support it and may be empty.
+func NewSNSSMSSender(client SNSAPI, senderID string) *SNSSMSSender {
+	return &SNSSMSSender{client: client, senderID: senderID}
+}
+
+// SendSMS texts phone
+func (s *SNSSMSSender) SendSMS(ctx context.Context, phone, text string) error {
+	if err := ValidatePhone(phone); err != nil {
+		return err
+	}
+	attributes := map[string]types.MessageAttributeValue{
+		// Transactional texts are delivered ahead of promotional ones, and
+		// to numbers that opted out of marketing
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+	}
+	if s.senderID != "" {
+		attributes["AWS.SNS.SMS.SenderID"] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(s.senderID)}
+	}
+	_, err := s.client.Publish(ctx, &sns.PublishInput{
+		PhoneNumber:       aws.String(phone),
+		Message:           aws.String(text),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %v", err)
+	}
+	return nil
+}
+
+// Characters of the GSM 03.38 alphabet. Texts written only in it are sent
+// 7 bits a character; any other character makes the whole text UCS-2.
+const (
+	gsmBasic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsmExtended = "\f^{}\\[~]|€" // Take two characters, an escape and the character
+)
+
+// Characters per segment. A text longer than one segment is split into
+// parts that each lose some room to the header joining them back up.
+const (
+	gsmSingle  = 160
+	gsmPart    = 153
+	ucs2Single = 70
+	ucs2Part   = 67
+)
+
+// smsEncoding reports whether text can be sent as GSM-7 and how many
+// characters it takes in the encoding it is sent in
+func smsEncoding(text string) (gsm bool, length int) {
+	gsm = true
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsmBasic, r):
+			length++
+		case strings.ContainsRune(gsmExtended, r):
+			length += 2
+		default:
+			gsm = false
+		}
+	}
+	if !gsm {
+		// UCS-2 counts UTF-16 code units, so characters outside the basic
+		// plane take two
+		length = len(utf16.Encode([]rune(text)))
+	}
+	return gsm, length
+}
+
+// smsCapacity returns how many characters fit in segments segments
+func smsCapacity(gsm bool, segments int) int {
+	single, part := ucs2Single, ucs2Part
+	if gsm {
+		single, part = gsmSingle, gsmPart
+	}
+	if segments <= 1 {
+		return single
+	}
+	return part * segments
+}
+
+// SMSSegments returns how many segments text is sent as
+func SMSSegments(text string) int {
+	gsm, length := smsEncoding(text)
+	if length <= smsCapacity(gsm, 1) {
+		return 1
+	}
+	per := smsCapacity(gsm, 2) / 2
+	return (length + per - 1) / per
+}
+
+// FitSMS shortens text to fit in at most segments segments, marking where
+// it was cut
+func FitSMS(text string, segments int) string {
+	gsm, length := smsEncoding(text)
+	capacity := smsCapacity(gsm, segments)
+	if length <= capacity {
+		return text
+	}
+
+	// UCS-2 texts can use a real ellipsis without changing encoding
+	ellipsis, ellipsisLen := "...", 3
+	if !gsm {
+		ellipsis, ellipsisLen = "…", 1
+	}
+	var b strings.Builder
+	used := 0
+	for _, r := range text {
+		n := 1
+		switch {
+		case !gsm && r > 0xFFFF:
+			n = 2
+		case gsm && strings.ContainsRune(gsmExtended, r):
+			n = 2
+		}
+		if used+n+ellipsisLen > capacity {
+			break
+		}
+		b.WriteRune(r)
+		used += n
+	}
+	return strings.TrimRight(b.String(), " ") + ellipsis
+}
+
+// SMSLimiter caps how many texts each user is sent a day
+type SMSLimiter struct {
+	counter    Counter
+	DailyLimit int // Texts per user per UTC day; zero or less is no cap
+}
+
+// NewSMSLimiter creates a limiter that counts texts in counter
+func NewSMSLimiter(counter Counter, dailyLimit int) *SMSLimiter {
+	return &SMSLimiter{counter: counter, DailyLimit: dailyLimit}
+}
+
+// Allow counts one text to userID at now and reports whether it is within
+// the user's daily cap
+func (l *SMSLimiter) Allow(ctx context.Context, userID string, now time.Time) (bool, error) {
+	if l == nil || l.DailyLimit <= 0 {
+		return true, nil
+	}
+	key := "sms:" + userID + ":" + now.UTC().Format("2006-01-02")
+	// The key names the day, so the window only has to outlast it
+	n, err := l.counter.Increment(ctx, key, 25*time.Hour)
+	if err != nil {
+		return false, err
+	}
+	return n <= int64(l.DailyLimit), nil
+}
+
+// SMSNotifier texts alerts to the user's verified phone number
+type SMSNotifier struct {
+	sender  SMSSender
+	limiter *SMSLimiter
+
+	MaxSegments int // Longer alerts are cut short
+}
+
+// NewSMSNotifier creates an SMS notifier. limiter may be nil for no cap.
+func NewSMSNotifier(sender SMSSender, limiter *SMSLimiter) *SMSNotifier {
+	return &SMSNotifier{sender: sender, limiter: limiter, MaxSegments: 1}
+}
+
+func (n *SMSNotifier) Channel() string { return models.ChannelSMS }
+
+// Notify texts alert to user
+func (n *SMSNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	prefs := user.NotificationPreferences
+	if prefs.Phone == "" || !prefs.PhoneVerified {
+		return &SkipError{Reason: "phone number not verified"}
+	}
+	// A text that then fails to send still counts, which errs on the side
+	// of sending too few
+	ok, err := n.limiter.Allow(ctx, user.Email, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &SkipError{Reason: "daily SMS limit reached"}
+	}
+	text, err := smsText(user, alert)
+	if err != nil {
+		return err
+	}
+	return n.sender.SendSMS(ctx, prefs.Phone, FitSMS(text, n.MaxSegments))
+}
+
+// NotifyBatch texts user the alerts together, as one text against the
+// daily cap
+func (n *SMSNotifier) NotifyBatch(ctx context.Context, user *models.User, alerts []Alert) error {
+	prefs := user.NotificationPreferences
+	if prefs.Phone == "" || !prefs.PhoneVerified {
+		return &SkipError{Reason: "phone number not verified"}
+	}
+	ok, err := n.limiter.Allow(ctx, user.Email, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &SkipError{Reason: "daily SMS limit reached"}
+	}
+	notification := heldNotification(user, alerts)
+	msg, err := templates.render(models.ChannelSMS, NotificationTypeHeld, notification.Language, notification, "")
+	if err != nil {
+		return err
+	}
+	text := smsSpaces.Replace(strings.TrimSpace(msg.Text))
+	return n.sender.SendSMS(ctx, prefs.Phone, FitSMS(text, n.MaxSegments))
+}
+
+// smsSpaces swaps the no-break spaces numbers are written with for plain
+// ones, which GSM-7 has, so the text isn't sent as UCS-2 at half the length
+var smsSpaces = strings.NewReplacer("\u00a0", " ", "\u202f", " ")
+
+// smsText is the text of an alert in user's language, most important part
+// first so cutting it short loses the least
+func smsText(user *models.User, alert Alert) (string, error) {
+	notification := triggerNotification(user, alert)
+	notification.Message = alertMessage(notification.Language, alert.TriggerType, alert.Message)
+	msg, err := templates.render(models.ChannelSMS, NotificationTypeTrigger, notification.Language, notification, "")
+	if err != nil {
+		return "", err
+	}
+	return smsSpaces.Replace(strings.TrimSpace(msg.Text)), nil
+}
@@ -0,0 +1,215 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestValidatePhone(t *testing.T) {
+	for phone, valid := range map[string]bool{
+		"+14155550100":      true,
+		"+919876543210":     true,
+		"14155550100":       false,
+		"+04155550100":      false,
+		"+1 415 555 0100":   false,
+		"+1234567890123456": false, // 16 digits
+		"":                  false,
+	} {
+		if err := ValidatePhone(phone); (err == nil) != valid {
+			t.Errorf("ValidatePhone(%q) = %v, want valid %v", phone, err, valid)
+		}
+	}
+}
+
+func TestFitSMS(t *testing.T) {
+	short := "AAPL 201.00: Price exceeded upper limit"
+	if got := FitSMS(short, 1); got != short {
+		t.Errorf("FitSMS changed a text that fits: %q", got)
+	}
+
+	long := strings.Repeat("a", 200)
+	got := FitSMS(long, 1)
+	if len(got) != 160 || !strings.HasSuffix(got, "...") {
+		t.Errorf("FitSMS(200 GSM chars, 1) is %d chars %q, want 160 ending in ...", len(got), got)
+	}
+	if got := FitSMS(long, 2); got != long {
+		t.Error("FitSMS cut a text that fits two segments")
+	}
+
+	// Extended characters take two places
+	euros := strings.Repeat("€", 100)
+	if got := FitSMS(euros, 1); SMSSegments(got) != 1 || strings.Count(got, "€") != 78 {
+		t.Errorf("FitSMS(100 euros, 1) kept %d euros, want 78", strings.Count(got, "€"))
+	}
+
+	// Any character outside GSM-7 makes the text UCS-2
+	unicode := strings.Repeat("₹", 100)
+	got = FitSMS(unicode, 1)
+	if n := len([]rune(got)); n != 70 || !strings.HasSuffix(got, "…") {
+		t.Errorf("FitSMS(100 rupee signs, 1) is %d chars, want 70 ending in …", n)
+	}
+	if n := SMSSegments(unicode); n != 2 {
+		t.Errorf("SMSSegments(100 UCS-2 chars) = %d, want 2", n)
+	}
+}
+
+func verifiedUser() *models.User {
+	user := newUser(false, true)
+	user.NotificationPreferences.WebSocket = false
+	return user
+}
+
+func TestSMSNotifierPublishesToPhone(t *testing.T) {
+	client := &fakeSNS{}
+	notifier := NewSMSNotifier(NewSNSSMSSender(client, "STOCKS"), nil)
+
+	alert := Alert{Symbol: "AAPL", Exchange: "NASDAQ", Price: 201, Message: strings.Repeat("Price exceeded upper limit. ", 10)}
+	if err := notifier.Notify(context.Background(), verifiedUser(), alert); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(client.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(client.published))
+	}
+	published := client.published[0]
+	if aws.ToString(published.PhoneNumber) != "+15555550100" || published.TopicArn != nil {
+		t.Errorf("published to phone %q topic %q, want the user's phone only", aws.ToString(published.PhoneNumber), aws.ToString(published.TopicArn))
+	}
+	text := aws.ToString(published.Message)
+	if !strings.HasPrefix(text, "AAPL (NASDAQ) 201.00: ") || SMSSegments(text) != 1 {
+		t.Errorf("text = %q, want the alert in one segment", text)
+	}
+	if got := aws.ToString(published.MessageAttributes["AWS.SNS.SMS.SMSType"].StringValue); got != "Transactional" {
+		t.Errorf("SMS type = %q, want Transactional", got)
+	}
+}
+
+func TestSMSNotifierSkips(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeSNS{}
+	notifier := NewSMSNotifier(NewSNSSMSSender(client, ""), NewSMSLimiter(NewMemoryCounter(), 2))
+	d := NewDispatcher(notifier)
+
+	unverified := verifiedUser()
+	unverified.NotificationPreferences.PhoneVerified = false
+	var skip *SkipError
+	if err := notifier.Notify(ctx, unverified, Alert{}); !errors.As(err, &skip) {
+		t.Errorf("Notify to an unverified number = %v, want a SkipError", err)
+	}
+
+	user := verifiedUser()
+	var statuses []string
+	for range 3 {
+		deliveries := d.Dispatch(ctx, user, []string{models.ChannelSMS}, Alert{Symbol: "AAPL"})
+		statuses = append(statuses, deliveries[0].Status)
+	}
+	want := []string{models.DeliverySent, models.DeliverySent, models.DeliverySkipped}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+	if len(client.published) != 2 {
+		t.Errorf("published %d texts, want the daily cap of 2", len(client.published))
+	}
+}
+
+// fakeSMS records the texts it is asked to send
+type fakeSMS struct {
+	texts map[string][]string
+}
+
+func (f *fakeSMS) SendSMS(ctx context.Context, phone, text string) error {
+	if f.texts == nil {
+		f.texts = make(map[string][]string)
+	}
+	f.texts[phone] = append(f.texts[phone], text)
+	return nil
+}
+
+var codePattern = regexp.MustCompile(`[0-9]{6}`)
+
+func (f *fakeSMS) lastCode(phone string) string {
+	texts := f.texts[phone]
+	if len(texts) == 0 {
+		return ""
+	}
+	return codePattern.FindString(texts[len(texts)-1])
+}
+
+func TestPhoneVerification(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := &models.User{UserID: "id-bob", Email: "bob@example.com"}
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	sender := &fakeSMS{}
+	verifier := NewPhoneVerifier(store, sender, nil)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	if err := verifier.Start(ctx, user.Email, "555-0100"); !errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("Start with a bad number = %v, want ErrInvalidPhone", err)
+	}
+	if err := verifier.Confirm(ctx, user.Email, "123456"); !errors.Is(err, ErrNoPendingVerification) {
+		t.Errorf("Confirm before Start = %v, want ErrNoPendingVerification", err)
+	}
+
+	phone := "+447700900123"
+	if err := verifier.Start(ctx, user.Email, phone); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	code := sender.lastCode(phone)
+	if code == "" {
+		t.Fatalf("no code texted to %s: %v", phone, sender.texts)
+	}
+	if err := verifier.Confirm(ctx, user.Email, "not-it"); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("Confirm with a wrong code = %v, want ErrWrongCode", err)
+	}
+	stored, _ := store.GetUserByEmail(ctx, user.Email)
+	if stored.ChannelEnabled(models.ChannelSMS) {
+		t.Error("SMS enabled before the number was verified")
+	}
+	if err := verifier.Confirm(ctx, user.Email, code); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	stored, _ = store.GetUserByEmail(ctx, user.Email)
+	prefs := stored.NotificationPreferences
+	if prefs.Phone != phone || !stored.ChannelEnabled(models.ChannelSMS) || prefs.PhoneVerification != nil {
+		t.Errorf("preferences after Confirm = %+v, want SMS on to %s", prefs, phone)
+	}
+
+	// Expired codes and too many guesses both need a new code
+	if err := verifier.Start(ctx, user.Email, "+14155550100"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	code = sender.lastCode("+14155550100")
+	now = now.Add(codeTTL)
+	if err := verifier.Confirm(ctx, user.Email, code); !errors.Is(err, ErrCodeExpired) {
+		t.Errorf("Confirm after expiry = %v, want ErrCodeExpired", err)
+	}
+	if err := verifier.Start(ctx, user.Email, "+14155550100"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	for i := 1; i < maxAttempts; i++ {
+		if err := verifier.Confirm(ctx, user.Email, "000000x"); !errors.Is(err, ErrWrongCode) {
+			t.Fatalf("guess %d = %v, want ErrWrongCode", i, err)
+		}
+	}
+	if err := verifier.Confirm(ctx, user.Email, "000000x"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("last guess = %v, want ErrTooManyAttempts", err)
+	}
+	stored, _ = store.GetUserByEmail(ctx, user.Email)
+	if stored.NotificationPreferences.Phone != phone {
+		t.Errorf("phone = %s, want the verified number kept", stored.NotificationPreferences.Phone)
+	}
+}
@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTPMailer sends each email straight to its recipient through an SMTP
+// relay
+type SMTPMailer struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth // nil for relays without authentication
+}
+
+// NewSMTPMailer creates a mailer that relays through addr. Credentials are
+// only sent over TLS, or to a relay on localhost.
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers email to its recipient
+func (m *SMTPMailer) Send(ctx context.Context, email Email) error {
+	if err := email.validate(); err != nil {
+		return err
+	}
+	msg, err := m.message(email, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(m.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(nil); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return fmt.Errorf("failed to set sender: %v", err)
+	}
+	if err := c.Rcpt(email.To.Email); err != nil {
+		return fmt.Errorf("failed to set recipient: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	return c.Quit()
+}
+
+// message renders email as a quoted-printable plaintext message, or as
+// multipart/alternative with an HTML part if it has one
+func (m *SMTPMailer) message(email Email, at time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", email.To.Email)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@stockmarket>\r\n", uuid.New().String())
+	if email.Unsubscribe != "" {
+		// RFC 8058: clients unsubscribe by POSTing to the link
+		fmt.Fprintf(&buf, "List-Unsubscribe: <%s>\r\n", email.Unsubscribe)
+		buf.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if email.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, email.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n\r\n", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	// Clients show the last part they can, so plain text goes first
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", email.Body},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
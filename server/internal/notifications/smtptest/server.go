@@ -0,0 +1,156 @@
+// Package smtptest is a local SMTP stand-in that accepts every message and
+// keeps it for inspection, so email delivery can be tested without a real
+// relay.
+package smtptest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+// Message is a message the server accepted
+type Message struct {
+	From string
+	To   []string // Envelope recipients
+	Data []byte   // The message as sent, after dot-unstuffing
+}
+
+// Parse parses the message's headers and body
+func (m Message) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// Server is an SMTP server listening on a local port
+type Server struct {
+	Addr string // host:port to send to
+
+	listener net.Listener
+	messages []Message
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a free local port. Callers should Close it
+// when done.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Messages returns the messages accepted so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server and waits for open sessions to end
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.session(conn)
+		}()
+	}
+}
+
+// session speaks just enough SMTP for net/smtp clients
+func (s *Server) session(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost smtptest")
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if len(msg.To) == 0 {
+				reply("503 No recipients")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(r)
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = Message{}
+			reply("250 OK")
+		case "RSET":
+			msg = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address extracts the address from a MAIL FROM:<...> or RCPT TO:<...>
+// argument
+func address(arg string) string {
+	_, addr, _ := strings.Cut(arg, ":")
+	addr, _, _ = strings.Cut(strings.TrimSpace(addr), " ")
+	return strings.Trim(addr, "<>")
+}
+
+// readData reads a message up to the lone dot that ends it
+func readData(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return buf.Bytes(), nil
+		}
+		buf.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
@@ -2,13 +2,16 @@ package notifications
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"stockmarket/server/internal/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
 )
 
 var snsClient *sns.Client
@@ -109,3 +112,141 @@ func ListSubscriptions(ctx context.Context, topicArn string) ([]string, error) {
 
 	return subscriptions, nil
 }
+
+// SNSAPI is the part of the SNS client the SNS mailer uses
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+	Subscribe(ctx context.Context, params *sns.SubscribeInput, optFns ...func(*sns.Options)) (*sns.SubscribeOutput, error)
+	Unsubscribe(ctx context.Context, params *sns.UnsubscribeInput, optFns ...func(*sns.Options)) (*sns.UnsubscribeOutput, error)
+	ListSubscriptionsByTopic(ctx context.Context, params *sns.ListSubscriptionsByTopicInput, optFns ...func(*sns.Options)) (*sns.ListSubscriptionsByTopicOutput, error)
+}
+
+// pendingConfirmation is the ARN SNS lists a subscription under until its
+// owner confirms it
+const pendingConfirmation = "PendingConfirmation"
+
+// userIDAttribute is the message attribute subscriptions filter on
+const userIDAttribute = "user_id"
+
+// SNSMailer delivers emails through a shared topic. Every user's email
+// subscription carries a filter policy on their user ID and every message
+// the ID of its recipient, so SNS only delivers a message to its recipient.
+type SNSMailer struct {
+	client   SNSAPI
+	topicArn string
+}
+
+// NewSNSMailer creates a mailer that publishes to topicArn
+func NewSNSMailer(client SNSAPI, topicArn string) *SNSMailer {
+	return &SNSMailer{client: client, topicArn: topicArn}
+}
+
+// Subscribe subscribes the recipient's address to their own messages. SNS
+// emails them to confirm the subscription before delivering any.
+func (m *SNSMailer) Subscribe(ctx context.Context, to Recipient) (string, error) {
+	if to.UserID == "" {
+		return "", fmt.Errorf("recipient user ID is required")
+	}
+	policy, err := json.Marshal(map[string][]string{userIDAttribute: {to.UserID}})
+	if err != nil {
+		return "", err
+	}
+	result, err := m.client.Subscribe(ctx, &sns.SubscribeInput{
+		Protocol: aws.String("email"),
+		TopicArn: aws.String(m.topicArn),
+		Endpoint: aws.String(to.Email),
+		Attributes: map[string]string{
+			"FilterPolicy":      string(policy),
+			"FilterPolicyScope": "MessageAttributes",
+		},
+		ReturnSubscriptionArn: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to subscribe email: %v", err)
+	}
+	return aws.ToString(result.SubscriptionArn), nil
+}
+
+// Unsubscribe removes the recipient's email subscriptions. Subscriptions
+// that were never confirmed can't be removed; SNS deletes them after a few
+// days.
+func (m *SNSMailer) Unsubscribe(ctx context.Context, to Recipient) error {
+	subscriptions, err := m.subscriptions(ctx, to)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subscriptions {
+		arn := aws.ToString(sub.SubscriptionArn)
+		if arn == pendingConfirmation {
+			continue
+		}
+		if _, err := m.client.Unsubscribe(ctx, &sns.UnsubscribeInput{SubscriptionArn: aws.String(arn)}); err != nil {
+			return fmt.Errorf("failed to unsubscribe: %v", err)
+		}
+	}
+	return nil
+}
+
+// SubscriptionStatus returns whether the recipient has a confirmed email
+// subscription, one waiting to be confirmed, or none
+func (m *SNSMailer) SubscriptionStatus(ctx context.Context, to Recipient) (string, error) {
+	subscriptions, err := m.subscriptions(ctx, to)
+	if err != nil {
+		return "", err
+	}
+	status := SubscriptionNone
+	for _, sub := range subscriptions {
+		if aws.ToString(sub.SubscriptionArn) != pendingConfirmation {
+			return SubscriptionConfirmed, nil
+		}
+		status = SubscriptionPending
+	}
+	return status, nil
+}
+
+// subscriptions returns the topic's email subscriptions for the
+// recipient's address
+func (m *SNSMailer) subscriptions(ctx context.Context, to Recipient) ([]types.Subscription, error) {
+	var matching []types.Subscription
+	input := &sns.ListSubscriptionsByTopicInput{TopicArn: aws.String(m.topicArn)}
+	for {
+		result, err := m.client.ListSubscriptionsByTopic(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %v", err)
+		}
+		for _, sub := range result.Subscriptions {
+			if aws.ToString(sub.Protocol) == "email" && strings.EqualFold(aws.ToString(sub.Endpoint), to.Email) {
+				matching = append(matching, sub)
+			}
+		}
+		if aws.ToString(result.NextToken) == "" {
+			return matching, nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
+
+// Send publishes email for its recipient's subscription only. SNS emails
+// plain text, so only the email's text is sent.
+func (m *SNSMailer) Send(ctx context.Context, email Email) error {
+	if err := email.validate(); err != nil {
+		return err
+	}
+	// Without a user ID only unfiltered subscriptions would match, which
+	// is exactly the broadcast this mailer exists to avoid
+	if email.To.UserID == "" {
+		return fmt.Errorf("recipient user ID is required")
+	}
+	_, err := m.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(m.topicArn),
+		Subject:  aws.String(email.Subject),
+		Message:  aws.String(email.Body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			userIDAttribute: {DataType: aws.String("String"), StringValue: aws.String(email.To.UserID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %v", err)
+	}
+	return nil
+}
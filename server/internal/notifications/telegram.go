@@ -0,0 +1,113 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/models"
+)
+
+// DefaultTelegramAPIURL is the Telegram Bot API
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+// TelegramNotifier sends alerts as messages from the user's bot to their
+// chat
+type TelegramNotifier struct {
+	client *http.Client
+	appURL string
+
+	APIURL string // Bot API base URL, e.g. a local Bot API server
+}
+
+// NewTelegramNotifier creates a Telegram notifier. Messages have a button
+// to the symbol's page under appURL, or none if it is empty.
+func NewTelegramNotifier(appURL string) *TelegramNotifier {
+	return &TelegramNotifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		appURL: appURL,
+		APIURL: DefaultTelegramAPIURL,
+	}
+}
+
+func (n *TelegramNotifier) Channel() string { return models.ChannelTelegram }
+
+// Notify sends alert to user's Telegram chat
+func (n *TelegramNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	dest := user.NotificationPreferences.Telegram
+	endpoint := strings.TrimRight(n.APIURL, "/") + "/bot" + dest.BotToken + "/sendMessage"
+	body, err := postChatJSON(ctx, n.client, "Telegram", endpoint, telegramMessage(dest.ChatID, newChatAlert(alert, user.NotificationPreferences.Language, n.appURL)))
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("failed to read Telegram response: %v", err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("telegram refused message: %s", resp.Description)
+	}
+	return nil
+}
+
+// sendMessage, https://core.telegram.org/bots/api#sendmessage
+type telegramPayload struct {
+	ChatID             string                  `json:"chat_id"`
+	Text               string                  `json:"text"`
+	ParseMode          string                  `json:"parse_mode"`
+	LinkPreviewOptions telegramLinkPreview     `json:"link_preview_options"`
+	ReplyMarkup        *telegramInlineKeyboard `json:"reply_markup,omitempty"`
+}
+
+type telegramLinkPreview struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+type telegramInlineKeyboard struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// telegramMessage lays an alert out as HTML text with a button to the app
+func telegramMessage(chatID string, c chatAlert) telegramPayload {
+	trend := ""
+	switch c.Direction {
+	case 1:
+		trend = "📈 "
+	case -1:
+		trend = "📉 "
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s<b>%s</b>\n%s\n\n", trend, html.EscapeString(c.Title), html.EscapeString(c.Message))
+	fmt.Fprintf(&text, "%s: <b>%s</b>\n", html.EscapeString(c.PriceLabel), c.Price)
+	if c.Change != "" {
+		fmt.Fprintf(&text, "%s: %s\n", html.EscapeString(c.ChangeLabel), c.Change)
+	}
+	fmt.Fprintf(&text, "<i>%s</i>", c.At.Format("2 Jan 2006 15:04 UTC"))
+
+	payload := telegramPayload{
+		ChatID:             chatID,
+		Text:               text.String(),
+		ParseMode:          "HTML",
+		LinkPreviewOptions: telegramLinkPreview{IsDisabled: true},
+	}
+	if c.Link != "" {
+		payload.ReplyMarkup = &telegramInlineKeyboard{
+			InlineKeyboard: [][]telegramButton{{{Text: c.ViewLabel, URL: c.Link}}},
+		}
+	}
+	return payload
+}
@@ -0,0 +1,168 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Rendered is a notification written out for one channel
+type Rendered struct {
+	Subject string // Empty for channels without subjects
+	Text    string
+	HTML    string // Empty for channels without HTML
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+// renderer holds the templates of each channel and notification type. A
+// type's text template is templates/<channel>/<type>.txt, which may define
+// a "subject", and its HTML template is <type>.html, laid out by the
+// channel's layout.html.
+type renderer struct {
+	text map[string]*texttemplate.Template // By "<channel>/<type>"
+	html map[string]*htmltemplate.Template
+}
+
+// templates are the built-in notification templates
+var templates = mustLoadTemplates(templateFS)
+
+func mustLoadTemplates(fsys fs.FS) *renderer {
+	r, err := loadTemplates(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func loadTemplates(fsys fs.FS) (*renderer, error) {
+	r := &renderer{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	// Functions are bound to the recipient's language when rendering
+	funcs := templateFuncs(DefaultLanguage, "", "")
+
+	files, err := fs.Glob(fsys, "templates/*/*")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		channel := path.Base(path.Dir(file))
+		name := path.Base(file)
+		kind, ext := strings.TrimSuffix(name, path.Ext(name)), path.Ext(name)
+		if kind == "layout" {
+			continue
+		}
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		switch ext {
+		case ".txt":
+			t, err := texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).Parse(string(src))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+			}
+			r.text[channel+"/"+kind] = t
+		case ".html":
+			layout, err := fs.ReadFile(fsys, path.Join(path.Dir(file), "layout.html"))
+			if err != nil {
+				return nil, fmt.Errorf("%s has no layout: %v", file, err)
+			}
+			t, err := htmltemplate.New("layout.html").Funcs(htmltemplate.FuncMap(funcs)).Parse(string(layout))
+			if err == nil {
+				t, err = t.New(name).Parse(string(src))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+			}
+			r.html[channel+"/"+kind] = t
+		default:
+			return nil, fmt.Errorf("template %s is neither .txt nor .html", file)
+		}
+	}
+	return r, nil
+}
+
+// render writes a notification of the given type out for channel in lang.
+// unsubscribe is the link emails offer to unsubscribe with, if any.
+func (r *renderer) render(channel string, kind NotificationType, lang string, data any, unsubscribe string) (Rendered, error) {
+	key := channel + "/" + strings.ToLower(string(kind))
+	text, ok := r.text[key]
+	if !ok {
+		return Rendered{}, fmt.Errorf("no %s template for %s notifications", channel, kind)
+	}
+	lang = language(lang)
+
+	var rendered Rendered
+	var buf bytes.Buffer
+	t, err := text.Clone()
+	if err != nil {
+		return Rendered{}, err
+	}
+	t.Funcs(texttemplate.FuncMap(templateFuncs(lang, "", unsubscribe)))
+	if t.Lookup("subject") != nil {
+		if err := t.ExecuteTemplate(&buf, "subject", data); err != nil {
+			return Rendered{}, fmt.Errorf("failed to render %s subject: %v", key, err)
+		}
+		rendered.Subject = strings.TrimSpace(buf.String())
+		buf.Reset()
+	}
+	if err := t.Execute(&buf, data); err != nil {
+		return Rendered{}, fmt.Errorf("failed to render %s: %v", key, err)
+	}
+	rendered.Text = buf.String()
+
+	if html, ok := r.html[key]; ok {
+		buf.Reset()
+		t, err := html.Clone()
+		if err != nil {
+			return Rendered{}, err
+		}
+		t.Funcs(htmltemplate.FuncMap(templateFuncs(lang, rendered.Subject, unsubscribe)))
+		if err := t.ExecuteTemplate(&buf, path.Base(key)+".html", data); err != nil {
+			return Rendered{}, fmt.Errorf("failed to render %s HTML: %v", key, err)
+		}
+		rendered.HTML = buf.String()
+	}
+	return rendered, nil
+}
+
+// templateFuncs are the functions templates write text and numbers with
+func templateFuncs(lang, subject, unsubscribe string) map[string]any {
+	return map[string]any{
+		// t looks up a string in the recipient's language
+		"t": func(key string, args ...any) string {
+			s, _ := translate(lang, key, args...)
+			return s
+		},
+		// triggerType names a trigger type, or writes it as is if it has no
+		// name
+		"triggerType": func(triggerType string) string {
+			if s, ok := translate(lang, "trigger_type."+triggerType); ok {
+				return s
+			}
+			return triggerType
+		},
+		"money":  func(amount float64, currency string) string { return formatMoney(lang, amount, currency) },
+		"number": func(v float64, decimals int) string { return formatNumber(lang, v, decimals) },
+		"shares": func(v float64) string { return formatQuantity(lang, v) },
+		"change": func(price, prev float64, currency string) string { return formatChange(lang, price, prev, currency) },
+		"time":   func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+		"date":   func(t time.Time) string { return t.Format("2006-01-02") },
+		"lang":   func() string { return lang },
+		// subject is the rendered subject, for the HTML title
+		"subject": func() string { return subject },
+		// unsubscribe is the recipient's unsubscribe link, or empty
+		"unsubscribe": func() string { return unsubscribe },
+	}
+}
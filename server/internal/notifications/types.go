@@ -1,5 +1,7 @@
 package notifications
 
+import "time"
+
 // NotificationType represents different types of notifications
 type NotificationType string
 
@@ -12,6 +14,12 @@ const (
 	NotificationTypePriceAlert NotificationType = "PRICE_ALERT"
 	// NotificationTypeVolumeAlert represents a volume alert notification
 	NotificationTypeVolumeAlert NotificationType = "VOLUME_ALERT"
+	// NotificationTypeWebhookDisabled tells a user their webhook was disabled
+	NotificationTypeWebhookDisabled NotificationType = "WEBHOOK_DISABLED"
+	// NotificationTypeDigest summarises a user's portfolio after the close
+	NotificationTypeDigest NotificationType = "DIGEST"
+	// NotificationTypeHeld lists the alerts held through a user's quiet hours
+	NotificationTypeHeld NotificationType = "HELD"
 )
 
 // Notification represents a notification message
@@ -26,14 +34,106 @@ type Notification struct {
 // TriggerNotification represents a stock trigger notification
 type TriggerNotification struct {
 	Symbol      string
+	Exchange    string
+	Currency    string // ISO 4217 code of the stock's prices, if known
 	Price       float64
+	PrevPrice   float64 // 0 if unknown
 	TriggerType string
+	Message     string
+	At          time.Time
 	UserID      string
 	Email       string
+	Language    string
 }
 
 // WelcomeNotification represents a welcome notification
 type WelcomeNotification struct {
+	UserID   string
 	Email    string
 	Username string
+	Language string
+}
+
+// PriceAlertNotification represents a price alert notification
+type PriceAlertNotification struct {
+	Symbol       string
+	Currency     string
+	CurrentPrice float64
+	TargetPrice  float64
+	At           time.Time
+}
+
+// VolumeAlertNotification represents a volume alert notification
+type VolumeAlertNotification struct {
+	Symbol        string
+	CurrentVolume float64
+	AverageVolume float64
+	At            time.Time
+}
+
+// WebhookDisabledNotification tells a user their webhook was disabled
+type WebhookDisabledNotification struct {
+	UserID    string
+	Email     string
+	URL       string
+	Failures  int
+	LastError string
+	At        time.Time
+	Language  string
+}
+
+// DigestNotification summarises a user's portfolio after the market closes
+type DigestNotification struct {
+	UserID    string
+	Email     string
+	Language  string
+	Frequency string    // models.DigestDaily or models.DigestWeekly
+	Date      time.Time // Last trading day covered
+	Values    []DigestValue
+	Holdings  []DigestHolding
+	Movers    []DigestHolding // Biggest moves of the day, largest first
+	Fires     []DigestFire    // Triggers fired in the period, oldest first
+	Earnings  []DigestEarnings
+}
+
+// DigestValue is what the shares held in one currency are worth
+type DigestValue struct {
+	Currency  string
+	Value     float64
+	PrevValue float64 // At the previous close
+}
+
+// DigestHolding is a stock in the portfolio and its move on the day
+type DigestHolding struct {
+	Symbol    string
+	Name      string
+	Exchange  string
+	Currency  string
+	Quantity  float64 // 0 if only watched
+	Price     float64
+	PrevClose float64 // 0 if unknown
+}
+
+// DigestFire is a trigger that fired in the period
+type DigestFire struct {
+	Symbol   string
+	Currency string
+	Price    float64
+	Message  string
+	At       time.Time
+}
+
+// DigestEarnings is an upcoming earnings report
+type DigestEarnings struct {
+	Symbol string
+	Date   time.Time
+}
+
+// HeldAlertsNotification lists the alerts that fired during a user's quiet
+// hours, oldest first
+type HeldAlertsNotification struct {
+	UserID   string
+	Email    string
+	Language string
+	Alerts   []TriggerNotification
 }
@@ -0,0 +1,67 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidUnsubscribeToken is returned for an unsubscribe link that was
+// not issued by this server
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe link")
+
+// UnsubscribeLinks signs the one-click unsubscribe links put in every
+// email. A token names the user and is signed with a server secret, so it
+// works without logging in but can't be made up for someone else. Tokens
+// don't expire, as old emails must keep working.
+type UnsubscribeLinks struct {
+	secret  []byte
+	baseURL string
+}
+
+// NewUnsubscribeLinks creates links to the unsubscribe endpoint under
+// baseURL, signed with secret
+func NewUnsubscribeLinks(secret, baseURL string) *UnsubscribeLinks {
+	return &UnsubscribeLinks{secret: []byte(secret), baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// URL returns the unsubscribe link of the user with the given email
+func (l *UnsubscribeLinks) URL(userID string) string {
+	return l.baseURL + "/unsubscribe?token=" + url.QueryEscape(l.Token(userID))
+}
+
+// Token returns the signed token naming the user with the given email
+func (l *UnsubscribeLinks) Token(userID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." +
+		base64.RawURLEncoding.EncodeToString(l.sign(userID))
+}
+
+// Verify returns the email of the user a token names, or
+// ErrInvalidUnsubscribeToken if it isn't signed by this server
+func (l *UnsubscribeLinks) Verify(token string) (string, error) {
+	encodedID, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	userID, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil || len(userID) == 0 {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, l.sign(string(userID))) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	return string(userID), nil
+}
+
+// sign returns the HMAC of a user ID, bound to unsubscribing so the secret
+// can be shared with other uses
+func (l *UnsubscribeLinks) sign(userID string) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte("unsubscribe\x00"))
+	mac.Write([]byte(userID))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,35 @@
+package notifications
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeLinks(t *testing.T) {
+	links := NewUnsubscribeLinks("secret", "https://stocks.example.com")
+	link, err := url.Parse(links.URL(alice.Email))
+	if err != nil {
+		t.Fatalf("parsing link: %v", err)
+	}
+	token := link.Query().Get("token")
+	if userID, err := links.Verify(token); err != nil || userID != alice.Email {
+		t.Fatalf("Verify = %q, %v, want %s", userID, err, alice.Email)
+	}
+
+	alicesID, _, _ := strings.Cut(token, ".")
+	_, bobsMAC, _ := strings.Cut(links.Token(bob.Email), ".")
+	for _, token := range []string{
+		"",
+		"not a token",
+		NewUnsubscribeLinks("other secret", "https://stocks.example.com").Token(alice.Email),
+		alicesID + "." + bobsMAC,
+		alicesID + ".",
+		"." + bobsMAC,
+	} {
+		if userID, err := links.Verify(token); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+			t.Errorf("Verify(%q) = %q, %v, want ErrInvalidUnsubscribeToken", token, userID, err)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/database"
+	"stockmarket/server/internal/models"
+	"stockmarket/server/internal/notifications/egress"
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookSignatureHeader = "X-Stockmarket-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">
+	WebhookTimestampHeader = "X-Stockmarket-Timestamp" // Unix seconds when the delivery was signed
+	WebhookDeliveryHeader  = "X-Stockmarket-Delivery"  // The same on every attempt at one fire, to dedupe on
+)
+
+// Errors managing webhooks
+var (
+	ErrInvalidWebhookURL = errors.New("invalid webhook URL")
+	ErrInvalidSignature  = errors.New("webhook signature does not match")
+	ErrStaleTimestamp    = errors.New("webhook timestamp is too old")
+)
+
+// SignWebhook returns the signature header of body sent at timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature and timestamp headers of a delivery the
+// way receivers should: the signature must match and the timestamp be
+// within tolerance of now, so a captured delivery can't be replayed later.
+func VerifyWebhook(secret, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+// WebhookStore is the storage the webhook notifier uses
+type WebhookStore interface {
+	UserStore
+	database.WebhookRepository
+}
+
+// WebhookNotifier posts alerts to the webhooks users registered. Each
+// delivery is retried with exponential backoff; one that fails every
+// attempt is dead-lettered to be replayed by hand, and a webhook whose
+// deliveries keep failing is disabled and its owner emailed.
+type WebhookNotifier struct {
+	store  WebhookStore
+	email  *EmailService // nil when there is no mailer
+	client *http.Client
+
+	MaxAttempts  int           // Posts per delivery before it is dead-lettered
+	Backoff      time.Duration // Wait before the first retry, doubled for each one after
+	MaxBackoff   time.Duration
+	DisableAfter int  // Dead-lettered deliveries in a row that disable a webhook
+	AllowHTTP    bool // Accept plain http URLs, e.g. for endpoints on a private network
+	AllowPrivate bool // Post to loopback, private and link-local addresses, which are refused otherwise
+
+	now func() time.Time
+}
+
+// NewWebhookNotifier creates a webhook notifier. email may be nil, in which
+// case owners aren't told when their webhooks are disabled. Deliveries
+// don't follow redirects, and only go to public addresses unless
+// AllowPrivate is set, so users can't aim them at the server's network.
+func NewWebhookNotifier(store WebhookStore, email *EmailService) *WebhookNotifier {
+	// Every attempt and the waits between them fit in the dispatcher's
+	// 30 second timeout
+	n := &WebhookNotifier{
+		store:        store,
+		email:        email,
+		MaxAttempts:  4,
+		Backoff:      500 * time.Millisecond,
+		MaxBackoff:   4 * time.Second,
+		DisableAfter: 5,
+		now:          time.Now,
+	}
+	n.client = egress.NewClient(5*time.Second, func() bool { return n.AllowPrivate })
+	return n
+}
+
+func (n *WebhookNotifier) Channel() string { return models.ChannelWebhook }
+
+// Notify posts alert to each of user's enabled webhooks
+func (n *WebhookNotifier) Notify(ctx context.Context, user *models.User, alert Alert) error {
+	webhooks, err := n.store.GetUserWebhooks(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	var enabled []*models.Webhook
+	for _, webhook := range webhooks {
+		if webhook.Enabled {
+			enabled = append(enabled, webhook)
+		}
+	}
+	if len(enabled) == 0 {
+		return &SkipError{Reason: "no enabled webhooks"}
+	}
+
+	payload, err := evaluationJSON(alert)
+	if err != nil {
+		return err
+	}
+	if alert.Test {
+		return n.postTest(ctx, enabled, alert.FireID, payload)
+	}
+
+	errs := make([]error, len(enabled))
+	var wg sync.WaitGroup
+	for i, webhook := range enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = n.deliver(ctx, user, webhook, alert.FireID, payload)
+		}()
+	}
+	wg.Wait()
+
+	var dead []string
+	for i, err := range errs {
+		var letter *DeadLetterError
+		switch {
+		case errors.As(err, &letter):
+			dead = append(dead, enabled[i].URL+": "+letter.Reason)
+		case err != nil:
+			// Not even dead-lettered, so the whole delivery is retried
+			return err
+		}
+	}
+	if len(dead) > 0 {
+		return &DeadLetterError{Reason: fmt.Sprintf("%d of %d webhooks failed: %s", len(dead), len(enabled), strings.Join(dead, "; "))}
+	}
+	return nil
+}
+
+// postTest posts a test alert to each webhook once. Failures are reported
+// but neither dead-lettered nor counted against the webhook.
+func (n *WebhookNotifier) postTest(ctx context.Context, webhooks []*models.Webhook, deliveryID string, payload []byte) error {
+	errs := make([]error, len(webhooks))
+	var wg sync.WaitGroup
+	for i, webhook := range webhooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.postOnce(ctx, webhook, deliveryID, payload); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", webhook.URL, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Test posts a sample alert to one of the user's webhooks, enabled or not,
+// so they can check their endpoint accepts and verifies deliveries. It
+// returns a WebhookTestError if the endpoint doesn't accept it.
+func (n *WebhookNotifier) Test(ctx context.Context, userID, webhookID string) error {
+	webhook, err := n.store.GetWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return err
+	}
+	alert := sampleAlert(userID, n.now())
+	payload, err := evaluationJSON(alert)
+	if err != nil {
+		return err
+	}
+	if err := n.postOnce(ctx, webhook, alert.FireID, payload); err != nil {
+		return &WebhookTestError{Reason: err.Error()}
+	}
+	return nil
+}
+
+// WebhookTestError is returned when a webhook doesn't accept a test alert
+type WebhookTestError struct {
+	Reason string
+}
+
+func (e *WebhookTestError) Error() string { return "webhook test failed: " + e.Reason }
+
+// deliver posts payload to webhook, dead-lettering it if every attempt
+// fails. It returns a DeadLetterError once the payload is safely kept.
+func (n *WebhookNotifier) deliver(ctx context.Context, user *models.User, webhook *models.Webhook, fireID string, payload []byte) error {
+	attempts, err := n.post(ctx, webhook, fireID, payload)
+
+	// Record the outcome even if the dispatcher's timeout cut the attempts
+	// short
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if _, err := n.store.RecordWebhookResult(ctx, webhook.UserID, webhook.WebhookID, true); err != nil {
+			log.Printf("Failed to record delivery to webhook %s: %v", webhook.WebhookID, err)
+		}
+		return nil
+	}
+
+	letter := &models.WebhookDeadLetter{
+		UserID:       webhook.UserID,
+		DeadLetterID: fireID + ":" + webhook.WebhookID,
+		WebhookID:    webhook.WebhookID,
+		FireID:       fireID,
+		Payload:      string(payload),
+		Error:        err.Error(),
+		Attempts:     attempts,
+		FailedAt:     n.now(),
+	}
+	if err := n.store.SaveWebhookDeadLetter(ctx, letter); err != nil {
+		return fmt.Errorf("failed to dead-letter delivery to webhook %s: %v", webhook.WebhookID, err)
+	}
+	n.recordFailure(ctx, user, webhook, err)
+	return &DeadLetterError{Reason: err.Error()}
+}
+
+// recordFailure counts a dead-lettered delivery against webhook and
+// disables it once too many have failed in a row
+func (n *WebhookNotifier) recordFailure(ctx context.Context, user *models.User, webhook *models.Webhook, cause error) {
+	failures, err := n.store.RecordWebhookResult(ctx, webhook.UserID, webhook.WebhookID, false)
+	if err != nil {
+		log.Printf("Failed to record failed delivery to webhook %s: %v", webhook.WebhookID, err)
+		return
+	}
+	if failures < n.DisableAfter {
+		return
+	}
+
+	current, err := n.store.GetWebhook(ctx, webhook.UserID, webhook.WebhookID)
+	if err != nil {
+		log.Printf("Failed to disable webhook %s: %v", webhook.WebhookID, err)
+		return
+	}
+	if !current.Enabled {
+		return
+	}
+	disabledAt := n.now()
+	current.Enabled = false
+	current.DisabledAt = &disabledAt
+	// Of concurrent failures only the one whose update lands emails the
+	// owner; the others see a conflict
+	if err := n.store.UpdateWebhook(ctx, current); err != nil {
+		if !errors.Is(err, database.ErrConflict) {
+			log.Printf("Failed to disable webhook %s: %v", webhook.WebhookID, err)
+		}
+		return
+	}
+	log.Printf("Disabled webhook %s of %s after %d failed deliveries", webhook.WebhookID, webhook.UserID, failures)
+
+	if n.email == nil {
+		return
+	}
+	err = n.email.SendWebhookDisabled(ctx, WebhookDisabledNotification{
+		UserID:    user.UserID,
+		Email:     user.Email,
+		URL:       current.URL,
+		Failures:  failures,
+		LastError: cause.Error(),
+		Language:  user.NotificationPreferences.Language,
+	})
+	if err != nil {
+		log.Printf("Failed to tell %s webhook %s was disabled: %v", user.Email, webhook.WebhookID, err)
+	}
+}
+
+// permanentError is a response that retrying won't change
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// post sends payload to webhook until it is accepted or the attempts run
+// out, and returns how many attempts were made
+func (n *WebhookNotifier) post(ctx context.Context, webhook *models.Webhook, deliveryID string, payload []byte) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = n.postOnce(ctx, webhook, deliveryID, payload)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || attempt >= n.MaxAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(n.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("%v; gave up retrying: %v", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the wait after the given attempt: the base backoff
+// doubled for each attempt before, capped, with jitter so retries to a
+// struggling endpoint don't arrive in lockstep
+func (n *WebhookNotifier) backoff(attempt int) time.Duration {
+	d := n.Backoff << (attempt - 1)
+	if d <= 0 || d > n.MaxBackoff {
+		d = n.MaxBackoff
+	}
+	return d/2 + mathrand.N(d/2+1)
+}
+
+// postOnce makes one signed POST of payload to webhook
+func (n *WebhookNotifier) postOnce(ctx context.Context, webhook *models.Webhook, deliveryID string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return &permanentError{err}
+	}
+	timestamp := n.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "stockmarket-webhooks/1")
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, payload))
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+
+	resp, err := n.client.Do(req)
+	if errors.Is(err, egress.ErrBlocked) {
+		return &permanentError{err}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook responded %s", resp.Status)
+	// Redirects aren't followed, and other client errors mean the endpoint
+	// rejects the delivery itself
+	if resp.StatusCode >= 300 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// Register adds a webhook for the user with the given email and turns on
+// webhook alerts. The returned webhook holds the secret deliveries are
+// signed with.
+func (n *WebhookNotifier) Register(ctx context.Context, userID, rawURL string) (*models.Webhook, error) {
+	if err := n.validateURL(rawURL); err != nil {
+		return nil, err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := &models.Webhook{UserID: userID, URL: rawURL, Secret: secret, Enabled: true}
+	if err := n.store.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	user, err := n.store.GetUserByEmail(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.NotificationPreferences.Webhook {
+		user.NotificationPreferences.Webhook = true
+		if err := n.store.UpdateUser(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to turn on webhook alerts: %v", err)
+		}
+	}
+	return webhook, nil
+}
+
+// validateURL checks a webhook URL is absolute, https unless AllowHTTP is
+// set, and not obviously on a private network unless AllowPrivate is set
+func (n *WebhookNotifier) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.User != nil {
+		return fmt.Errorf("%w: must be an absolute https URL", ErrInvalidWebhookURL)
+	}
+	if u.Scheme != "https" && !(n.AllowHTTP && u.Scheme == "http") {
+		return fmt.Errorf("%w: must be an absolute https URL", ErrInvalidWebhookURL)
+	}
+	if !n.AllowPrivate {
+		if err := egress.CheckURL(u); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidWebhookURL, err)
+		}
+	}
+	return nil
+}
+
+// Webhooks returns the user's webhooks
+func (n *WebhookNotifier) Webhooks(ctx context.Context, userID string) ([]*models.Webhook, error) {
+	return n.store.GetUserWebhooks(ctx, userID)
+}
+
+// Delete removes one of the user's webhooks and its dead letters
+func (n *WebhookNotifier) Delete(ctx context.Context, userID, webhookID string) error {
+	return n.store.DeleteWebhook(ctx, userID, webhookID)
+}
+
+// Enable turns a disabled webhook back on with a clean failure count
+func (n *WebhookNotifier) Enable(ctx context.Context, userID, webhookID string) (*models.Webhook, error) {
+	webhook, err := n.store.GetWebhook(ctx, userID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Enabled = true
+	webhook.DisabledAt = nil
+	webhook.Failures = 0
+	if err := n.store.UpdateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// DeadLetters returns the user's dead-lettered deliveries
+func (n *WebhookNotifier) DeadLetters(ctx context.Context, userID string) ([]*models.WebhookDeadLetter, error) {
+	return n.store.GetUserWebhookDeadLetters(ctx, userID)
+}
+
+// Replay posts a dead-lettered delivery again, with the same retries as the
+// first time, and removes it once it is accepted. Replays go to the webhook
+// even while it is disabled, so a fixed endpoint can be checked before it
+// is enabled again.
+func (n *WebhookNotifier) Replay(ctx context.Context, userID, deadLetterID string) error {
+	letter, err := n.store.GetWebhookDeadLetter(ctx, userID, deadLetterID)
+	if err != nil {
+		return err
+	}
+	webhook, err := n.store.GetWebhook(ctx, userID, letter.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := n.post(ctx, webhook, letter.FireID, []byte(letter.Payload))
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		letter.Attempts += attempts
+		letter.Error = err.Error()
+		letter.FailedAt = n.now()
+		if saveErr := n.store.SaveWebhookDeadLetter(ctx, letter); saveErr != nil {
+			log.Printf("Failed to update dead letter %s: %v", deadLetterID, saveErr)
+		}
+		return &DeadLetterError{Reason: err.Error()}
+	}
+
+	if err := n.store.DeleteWebhookDeadLetter(ctx, userID, deadLetterID); err != nil && !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+	if _, err := n.store.RecordWebhookResult(ctx, userID, webhook.WebhookID, true); err != nil {
+		log.Printf("Failed to record delivery to webhook %s: %v", webhook.WebhookID, err)
+	}
+	return nil
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,316 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+)
+
+// fakeMailer records the emails it is asked to send
+type fakeMailer struct {
+	mu     sync.Mutex
+	emails []Email
+}
+
+func (f *fakeMailer) Send(ctx context.Context, email Email) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emails = append(f.emails, email)
+	return nil
+}
+
+// webhookReceiver is an endpoint that answers with the next of its
+// statuses, then 200 once they run out, and records what it was sent
+type webhookReceiver struct {
+	*httptest.Server
+	statuses []int
+	hits     atomic.Int32
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		n := int(r.hits.Add(1))
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+		if n <= len(r.statuses) {
+			w.WriteHeader(r.statuses[n-1])
+		}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func setupWebhooks(t *testing.T) (*memory.Store, *WebhookNotifier, *fakeMailer, *models.User) {
+	t.Helper()
+	store := memory.NewStore()
+	user := &models.User{UserID: "id-carol", Email: "carol@example.com"}
+	if err := store.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	mailer := &fakeMailer{}
+	n := NewWebhookNotifier(store, NewEmailService(mailer))
+	n.AllowHTTP = true
+	n.AllowPrivate = true // The receivers listen on loopback
+	n.Backoff = time.Millisecond
+	n.MaxBackoff = 5 * time.Millisecond
+	return store, n, mailer, user
+}
+
+func register(t *testing.T, store *memory.Store, n *WebhookNotifier, url string) (*models.Webhook, *models.User) {
+	t.Helper()
+	webhook, err := n.Register(context.Background(), "carol@example.com", url)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	user, err := store.GetUserByEmail(context.Background(), "carol@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	return webhook, user
+}
+
+var webhookAlert = Alert{
+	FireID:    "fire-1",
+	TriggerID: "trigger-1",
+	UserID:    "carol@example.com",
+	Symbol:    "AAPL",
+	Exchange:  "NASDAQ",
+	Price:     201,
+	Message:   "Price exceeded upper limit",
+	At:        time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC),
+}
+
+func TestWebhookRegisterValidatesURL(t *testing.T) {
+	_, n, _, _ := setupWebhooks(t)
+	n.AllowHTTP = false
+	n.AllowPrivate = false
+	for _, url := range []string{"http://example.com/hook", "example.com/hook", "https://user:pw@example.com/", "https:///path",
+		"https://localhost/hook", "https://127.0.0.1/hook", "https://169.254.169.254/latest/meta-data", "https://[::1]/hook"} {
+		if _, err := n.Register(context.Background(), "carol@example.com", url); !errors.Is(err, ErrInvalidWebhookURL) {
+			t.Errorf("Register(%q) = %v, want ErrInvalidWebhookURL", url, err)
+		}
+	}
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	store, n, _, _ := setupWebhooks(t)
+	receiver := newWebhookReceiver(t)
+	webhook, user := register(t, store, n, receiver.URL)
+	if !user.ChannelEnabled(models.ChannelWebhook) || !strings.HasPrefix(webhook.Secret, "whsec_") {
+		t.Fatalf("after Register, user prefs = %+v and secret = %q", user.NotificationPreferences, webhook.Secret)
+	}
+
+	if err := n.Notify(context.Background(), user, webhookAlert); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if receiver.hits.Load() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", receiver.hits.Load())
+	}
+	req, body := receiver.requests[0], receiver.bodies[0]
+	err := VerifyWebhook(webhook.Secret, req.Header.Get(WebhookSignatureHeader), req.Header.Get(WebhookTimestampHeader), body, 5*time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("VerifyWebhook: %v", err)
+	}
+	if err := VerifyWebhook("whsec_other", req.Header.Get(WebhookSignatureHeader), req.Header.Get(WebhookTimestampHeader), body, 5*time.Minute, time.Now()); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyWebhook with the wrong secret = %v, want ErrInvalidSignature", err)
+	}
+	if err := VerifyWebhook(webhook.Secret, req.Header.Get(WebhookSignatureHeader), req.Header.Get(WebhookTimestampHeader), body, 5*time.Minute, time.Now().Add(time.Hour)); !errors.Is(err, ErrStaleTimestamp) {
+		t.Errorf("VerifyWebhook an hour later = %v, want ErrStaleTimestamp", err)
+	}
+	if got := req.Header.Get(WebhookDeliveryHeader); got != "fire-1" {
+		t.Errorf("delivery header = %q, want the fire ID", got)
+	}
+
+	var evaluation map[string]any
+	if err := json.Unmarshal(body, &evaluation); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if evaluation["trigger_id"] != "trigger-1" || evaluation["current_price"] != 201.0 || evaluation["triggered"] != true {
+		t.Errorf("body = %s, want the trigger evaluation", body)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	store, n, _, _ := setupWebhooks(t)
+	receiver := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	_, user := register(t, store, n, receiver.URL)
+
+	if err := n.Notify(context.Background(), user, webhookAlert); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if receiver.hits.Load() != 3 {
+		t.Errorf("receiver got %d requests, want 3", receiver.hits.Load())
+	}
+	letters, _ := store.GetUserWebhookDeadLetters(context.Background(), user.Email)
+	if len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want none", letters)
+	}
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		want := min(n.Backoff<<(attempt-1), n.MaxBackoff)
+		if d := n.backoff(attempt); d < want/2 || d > want {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, d, want/2, want)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddressesAndRedirects(t *testing.T) {
+	ctx := context.Background()
+	store, n, _, _ := setupWebhooks(t)
+	private := newWebhookReceiver(t)
+	redirecting := newWebhookReceiver(t, http.StatusTemporaryRedirect)
+	register(t, store, n, private.URL)
+	_, user := register(t, store, n, redirecting.URL)
+
+	// A name can resolve to a private address after it was registered, so
+	// the address is checked again when the delivery connects
+	n.AllowPrivate = false
+	err := n.Notify(ctx, user, webhookAlert)
+	var letter *DeadLetterError
+	if !errors.As(err, &letter) || !strings.Contains(letter.Reason, "not publicly routable") {
+		t.Fatalf("Notify = %v, want dead-lettered for the private address", err)
+	}
+	if private.hits.Load() != 0 {
+		t.Errorf("private receiver got %d requests, want none", private.hits.Load())
+	}
+
+	n.AllowPrivate = true
+	if err := n.Notify(ctx, user, webhookAlert); !errors.As(err, &letter) || !strings.Contains(letter.Reason, "307") {
+		t.Fatalf("Notify = %v, want the redirect dead-lettered", err)
+	}
+	if redirecting.hits.Load() != 1 {
+		t.Errorf("redirecting receiver got %d requests, want the redirect neither followed nor retried", redirecting.hits.Load())
+	}
+}
+
+func TestWebhookDeadLettersAndDisables(t *testing.T) {
+	ctx := context.Background()
+	store, n, mailer, _ := setupWebhooks(t)
+	n.MaxAttempts = 2
+	n.DisableAfter = 2
+	failing := newWebhookReceiver(t, 500, 500, 500, 500)
+	healthy := newWebhookReceiver(t)
+	webhook, _ := register(t, store, n, failing.URL)
+	_, user := register(t, store, n, healthy.URL)
+	d := NewDispatcher(n)
+
+	deliveries := d.Dispatch(ctx, user, []string{models.ChannelWebhook}, webhookAlert)
+	if got := deliveries[0]; got.Status != models.DeliveryDead || !strings.Contains(got.Error, "1 of 2 webhooks failed") {
+		t.Fatalf("delivery = %+v, want dead with one of two webhooks failed", got)
+	}
+	letter, err := store.GetWebhookDeadLetter(ctx, user.Email, "fire-1:"+webhook.WebhookID)
+	if err != nil {
+		t.Fatalf("GetWebhookDeadLetter: %v", err)
+	}
+	if letter.Attempts != 2 || !strings.Contains(letter.Error, "500") || letter.Payload != string(failing.bodies[0]) {
+		t.Errorf("dead letter = %+v", letter)
+	}
+
+	// A second delivery in a row fails, which disables the webhook and
+	// tells its owner
+	second := webhookAlert
+	second.FireID = "fire-2"
+	d.Dispatch(ctx, user, []string{models.ChannelWebhook}, second)
+	stored, _ := store.GetWebhook(ctx, user.Email, webhook.WebhookID)
+	if stored.Enabled || stored.DisabledAt == nil || stored.Failures != 2 {
+		t.Fatalf("webhook after two failed deliveries = %+v, want disabled", stored)
+	}
+	if len(mailer.emails) != 1 || mailer.emails[0].To.Email != user.Email || !strings.Contains(mailer.emails[0].Body, failing.URL) {
+		t.Fatalf("emails = %+v, want one to the owner naming the webhook", mailer.emails)
+	}
+
+	// Disabled webhooks get nothing more
+	third := webhookAlert
+	third.FireID = "fire-3"
+	d.Dispatch(ctx, user, []string{models.ChannelWebhook}, third)
+	if failing.hits.Load() != 4 || healthy.hits.Load() != 3 {
+		t.Errorf("failing got %d requests and healthy %d, want 4 and 3", failing.hits.Load(), healthy.hits.Load())
+	}
+
+	// The endpoint recovers: replaying succeeds and removes the dead letter
+	if err := n.Replay(ctx, user.Email, letter.DeadLetterID); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if _, err := store.GetWebhookDeadLetter(ctx, user.Email, letter.DeadLetterID); err == nil {
+		t.Error("dead letter kept after a successful replay")
+	}
+	if got := failing.bodies[len(failing.bodies)-1]; string(got) != letter.Payload {
+		t.Errorf("replayed body = %s, want %s", got, letter.Payload)
+	}
+	if _, err := n.Enable(ctx, user.Email, webhook.WebhookID); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	stored, _ = store.GetWebhook(ctx, user.Email, webhook.WebhookID)
+	if !stored.Enabled || stored.Failures != 0 || stored.DisabledAt != nil {
+		t.Errorf("webhook after Enable = %+v", stored)
+	}
+}
+
+func TestWebhookClientErrorsAreNotRetried(t *testing.T) {
+	store, n, _, _ := setupWebhooks(t)
+	receiver := newWebhookReceiver(t, http.StatusGone)
+	_, user := register(t, store, n, receiver.URL)
+
+	var dead *DeadLetterError
+	if err := n.Notify(context.Background(), user, webhookAlert); !errors.As(err, &dead) {
+		t.Fatalf("Notify = %v, want a DeadLetterError", err)
+	}
+	if receiver.hits.Load() != 1 {
+		t.Errorf("receiver got %d requests, want 1", receiver.hits.Load())
+	}
+}
+
+func TestWebhookTestsArePostedOnce(t *testing.T) {
+	ctx := context.Background()
+	store, n, _, _ := setupWebhooks(t)
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	webhook, user := register(t, store, n, receiver.URL)
+
+	// A failing test isn't retried, dead-lettered or counted
+	alert := sampleAlert(user.Email, time.Now())
+	if err := n.Notify(ctx, user, alert); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("Notify = %v, want the endpoint's failure", err)
+	}
+	if err := n.Test(ctx, user.Email, webhook.WebhookID); err != nil {
+		t.Fatalf("Test = %v, want the endpoint to accept the second", err)
+	}
+	if receiver.hits.Load() != 2 {
+		t.Errorf("receiver got %d requests, want 2", receiver.hits.Load())
+	}
+	stored, _ := store.GetWebhook(ctx, user.Email, webhook.WebhookID)
+	letters, _ := store.GetUserWebhookDeadLetters(ctx, user.Email)
+	if stored.Failures != 0 || len(letters) != 0 {
+		t.Errorf("webhook = %+v with %d dead letters, want no failure kept", stored, len(letters))
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(receiver.bodies[1], &payload); err != nil || payload["test"] != true {
+		t.Errorf("payload = %s, want it marked as a test", receiver.bodies[1])
+	}
+
+	receiver.statuses = []int{http.StatusNotFound}
+	receiver.hits.Store(0)
+	var failed *WebhookTestError
+	if err := n.Test(ctx, user.Email, webhook.WebhookID); !errors.As(err, &failed) {
+		t.Errorf("Test = %v, want a WebhookTestError", err)
+	}
+}
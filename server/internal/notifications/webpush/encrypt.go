@@ -0,0 +1,190 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	saltSize = 16
+	// recordSize is the one record a message is sent in. Push services
+	// accept at most 4096 bytes of body, header included.
+	recordSize = 4096
+	headerSize = saltSize + 4 + 1 + 65 // salt, record size, key ID length, sender's public key
+	// MaxPayload is the largest payload Send can deliver: what is left of
+	// the body after the header, the padding delimiter and the GCM tag
+	MaxPayload = recordSize - headerSize - 1 - 16
+)
+
+// Encrypt encrypts payload for sub as an aes128gcm message body (RFC 8188)
+// with keys agreed with the browser as RFC 8291 describes. Every message
+// uses a new key pair and salt.
+func Encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayload {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d fit", ErrPayloadTooLarge, len(payload), MaxPayload)
+	}
+	browserKey, authSecret, err := sub.keys()
+	if err != nil {
+		return nil, err
+	}
+	senderKey, err := p256.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(payload, senderKey, browserKey, authSecret, salt)
+}
+
+// Validate checks the subscription's keys are ones messages can be
+// encrypted with
+func (s Subscription) Validate() error {
+	_, _, err := s.keys()
+	return err
+}
+
+// keys decodes the browser's public key and authentication secret
+func (s Subscription) keys() (*ecdh.PublicKey, []byte, error) {
+	p256dh, err := decodeBase64(s.P256DH)
+	if err != nil {
+		return nil, nil, errors.New("subscription key must be base64url")
+	}
+	browserKey, err := p256.NewPublicKey(p256dh)
+	if err != nil {
+		return nil, nil, errors.New("subscription key must be an uncompressed P-256 point")
+	}
+	authSecret, err := decodeBase64(s.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, nil, errors.New("subscription auth secret must be 16 bytes of base64url")
+	}
+	return browserKey, authSecret, nil
+}
+
+// encrypt writes payload as one record, sealed with the content key and
+// nonce derived from the sender's key, the browser's key and the salt
+func encrypt(payload []byte, senderKey *ecdh.PrivateKey, browserKey *ecdh.PublicKey, authSecret, salt []byte) ([]byte, error) {
+	secret, err := senderKey.ECDH(browserKey)
+	if err != nil {
+		return nil, err
+	}
+	senderPublic := senderKey.PublicKey().Bytes()
+	gcm, nonce, err := contentCipher(secret, authSecret, salt, browserKey.Bytes(), senderPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(recordSize))
+	body.WriteByte(byte(len(senderPublic)))
+	body.Write(senderPublic)
+	// 2 marks the last record; no padding follows
+	record := append(append([]byte(nil), payload...), 2)
+	body.Write(gcm.Seal(nil, nonce, record, nil))
+	return body.Bytes(), nil
+}
+
+// Decrypt reads a message body Encrypt wrote for the browser holding
+// private and authSecret. Push services can't, as they don't hold the
+// browser's key; it is here for browsers' stand-ins in tests.
+func Decrypt(body []byte, private *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < saltSize+5 {
+		return nil, errors.New("message is too short")
+	}
+	salt := body[:saltSize]
+	rs := binary.BigEndian.Uint32(body[saltSize:])
+	idLen := int(body[saltSize+4])
+	rest := body[saltSize+5:]
+	if len(rest) < idLen || rs < 18 {
+		return nil, errors.New("malformed message header")
+	}
+	senderPublic, records := rest[:idLen], rest[idLen:]
+	senderKey, err := p256.NewPublicKey(senderPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender key: %v", err)
+	}
+	secret, err := private.ECDH(senderKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := contentCipher(secret, authSecret, salt, private.PublicKey().Bytes(), senderPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	for seq := uint64(0); len(records) > 0; seq++ {
+		n := min(int(rs), len(records))
+		plain, err := gcm.Open(nil, recordNonce(nonce, seq), records[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt record %d: %v", seq, err)
+		}
+		records = records[n:]
+		// Padding is zeros after a delimiter: 2 on the last record, 1 on
+		// the others
+		end := bytes.TrimRight(plain, "\x00")
+		if len(end) == 0 {
+			return nil, errors.New("record has no delimiter")
+		}
+		last := end[len(end)-1] == 2
+		if last != (len(records) == 0) || (!last && end[len(end)-1] != 1) {
+			return nil, errors.New("record has the wrong delimiter")
+		}
+		payload = append(payload, end[:len(end)-1]...)
+	}
+	return payload, nil
+}
+
+// contentCipher derives the AES-128-GCM cipher and base nonce of a message
+// from the ECDH secret, the browser's auth secret, the salt and both public
+// keys
+func contentCipher(secret, authSecret, salt, browserPublic, senderPublic []byte) (cipher.AEAD, []byte, error) {
+	// RFC 8291 section 3.4: mix in the auth secret and both keys
+	keyInfo := "WebPush: info\x00" + string(browserPublic) + string(senderPublic)
+	ikm, err := hkdf.Key(sha256.New, secret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	// RFC 8188 section 2.2 and 2.3
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, nonce, nil
+}
+
+// recordNonce is the nonce of record seq: the base nonce XORed with the
+// sequence number
+func recordNonce(nonce []byte, seq uint64) []byte {
+	n := append([]byte(nil), nonce...)
+	for i := range 8 {
+		n[len(n)-1-i] ^= byte(seq >> (8 * i))
+	}
+	return n
+}
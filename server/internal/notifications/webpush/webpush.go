@@ -0,0 +1,284 @@
+// Package webpush sends Web Push messages (RFC 8030) to browsers. Payloads
+// are encrypted for the subscribing browser (RFC 8291) and every request
+// identifies the application server with VAPID (RFC 8292).
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"stockmarket/server/internal/notifications/egress"
+)
+
+// Errors sending a push message
+var (
+	// ErrGone is returned when the push service no longer knows the
+	// subscription, which should then be forgotten
+	ErrGone = errors.New("push subscription is gone")
+	// ErrPayloadTooLarge is returned for a payload that doesn't fit in one
+	// push message
+	ErrPayloadTooLarge = errors.New("push payload is too large")
+)
+
+// Subscription is where a browser receives push messages and the keys it
+// decrypts them with, as its PushSubscription reports them
+type Subscription struct {
+	Endpoint string // The push service URL messages are posted to
+	P256DH   string // The browser's P-256 public key, base64url
+	Auth     string // The browser's 16 byte authentication secret, base64url
+}
+
+// VAPIDKeys is the P-256 key pair the application server signs push
+// requests with. Browsers subscribe with the public key, so push services
+// only accept messages signed with the private one.
+type VAPIDKeys struct {
+	private *ecdsa.PrivateKey
+	public  []byte // Uncompressed point
+}
+
+// GenerateVAPIDKeys creates a new key pair
+func GenerateVAPIDKeys() (*VAPIDKeys, error) {
+	private, err := p256.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate VAPID keys: %v", err)
+	}
+	return newVAPIDKeys(private), nil
+}
+
+// ParseVAPIDKeys reads a key pair from its private key, the base64url
+// 32 byte scalar most Web Push libraries print
+func ParseVAPIDKeys(privateKey string) (*VAPIDKeys, error) {
+	d, err := decodeBase64(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, fmt.Errorf("VAPID private key must be 32 bytes of base64url")
+	}
+	private, err := p256.NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %v", err)
+	}
+	return newVAPIDKeys(private), nil
+}
+
+// newVAPIDKeys makes the signing key of an ECDH key on the same curve
+func newVAPIDKeys(private *ecdh.PrivateKey) *VAPIDKeys {
+	public := private.PublicKey().Bytes()
+	x, y := elliptic.Unmarshal(elliptic.P256(), public)
+	return &VAPIDKeys{
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+			D:         new(big.Int).SetBytes(private.Bytes()),
+		},
+		public: public,
+	}
+}
+
+// PublicKey returns the uncompressed public key, base64url, which browsers
+// take as the applicationServerKey when subscribing
+func (k *VAPIDKeys) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.public)
+}
+
+// PrivateKey returns the private key, base64url, as ParseVAPIDKeys reads it
+func (k *VAPIDKeys) PrivateKey() string {
+	return base64.RawURLEncoding.EncodeToString(k.private.D.FillBytes(make([]byte, 32)))
+}
+
+// authorization returns the Authorization header of a push request to
+// endpoint: a JWT for the push service's origin, signed ES256, with the
+// public key to check it against
+func (k *VAPIDKeys) authorization(endpoint, subject string, expires time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": expires.Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign VAPID token: %v", err)
+	}
+	// JWS signatures are r and s as fixed size big-endian integers
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return "vapid t=" + token + ", k=" + k.PublicKey(), nil
+}
+
+// VerifyAuthorization checks the Authorization header of a push request to
+// endpoint, as a push service does. It returns the public key the request
+// was signed with, which must be the one the subscription was made with.
+func VerifyAuthorization(header, endpoint string, now time.Time) (string, error) {
+	scheme, params, _ := strings.Cut(header, " ")
+	var token, key string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+	parts := strings.Split(token, ".")
+	if scheme != "vapid" || len(parts) != 3 || key == "" {
+		return "", errors.New("authorization is not a VAPID token and key")
+	}
+
+	public, err := decodeBase64(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid VAPID key: %v", err)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), public)
+	if x == nil {
+		return "", errors.New("VAPID key is not a P-256 point")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return "", errors.New("invalid VAPID signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s) {
+		return "", errors.New("VAPID signature doesn't match its key")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("invalid VAPID claims")
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.New("invalid VAPID claims")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || claims.Aud != u.Scheme+"://"+u.Host {
+		return "", fmt.Errorf("VAPID token is for %q", claims.Aud)
+	}
+	// Tokens may be valid for at most a day
+	if exp := time.Unix(claims.Exp, 0); !exp.After(now) || exp.After(now.Add(24*time.Hour)) {
+		return "", errors.New("VAPID token has expired or lives too long")
+	}
+	if claims.Sub == "" {
+		return "", errors.New("VAPID token has no contact")
+	}
+	return key, nil
+}
+
+// Sender posts encrypted messages to push services
+type Sender struct {
+	keys    *VAPIDKeys
+	subject string
+	client  *http.Client
+	now     func() time.Time
+
+	TTL          time.Duration // How long the push service keeps a message for a browser that is offline
+	Urgency      string        // very-low, low, normal or high (RFC 8030)
+	AllowPrivate bool          // Post to loopback, private and link-local addresses, e.g. a local push service in tests
+}
+
+// NewSender creates a sender signing with keys. subject is a mailto: or
+// https: URL push services can reach the sender's operator at. Endpoints
+// come from browsers, so messages don't follow redirects and only go to
+// public addresses unless AllowPrivate is set.
+func NewSender(keys *VAPIDKeys, subject string) *Sender {
+	s := &Sender{
+		keys:    keys,
+		subject: subject,
+		now:     time.Now,
+		TTL:     24 * time.Hour,
+		Urgency: "high",
+	}
+	s.client = egress.NewClient(10*time.Second, func() bool { return s.AllowPrivate })
+	return s
+}
+
+// PublicKey returns the key browsers subscribe with
+func (s *Sender) PublicKey() string {
+	return s.keys.PublicKey()
+}
+
+// Send encrypts payload for sub and posts it to its push service. It
+// returns an error wrapping ErrGone if the push service says the
+// subscription has expired or was removed.
+func (s *Sender) Send(ctx context.Context, sub Subscription, payload []byte) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := s.keys.authorization(sub.Endpoint, s.subject, s.now().Add(12*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid push endpoint: %v", err)
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(s.TTL.Seconds())))
+	if s.Urgency != "" {
+		req.Header.Set("Urgency", s.Urgency)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fmt.Errorf("%w: push service responded %s", ErrGone, resp.Status)
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return fmt.Errorf("%w: push service responded %s", ErrPayloadTooLarge, resp.Status)
+	}
+	if len(bytes.TrimSpace(reason)) > 0 {
+		return fmt.Errorf("push service responded %s: %s", resp.Status, bytes.TrimSpace(reason))
+	}
+	return fmt.Errorf("push service responded %s", resp.Status)
+}
+
+// decodeBase64 decodes base64url with or without padding, as browsers and
+// libraries write keys either way
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// p256 is the curve every Web Push key is on
+var p256 = ecdh.P256()
@@ -0,0 +1,104 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeBase64(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+	return b
+}
+
+// TestEncryptMatchesRFC8291 encrypts the example in RFC 8291 appendix A
+// with its keys and salt
+func TestEncryptMatchesRFC8291(t *testing.T) {
+	senderKey, err := p256.NewPrivateKey(mustDecode(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	browserKey, err := p256.NewPrivateKey(mustDecode(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := mustDecode(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := mustDecode(t, "DGv6ra1nlYgDCS1FRnbzlw")
+	plaintext := []byte("When I grow up, I want to be a watermelon")
+
+	body, err := encrypt(plaintext, senderKey, browserKey.PublicKey(), authSecret, salt)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := base64.RawURLEncoding.EncodeToString(body); got != want {
+		t.Errorf("body = %s\nwant  %s", got, want)
+	}
+
+	decrypted, err := Decrypt(body, browserKey, authSecret)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt = %q, %v", decrypted, err)
+	}
+}
+
+func TestEncryptRejectsLargePayloads(t *testing.T) {
+	browserKey, _ := p256.GenerateKey(rand.Reader)
+	sub := Subscription{
+		P256DH: base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(make([]byte, 16)),
+	}
+	if _, err := Encrypt(sub, make([]byte, MaxPayload+1)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("Encrypt of %d bytes = %v, want ErrPayloadTooLarge", MaxPayload+1, err)
+	}
+	body, err := Encrypt(sub, make([]byte, MaxPayload))
+	if err != nil || len(body) != 4096 {
+		t.Fatalf("Encrypt of %d bytes = %d bytes, %v, want exactly 4096", MaxPayload, len(body), err)
+	}
+	if _, err := Decrypt(body, browserKey, make([]byte, 16)); err != nil {
+		t.Errorf("Decrypt: %v", err)
+	}
+	if _, err := Decrypt(body, browserKey, bytes.Repeat([]byte{1}, 16)); err == nil {
+		t.Error("Decrypt with the wrong auth secret succeeded")
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseVAPIDKeys(keys.PrivateKey())
+	if err != nil || parsed.PublicKey() != keys.PublicKey() {
+		t.Fatalf("ParseVAPIDKeys = %v, %v, want the same keys back", parsed, err)
+	}
+
+	now := time.Now()
+	endpoint := "https://push.example.com/send/abc"
+	header, err := parsed.authorization(endpoint, "mailto:ops@example.com", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("authorization: %v", err)
+	}
+	if key, err := VerifyAuthorization(header, endpoint, now); err != nil || key != keys.PublicKey() {
+		t.Errorf("VerifyAuthorization = %q, %v, want the public key", key, err)
+	}
+	if _, err := VerifyAuthorization(header, "https://other.example.com/send/abc", now); err == nil {
+		t.Error("token accepted by another push service")
+	}
+	if _, err := VerifyAuthorization(header, endpoint, now.Add(2*time.Hour)); err == nil {
+		t.Error("expired token accepted")
+	}
+
+	other, _ := GenerateVAPIDKeys()
+	forged := strings.Replace(header, "k="+keys.PublicKey(), "k="+other.PublicKey(), 1)
+	if _, err := VerifyAuthorization(forged, endpoint, now); err == nil {
+		t.Error("token accepted with another key")
+	}
+}
@@ -0,0 +1,248 @@
+// Package scheduler decides when each tracked symbol's price is refreshed.
+// Instead of polling everything every few seconds around the clock, each
+// symbol's interval follows its exchange's session, how recently users
+// looked at it and how close its price is to a trigger.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/tracking"
+)
+
+// Policy sets the refresh intervals
+type Policy struct {
+	Regular time.Duration // During regular trading
+	Pre     time.Duration // During pre-market trading
+	Post    time.Duration // During post-market trading
+	Closed  time.Duration // While the exchange is closed
+
+	Viewed       time.Duration // While trading, for symbols viewed within ViewedWindow
+	ViewedWindow time.Duration
+	Near         time.Duration // While trading, for prices within NearThreshold of a trigger
+	NearFraction float64       // e.g. 0.01 for within 1%
+
+	SessionChange time.Duration // Delay of the refresh after a session starts or ends
+	Retry         time.Duration // Wait after a failed fetch
+}
+
+// DefaultPolicy keeps watched symbols fresh while markets trade and backs
+// off sharply when they don't
+var DefaultPolicy = Policy{
+	Regular:       15 * time.Second,
+	Pre:           time.Minute,
+	Post:          time.Minute,
+	Closed:        time.Hour,
+	Viewed:        5 * time.Second,
+	ViewedWindow:  5 * time.Minute,
+	Near:          5 * time.Second,
+	NearFraction:  0.01,
+	SessionChange: time.Minute,
+	Retry:         30 * time.Second,
+}
+
+// SessionFunc returns the session an exchange is in at a time
+type SessionFunc func(exchange string, at time.Time) marketcalendar.Session
+
+// FetchFunc fetches and publishes a symbol's price
+type FetchFunc func(ctx context.Context, key tracking.Key) (float64, error)
+
+// ProximityFunc returns how far price is from the closest trigger on a
+// symbol, as a fraction of price. ok is false if it has no triggers.
+type ProximityFunc func(ctx context.Context, key tracking.Key, price float64) (fraction float64, ok bool, err error)
+
+// symbolState is what the scheduler knows about one symbol
+type symbolState struct {
+	next       time.Time              // When the symbol is next due
+	session    marketcalendar.Session // Session at the last check
+	lastViewed time.Time
+}
+
+// Scheduler refreshes tracked symbols when they are due
+type Scheduler struct {
+	symbols   tracking.Registry
+	sessions  SessionFunc
+	proximity ProximityFunc
+	policy    Policy
+
+	Tick         time.Duration // How often due symbols are looked for
+	ListInterval time.Duration // How often the tracked symbols are re-read
+
+	states   map[tracking.Key]*symbolState
+	listedAt time.Time
+	mu       sync.Mutex
+}
+
+// New creates a scheduler for the symbols in registry. proximity may be nil.
+func New(symbols tracking.Registry, sessions SessionFunc, proximity ProximityFunc, policy Policy) *Scheduler {
+	return &Scheduler{
+		symbols:      symbols,
+		sessions:     sessions,
+		proximity:    proximity,
+		policy:       policy,
+		Tick:         time.Second,
+		ListInterval: 10 * time.Second,
+		states:       make(map[tracking.Key]*symbolState),
+	}
+}
+
+// MarkViewed records that a user looked at a symbol. Its next refresh is
+// brought forward if it is trading.
+func (s *Scheduler) MarkViewed(key tracking.Key, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return
+	}
+	state.lastViewed = at
+	if state.session != marketcalendar.SessionClosed {
+		if soon := at.Add(s.policy.Viewed); soon.Before(state.next) {
+			state.next = soon
+		}
+	}
+}
+
+// Run refreshes due symbols until ctx is cancelled. If owns is set only the
+// symbols it accepts are refreshed.
+func (s *Scheduler) Run(ctx context.Context, fetch FetchFunc, owns func(key string) bool) {
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+	for {
+		s.tick(ctx, time.Now(), fetch, owns)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick refreshes every symbol due at now
+func (s *Scheduler) tick(ctx context.Context, now time.Time, fetch FetchFunc, owns func(key string) bool) {
+	if now.Sub(s.listedAt) >= s.ListInterval {
+		if err := s.sync(ctx, now); err != nil {
+			log.Printf("Failed to list tracked symbols: %v", err)
+		}
+	}
+
+	for _, key := range s.due(now) {
+		if ctx.Err() != nil {
+			return
+		}
+		if owns != nil && !owns(key.String()) {
+			continue
+		}
+
+		price, err := fetch(ctx, key)
+		if err != nil {
+			log.Printf("Failed to fetch price for %s: %v", key.Symbol, err)
+			s.schedule(key, now.Add(s.policy.Retry))
+			continue
+		}
+		s.schedule(key, now.Add(s.interval(ctx, key, now, price)))
+	}
+}
+
+// sync adds newly tracked symbols, due straight away, and forgets
+// untracked ones
+func (s *Scheduler) sync(ctx context.Context, now time.Time) error {
+	entries, err := s.symbols.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracked := make(map[tracking.Key]bool, len(entries))
+	for _, e := range entries {
+		tracked[e.Key] = true
+		if _, ok := s.states[e.Key]; !ok {
+			s.states[e.Key] = &symbolState{next: now, session: s.sessions(e.Exchange, now)}
+		}
+	}
+	for key := range s.states {
+		if !tracked[key] {
+			delete(s.states, key)
+		}
+	}
+	s.listedAt = now
+	return nil
+}
+
+// due returns the symbols to refresh at now. A session change since the
+// last check brings a symbol forward, so the opening and closing prices
+// are always captured.
+func (s *Scheduler) due(now time.Time) []tracking.Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []tracking.Key
+	for key, state := range s.states {
+		session := s.sessions(key.Exchange, now)
+		if session != state.session {
+			state.session = session
+			if soon := now.Add(s.policy.SessionChange); soon.Before(state.next) {
+				state.next = soon
+			}
+		}
+		if !now.Before(state.next) {
+			due = append(due, key)
+		}
+	}
+	return due
+}
+
+// interval returns how long to wait before refreshing a symbol again after
+// it was fetched at price
+func (s *Scheduler) interval(ctx context.Context, key tracking.Key, now time.Time, price float64) time.Duration {
+	s.mu.Lock()
+	var session marketcalendar.Session
+	var lastViewed time.Time
+	if state, ok := s.states[key]; ok {
+		session, lastViewed = state.session, state.lastViewed
+	}
+	s.mu.Unlock()
+
+	var interval time.Duration
+	switch session {
+	case marketcalendar.SessionRegular:
+		interval = s.policy.Regular
+	case marketcalendar.SessionPre:
+		interval = s.policy.Pre
+	case marketcalendar.SessionPost:
+		interval = s.policy.Post
+	default:
+		// Prices don't move while the exchange is closed
+		return s.policy.Closed
+	}
+
+	if !lastViewed.IsZero() && now.Sub(lastViewed) < s.policy.ViewedWindow {
+		interval = min(interval, s.policy.Viewed)
+	}
+	if s.proximity != nil {
+		fraction, ok, err := s.proximity(ctx, key, price)
+		if err != nil {
+			log.Printf("Failed to check triggers near %s: %v", key, err)
+		} else if ok && fraction <= s.policy.NearFraction {
+			interval = min(interval, s.policy.Near)
+		}
+	}
+	return interval
+}
+
+// schedule sets when a symbol is next due, if it is still tracked
+func (s *Scheduler) schedule(key tracking.Key, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.states[key]; ok {
+		state.next = next
+	}
+}
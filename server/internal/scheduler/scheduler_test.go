@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/marketcalendar"
+	"stockmarket/server/internal/tracking"
+)
+
+var (
+	aapl = tracking.Key{Symbol: "AAPL", Exchange: "NASDAQ"}
+	infy = tracking.Key{Symbol: "INFY", Exchange: "NSE"}
+)
+
+// fixedSessions reports one session for every exchange, changeable by tests
+type fixedSessions struct {
+	session marketcalendar.Session
+}
+
+func (f *fixedSessions) at(exchange string, at time.Time) marketcalendar.Session {
+	return f.session
+}
+
+// fetchLog records fetches and returns a fixed price
+type fetchLog struct {
+	fetched []tracking.Key
+	fail    bool
+}
+
+func (f *fetchLog) fetch(ctx context.Context, key tracking.Key) (float64, error) {
+	f.fetched = append(f.fetched, key)
+	if f.fail {
+		return 0, context.DeadlineExceeded
+	}
+	return 100, nil
+}
+
+func (f *fetchLog) take() []tracking.Key {
+	fetched := f.fetched
+	f.fetched = nil
+	return fetched
+}
+
+func newTestScheduler(t *testing.T, sessions *fixedSessions, proximity ProximityFunc, keys ...tracking.Key) *Scheduler {
+	t.Helper()
+	registry := tracking.NewMemoryRegistry()
+	for _, key := range keys {
+		if err := registry.Add(context.Background(), key, 1); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	s := New(registry, sessions.at, proximity, DefaultPolicy)
+	s.ListInterval = time.Hour
+	return s
+}
+
+func TestSchedulerIntervalsFollowTheSession(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 6, 9, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		session marketcalendar.Session
+		want    time.Duration
+	}{
+		{marketcalendar.SessionRegular, DefaultPolicy.Regular},
+		{marketcalendar.SessionPre, DefaultPolicy.Pre},
+		{marketcalendar.SessionPost, DefaultPolicy.Post},
+		{marketcalendar.SessionClosed, DefaultPolicy.Closed},
+	} {
+		t.Run(string(tt.session), func(t *testing.T) {
+			s := newTestScheduler(t, &fixedSessions{tt.session}, nil, aapl)
+			var log fetchLog
+
+			s.tick(ctx, start, log.fetch, nil)
+			if got := len(log.take()); got != 1 {
+				t.Fatalf("a new symbol should be fetched straight away, got %d fetches", got)
+			}
+
+			s.tick(ctx, start.Add(tt.want-time.Second), log.fetch, nil)
+			if got := len(log.take()); got != 0 {
+				t.Fatalf("fetched %d times before the interval was up", got)
+			}
+			s.tick(ctx, start.Add(tt.want), log.fetch, nil)
+			if got := len(log.take()); got != 1 {
+				t.Fatalf("fetched %d times when due, want 1", got)
+			}
+		})
+	}
+}
+
+func TestSchedulerRefreshesViewedAndNearSymbolsSooner(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 6, 9, 12, 0, 0, 0, time.UTC)
+	sessions := &fixedSessions{marketcalendar.SessionRegular}
+
+	// INFY's price is within 0.5% of a trigger
+	proximity := func(ctx context.Context, key tracking.Key, price float64) (float64, bool, error) {
+		return 0.005, key == infy, nil
+	}
+	s := newTestScheduler(t, sessions, proximity, aapl, infy)
+	var log fetchLog
+	s.tick(ctx, start, log.fetch, nil)
+	log.take()
+
+	at := start.Add(DefaultPolicy.Near)
+	s.tick(ctx, at, log.fetch, nil)
+	if got := log.take(); len(got) != 1 || got[0] != infy {
+		t.Fatalf("fetched %v after the near interval, want [INFY]", got)
+	}
+
+	// Viewing AAPL brings its refresh forward
+	s.MarkViewed(aapl, at)
+	s.tick(ctx, at.Add(DefaultPolicy.Viewed), log.fetch, nil)
+	fetched := log.take()
+	if len(fetched) != 2 {
+		t.Fatalf("fetched %v after a view, want both symbols", fetched)
+	}
+
+	// A view doesn't matter once the window has passed
+	later := at.Add(DefaultPolicy.ViewedWindow + time.Second)
+	s.tick(ctx, later, log.fetch, nil)
+	log.take()
+	s.tick(ctx, later.Add(DefaultPolicy.Viewed), log.fetch, nil)
+	if got := log.take(); len(got) != 1 || got[0] != infy {
+		t.Fatalf("fetched %v after the view expired, want [INFY]", got)
+	}
+}
+
+func TestSchedulerRefreshesAfterTheClose(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 6, 9, 19, 59, 0, 0, time.UTC)
+	sessions := &fixedSessions{marketcalendar.SessionPost}
+	s := newTestScheduler(t, sessions, nil, aapl)
+	var log fetchLog
+
+	s.tick(ctx, start, log.fetch, nil)
+	log.take()
+
+	// Closed symbols are refreshed hourly, but the close itself is captured
+	// shortly after it happens
+	sessions.session = marketcalendar.SessionClosed
+	closeAt := start.Add(30 * time.Second)
+	s.tick(ctx, closeAt, log.fetch, nil)
+	if got := len(log.take()); got != 0 {
+		t.Fatalf("fetched %d times at the close, want the refresh delayed", got)
+	}
+	s.tick(ctx, closeAt.Add(DefaultPolicy.SessionChange), log.fetch, nil)
+	if got := len(log.take()); got != 1 {
+		t.Fatalf("fetched %d times after the close, want 1", got)
+	}
+
+	// Then it settles into the closed interval
+	s.tick(ctx, closeAt.Add(DefaultPolicy.SessionChange+30*time.Minute), log.fetch, nil)
+	if got := len(log.take()); got != 0 {
+		t.Fatalf("fetched %d times while closed, want 0", got)
+	}
+}
+
+func TestSchedulerRetriesFailuresAndSkipsOtherShards(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 6, 9, 12, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, &fixedSessions{marketcalendar.SessionClosed}, nil, aapl, infy)
+	log := fetchLog{fail: true}
+
+	owns := func(key string) bool { return key == aapl.String() }
+	s.tick(ctx, start, log.fetch, owns)
+	if got := log.take(); len(got) != 1 || got[0] != aapl {
+		t.Fatalf("fetched %v, want only the owned AAPL", got)
+	}
+
+	// A failed fetch is retried long before the closed interval
+	log.fail = false
+	s.tick(ctx, start.Add(DefaultPolicy.Retry), log.fetch, owns)
+	if got := len(log.take()); got != 1 {
+		t.Fatalf("fetched %d times after the retry delay, want 1", got)
+	}
+}
@@ -0,0 +1,122 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Membership tracks the live instances through heartbeats. An instance that
+// stops heartbeating drops out once its TTL passes.
+type Membership interface {
+	// Heartbeat marks id as alive for ttl
+	Heartbeat(ctx context.Context, id string, ttl time.Duration) error
+	// Leave removes id straight away
+	Leave(ctx context.Context, id string) error
+	// Members returns the live instances in name order
+	Members(ctx context.Context) ([]string, error)
+}
+
+// MemoryMembership tracks members within one process, for single-instance
+// runs and tests
+type MemoryMembership struct {
+	expires map[string]time.Time
+	mu      sync.Mutex
+}
+
+var _ Membership = (*MemoryMembership)(nil)
+
+// NewMemoryMembership creates an empty membership
+func NewMemoryMembership() *MemoryMembership {
+	return &MemoryMembership{expires: make(map[string]time.Time)}
+}
+
+// Heartbeat marks id as alive for ttl
+func (m *MemoryMembership) Heartbeat(ctx context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expires[id] = time.Now().Add(ttl)
+	return nil
+}
+
+// Leave removes id
+func (m *MemoryMembership) Leave(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.expires, id)
+	return nil
+}
+
+// Members returns the members whose heartbeat has not expired
+func (m *MemoryMembership) Members(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var members []string
+	for id, expires := range m.expires {
+		if now.Before(expires) {
+			members = append(members, id)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// DefaultRedisKey is the sorted set holding the poller members
+const DefaultRedisKey = "shard_members"
+
+// RedisMembership keeps members in a sorted set scored by when their
+// heartbeat expires, in Unix milliseconds
+type RedisMembership struct {
+	client *redis.Client
+	key    string
+}
+
+var _ Membership = (*RedisMembership)(nil)
+
+// NewRedisMembership creates a membership stored in the sorted set key
+func NewRedisMembership(client *redis.Client, key string) *RedisMembership {
+	return &RedisMembership{client: client, key: key}
+}
+
+// Heartbeat marks id as alive for ttl
+func (m *RedisMembership) Heartbeat(ctx context.Context, id string, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UnixMilli()
+	if err := m.client.ZAdd(ctx, m.key, redis.Z{Score: float64(expires), Member: id}).Err(); err != nil {
+		return fmt.Errorf("failed to heartbeat %s: %v", id, err)
+	}
+	return nil
+}
+
+// Leave removes id
+func (m *RedisMembership) Leave(ctx context.Context, id string) error {
+	if err := m.client.ZRem(ctx, m.key, id).Err(); err != nil {
+		return fmt.Errorf("failed to remove member %s: %v", id, err)
+	}
+	return nil
+}
+
+// Members drops expired members and returns the rest
+func (m *RedisMembership) Members(ctx context.Context) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	var live *redis.StringSliceCmd
+	_, err := m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, m.key, "-inf", now)
+		live = pipe.ZRangeByScore(ctx, m.key, &redis.ZRangeBy{Min: "(" + now, Max: "+inf"})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %v", err)
+	}
+
+	members := live.Val()
+	sort.Strings(members)
+	return members, nil
+}
@@ -0,0 +1,83 @@
+// Package sharding partitions the tracked symbols between the live
+// instances so each polls prices and evaluates triggers for its own share.
+// Symbols are placed on a consistent hash ring, so when an instance joins or
+// leaves only the symbols it gains or loses move.
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is how many points each member gets on the ring. More
+// points spread symbols more evenly between members.
+const DefaultReplicas = 128
+
+// Ring is an immutable consistent hash ring of members
+type Ring struct {
+	points  []uint64          // sorted
+	owners  map[uint64]string // point -> member
+	members []string          // sorted
+}
+
+// NewRing places each member on the ring at replicas points
+func NewRing(replicas int, members ...string) *Ring {
+	r := &Ring{owners: make(map[uint64]string, replicas*len(members))}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.members = append(r.members, m)
+		for i := 0; i < replicas; i++ {
+			p := hash(m + "#" + strconv.Itoa(i))
+			// On the rare collision the smaller name wins, so every
+			// instance builds the same ring
+			if owner, ok := r.owners[p]; ok && owner < m {
+				continue
+			}
+			r.owners[p] = m
+		}
+	}
+	for p := range r.owners {
+		r.points = append(r.points, p)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	sort.Strings(r.members)
+	return r
+}
+
+// Owner returns the member responsible for key: the first point at or
+// after the key's hash, wrapping around. It is "" on an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Members returns the members of the ring in name order
+func (r *Ring) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+// hash is FNV-1a followed by a finalizer that spreads the similar inputs
+// used for a member's points across the whole ring
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
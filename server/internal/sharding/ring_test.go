@@ -0,0 +1,83 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+// symbolKeys returns n distinct keys shaped like tracked symbols
+func symbolKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("SYM%04d:NASDAQ", i)
+	}
+	return keys
+}
+
+func TestRingSpreadsKeysEvenly(t *testing.T) {
+	ring := NewRing(DefaultReplicas, "a", "b", "c", "d")
+	keys := symbolKeys(10_000)
+
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[ring.Owner(key)]++
+	}
+
+	// Each of 4 members should get about a quarter of the keys
+	for _, m := range ring.Members() {
+		if share := float64(counts[m]) / float64(len(keys)); share < 0.18 || share > 0.32 {
+			t.Errorf("member %s owns %.1f%% of keys, want about 25%%", m, share*100)
+		}
+	}
+}
+
+func TestRingMovesOnlyTheMinimumOnMembershipChange(t *testing.T) {
+	before := NewRing(DefaultReplicas, "a", "b", "c")
+	keys := symbolKeys(10_000)
+
+	t.Run("join", func(t *testing.T) {
+		after := NewRing(DefaultReplicas, "a", "b", "c", "d")
+		moved := 0
+		for _, key := range keys {
+			old, now := before.Owner(key), after.Owner(key)
+			if old == now {
+				continue
+			}
+			moved++
+			// Keys only move to the new member, never between old ones
+			if now != "d" {
+				t.Fatalf("key %s moved from %s to %s", key, old, now)
+			}
+		}
+		if share := float64(moved) / float64(len(keys)); share < 0.15 || share > 0.35 {
+			t.Fatalf("%.1f%% of keys moved, want about 25%%", share*100)
+		}
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		after := NewRing(DefaultReplicas, "a", "c")
+		for _, key := range keys {
+			old, now := before.Owner(key), after.Owner(key)
+			// Only the departed member's keys move
+			if old != "b" && old != now {
+				t.Fatalf("key %s moved from %s to %s", key, old, now)
+			}
+			if now == "b" {
+				t.Fatalf("key %s still owned by the departed member", key)
+			}
+		}
+	})
+}
+
+func TestRingIsIndependentOfMemberOrder(t *testing.T) {
+	x := NewRing(DefaultReplicas, "a", "b", "c")
+	y := NewRing(DefaultReplicas, "c", "a", "b", "a")
+	for _, key := range symbolKeys(1_000) {
+		if x.Owner(key) != y.Owner(key) {
+			t.Fatalf("rings disagree on %s", key)
+		}
+	}
+	if got := NewRing(DefaultReplicas).Owner("AAPL:NASDAQ"); got != "" {
+		t.Fatalf("empty ring owner = %q, want \"\"", got)
+	}
+}
@@ -0,0 +1,133 @@
+package sharding
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Assignment lists the keys a member owns
+type Assignment struct {
+	Member string   `json:"member"`
+	Keys   []string `json:"keys"`
+}
+
+// Status describes the ring as one instance sees it, for debugging
+type Status struct {
+	Instance    string       `json:"instance"`
+	Members     []string     `json:"members"`
+	Assignments []Assignment `json:"assignments"`
+}
+
+// Sharder keeps an instance in the membership and maintains its view of the
+// ring. Instances may briefly disagree while a membership change propagates,
+// so work guarded by Owns must tolerate a key being handled twice or
+// skipped for one heartbeat interval.
+type Sharder struct {
+	membership Membership
+	id         string
+	ring       atomic.Pointer[Ring]
+
+	HeartbeatInterval time.Duration // Wait between heartbeats and ring refreshes
+	TTL               time.Duration // How long a member lives without a heartbeat
+	Replicas          int           // Points per member on the ring
+
+	// OnChange, if set, is called after the ring changes, e.g. to load the
+	// state of newly owned keys and drop that of released ones
+	OnChange func(ctx context.Context)
+}
+
+// NewSharder creates a sharder for the instance id. With the defaults a
+// dead instance's keys move to the others within about 20 seconds.
+func NewSharder(membership Membership, id string) *Sharder {
+	return &Sharder{
+		membership:        membership,
+		id:                id,
+		HeartbeatInterval: 5 * time.Second,
+		TTL:               15 * time.Second,
+		Replicas:          DefaultReplicas,
+	}
+}
+
+// Run heartbeats and refreshes the ring until ctx is cancelled, then leaves
+// the membership so the instance's keys move straight away
+func (s *Sharder) Run(ctx context.Context) error {
+	for {
+		s.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.membership.Leave(leaveCtx, s.id); err != nil {
+				log.Printf("Failed to leave the ring: %v", err)
+			}
+			return ctx.Err()
+		case <-time.After(s.HeartbeatInterval):
+		}
+	}
+}
+
+// refresh heartbeats and rebuilds the ring if the members changed. If the
+// membership can't be reached the last ring is kept.
+func (s *Sharder) refresh(ctx context.Context) {
+	if err := s.membership.Heartbeat(ctx, s.id, s.TTL); err != nil {
+		log.Printf("Failed to heartbeat: %v", err)
+		return
+	}
+	members, err := s.membership.Members(ctx)
+	if err != nil {
+		log.Printf("Failed to refresh ring members: %v", err)
+		return
+	}
+
+	if current := s.ring.Load(); current != nil && slices.Equal(current.Members(), members) {
+		return
+	}
+	s.ring.Store(NewRing(s.Replicas, members...))
+	log.Printf("Ring members are now %v", members)
+	if s.OnChange != nil {
+		s.OnChange(ctx)
+	}
+}
+
+// Owns reports whether this instance is responsible for key. Nothing is
+// owned until the first heartbeat has completed.
+func (s *Sharder) Owns(key string) bool {
+	return s.Owner(key) == s.id
+}
+
+// Owner returns the member responsible for key, or "" if the ring is empty
+func (s *Sharder) Owner(key string) string {
+	ring := s.ring.Load()
+	if ring == nil {
+		return ""
+	}
+	return ring.Owner(key)
+}
+
+// Status reports the members and which of keys each owns
+func (s *Sharder) Status(keys []string) Status {
+	status := Status{Instance: s.id, Members: []string{}, Assignments: []Assignment{}}
+	ring := s.ring.Load()
+	if ring == nil {
+		return status
+	}
+
+	status.Members = ring.Members()
+	owned := make(map[string][]string, len(status.Members))
+	for _, key := range keys {
+		owner := ring.Owner(key)
+		owned[owner] = append(owned[owner], key)
+	}
+	for _, m := range status.Members {
+		assignment := Assignment{Member: m, Keys: owned[m]}
+		if assignment.Keys == nil {
+			assignment.Keys = []string{}
+		}
+		status.Assignments = append(status.Assignments, assignment)
+	}
+	return status
+}
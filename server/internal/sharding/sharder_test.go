@@ -0,0 +1,172 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryMembership(t *testing.T) {
+	testMembership(t, NewMemoryMembership())
+}
+
+// TestRedisMembership runs against the Redis server at REDIS_TEST_ADDR
+func TestRedisMembership(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	key := fmt.Sprintf("shard_members_test:%d", time.Now().UnixNano())
+	defer client.Del(context.Background(), key)
+	testMembership(t, NewRedisMembership(client, key))
+}
+
+func testMembership(t *testing.T, membership Membership) {
+	ctx := context.Background()
+	expectMembers := func(want string) {
+		t.Helper()
+		members, err := membership.Members(ctx)
+		if err != nil || fmt.Sprint(members) != want {
+			t.Fatalf("Members() = %v, %v; want %s", members, err, want)
+		}
+	}
+
+	for _, id := range []string{"b", "a"} {
+		if err := membership.Heartbeat(ctx, id, time.Minute); err != nil {
+			t.Fatalf("Heartbeat: %v", err)
+		}
+	}
+	if err := membership.Heartbeat(ctx, "c", 50*time.Millisecond); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	expectMembers("[a b c]")
+
+	if err := membership.Leave(ctx, "b"); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	expectMembers("[a c]")
+
+	// A member that stops heartbeating drops out
+	time.Sleep(100 * time.Millisecond)
+	expectMembers("[a]")
+}
+
+func TestSharderPartitionsKeysBetweenInstances(t *testing.T) {
+	membership := NewMemoryMembership()
+	keys := symbolKeys(200)
+
+	sharders := make(map[string]*Sharder)
+	cancels := make(map[string]context.CancelFunc)
+	done := make(map[string]chan struct{})
+	for _, id := range []string{"a", "b", "c"} {
+		s := NewSharder(membership, id)
+		s.HeartbeatInterval = 10 * time.Millisecond
+		s.TTL = time.Minute
+		ctx, cancel := context.WithCancel(context.Background())
+		sharders[id], cancels[id], done[id] = s, cancel, make(chan struct{})
+		go func() {
+			s.Run(ctx)
+			close(done[id])
+		}()
+	}
+	defer func() {
+		for id, cancel := range cancels {
+			cancel()
+			<-done[id]
+		}
+	}()
+
+	// Every key is owned by exactly one instance once they agree
+	waitForMembers(t, sharders, "[a b c]")
+	for _, key := range keys {
+		owners := 0
+		for _, s := range sharders {
+			if s.Owns(key) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("key %s has %d owners, want 1", key, owners)
+		}
+	}
+
+	status := sharders["a"].Status(keys)
+	total := 0
+	for _, a := range status.Assignments {
+		total += len(a.Keys)
+	}
+	if status.Instance != "a" || len(status.Assignments) != 3 || total != len(keys) {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// A stopped instance leaves and its keys move to the others
+	cancels["b"]()
+	<-done["b"]
+	delete(sharders, "b")
+	waitForMembers(t, sharders, "[a c]")
+	for _, key := range keys {
+		if !sharders["a"].Owns(key) && !sharders["c"].Owns(key) {
+			t.Fatalf("key %s is not owned after b left", key)
+		}
+	}
+}
+
+func TestSharderCallsOnChange(t *testing.T) {
+	membership := NewMemoryMembership()
+	s := NewSharder(membership, "a")
+	changes := 0
+	s.OnChange = func(ctx context.Context) { changes++ }
+
+	ctx := context.Background()
+	s.refresh(ctx)
+	s.refresh(ctx)
+	if changes != 1 {
+		t.Fatalf("OnChange called %d times for one ring, want 1", changes)
+	}
+
+	if err := membership.Heartbeat(ctx, "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s.refresh(ctx)
+	if changes != 2 {
+		t.Fatalf("OnChange called %d times after b joined, want 2", changes)
+	}
+}
+
+func waitForMembers(t *testing.T, sharders map[string]*Sharder, want string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		agreed := true
+		for _, s := range sharders {
+			if fmt.Sprint(s.Status(nil).Members) != want {
+				agreed = false
+			}
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sharders did not agree on members %s", want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSharderOwnsNothingBeforeJoining(t *testing.T) {
+	s := NewSharder(NewMemoryMembership(), "a")
+	if s.Owns("AAPL:NASDAQ") {
+		t.Fatal("Owns() = true before the first heartbeat")
+	}
+	if status := s.Status([]string{"AAPL:NASDAQ"}); !slices.Equal(status.Members, []string{}) {
+		t.Fatalf("status = %+v, want no members", status)
+	}
+}
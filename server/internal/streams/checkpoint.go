@@ -0,0 +1,123 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ShardEnd is the checkpoint recorded once a closed shard has been read to
+// its end
+const ShardEnd = "SHARD_END"
+
+// checkpointTTL is how long a checkpoint is kept after its last write.
+// Stream records are retained for 24 hours, so older checkpoints point at
+// shards that no longer exist.
+const checkpointTTL = 48 * time.Hour
+
+// Checkpointer records how far each shard has been processed
+type Checkpointer interface {
+	// Get returns the last processed sequence number of a shard, ShardEnd
+	// if the shard is finished, or "" if it has never been read
+	Get(ctx context.Context, streamARN, shardID string) (string, error)
+	// Set records the last processed sequence number of a shard
+	Set(ctx context.Context, streamARN, shardID, sequenceNumber string) error
+}
+
+// MemoryCheckpointer keeps checkpoints in memory, for tests and single runs
+type MemoryCheckpointer struct {
+	checkpoints map[string]string
+	mu          sync.Mutex
+}
+
+// NewMemoryCheckpointer creates an empty in-memory checkpointer
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{checkpoints: make(map[string]string)}
+}
+
+// Get returns the checkpoint of a shard
+func (c *MemoryCheckpointer) Get(ctx context.Context, streamARN, shardID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkpoints[streamARN+"/"+shardID], nil
+}
+
+// Set records the checkpoint of a shard
+func (c *MemoryCheckpointer) Set(ctx context.Context, streamARN, shardID, sequenceNumber string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkpoints[streamARN+"/"+shardID] = sequenceNumber
+	return nil
+}
+
+// CheckpointAPI is the subset of the DynamoDB client used by DynamoCheckpointer
+type CheckpointAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoCheckpointer keeps checkpoints in a DynamoDB table keyed by
+// consumer, stream ARN and shard ID, so a restarted consumer resumes where
+// it stopped. Consumers with different names read the streams independently.
+type DynamoCheckpointer struct {
+	client   CheckpointAPI
+	table    string
+	consumer string
+}
+
+// NewDynamoCheckpointer creates a checkpointer for the named consumer backed
+// by table
+func NewDynamoCheckpointer(client CheckpointAPI, table, consumer string) *DynamoCheckpointer {
+	return &DynamoCheckpointer{client: client, table: table, consumer: consumer}
+}
+
+// Get returns the checkpoint of a shard
+func (c *DynamoCheckpointer) Get(ctx context.Context, streamARN, shardID string) (string, error) {
+	result, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(c.table),
+		Key:            c.key(streamARN, shardID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint of shard %s: %v", shardID, err)
+	}
+	if seq, ok := result.Item["sequence_number"].(*types.AttributeValueMemberS); ok {
+		return seq.Value, nil
+	}
+	return "", nil
+}
+
+// Set records the checkpoint of a shard
+func (c *DynamoCheckpointer) Set(ctx context.Context, streamARN, shardID, sequenceNumber string) error {
+	item := c.key(streamARN, shardID)
+	item["consumer"] = &types.AttributeValueMemberS{Value: c.consumer}
+	item["stream_arn"] = &types.AttributeValueMemberS{Value: streamARN}
+	item["sequence_number"] = &types.AttributeValueMemberS{Value: sequenceNumber}
+	item["expires_at"] = &types.AttributeValueMemberN{
+		Value: strconv.FormatInt(time.Now().Add(checkpointTTL).Unix(), 10),
+	}
+
+	_, err := c.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint of shard %s: %v", shardID, err)
+	}
+	return nil
+}
+
+// key returns the key of a shard's checkpoint. The hash key combines the
+// consumer and the stream so each consumer's shards sit in one partition.
+func (c *DynamoCheckpointer) key(streamARN, shardID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"checkpoint_id": &types.AttributeValueMemberS{Value: c.consumer + "|" + streamARN},
+		"shard_id":      &types.AttributeValueMemberS{Value: shardID},
+	}
+}
@@ -0,0 +1,27 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+
+	appconfig "stockmarket/server/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+)
+
+// NewClient creates a DynamoDB Streams client for the configured region and
+// endpoint
+func NewClient(ctx context.Context, cfg *appconfig.Config) (*dynamodbstreams.Client, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.AWSRegion))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return dynamodbstreams.NewFromConfig(awsCfg, func(o *dynamodbstreams.Options) {
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	}), nil
+}
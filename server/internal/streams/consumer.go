@@ -0,0 +1,274 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// API is the subset of the DynamoDB Streams client used by Consumer
+type API interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// Stream is a table stream to consume
+type Stream struct {
+	ARN  string
+	Kind TableKind
+}
+
+// Handler processes one event. If it returns an error the shard is re-read
+// from its last checkpoint, so delivery is at least once and handlers must
+// be idempotent.
+type Handler func(ctx context.Context, event Event) error
+
+// Consumer reads every shard of a set of streams, in parent-before-child
+// order, and hands the decoded events to a handler
+type Consumer struct {
+	client      API
+	checkpoints Checkpointer
+	handler     Handler
+	streams     []Stream
+
+	PollInterval     time.Duration // Wait between reads of an idle shard
+	DiscoverInterval time.Duration // Wait between looking for new shards
+	RetryInterval    time.Duration // Wait before re-reading a shard after an error
+
+	// StartAtLatest reads the shards found at start that have no checkpoint
+	// from their newest records rather than their oldest. Shards opened
+	// later are still read from their oldest records, so none of their
+	// records are missed.
+	StartAtLatest bool
+}
+
+// NewConsumer creates a consumer of streams
+func NewConsumer(client API, checkpoints Checkpointer, handler Handler, streams ...Stream) *Consumer {
+	return &Consumer{
+		client:           client,
+		checkpoints:      checkpoints,
+		handler:          handler,
+		streams:          streams,
+		PollInterval:     time.Second,
+		DiscoverInterval: time.Minute,
+		RetryInterval:    5 * time.Second,
+	}
+}
+
+// Run consumes every stream until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, stream := range c.streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runStream(ctx, stream)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// runStream starts a reader for every shard of a stream whose parent has
+// been read to its end, rediscovering shards as they split
+func (c *Consumer) runStream(ctx context.Context, stream Stream) {
+	var mu sync.Mutex
+	started := make(map[string]bool)
+	finished := make(map[string]bool)
+	shardDone := make(chan struct{}, 1)
+	var initial map[string]bool // The shards there at start, once described
+
+	for {
+		shards, err := c.describeShards(ctx, stream.ARN)
+		if err != nil {
+			log.Printf("Failed to describe stream %s: %v", stream.ARN, err)
+		}
+
+		known := make(map[string]bool, len(shards))
+		for _, shard := range shards {
+			known[aws.ToString(shard.ShardId)] = true
+		}
+		if initial == nil && err == nil {
+			initial = known
+		}
+
+		for _, shard := range shards {
+			id := aws.ToString(shard.ShardId)
+			parent := aws.ToString(shard.ParentShardId)
+
+			mu.Lock()
+			// A parent that has aged out of the stream no longer blocks its children
+			ready := !started[id] && (parent == "" || !known[parent] || finished[parent])
+			if ready {
+				started[id] = true
+			}
+			mu.Unlock()
+			if !ready {
+				continue
+			}
+
+			latest := c.StartAtLatest && initial[id]
+			go func() {
+				if err := c.consumeShard(ctx, stream, id, latest); err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Stopped reading shard %s: %v", id, err)
+					}
+					return
+				}
+				mu.Lock()
+				finished[id] = true
+				mu.Unlock()
+				// Wake the loop so the shard's children start straight away
+				select {
+				case shardDone <- struct{}{}:
+				default:
+				}
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-shardDone:
+		case <-time.After(c.DiscoverInterval):
+		}
+	}
+}
+
+// describeShards lists every shard of a stream
+func (c *Consumer) describeShards(ctx context.Context, streamARN string) ([]streamtypes.Shard, error) {
+	var shards []streamtypes.Shard
+	var start *string
+	for {
+		out, err := c.client.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(streamARN),
+			ExclusiveStartShardId: start,
+		})
+		if err != nil {
+			return shards, err
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		start = out.StreamDescription.LastEvaluatedShardId
+		if start == nil {
+			return shards, nil
+		}
+	}
+}
+
+// consumeShard reads a shard from its checkpoint until it is closed and
+// fully read, which it records with a ShardEnd checkpoint. Without a
+// checkpoint it starts from the newest records if latest is set.
+func (c *Consumer) consumeShard(ctx context.Context, stream Stream, shardID string, latest bool) error {
+	for {
+		checkpoint, err := c.checkpoints.Get(ctx, stream.ARN, shardID)
+		if err != nil {
+			log.Printf("Failed to read checkpoint of shard %s: %v", shardID, err)
+			if !sleep(ctx, c.RetryInterval) {
+				return ctx.Err()
+			}
+			continue
+		}
+		if checkpoint == ShardEnd {
+			return nil
+		}
+
+		done, err := c.readShard(ctx, stream, shardID, checkpoint, latest)
+		// Records may have been written since the first read, so a retry
+		// without a checkpoint starts from the oldest
+		latest = false
+		if done {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Error reading shard %s, retrying from checkpoint: %v", shardID, err)
+		if !sleep(ctx, c.RetryInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// readShard reads a shard from just after checkpoint, or from its newest or
+// oldest records if it has none. It returns true once the shard is closed
+// and every record has been handled.
+func (c *Consumer) readShard(ctx context.Context, stream Stream, shardID, checkpoint string, latest bool) (bool, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(stream.ARN),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: streamtypes.ShardIteratorTypeTrimHorizon,
+	}
+	switch {
+	case checkpoint != "":
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeAfterSequenceNumber
+		input.SequenceNumber = aws.String(checkpoint)
+	case latest:
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeLatest
+	}
+	out, err := c.client.GetShardIterator(ctx, input)
+	if err != nil {
+		return false, fmt.Errorf("failed to get shard iterator: %v", err)
+	}
+
+	iterator := out.ShardIterator
+	for iterator != nil {
+		page, err := c.client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+		if err != nil {
+			return false, fmt.Errorf("failed to get records: %v", err)
+		}
+
+		var last string
+		for _, record := range page.Records {
+			if record.Dynamodb != nil {
+				last = aws.ToString(record.Dynamodb.SequenceNumber)
+			}
+			event, err := decodeRecord(stream.Kind, record)
+			if err != nil {
+				// A record that can't be decoded never will be; skip it
+				// rather than block the shard
+				log.Printf("Skipping stream record in shard %s: %v", shardID, err)
+				continue
+			}
+			if err := c.handler(ctx, event); err != nil {
+				return false, fmt.Errorf("handler failed on %s %s: %v", event.Type, event.SequenceNumber, err)
+			}
+		}
+
+		if last != "" {
+			if err := c.checkpoints.Set(ctx, stream.ARN, shardID, last); err != nil {
+				return false, err
+			}
+		}
+
+		iterator = page.NextShardIterator
+		if iterator == nil {
+			break
+		}
+		if len(page.Records) == 0 && !sleep(ctx, c.PollInterval) {
+			return false, ctx.Err()
+		}
+	}
+
+	// A nil iterator means the shard was closed and has been read to its end
+	if err := c.checkpoints.Set(ctx, stream.ARN, shardID, ShardEnd); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,203 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/models"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// startConsumer runs a fast-polling consumer until the test ends and
+// returns the events it handles
+func startConsumer(t *testing.T, client API, checkpoints Checkpointer, handler Handler, streams ...Stream) <-chan Event {
+	t.Helper()
+	return startConfiguredConsumer(t, nil, client, checkpoints, handler, streams...)
+}
+
+// startConfiguredConsumer is startConsumer with configure applied to the
+// consumer before it runs
+func startConfiguredConsumer(t *testing.T, configure func(*Consumer), client API, checkpoints Checkpointer, handler Handler, streams ...Stream) <-chan Event {
+	t.Helper()
+	events := make(chan Event, 100)
+	if handler == nil {
+		handler = func(ctx context.Context, e Event) error { return nil }
+	}
+
+	consumer := NewConsumer(client, checkpoints, func(ctx context.Context, e Event) error {
+		if err := handler(ctx, e); err != nil {
+			return err
+		}
+		events <- e
+		return nil
+	}, streams...)
+	consumer.PollInterval = time.Millisecond
+	consumer.DiscoverInterval = 5 * time.Millisecond
+	consumer.RetryInterval = time.Millisecond
+	if configure != nil {
+		configure(consumer)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return Event{}
+	}
+}
+
+func expectNoEvent(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %s %s", e.Type, e.SequenceNumber)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConsumerDecodesDomainEvents(t *testing.T) {
+	stocks := NewLocalStream("arn:stocks")
+	stock := models.Stock{UserID: "alice", StockID: "s1", Symbol: "AAPL", Exchange: "NASDAQ", Price: 150, Version: 1}
+	updated := stock
+	updated.Price = 155
+	updated.Version = 2
+
+	stocks.Put(streamtypes.OperationTypeInsert, nil, stock)
+	stocks.Put(streamtypes.OperationTypeModify, stock, updated)
+	stocks.Put(streamtypes.OperationTypeRemove, updated, nil)
+
+	events := startConsumer(t, stocks, NewMemoryCheckpointer(), nil, Stream{ARN: stocks.ARN(), Kind: StocksTable})
+
+	added := nextEvent(t, events)
+	if added.Type != HoldingAdded || added.OldStock != nil || added.NewStock.Symbol != "AAPL" {
+		t.Fatalf("first event = %+v, want HoldingAdded of AAPL", added)
+	}
+	changed := nextEvent(t, events)
+	if changed.Type != HoldingUpdated || changed.OldStock.Price != 150 || changed.NewStock.Price != 155 {
+		t.Fatalf("second event = %+v, want HoldingUpdated 150 -> 155", changed)
+	}
+	removed := nextEvent(t, events)
+	if removed.Type != HoldingRemoved || removed.NewStock != nil || removed.OldStock.Version != 2 {
+		t.Fatalf("third event = %+v, want HoldingRemoved", removed)
+	}
+}
+
+func TestConsumerResumesFromCheckpoint(t *testing.T) {
+	triggers := NewLocalStream("arn:triggers")
+	checkpoints := NewMemoryCheckpointer()
+	stream := Stream{ARN: triggers.ARN(), Kind: TriggersTable}
+
+	for i := 1; i <= 2; i++ {
+		triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: fmt.Sprintf("t%d", i)})
+	}
+
+	// The first consumer handles both records, then stops
+	t.Run("first run", func(t *testing.T) {
+		events := startConsumer(t, triggers, checkpoints, nil, stream)
+		for i := 1; i <= 2; i++ {
+			if e := nextEvent(t, events); e.Type != TriggerCreated || e.NewTrigger.TriggerID != fmt.Sprintf("t%d", i) {
+				t.Fatalf("event %d = %+v", i, e)
+			}
+		}
+	})
+
+	// A restarted consumer only sees what was written since
+	triggers.Put(streamtypes.OperationTypeRemove, models.StockTrigger{TriggerID: "t1"}, nil)
+	events := startConsumer(t, triggers, checkpoints, nil, stream)
+	if e := nextEvent(t, events); e.Type != TriggerDeleted || e.OldTrigger.TriggerID != "t1" {
+		t.Fatalf("event after restart = %+v, want TriggerDeleted of t1", e)
+	}
+	expectNoEvent(t, events)
+}
+
+func TestConsumerStartsAtLatest(t *testing.T) {
+	triggers := NewLocalStream("arn:triggers")
+	checkpoints := NewMemoryCheckpointer()
+	stream := Stream{ARN: triggers.ARN(), Kind: TriggersTable}
+
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "in-parent"})
+	triggers.Split()
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "before"})
+
+	// What was written before the consumer started is skipped
+	events := startConfiguredConsumer(t, func(c *Consumer) { c.StartAtLatest = true }, triggers, checkpoints, nil, stream)
+	expectNoEvent(t, events)
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "after"})
+	if e := nextEvent(t, events); e.NewTrigger.TriggerID != "after" {
+		t.Fatalf("event = %+v, want after", e)
+	}
+
+	// A shard opened since is read from its oldest record
+	triggers.Split()
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "split-1"})
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "split-2"})
+	for _, want := range []string{"split-1", "split-2"} {
+		if e := nextEvent(t, events); e.NewTrigger.TriggerID != want {
+			t.Fatalf("event = %+v, want %s", e, want)
+		}
+	}
+	expectNoEvent(t, events)
+}
+
+func TestConsumerRetriesFailedEvents(t *testing.T) {
+	triggers := NewLocalStream("arn:triggers")
+	triggers.Put(streamtypes.OperationTypeInsert, nil, models.StockTrigger{TriggerID: "t1"})
+
+	failures := 2
+	events := startConsumer(t, triggers, NewMemoryCheckpointer(), func(ctx context.Context, e Event) error {
+		if failures > 0 {
+			failures--
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}, Stream{ARN: triggers.ARN(), Kind: TriggersTable})
+
+	if e := nextEvent(t, events); e.NewTrigger.TriggerID != "t1" {
+		t.Fatalf("event = %+v, want t1 after retries", e)
+	}
+	expectNoEvent(t, events)
+}
+
+func TestConsumerReadsParentShardBeforeChild(t *testing.T) {
+	stocks := NewLocalStream("arn:stocks")
+	checkpoints := NewMemoryCheckpointer()
+
+	stocks.Put(streamtypes.OperationTypeInsert, nil, models.Stock{StockID: "in-parent"})
+	child := stocks.Split()
+	stocks.Put(streamtypes.OperationTypeInsert, nil, models.Stock{StockID: "in-child"})
+
+	events := startConsumer(t, stocks, checkpoints, nil, Stream{ARN: stocks.ARN(), Kind: StocksTable})
+	for _, want := range []string{"in-parent", "in-child"} {
+		if e := nextEvent(t, events); e.NewStock.StockID != want {
+			t.Fatalf("event = %s, want %s", e.NewStock.StockID, want)
+		}
+	}
+
+	parent, _ := checkpoints.Get(context.Background(), stocks.ARN(), "shardId-00000000")
+	if parent != ShardEnd {
+		t.Fatalf("parent checkpoint = %q, want %q", parent, ShardEnd)
+	}
+	if cp, _ := checkpoints.Get(context.Background(), stocks.ARN(), child); cp == "" || cp == ShardEnd {
+		t.Fatalf("child checkpoint = %q, want its last sequence number", cp)
+	}
+}
@@ -0,0 +1,119 @@
+// Package streams consumes the DynamoDB Streams of the Stocks and Triggers
+// tables and turns their change records into domain events, so caches,
+// trigger registries and WebSocket clients can react to writes made by any
+// instance without polling.
+package streams
+
+import (
+	"fmt"
+	"time"
+
+	"stockmarket/server/internal/models"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// EventType names a domain event
+type EventType string
+
+const (
+	HoldingAdded   EventType = "HoldingAdded"
+	HoldingUpdated EventType = "HoldingUpdated"
+	HoldingRemoved EventType = "HoldingRemoved"
+	TriggerCreated EventType = "TriggerCreated"
+	TriggerChanged EventType = "TriggerChanged"
+	TriggerDeleted EventType = "TriggerDeleted"
+)
+
+// TableKind says which table a stream belongs to and so how its records
+// are decoded
+type TableKind string
+
+const (
+	StocksTable   TableKind = "stocks"
+	TriggersTable TableKind = "triggers"
+)
+
+// Event is a change to a holding or a trigger. Old is nil for additions
+// and New is nil for removals.
+type Event struct {
+	Type           EventType
+	SequenceNumber string
+	At             time.Time // Approximate time of the write
+
+	OldStock, NewStock     *models.Stock
+	OldTrigger, NewTrigger *models.StockTrigger
+}
+
+// decodeRecord converts a stream record from a table of the given kind into
+// an event
+func decodeRecord(kind TableKind, record streamtypes.Record) (Event, error) {
+	if record.Dynamodb == nil {
+		return Event{}, fmt.Errorf("stream record %s has no data", aws.ToString(record.EventID))
+	}
+	data := record.Dynamodb
+
+	event := Event{SequenceNumber: aws.ToString(data.SequenceNumber)}
+	if data.ApproximateCreationDateTime != nil {
+		event.At = *data.ApproximateCreationDateTime
+	}
+
+	switch kind {
+	case StocksTable:
+		event.Type = eventType(record.EventName, HoldingAdded, HoldingUpdated, HoldingRemoved)
+		if err := decodeImage(data.OldImage, &event.OldStock); err != nil {
+			return Event{}, err
+		}
+		if err := decodeImage(data.NewImage, &event.NewStock); err != nil {
+			return Event{}, err
+		}
+	case TriggersTable:
+		event.Type = eventType(record.EventName, TriggerCreated, TriggerChanged, TriggerDeleted)
+		if err := decodeImage(data.OldImage, &event.OldTrigger); err != nil {
+			return Event{}, err
+		}
+		if err := decodeImage(data.NewImage, &event.NewTrigger); err != nil {
+			return Event{}, err
+		}
+	default:
+		return Event{}, fmt.Errorf("unknown table kind %q", kind)
+	}
+
+	if event.Type == "" {
+		return Event{}, fmt.Errorf("unknown stream event %q", record.EventName)
+	}
+	return event, nil
+}
+
+// eventType maps a stream event name onto the matching domain event
+func eventType(name streamtypes.OperationType, insert, modify, remove EventType) EventType {
+	switch name {
+	case streamtypes.OperationTypeInsert:
+		return insert
+	case streamtypes.OperationTypeModify:
+		return modify
+	case streamtypes.OperationTypeRemove:
+		return remove
+	}
+	return ""
+}
+
+// decodeImage unmarshals a stream image into *out, leaving it nil if the
+// record carries no such image
+func decodeImage[T any](image map[string]streamtypes.AttributeValue, out **T) error {
+	if len(image) == 0 {
+		return nil
+	}
+	item, err := attributevalue.FromDynamoDBStreamsMap(image)
+	if err != nil {
+		return fmt.Errorf("failed to convert stream image: %v", err)
+	}
+	var v T
+	if err := attributevalue.UnmarshalMap(item, &v); err != nil {
+		return fmt.Errorf("failed to unmarshal stream image: %v", err)
+	}
+	*out = &v
+	return nil
+}
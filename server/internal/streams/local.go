@@ -0,0 +1,262 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// LocalStream is an in-process stand-in for a DynamoDB stream that
+// implements API. Records are appended with Put, and Split closes the
+// current shard and opens a child, as DynamoDB does when it reshards.
+type LocalStream struct {
+	arn    string
+	shards []*localShard // in creation order; the last one is open
+	seq    int64
+	mu     sync.Mutex
+}
+
+type localShard struct {
+	id      string
+	parent  string
+	records []streamtypes.Record
+	closed  bool
+}
+
+var _ API = (*LocalStream)(nil)
+
+// NewLocalStream creates a stream with a single open shard
+func NewLocalStream(arn string) *LocalStream {
+	s := &LocalStream{arn: arn}
+	s.shards = append(s.shards, &localShard{id: s.shardID(0)})
+	return s
+}
+
+// ARN returns the stream's ARN
+func (s *LocalStream) ARN() string {
+	return s.arn
+}
+
+// Put appends a change record to the open shard. oldItem and newItem are
+// marshalled like table items; pass nil for an image the operation lacks.
+func (s *LocalStream) Put(op streamtypes.OperationType, oldItem, newItem any) error {
+	oldImage, err := streamImage(oldItem)
+	if err != nil {
+		return err
+	}
+	newImage, err := streamImage(newItem)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	seq := fmt.Sprintf("%021d", s.seq)
+	shard := s.shards[len(s.shards)-1]
+	shard.records = append(shard.records, streamtypes.Record{
+		EventID:   aws.String(seq),
+		EventName: op,
+		Dynamodb: &streamtypes.StreamRecord{
+			SequenceNumber:              aws.String(seq),
+			ApproximateCreationDateTime: aws.Time(time.Now()),
+			OldImage:                    oldImage,
+			NewImage:                    newImage,
+			StreamViewType:              streamtypes.StreamViewTypeNewAndOldImages,
+		},
+	})
+	return nil
+}
+
+// Split closes the open shard and opens a child of it, returning the
+// child's ID
+func (s *LocalStream) Split() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parent := s.shards[len(s.shards)-1]
+	parent.closed = true
+	child := &localShard{id: s.shardID(len(s.shards)), parent: parent.id}
+	s.shards = append(s.shards, child)
+	return child.id
+}
+
+// DescribeStream lists the stream's shards
+func (s *LocalStream) DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if aws.ToString(params.StreamArn) != s.arn {
+		return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("stream not found")}
+	}
+
+	var shards []streamtypes.Shard
+	for _, shard := range s.shards {
+		out := streamtypes.Shard{ShardId: aws.String(shard.id)}
+		if shard.parent != "" {
+			out.ParentShardId = aws.String(shard.parent)
+		}
+		shards = append(shards, out)
+	}
+	return &dynamodbstreams.DescribeStreamOutput{
+		StreamDescription: &streamtypes.StreamDescription{
+			StreamArn:    aws.String(s.arn),
+			StreamStatus: streamtypes.StreamStatusEnabled,
+			Shards:       shards,
+		},
+	}, nil
+}
+
+// GetShardIterator returns an iterator positioned in a shard
+func (s *LocalStream) GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard := s.shard(aws.ToString(params.ShardId))
+	if shard == nil {
+		return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("shard not found")}
+	}
+
+	var pos int
+	switch params.ShardIteratorType {
+	case streamtypes.ShardIteratorTypeTrimHorizon:
+		pos = 0
+	case streamtypes.ShardIteratorTypeLatest:
+		pos = len(shard.records)
+	case streamtypes.ShardIteratorTypeAtSequenceNumber, streamtypes.ShardIteratorTypeAfterSequenceNumber:
+		seq := aws.ToString(params.SequenceNumber)
+		pos = -1
+		for i, r := range shard.records {
+			if aws.ToString(r.Dynamodb.SequenceNumber) == seq {
+				pos = i
+				break
+			}
+		}
+		if pos < 0 {
+			return nil, &streamtypes.TrimmedDataAccessException{Message: aws.String("sequence number not in shard")}
+		}
+		if params.ShardIteratorType == streamtypes.ShardIteratorTypeAfterSequenceNumber {
+			pos++
+		}
+	default:
+		return nil, fmt.Errorf("unsupported iterator type %q", params.ShardIteratorType)
+	}
+
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(iteratorFor(shard.id, pos))}, nil
+}
+
+// GetRecords returns the records after an iterator. The next iterator is nil
+// once a closed shard has been read to its end.
+func (s *LocalStream) GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shardID, pos, err := parseIterator(aws.ToString(params.ShardIterator))
+	if err != nil {
+		return nil, err
+	}
+	shard := s.shard(shardID)
+	if shard == nil {
+		return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("shard not found")}
+	}
+
+	end := len(shard.records)
+	if params.Limit != nil && pos+int(*params.Limit) < end {
+		end = pos + int(*params.Limit)
+	}
+	out := &dynamodbstreams.GetRecordsOutput{
+		Records: append([]streamtypes.Record(nil), shard.records[pos:end]...),
+	}
+	if !shard.closed || end < len(shard.records) {
+		out.NextShardIterator = aws.String(iteratorFor(shard.id, end))
+	}
+	return out, nil
+}
+
+func (s *LocalStream) shardID(n int) string {
+	return fmt.Sprintf("shardId-%08d", n)
+}
+
+func (s *LocalStream) shard(id string) *localShard {
+	for _, shard := range s.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func iteratorFor(shardID string, pos int) string {
+	return shardID + "|" + strconv.Itoa(pos)
+}
+
+func parseIterator(iterator string) (string, int, error) {
+	shardID, pos, ok := strings.Cut(iterator, "|")
+	n, err := strconv.Atoi(pos)
+	if !ok || err != nil {
+		return "", 0, &streamtypes.ExpiredIteratorException{Message: aws.String("invalid shard iterator")}
+	}
+	return shardID, n, nil
+}
+
+// streamImage marshals an item into a stream image
+func streamImage(item any) (map[string]streamtypes.AttributeValue, error) {
+	if item == nil {
+		return nil, nil
+	}
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stream image: %v", err)
+	}
+	image := make(map[string]streamtypes.AttributeValue, len(av))
+	for k, v := range av {
+		image[k] = toStreamValue(v)
+	}
+	return image, nil
+}
+
+// toStreamValue converts a DynamoDB attribute value to its DynamoDB Streams
+// equivalent
+func toStreamValue(av ddbtypes.AttributeValue) streamtypes.AttributeValue {
+	switch v := av.(type) {
+	case *ddbtypes.AttributeValueMemberS:
+		return &streamtypes.AttributeValueMemberS{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberN:
+		return &streamtypes.AttributeValueMemberN{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberB:
+		return &streamtypes.AttributeValueMemberB{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberBOOL:
+		return &streamtypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberNULL:
+		return &streamtypes.AttributeValueMemberNULL{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberSS:
+		return &streamtypes.AttributeValueMemberSS{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberNS:
+		return &streamtypes.AttributeValueMemberNS{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberBS:
+		return &streamtypes.AttributeValueMemberBS{Value: v.Value}
+	case *ddbtypes.AttributeValueMemberL:
+		list := make([]streamtypes.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			list[i] = toStreamValue(e)
+		}
+		return &streamtypes.AttributeValueMemberL{Value: list}
+	case *ddbtypes.AttributeValueMemberM:
+		m := make(map[string]streamtypes.AttributeValue, len(v.Value))
+		for k, e := range v.Value {
+			m[k] = toStreamValue(e)
+		}
+		return &streamtypes.AttributeValueMemberM{Value: m}
+	}
+	return &streamtypes.AttributeValueMemberNULL{Value: true}
+}
@@ -0,0 +1,56 @@
+package tracking
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryRegistry is a Registry for single-instance and test use
+type MemoryRegistry struct {
+	refs map[Key]int64
+	mu   sync.Mutex
+}
+
+// NewMemoryRegistry creates an empty in-memory registry
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{refs: make(map[Key]int64)}
+}
+
+// Add adjusts the references on key, retiring it at zero
+func (r *MemoryRegistry) Add(ctx context.Context, key Key, delta int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refs[key] += delta
+	if r.refs[key] <= 0 {
+		delete(r.refs, key)
+	}
+	return nil
+}
+
+// List returns every tracked symbol ordered by key
+func (r *MemoryRegistry) List(ctx context.Context) ([]Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]Entry, 0, len(r.refs))
+	for key, refs := range r.refs {
+		entries = append(entries, Entry{Key: key, Refs: refs})
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// Reset replaces the whole set
+func (r *MemoryRegistry) Reset(ctx context.Context, refs map[Key]int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refs = make(map[Key]int64, len(refs))
+	for key, n := range refs {
+		if n > 0 {
+			r.refs[key] = n
+		}
+	}
+	return nil
+}
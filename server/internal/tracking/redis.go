@@ -0,0 +1,86 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisKey is the sorted set holding the tracked symbols
+const DefaultRedisKey = "tracked_symbols"
+
+// addScript increments a member and removes it once it has no references
+// left, so the check and the removal can't race with another Add
+var addScript = redis.NewScript(`
+local refs = tonumber(redis.call('ZINCRBY', KEYS[1], ARGV[1], ARGV[2]))
+if refs <= 0 then
+	redis.call('ZREM', KEYS[1], ARGV[2])
+end
+return refs
+`)
+
+// RedisRegistry is a Registry shared by every instance, stored as a sorted
+// set of symbol:exchange members scored by their reference count
+type RedisRegistry struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisRegistry creates a registry stored in the sorted set key
+func NewRedisRegistry(client *redis.Client, key string) *RedisRegistry {
+	return &RedisRegistry{client: client, key: key}
+}
+
+// Add adjusts the references on key, retiring it at zero
+func (r *RedisRegistry) Add(ctx context.Context, key Key, delta int64) error {
+	if err := addScript.Run(ctx, r.client, []string{r.key}, delta, key.String()).Err(); err != nil {
+		return fmt.Errorf("failed to update tracked symbol %s: %v", key, err)
+	}
+	return nil
+}
+
+// List returns every tracked symbol ordered by key
+func (r *RedisRegistry) List(ctx context.Context) ([]Entry, error) {
+	members, err := r.client.ZRangeWithScores(ctx, r.key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tracked symbols: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(members))
+	for _, m := range members {
+		entries = append(entries, Entry{
+			Key:  ParseKey(m.Member.(string)),
+			Refs: int64(m.Score),
+		})
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// Reset replaces the whole set. The new set is built under a temporary key
+// and renamed over the old one so readers never see a partial set.
+func (r *RedisRegistry) Reset(ctx context.Context, refs map[Key]int64) error {
+	members := make([]redis.Z, 0, len(refs))
+	for key, n := range refs {
+		if n > 0 {
+			members = append(members, redis.Z{Score: float64(n), Member: key.String()})
+		}
+	}
+
+	tmp := r.key + ":rebuild"
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, tmp)
+		if len(members) == 0 {
+			pipe.Del(ctx, r.key)
+			return nil
+		}
+		pipe.ZAdd(ctx, tmp, members...)
+		pipe.Rename(ctx, tmp, r.key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset tracked symbols: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package tracking maintains the set of symbols that anyone holds or has a
+// trigger on. Every holding and every trigger takes a reference on its
+// symbol, so pollers and streamers can read the set in O(symbols) instead of
+// scanning every portfolio, and a symbol is retired as soon as its last
+// reference is released.
+package tracking
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"stockmarket/server/internal/models"
+)
+
+// Key identifies a symbol on an exchange
+type Key struct {
+	Symbol   string
+	Exchange string
+}
+
+// String returns the key as symbol:exchange
+func (k Key) String() string {
+	return k.Symbol + ":" + k.Exchange
+}
+
+// ParseKey parses a key produced by Key.String
+func ParseKey(s string) Key {
+	symbol, exchange, _ := strings.Cut(s, ":")
+	return Key{Symbol: symbol, Exchange: exchange}
+}
+
+// Entry is a tracked symbol and the number of references held on it
+type Entry struct {
+	Key
+	Refs int64
+}
+
+// Registry is a reference-counted set of tracked symbols
+type Registry interface {
+	// Add adjusts the references on key by delta. A symbol appears when it
+	// gains its first reference and is retired when its count drops to zero.
+	Add(ctx context.Context, key Key, delta int64) error
+	// List returns every tracked symbol ordered by key
+	List(ctx context.Context) ([]Entry, error)
+	// Reset atomically replaces the whole set with refs
+	Reset(ctx context.Context, refs map[Key]int64) error
+}
+
+// StockRefs returns the references a holding accounts for: one for the
+// holding itself and one per trigger on it
+func StockRefs(stock models.Stock) int64 {
+	return 1 + int64(len(stock.Triggers))
+}
+
+// Rebuild recomputes the reference counts from every holding in the store
+// and replaces the registry with them. It repairs any drift left by a crash
+// between a database write and the matching Add. Adds made while it runs
+// are lost, so a registry shared with running instances shouldn't be
+// rebuilt.
+func Rebuild(ctx context.Context, registry Registry, scan func(ctx context.Context, fn func(models.Stock) error) error) error {
+	refs := make(map[Key]int64)
+	var mu sync.Mutex
+
+	// The scan may call back concurrently
+	err := scan(ctx, func(stock models.Stock) error {
+		mu.Lock()
+		defer mu.Unlock()
+		refs[Key{Symbol: stock.Symbol, Exchange: stock.Exchange}] += StockRefs(stock)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return registry.Reset(ctx, refs)
+}
+
+// sortEntries orders entries by key
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key.String() < entries[j].Key.String()
+	})
+}
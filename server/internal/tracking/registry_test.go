@@ -0,0 +1,106 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"stockmarket/server/internal/database/memory"
+	"stockmarket/server/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	testRegistry(t, NewMemoryRegistry())
+}
+
+// TestRedisRegistry runs against the Redis server at REDIS_TEST_ADDR
+func TestRedisRegistry(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	key := fmt.Sprintf("tracked_symbols_test:%d", time.Now().UnixNano())
+	defer client.Del(context.Background(), key)
+	testRegistry(t, NewRedisRegistry(client, key))
+}
+
+func testRegistry(t *testing.T, registry Registry) {
+	ctx := context.Background()
+	aapl := Key{Symbol: "AAPL", Exchange: "NASDAQ"}
+	msft := Key{Symbol: "MSFT", Exchange: "NASDAQ"}
+
+	for _, step := range []struct {
+		key   Key
+		delta int64
+	}{{aapl, 1}, {aapl, 2}, {msft, 1}, {aapl, -1}} {
+		if err := registry.Add(ctx, step.key, step.delta); err != nil {
+			t.Fatalf("Add(%s, %d): %v", step.key, step.delta, err)
+		}
+	}
+	assertEntries(t, registry, "[{AAPL:NASDAQ 2} {MSFT:NASDAQ 1}]")
+
+	// Releasing the last reference retires the symbol
+	if err := registry.Add(ctx, msft, -1); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	assertEntries(t, registry, "[{AAPL:NASDAQ 2}]")
+
+	if err := registry.Reset(ctx, map[Key]int64{msft: 3, aapl: 0}); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	assertEntries(t, registry, "[{MSFT:NASDAQ 3}]")
+
+	if err := registry.Reset(ctx, nil); err != nil {
+		t.Fatalf("Reset to empty: %v", err)
+	}
+	assertEntries(t, registry, "[]")
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	for _, s := range []models.Stock{
+		{UserID: "alice", Symbol: "AAPL", Exchange: "NASDAQ"},
+		{UserID: "bob", Symbol: "AAPL", Exchange: "NASDAQ"},
+		{UserID: "bob", Symbol: "INFY", Exchange: "NSE"},
+	} {
+		if err := store.CreateStock(ctx, &s); err != nil {
+			t.Fatalf("CreateStock: %v", err)
+		}
+		if s.Symbol == "INFY" {
+			trigger := &models.StockTrigger{UserID: s.UserID, StockID: s.StockID, Symbol: s.Symbol, Exchange: s.Exchange}
+			if err := store.CreateTrigger(ctx, trigger); err != nil {
+				t.Fatalf("CreateTrigger: %v", err)
+			}
+		}
+	}
+
+	registry := NewMemoryRegistry()
+	registry.Add(ctx, Key{Symbol: "GONE", Exchange: "NYSE"}, 1)
+	if err := Rebuild(ctx, registry, store.ScanStocks); err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	assertEntries(t, registry, "[{AAPL:NASDAQ 2} {INFY:NSE 2}]")
+}
+
+func assertEntries(t *testing.T, registry Registry, want string) {
+	t.Helper()
+	entries, err := registry.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("{%s %d}", e.Key, e.Refs))
+	}
+	if fmt.Sprint(got) != want {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+}
@@ -7,26 +7,21 @@ import (
 	"sync"
 	"time"
 
+	"stockmarket/server/internal/marketcalendar"
+
 	"github.com/gorilla/websocket"
 )
 
-// MarketHours represents the trading hours for different exchanges
-type MarketHours struct {
-	StartTime time.Time
-	EndTime   time.Time
-	Timezone  string
-}
-
 // MarketWebSocket handles websocket connections during market hours
 type MarketWebSocket struct {
-	clients     map[string]*websocket.Conn // userID -> connection
-	mu          sync.RWMutex
-	upgrader    websocket.Upgrader
-	marketHours map[string]MarketHours // exchange -> hours
+	clients  map[string]*websocket.Conn // userID -> connection
+	mu       sync.RWMutex
+	upgrader websocket.Upgrader
+	calendar *marketcalendar.Calendar
 }
 
 // NewMarketWebSocket creates a new market websocket handler
-func NewMarketWebSocket() *MarketWebSocket {
+func NewMarketWebSocket(calendar *marketcalendar.Calendar) *MarketWebSocket {
 	return &MarketWebSocket{
 		clients: make(map[string]*websocket.Conn),
 		upgrader: websocket.Upgrader{
@@ -36,50 +31,15 @@ func NewMarketWebSocket() *MarketWebSocket {
 				return true // TODO: Implement proper origin checking
 			},
 		},
-		marketHours: map[string]MarketHours{
-			"NYSE": {
-				StartTime: time.Date(0, 0, 0, 9, 30, 0, 0, time.UTC),
-				EndTime:   time.Date(0, 0, 0, 16, 0, 0, 0, time.UTC),
-				Timezone:  "America/New_York",
-			},
-			"NASDAQ": {
-				StartTime: time.Date(0, 0, 0, 9, 30, 0, 0, time.UTC),
-				EndTime:   time.Date(0, 0, 0, 16, 0, 0, 0, time.UTC),
-				Timezone:  "America/New_York",
-			},
-			// Add other exchanges as needed
-		},
+		calendar: calendar,
 	}
 }
 
-// IsMarketOpen checks if the market is currently open for a given exchange
+// IsMarketOpen checks if the market is currently in its regular session for
+// a given exchange. Unknown exchanges are treated as closed.
 func (m *MarketWebSocket) IsMarketOpen(exchange string) bool {
-	hours, ok := m.marketHours[exchange]
-	if !ok {
-		return false
-	}
-
-	now := time.Now().UTC()
-
-	// Check if it's a weekday
-	if now.Weekday() == time.Saturday || now.Weekday() == time.Sunday {
-		return false
-	}
-
-	// Get current time in exchange's timezone
-	loc, err := time.LoadLocation(hours.Timezone)
-	if err != nil {
-		log.Printf("Error loading timezone: %v", err)
-		return false
-	}
-
-	currentTime := now.In(loc)
-	marketStart := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(),
-		hours.StartTime.Hour(), hours.StartTime.Minute(), 0, 0, loc)
-	marketEnd := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(),
-		hours.EndTime.Hour(), hours.EndTime.Minute(), 0, 0, loc)
-
-	return currentTime.After(marketStart) && currentTime.Before(marketEnd)
+	open, err := m.calendar.IsOpen(exchange, time.Now())
+	return err == nil && open
 }
 
 // HandleConnection handles a new websocket connection